@@ -2,28 +2,73 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
 	"github.com/PtsPuf/telegram-mini-app/pkg/server"
-	"github.com/joho/godotenv"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
 )
 
 func main() {
-	log.Println("Запуск сервера локально через cmd/server...")
+	configFile := flag.String("config", "", "путь к файлу конфигурации (YAML или TOML)")
+	printConfig := flag.Bool("print-config", false, "вывести итоговую конфигурацию (секреты скрыты) и выйти")
+	mockProviders := flag.Bool("mock", false, "использовать офлайн-заглушки вместо OpenRouter и Kandinsky (то же, что PROVIDER=mock)")
+	flag.Parse()
 
-	// Попытка загрузить .env для локальной разработки
-	if os.Getenv("OPENROUTER_API_KEY") == "" { // Проверяем одну из переменных
-		log.Println("Переменные окружения не найдены, попытка загрузить .env файл...")
-		if err := godotenv.Load(); err != nil {
-			log.Printf("Предупреждение: Не удалось загрузить .env файл: %v", err)
+	cfg, err := config.Load(config.Options{File: *configFile, DotEnv: ".env"})
+	if err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	if *mockProviders {
+		cfg.Provider = config.ProviderMock
+	}
+	err = cfg.Validate()
+
+	if *printConfig {
+		if printErr := cfg.Print(os.Stdout); printErr != nil {
+			log.Fatal(printErr)
 		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logging.MustSetup(logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format, HashKey: string(cfg.Log.HashKey)})
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, false)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Запускаем настройку и сервер напрямую
-	server.SetupAndRunServer()
+	// SIGINT (Ctrl+C) и SIGTERM (docker stop, деплой) запускают корректную остановку
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = server.SetupAndRunServer(ctx, cfg)
 
-	log.Println("Локальный сервер запущен. Нажмите Ctrl+C для выхода.")
-	// Блокируем main горутину, чтобы сервер продолжал работать в фоне
-	select {}
+	// Отправляем оставшиеся спаны; коллектор может быть недоступен, поэтому с таймаутом
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		slog.Warn("tracing flush failed", slog.String("error", flushErr.Error()))
+	}
+
+	if err != nil {
+		slog.Error("server failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
 }
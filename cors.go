@@ -1,14 +0,0 @@
-package main
-
-import "net/http"
-
-func enableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "*")
-	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-}
-
-func handleOptions(w http.ResponseWriter, r *http.Request) {
-	enableCors(&w)
-	w.WriteHeader(http.StatusOK)
-}
@@ -0,0 +1,461 @@
+// Package admin - административное API и панель мониторинга /admin:
+// последние предсказания без персональных данных, очередь задач, состояние
+// внешних сервисов, лимиты и кредиты пользователей, блокировки и повтор
+// неудавшихся задач. Панель использует те же эндпоинты через HTML-формы.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/health"
+	"github.com/PtsPuf/telegram-mini-app/pkg/jobs"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// Options - зависимости административного API
+type Options struct {
+	// Token - пароль администратора (Bearer или пароль Basic-аутентификации)
+	Token string
+	// RecentLimit - число последних предсказаний в списке
+	RecentLimit int
+	Jobs        *jobs.Manager
+	Usage       *usage.Tracker
+	Health      *health.Checker
+	Bans        *Bans
+}
+
+// Admin обслуживает /admin
+type Admin struct {
+	opts Options
+	mux  *http.ServeMux
+}
+
+// Handler возвращает обработчик /admin с проверкой доступа
+func Handler(opts Options) http.Handler {
+	a := &Admin{opts: opts, mux: http.NewServeMux()}
+
+	a.mux.HandleFunc("GET /admin/{$}", a.handleDashboard)
+	a.mux.HandleFunc("GET /admin/api/predictions", a.handlePredictions)
+	a.mux.HandleFunc("GET /admin/api/jobs", a.handleJobs)
+	a.mux.HandleFunc("POST /admin/api/jobs/{id}/replay", a.handleReplay)
+	a.mux.HandleFunc("GET /admin/api/health", a.handleHealth)
+	a.mux.HandleFunc("GET /admin/api/bans", a.handleBans)
+	a.mux.HandleFunc("GET /admin/api/users/{user}", a.handleUser)
+	a.mux.HandleFunc("PUT /admin/api/users/{user}/quota", a.handleSetQuota)
+	a.mux.HandleFunc("DELETE /admin/api/users/{user}/quota", a.handleClearQuota)
+	a.mux.HandleFunc("POST /admin/api/users/{user}/credits", a.handleCredit)
+	a.mux.HandleFunc("PUT /admin/api/users/{user}/ban", a.handleBan)
+	a.mux.HandleFunc("DELETE /admin/api/users/{user}/ban", a.handleUnban)
+	return a
+}
+
+// ServeHTTP проверяет доступ и источник запроса, затем маршрутизирует его
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet && crossSite(r) {
+		http.Error(w, "Cross-site request rejected", http.StatusForbidden)
+		return
+	}
+	// HTML-формы умеют только POST, метод передается скрытым полем _method
+	if r.Method == http.MethodPost && isForm(r) {
+		if m := r.PostFormValue("_method"); m == http.MethodPut || m == http.MethodDelete {
+			r.Method = m
+		}
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// authorized сравнивает пароль за постоянное время
+func (a *Admin) authorized(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, got, ok = r.BasicAuth()
+	}
+	return ok && a.opts.Token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(a.opts.Token)) == 1
+}
+
+// crossSite сообщает, что изменяющий запрос отправлен со стороннего сайта.
+// Браузер передает Basic-аутентификацию автоматически, поэтому без этой
+// проверки чужая страница могла бы отправить форму от имени администратора.
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// PredictionView - предсказание в списке без персональных данных
+type PredictionView struct {
+	PredictionID string    `json:"predictionId"`
+	User         string    `json:"user"`
+	Mode         string    `json:"mode"`
+	Model        string    `json:"model,omitempty"`
+	Tokens       int       `json:"tokens"`
+	Images       int       `json:"images"`
+	Cost         float64   `json:"cost"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// JobView - задача в очереди без персональных данных
+type JobView struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	User       string    `json:"user"`
+	Name       string    `json:"name"`
+	Mode       string    `json:"mode"`
+	ImagesDone int       `json:"imagesDone"`
+	Images     int       `json:"images"`
+	Polls      int       `json:"polls"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// JobsView - состояние очереди
+type JobsView struct {
+	Counts map[string]int `json:"counts"`
+	Jobs   []JobView      `json:"jobs"`
+}
+
+// UserView - расходы, лимиты и блокировка пользователя
+type UserView struct {
+	User        string              `json:"user"`
+	Day         usage.Totals        `json:"day"`
+	Month       usage.Totals        `json:"month"`
+	Budget      config.BudgetConfig `json:"budget"`
+	Quota       bool                `json:"customQuota"`
+	Bonus       int                 `json:"bonus"`
+	Adjustments []usage.Adjustment  `json:"adjustments"`
+	Ban         *Ban                `json:"ban,omitempty"`
+}
+
+func (a *Admin) handlePredictions(w http.ResponseWriter, r *http.Request) {
+	list, err := a.predictions()
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *Admin) handleJobs(w http.ResponseWriter, r *http.Request) {
+	view, err := a.jobs()
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (a *Admin) handleReplay(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, err := a.opts.Jobs.Replay(r.Context(), id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrNotFailed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		internalError(w, r, err)
+		return
+	}
+	audit(r, "job_replay", slog.String("job_id", id))
+	respond(w, r, http.StatusAccepted, jobView(job), "/admin/")
+}
+
+func (a *Admin) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.opts.Health.Run(r.Context()))
+}
+
+func (a *Admin) handleBans(w http.ResponseWriter, r *http.Request) {
+	list, err := a.opts.Bans.List()
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *Admin) handleUser(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.user(r.PathValue("user")))
+}
+
+func (a *Admin) handleSetQuota(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	var quota config.BudgetConfig
+	if err := decode(r, &quota, func(form url.Values) error {
+		var err error
+		for _, f := range []struct {
+			name   string
+			target *float64
+		}{
+			{"daily", &quota.Daily},
+			{"monthly", &quota.Monthly},
+			{"hard_daily", &quota.HardDaily},
+			{"hard_monthly", &quota.HardMonthly},
+		} {
+			if *f.target, err = formFloat(form, f.name); err != nil {
+				return err
+			}
+		}
+		quota.FallbackModel = strings.TrimSpace(form.Get("fallback_model"))
+		return nil
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := a.opts.Usage.SetQuota(user, quota)
+	if errors.Is(err, usage.ErrInvalidAdjustment) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	audit(r, "quota_set", slog.String("user", user))
+	respond(w, r, http.StatusOK, a.user(user), userPage(user))
+}
+
+func (a *Admin) handleClearQuota(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	a.opts.Usage.ClearQuota(user)
+	audit(r, "quota_cleared", slog.String("user", user))
+	respond(w, r, http.StatusOK, a.user(user), userPage(user))
+}
+
+func (a *Admin) handleCredit(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	var req struct {
+		Amount float64 `json:"amount"`
+		Note   string  `json:"note"`
+	}
+	if err := decode(r, &req, func(form url.Values) (err error) {
+		req.Note = form.Get("note")
+		req.Amount, err = formFloat(form, "amount")
+		return err
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := a.opts.Usage.Credit(user, req.Amount, req.Note)
+	if errors.Is(err, usage.ErrInvalidAdjustment) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		internalError(w, r, err)
+		return
+	}
+	audit(r, "credit", slog.String("user", user), slog.Float64("amount", req.Amount))
+	respond(w, r, http.StatusOK, a.user(user), userPage(user))
+}
+
+func (a *Admin) handleBan(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	userID, err := strconv.ParseInt(user, 10, 64)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Блокировать можно только пользователя Telegram", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := decode(r, &req, func(form url.Values) error {
+		req.Reason = form.Get("reason")
+		return nil
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.opts.Bans.Ban(userID, req.Reason, time.Now()); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	audit(r, "ban", slog.String("user", user))
+	respond(w, r, http.StatusOK, a.user(user), userPage(user))
+}
+
+func (a *Admin) handleUnban(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if userID, err := strconv.ParseInt(user, 10, 64); err == nil {
+		a.opts.Bans.Unban(userID)
+	}
+	audit(r, "unban", slog.String("user", user))
+	respond(w, r, http.StatusOK, a.user(user), userPage(user))
+}
+
+// predictions собирает последние предсказания со статусом задачи
+func (a *Admin) predictions() ([]PredictionView, error) {
+	records, err := a.opts.Usage.Recent(a.opts.RecentLimit)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]PredictionView, len(records))
+	for i, rec := range records {
+		v := PredictionView{
+			PredictionID: rec.PredictionID,
+			User:         rec.User,
+			Mode:         rec.Mode,
+			Model:        rec.Model,
+			Tokens:       rec.TotalTokens,
+			Images:       rec.Images,
+			Cost:         rec.Cost,
+			// Синхронные предсказания не сохраняются как задачи
+			Status:    "sync",
+			CreatedAt: rec.CreatedAt,
+		}
+		if job, err := a.opts.Jobs.Get(rec.PredictionID); err == nil {
+			v.Status = string(job.Status)
+			v.Error = job.Error
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+// jobs собирает состояние очереди
+func (a *Admin) jobs() (*JobsView, error) {
+	list, err := a.opts.Jobs.List()
+	if err != nil {
+		return nil, err
+	}
+	view := &JobsView{Counts: make(map[string]int), Jobs: make([]JobView, len(list))}
+	for i, job := range list {
+		view.Counts[string(job.Status)]++
+		view.Jobs[i] = jobView(job)
+	}
+	return view, nil
+}
+
+// user собирает расходы и ограничения пользователя
+func (a *Admin) user(user string) *UserView {
+	v := &UserView{User: user, Budget: a.opts.Usage.Budget(user)}
+	v.Day, v.Month = a.opts.Usage.Totals(user)
+	_, v.Quota = a.opts.Usage.Quota(user)
+	v.Bonus = a.opts.Usage.Bonus(user)
+	v.Adjustments, _ = a.opts.Usage.Adjustments(user)
+	if userID, err := strconv.ParseInt(user, 10, 64); err == nil {
+		v.Ban, _ = a.opts.Bans.Get(userID)
+	}
+	return v
+}
+
+func jobView(job *jobs.Job) JobView {
+	v := JobView{
+		ID:        job.ID,
+		Status:    string(job.Status),
+		User:      usage.User(job.State.TelegramID),
+		Name:      Mask(job.State.Name),
+		Mode:      job.State.Mode,
+		Images:    len(job.Images),
+		Polls:     job.Polls,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+	}
+	for _, img := range job.Images {
+		if img.Status == jobs.StatusDone {
+			v.ImagesDone++
+		}
+	}
+	return v
+}
+
+// Mask скрывает персональные данные, оставляя первый символ
+func Mask(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return string(r) + "***"
+}
+
+// decode разбирает тело запроса: JSON от API или поля HTML-формы
+func decode(r *http.Request, v any, fromForm func(url.Values) error) error {
+	if isForm(r) {
+		if err := r.ParseForm(); err != nil {
+			return fmt.Errorf("некорректная форма: %v", err)
+		}
+		return fromForm(r.PostForm)
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("некорректный JSON: %v", err)
+	}
+	return nil
+}
+
+// formFloat разбирает число из поля формы; пустое поле - 0
+func formFloat(form url.Values, name string) (float64, error) {
+	value := strings.TrimSpace(strings.ReplaceAll(form.Get(name), ",", "."))
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: ожидается число, получено %q", name, value)
+	}
+	return f, nil
+}
+
+func isForm(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+}
+
+// respond отвечает JSON для API, а после отправки формы возвращает на панель
+func respond(w http.ResponseWriter, r *http.Request, status int, v any, redirect string) {
+	if isForm(r) {
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, status, v)
+}
+
+func userPage(user string) string {
+	return "/admin/?user=" + url.QueryEscape(user)
+}
+
+// audit записывает действие администратора в лог
+func audit(r *http.Request, action string, attrs ...any) {
+	slog.InfoContext(r.Context(), "admin action", append([]any{slog.String("action", action)}, attrs...)...)
+}
+
+func internalError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "admin request failed", slog.String("error", err.Error()))
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("response encode failed", slog.String("error", err.Error()))
+	}
+}
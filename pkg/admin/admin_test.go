@@ -0,0 +1,210 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/admin"
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/health"
+	"github.com/PtsPuf/telegram-mini-app/pkg/jobs"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+const token = "s3cret"
+
+// env - панель администратора поверх хранилища в памяти. Предсказание
+// падает, пока fail = true.
+type env struct {
+	handler http.Handler
+	jobs    *jobs.Manager
+	usage   *usage.Tracker
+	bans    *admin.Bans
+	fail    bool
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	e := &env{usage: usage.NewTracker(st, cfg.Usage, cfg.OpenRouter.Model), bans: admin.NewBans(st)}
+	e.jobs = jobs.NewManager(st, func(context.Context, *common.UserState) (*common.Prediction, error) {
+		if e.fail {
+			return nil, errors.New("модель недоступна")
+		}
+		return &common.Prediction{Text: "Все сложится."}, nil
+	}, nil, jobs.Options{TTL: time.Hour})
+	e.handler = admin.Handler(admin.Options{
+		Token:       token,
+		RecentLimit: 10,
+		Jobs:        e.jobs,
+		Usage:       e.usage,
+		Health:      health.NewChecker(time.Minute, time.Second),
+		Bans:        e.bans,
+	})
+	return e
+}
+
+// do выполняет запрос с заголовками; без Authorization в headers
+// подставляется Bearer-токен
+func (e *env) do(method, path, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if _, ok := headers["Authorization"]; !ok {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range headers {
+		if v != "" {
+			r.Header.Set(k, v)
+		}
+	}
+	w := httptest.NewRecorder()
+	e.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthorization(t *testing.T) {
+	e := newEnv(t)
+	basic := func(user, password string) string {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(user, password)
+		return r.Header.Get("Authorization")
+	}
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong bearer", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong basic", basic("admin", "wrong"), http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"bearer", "Bearer " + token, http.StatusOK},
+		{"basic", basic("admin", token), http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := e.do(http.MethodGet, "/admin/api/jobs", "", "", map[string]string{"Authorization": tt.auth})
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+			t.Errorf("%s: no Basic challenge", tt.name)
+		}
+	}
+}
+
+func TestCrossSite(t *testing.T) {
+	e := newEnv(t)
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"cross-site post", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same-site post", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"foreign origin", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"same-origin post", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"own origin", http.MethodPost, map[string]string{"Origin": "http://example.com"}, http.StatusOK},
+		{"api client", http.MethodPost, nil, http.StatusOK},
+		// Чтение не меняет данных и со стороннего сайта разрешено
+		{"cross-site get", http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		path, body := "/admin/api/users/42/credits", `{"amount":0.5,"note":"тест"}`
+		if tt.method == http.MethodGet {
+			path, body = "/admin/api/users/42", ""
+		}
+		if w := e.do(tt.method, path, "application/json", body, tt.headers); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+	// Запрещенные запросы не изменили кредит
+	if history, _ := e.usage.Adjustments("42"); len(history) != 3 {
+		t.Errorf("adjustments = %d, want 3", len(history))
+	}
+}
+
+func TestMethodOverride(t *testing.T) {
+	e := newEnv(t)
+	const formType = "application/x-www-form-urlencoded"
+	same := map[string]string{"Sec-Fetch-Site": "same-origin"}
+
+	w := e.do(http.MethodPost, "/admin/api/users/42/ban", formType, url.Values{"_method": {"PUT"}, "reason": {"спам"}}.Encode(), same)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/?user=42" {
+		t.Fatalf("ban form: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	if ban, ok := e.bans.Get(42); !ok || ban.Reason != "спам" {
+		t.Fatalf("ban = %+v, %v", ban, ok)
+	}
+
+	w = e.do(http.MethodPost, "/admin/api/users/42/quota", formType, url.Values{"_method": {"PUT"}, "daily": {"0,5"}, "monthly": {"5"}}.Encode(), same)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("quota form: status %d: %s", w.Code, w.Body)
+	}
+	if quota, ok := e.usage.Quota("42"); !ok || quota.Daily != 0.5 || quota.Monthly != 5 {
+		t.Errorf("quota = %+v, %v", quota, ok)
+	}
+
+	w = e.do(http.MethodPost, "/admin/api/users/42/ban", formType, url.Values{"_method": {"DELETE"}}.Encode(), same)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unban form: status %d", w.Code)
+	}
+	if _, ok := e.bans.Get(42); ok {
+		t.Error("user still banned")
+	}
+
+	// Переопределение работает только для форм и только в PUT и DELETE
+	w = e.do(http.MethodPost, "/admin/api/users/42/ban?_method=PUT", "application/json", `{"reason":"x"}`, same)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("JSON with _method: status %d, want 405", w.Code)
+	}
+	w = e.do(http.MethodPost, "/admin/api/users/42/ban", formType, url.Values{"_method": {"PATCH"}}.Encode(), same)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("form with _method=PATCH: status %d, want 405", w.Code)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	e := newEnv(t)
+	e.fail = true
+	job, err := e.jobs.Submit(context.Background(), common.UserState{Name: "Анна", Mode: "Карьера"})
+	if err != nil || job.Status != jobs.StatusFailed {
+		t.Fatalf("Submit = %+v, %v", job, err)
+	}
+
+	e.fail = false
+	w := e.do(http.MethodPost, "/admin/api/jobs/"+job.ID+"/replay", "", "", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("replay: status %d: %s", w.Code, w.Body)
+	}
+	var view admin.JobView
+	if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if view.ID != job.ID || view.Status != string(jobs.StatusDone) || view.Name != "А***" {
+		t.Errorf("replayed job = %+v", view)
+	}
+
+	// Повтор выполненной задачи не разрешен, неизвестной - не найден
+	if w := e.do(http.MethodPost, "/admin/api/jobs/"+job.ID+"/replay", "", "", nil); w.Code != http.StatusConflict {
+		t.Errorf("replay of a done job: status %d, want 409", w.Code)
+	}
+	if w := e.do(http.MethodPost, "/admin/api/jobs/unknown/replay", "", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("replay of an unknown job: status %d, want 404", w.Code)
+	}
+}
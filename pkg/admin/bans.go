@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+// bansCollection - коллекция заблокированных пользователей
+const bansCollection = "bans"
+
+// Ban - блокировка пользователя Telegram
+type Ban struct {
+	UserID    int64     `json:"userId"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Bans хранит блокировки пользователей
+type Bans struct {
+	store *store.Store
+}
+
+// NewBans создает список блокировок
+func NewBans(st *store.Store) *Bans {
+	return &Bans{store: st}
+}
+
+// Ban блокирует пользователя
+func (b *Bans) Ban(userID int64, reason string, now time.Time) error {
+	return b.store.Put(bansCollection, banKey(userID), Ban{UserID: userID, Reason: reason, CreatedAt: now})
+}
+
+// Unban снимает блокировку
+func (b *Bans) Unban(userID int64) {
+	b.store.Delete(bansCollection, banKey(userID))
+}
+
+// Get возвращает блокировку пользователя. Запросы без Telegram (userID 0)
+// не блокируются.
+func (b *Bans) Get(userID int64) (*Ban, bool) {
+	if userID == 0 {
+		return nil, false
+	}
+	var ban Ban
+	ok, err := b.store.Get(bansCollection, banKey(userID), &ban)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return &ban, true
+}
+
+// List возвращает все блокировки, новые первыми
+func (b *Bans) List() ([]Ban, error) {
+	var list []Ban
+	err := store.Each(b.store, bansCollection, func(_ string, ban *Ban) error {
+		list = append(list, *ban)
+		return nil
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, err
+}
+
+func banKey(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/health"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+// dashboardJobs - сколько задач показывать на панели
+const dashboardJobs = 20
+
+var dashboard = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"usd": func(v float64) string { return fmt.Sprintf("$%.4f", v) },
+}).Parse(dashboardHTML))
+
+// dashboardData - данные панели
+type dashboardData struct {
+	Health      health.Report
+	Jobs        *JobsView
+	Predictions []PredictionView
+	Bans        []Ban
+	User        *UserView
+}
+
+// handleDashboard отрисовывает панель из тех же данных, что отдает API
+func (a *Admin) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	data := dashboardData{Health: a.opts.Health.Run(r.Context())}
+
+	var err error
+	if data.Jobs, err = a.jobs(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if len(data.Jobs.Jobs) > dashboardJobs {
+		data.Jobs.Jobs = data.Jobs.Jobs[:dashboardJobs]
+	}
+	if data.Predictions, err = a.predictions(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if data.Bans, err = a.opts.Bans.List(); err != nil {
+		internalError(w, r, err)
+		return
+	}
+	if user := strings.TrimSpace(r.URL.Query().Get("user")); user != "" {
+		data.User = a.user(user)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboard.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "dashboard render failed", slog.String("error", err.Error()))
+	}
+}
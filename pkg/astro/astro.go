@@ -0,0 +1,208 @@
+// Package astro вычисляет астрологические факты по дате, времени и месту
+// рождения: знаки Солнца, Луны и асцендента, фазу Луны, знак китайского
+// календаря и текущие транзиты планет. Эти факты передаются LLM, чтобы
+// предсказание опиралось на расчет, а не на догадки модели.
+package astro
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrInvalidDate - дату рождения не удалось распознать
+var ErrInvalidDate = errors.New("некорректная дата рождения")
+
+// Birth - данные о рождении. Время и место необязательны.
+type Birth struct {
+	// Date - дата в формате ДД.ММ.ГГГГ или ГГГГ-ММ-ДД
+	Date string
+	// Time - местное время ЧЧ:ММ
+	Time string
+	// Place - город рождения
+	Place string
+}
+
+// Chart - натальная карта и текущие транзиты
+type Chart struct {
+	Sun Sign `json:"sun"`
+	// Moon - знак Луны. Без времени рождения он рассчитан на полдень и
+	// при смене знака в этот день MoonAlt содержит второй возможный знак.
+	Moon      Sign    `json:"moon"`
+	MoonAlt   *Sign   `json:"moonAlt,omitempty"`
+	MoonPhase Phase   `json:"moonPhase"`
+	Ascendant *Sign   `json:"ascendant,omitempty"`
+	Chinese   Chinese `json:"chinese"`
+	// Transits - положение планет на момент расчета
+	Transits []Transit `json:"transits"`
+
+	// Неточности расчета, о которых сообщается в описании
+	noTime  bool
+	noPlace bool
+	place   string
+}
+
+// Transit - текущее положение планеты и ее аспект к натальному Солнцу
+type Transit struct {
+	Planet     string  `json:"planet"`
+	Sign       Sign    `json:"sign"`
+	Degree     float64 `json:"degree"`
+	Retrograde bool    `json:"retrograde,omitempty"`
+	// Aspect - аспект к натальному Солнцу, если он есть
+	Aspect string `json:"aspect,omitempty"`
+}
+
+// transitPlanets - планеты транзитов и их названия
+var transitPlanets = []struct{ key, name string }{
+	{"mercury", "Меркурий"},
+	{"venus", "Венера"},
+	{"mars", "Марс"},
+	{"jupiter", "Юпитер"},
+	{"saturn", "Сатурн"},
+	{"uranus", "Уран"},
+	{"neptune", "Нептун"},
+	{"pluto", "Плутон"},
+}
+
+// dateLayouts - принимаемые форматы даты рождения
+var dateLayouts = []string{"02.01.2006", "2.1.2006", "2006-01-02", "02/01/2006", "02-01-2006"}
+
+// ParseDate разбирает дату рождения в одном из принятых форматов
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidDate, s)
+}
+
+// Compute рассчитывает карту рождения и транзиты на момент now
+func Compute(b Birth, now time.Time) (*Chart, error) {
+	date, err := ParseDate(b.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	chart := &Chart{}
+	place, hasPlace := LookupPlace(b.Place)
+	chart.noPlace = !hasPlace
+	chart.place = place.Name
+
+	// Без места время считается московским: так рождены большинство пользователей
+	loc, _ := time.LoadLocation("Europe/Moscow")
+	if hasPlace {
+		if l, err := time.LoadLocation(place.TimeZone); err == nil {
+			loc = l
+		}
+	}
+
+	hour, minute := 12, 0
+	if clock, err := time.Parse("15:04", strings.TrimSpace(b.Time)); err == nil {
+		hour, minute = clock.Hour(), clock.Minute()
+	} else {
+		chart.noTime = true
+	}
+	birth := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+	jd := julianDay(birth)
+
+	sun := sunLongitude(jd)
+	moon := moonLongitude(jd)
+	chart.Sun = SignAt(sun)
+	chart.Moon = SignAt(moon)
+	chart.MoonPhase = phaseAt(sun, moon)
+	chart.Chinese = chineseSign(date.Year(), sun, int(date.Month()))
+
+	if chart.noTime {
+		// Луна проходит знак за 2-3 дня, поэтому в течение суток знак может смениться
+		start := julianDay(time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc))
+		for _, edge := range []float64{start, start + 1} {
+			if s := SignAt(moonLongitude(edge)); s != chart.Moon {
+				chart.MoonAlt = &s
+				break
+			}
+		}
+	}
+	if !chart.noTime && hasPlace {
+		asc := SignAt(ascendant(jd, place.Lat, place.Lon))
+		chart.Ascendant = &asc
+	}
+
+	chart.Transits = transits(julianDay(now), sun)
+	return chart, nil
+}
+
+// SunSign возвращает знак Солнца на дату (полдень UTC). Солнце меняет знак
+// около 20-23 числа, и для дат на границе знак может отличаться от
+// рассчитанного по точному времени рождения.
+func SunSign(date time.Time) Sign {
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC)
+	return SignAt(sunLongitude(julianDay(noon)))
+}
+
+// SignTransits рассчитывает транзиты на момент now с аспектами к середине знака.
+// Подходит для общего гороскопа знака, когда натальная карта неизвестна.
+func SignTransits(sign Sign, now time.Time) []Transit {
+	return transits(julianDay(now), float64(sign)*30+15)
+}
+
+// transits рассчитывает положения планет на дату jd и аспекты к натальному Солнцу
+func transits(jd, natalSun float64) []Transit {
+	result := make([]Transit, 0, len(transitPlanets))
+	for _, p := range transitPlanets {
+		lon := planetLongitude(p.key, jd)
+		// Попятное движение: геоцентрическая долгота за сутки уменьшилась
+		delta := norm(planetLongitude(p.key, jd+1)-lon+180) - 180
+		result = append(result, Transit{
+			Planet:     p.name,
+			Sign:       SignAt(lon),
+			Degree:     math.Floor(math.Mod(lon, 30)),
+			Retrograde: delta < 0,
+			Aspect:     aspectBetween(lon, natalSun),
+		})
+	}
+	return result
+}
+
+// Summary описывает карту текстом для промпта
+func (c *Chart) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Солнце в знаке %s (стихия %s).\n", c.Sun, c.Sun.Element())
+	if c.MoonAlt != nil {
+		fmt.Fprintf(&b, "Луна в знаке %s или %s (время рождения неизвестно), фаза: %s.\n", c.Moon, *c.MoonAlt, c.MoonPhase.Name)
+	} else {
+		fmt.Fprintf(&b, "Луна в знаке %s, фаза: %s (освещенность %.0f%%).\n", c.Moon, c.MoonPhase.Name, c.MoonPhase.Illumination*100)
+	}
+	switch {
+	case c.Ascendant != nil:
+		fmt.Fprintf(&b, "Асцендент: %s (место рождения: %s).\n", *c.Ascendant, c.place)
+	case c.noTime:
+		b.WriteString("Асцендент не определен: время рождения неизвестно.\n")
+	default:
+		b.WriteString("Асцендент не определен: место рождения неизвестно.\n")
+	}
+	fmt.Fprintf(&b, "Китайский гороскоп: %s, стихия %s (%d год).\n", c.Chinese.Animal, c.Chinese.Element, c.Chinese.Year)
+
+	b.WriteString("Текущие транзиты:\n")
+	b.WriteString(FormatTransits(c.Transits, "натальному Солнцу"))
+	return b.String()
+}
+
+// FormatTransits описывает транзиты списком; target - к чему указаны аспекты
+func FormatTransits(transits []Transit, target string) string {
+	lines := make([]string, len(transits))
+	for i, t := range transits {
+		line := fmt.Sprintf("- %s в знаке %s %.0f°", t.Planet, t.Sign, t.Degree)
+		if t.Retrograde {
+			line += ", ретроградный"
+		}
+		if t.Aspect != "" {
+			line += fmt.Sprintf(", %s к %s", t.Aspect, target)
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,116 @@
+package astro_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/astro"
+)
+
+var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSunSignCusps(t *testing.T) {
+	// Моменты входа Солнца в знак: 20.03.2000 07:35 UTC, 22.12.2023 03:27 UTC,
+	// 22.07.2024 08:44 UTC. SunSign считает на полдень UTC.
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"19.03.2000", "Рыбы"},
+		{"20.03.2000", "Овен"},
+		{"21.12.2023", "Стрелец"},
+		{"22.12.2023", "Козерог"},
+		{"21.07.2024", "Рак"},
+		{"22.07.2024", "Лев"},
+	}
+	for _, tt := range tests {
+		date, err := astro.ParseDate(tt.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := astro.SunSign(date).String(); got != tt.want {
+			t.Errorf("SunSign(%s) = %s, want %s", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestChineseYear(t *testing.T) {
+	// Личунь 2024 года - 4 февраля, 2000 года - 4 февраля
+	tests := []struct {
+		date    string
+		animal  string
+		element string
+		year    int
+	}{
+		{"2024-01-25", "Кролик", "Вода", 2023},
+		{"2024-02-03", "Кролик", "Вода", 2023},
+		{"2024-02-05", "Дракон", "Дерево", 2024},
+		{"2024-12-31", "Дракон", "Дерево", 2024},
+		{"03.02.2000", "Кролик", "Земля", 1999},
+		{"05.02.2000", "Дракон", "Металл", 2000},
+	}
+	for _, tt := range tests {
+		chart, err := astro.Compute(astro.Birth{Date: tt.date}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := chart.Chinese; c.Animal != tt.animal || c.Element != tt.element || c.Year != tt.year {
+			t.Errorf("Chinese(%s) = %+v, want %s %s %d", tt.date, c, tt.animal, tt.element, tt.year)
+		}
+	}
+}
+
+func TestMoonPhase(t *testing.T) {
+	// Новолуние 08.04.2024 18:21 UTC, полнолуние 18.09.2024 02:34 UTC
+	tests := []struct {
+		birth astro.Birth
+		want  string
+		lit   func(float64) bool
+	}{
+		{astro.Birth{Date: "08.04.2024", Time: "21:21", Place: "Москва"}, "новолуние", func(l float64) bool { return l < 0.02 }},
+		{astro.Birth{Date: "18.09.2024", Time: "05:34", Place: "Москва"}, "полнолуние", func(l float64) bool { return l > 0.98 }},
+		{astro.Birth{Date: "11.09.2024", Time: "09:05", Place: "Москва"}, "первая четверть", func(l float64) bool { return l > 0.4 && l < 0.6 }},
+	}
+	for _, tt := range tests {
+		chart, err := astro.Compute(tt.birth, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := chart.MoonPhase; p.Name != tt.want || !tt.lit(p.Illumination) {
+			t.Errorf("phase on %s %s = %+v, want %s", tt.birth.Date, tt.birth.Time, p, tt.want)
+		}
+	}
+}
+
+func TestBirthPlace(t *testing.T) {
+	// Солнце входит в Овна 20.03.2000 в 07:35 UTC: полдень во Владивостоке
+	// (UTC+10) еще в Рыбах, а в Москве (UTC+3) и Калининграде (UTC+2) уже в Овне
+	tests := []struct {
+		place string
+		sun   string
+		asc   bool
+	}{
+		{"Владивосток", "Рыбы", true},
+		{"Москва", "Овен", true},
+		{"г. Калининград", "Овен", true},
+		{"Атлантида", "Овен", false},
+	}
+	for _, tt := range tests {
+		chart, err := astro.Compute(astro.Birth{Date: "20.03.2000", Time: "12:00", Place: tt.place}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chart.Sun.String() != tt.sun || (chart.Ascendant != nil) != tt.asc {
+			t.Errorf("%s: sun %s, ascendant %v, want %s, %v", tt.place, chart.Sun, chart.Ascendant, tt.sun, tt.asc)
+		}
+	}
+
+	for _, name := range []string{"спб", "Питер", " САНКТ-ПЕТЕРБУРГ "} {
+		if p, ok := astro.LookupPlace(name); !ok || p.TimeZone != "Europe/Moscow" {
+			t.Errorf("LookupPlace(%q) = %+v, %v", name, p, ok)
+		}
+	}
+	if p, ok := astro.LookupPlace("Кишинёв"); !ok || p.TimeZone != "Europe/Chisinau" {
+		t.Errorf("LookupPlace(Кишинёв) = %+v, %v", p, ok)
+	}
+}
@@ -0,0 +1,167 @@
+package astro
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Низкоточные формулы (Meeus, JPL) дают ошибку порядка градуса: этого
+// достаточно для определения знака, но не для точных аспектов
+
+//go:embed elements.txt
+var elementsData string
+
+// orbit - кеплеровы элементы планеты на J2000 и их изменения за столетие
+type orbit struct {
+	a, e, i, l, peri, node       float64
+	da, de, di, dl, dperi, dnode float64
+}
+
+// orbits - элементы планет из встроенной таблицы
+var orbits = mustParseElements(elementsData)
+
+func mustParseElements(data string) map[string]orbit {
+	result := make(map[string]orbit)
+	var name string
+	var values []float64
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+			name, values, fields = fields[0], nil, fields[1:]
+		}
+		for _, f := range fields {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				panic(fmt.Sprintf("astro: elements.txt:%d: %v", n+1, err))
+			}
+			values = append(values, v)
+		}
+		if len(values) == 12 {
+			result[name] = orbit{
+				values[0], values[1], values[2], values[3], values[4], values[5],
+				values[6], values[7], values[8], values[9], values[10], values[11],
+			}
+		}
+	}
+	return result
+}
+
+// julianDay возвращает юлианскую дату момента t
+func julianDay(t time.Time) float64 {
+	return float64(t.UTC().UnixNano())/86400e9 + 2440587.5
+}
+
+// centuries возвращает число юлианских столетий от J2000
+func centuries(jd float64) float64 {
+	return (jd - 2451545.0) / 36525
+}
+
+// sunLongitude - видимая эклиптическая долгота Солнца на дату, градусы
+func sunLongitude(jd float64) float64 {
+	t := centuries(jd)
+	l0 := 280.46646 + 36000.76983*t + 0.0003032*t*t
+	m := 357.52911 + 35999.05029*t - 0.0001537*t*t
+	c := (1.914602-0.004817*t-0.000014*t*t)*sin(m) +
+		(0.019993-0.000101*t)*sin(2*m) +
+		0.000289*sin(3*m)
+	return norm(l0 + c)
+}
+
+// moonLongitude - эклиптическая долгота Луны по главным членам теории движения
+func moonLongitude(jd float64) float64 {
+	t := centuries(jd)
+	l := 218.3164477 + 481267.88123421*t
+	d := 297.8501921 + 445267.1114034*t
+	m := 357.5291092 + 35999.0502909*t
+	mm := 134.9633964 + 477198.8675055*t
+	f := 93.2720950 + 483202.0175233*t
+	return norm(l +
+		6.289*sin(mm) +
+		1.274*sin(2*d-mm) +
+		0.658*sin(2*d) +
+		0.214*sin(2*mm) -
+		0.186*sin(m) -
+		0.114*sin(2*f) -
+		0.059*sin(2*d-2*mm) -
+		0.057*sin(2*d-m-mm) +
+		0.053*sin(2*d+mm))
+}
+
+// heliocentric возвращает гелиоцентрические эклиптические координаты планеты (J2000)
+func heliocentric(o orbit, t float64) (x, y, z float64) {
+	a := o.a + o.da*t
+	e := o.e + o.de*t
+	inc := o.i + o.di*t
+	l := o.l + o.dl*t
+	peri := o.peri + o.dperi*t
+	node := o.node + o.dnode*t
+
+	w := peri - node
+	m := norm(l - peri)
+	ecc := kepler(m*rad, e)
+
+	xp := a * (math.Cos(ecc) - e)
+	yp := a * math.Sqrt(1-e*e) * math.Sin(ecc)
+
+	cw, sw := cos(w), sin(w)
+	cn, sn := cos(node), sin(node)
+	ci, si := cos(inc), sin(inc)
+	x = (cw*cn-sw*sn*ci)*xp + (-sw*cn-cw*sn*ci)*yp
+	y = (cw*sn+sw*cn*ci)*xp + (-sw*sn+cw*cn*ci)*yp
+	z = sw*si*xp + cw*si*yp
+	return x, y, z
+}
+
+// kepler решает уравнение Кеплера E - e·sin E = M методом Ньютона (радианы)
+func kepler(m, e float64) float64 {
+	ecc := m + e*math.Sin(m)
+	for i := 0; i < 10; i++ {
+		delta := (ecc - e*math.Sin(ecc) - m) / (1 - e*math.Cos(ecc))
+		ecc -= delta
+		if math.Abs(delta) < 1e-9 {
+			break
+		}
+	}
+	return ecc
+}
+
+// planetLongitude - геоцентрическая эклиптическая долгота планеты на дату
+func planetLongitude(name string, jd float64) float64 {
+	t := centuries(jd)
+	px, py, _ := heliocentric(orbits[name], t)
+	ex, ey, _ := heliocentric(orbits["earth"], t)
+	// Элементы отнесены к равноденствию J2000: добавляем общую прецессию
+	return norm(math.Atan2(py-ey, px-ex)/rad + 1.396971*t)
+}
+
+// ascendant - эклиптическая долгота восходящей точки для места с широтой lat
+// и восточной долготой lon (градусы)
+func ascendant(jd, lat, lon float64) float64 {
+	t := centuries(jd)
+	gmst := 280.46061837 + 360.98564736629*(jd-2451545.0) + 0.000387933*t*t
+	ramc := norm(gmst + lon)
+	eps := 23.439291 - 0.0130042*t
+	return norm(math.Atan2(cos(ramc), -(sin(ramc)*cos(eps)+math.Tan(lat*rad)*sin(eps))) / rad)
+}
+
+const rad = math.Pi / 180
+
+func sin(deg float64) float64 { return math.Sin(deg * rad) }
+func cos(deg float64) float64 { return math.Cos(deg * rad) }
+
+// norm приводит угол к диапазону [0, 360)
+func norm(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
@@ -0,0 +1,72 @@
+package astro
+
+import (
+	"strings"
+	// Часовые пояса встроены, чтобы расчет не зависел от tzdata в контейнере
+	_ "time/tzdata"
+)
+
+// Place - место рождения
+type Place struct {
+	Name     string
+	Lat, Lon float64
+	TimeZone string
+}
+
+// places - города, которые распознаются по названию. Ключ - название в нижнем регистре.
+var places = map[string]Place{}
+
+func init() {
+	for _, p := range []Place{
+		{"Москва", 55.7558, 37.6173, "Europe/Moscow"},
+		{"Санкт-Петербург", 59.9343, 30.3351, "Europe/Moscow"},
+		{"Новосибирск", 55.0084, 82.9357, "Asia/Novosibirsk"},
+		{"Екатеринбург", 56.8389, 60.6057, "Asia/Yekaterinburg"},
+		{"Казань", 55.7961, 49.1064, "Europe/Moscow"},
+		{"Нижний Новгород", 56.2965, 43.9361, "Europe/Moscow"},
+		{"Челябинск", 55.1644, 61.4368, "Asia/Yekaterinburg"},
+		{"Самара", 53.1959, 50.1002, "Europe/Samara"},
+		{"Омск", 54.9885, 73.3242, "Asia/Omsk"},
+		{"Ростов-на-Дону", 47.2357, 39.7015, "Europe/Moscow"},
+		{"Уфа", 54.7388, 55.9721, "Asia/Yekaterinburg"},
+		{"Красноярск", 56.0153, 92.8932, "Asia/Krasnoyarsk"},
+		{"Воронеж", 51.6720, 39.1843, "Europe/Moscow"},
+		{"Пермь", 58.0105, 56.2502, "Asia/Yekaterinburg"},
+		{"Волгоград", 48.7080, 44.5133, "Europe/Volgograd"},
+		{"Краснодар", 45.0355, 38.9753, "Europe/Moscow"},
+		{"Саратов", 51.5331, 46.0342, "Europe/Saratov"},
+		{"Тюмень", 57.1522, 65.5272, "Asia/Yekaterinburg"},
+		{"Иркутск", 52.2870, 104.3050, "Asia/Irkutsk"},
+		{"Хабаровск", 48.4802, 135.0719, "Asia/Vladivostok"},
+		{"Владивосток", 43.1155, 131.8855, "Asia/Vladivostok"},
+		{"Калининград", 54.7104, 20.4522, "Europe/Kaliningrad"},
+		{"Сочи", 43.6028, 39.7342, "Europe/Moscow"},
+		{"Минск", 53.9006, 27.5590, "Europe/Minsk"},
+		{"Киев", 50.4501, 30.5234, "Europe/Kyiv"},
+		{"Алматы", 43.2220, 76.8512, "Asia/Almaty"},
+		{"Астана", 51.1694, 71.4491, "Asia/Almaty"},
+		{"Ташкент", 41.2995, 69.2401, "Asia/Tashkent"},
+		{"Тбилиси", 41.7151, 44.8271, "Asia/Tbilisi"},
+		{"Ереван", 40.1872, 44.5152, "Asia/Yerevan"},
+		{"Баку", 40.4093, 49.8671, "Asia/Baku"},
+		{"Бишкек", 42.8746, 74.5698, "Asia/Bishkek"},
+		{"Рига", 56.9496, 24.1052, "Europe/Riga"},
+		{"Вильнюс", 54.6872, 25.2797, "Europe/Vilnius"},
+		{"Таллин", 59.4370, 24.7536, "Europe/Tallinn"},
+		{"Кишинев", 47.0105, 28.8638, "Europe/Chisinau"},
+	} {
+		places[strings.ToLower(p.Name)] = p
+	}
+	places["спб"] = places["санкт-петербург"]
+	places["питер"] = places["санкт-петербург"]
+	places["киів"] = places["киев"]
+}
+
+// LookupPlace ищет город по названию без учета регистра
+func LookupPlace(name string) (Place, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimPrefix(name, "г. ")
+	name = strings.ReplaceAll(name, "ё", "е")
+	p, ok := places[name]
+	return p, ok
+}
@@ -0,0 +1,128 @@
+package astro
+
+import (
+	"fmt"
+	"math"
+)
+
+// Sign - знак зодиака, 0 - Овен
+type Sign int
+
+var signNames = [12]string{
+	"Овен", "Телец", "Близнецы", "Рак", "Лев", "Дева",
+	"Весы", "Скорпион", "Стрелец", "Козерог", "Водолей", "Рыбы",
+}
+
+var signElements = [4]string{"Огонь", "Земля", "Воздух", "Вода"}
+
+// SignAt возвращает знак, в котором находится эклиптическая долгота
+func SignAt(longitude float64) Sign {
+	return Sign(int(norm(longitude) / 30))
+}
+
+// String возвращает название знака
+func (s Sign) String() string {
+	return signNames[s%12]
+}
+
+// Element возвращает стихию знака
+func (s Sign) Element() string {
+	return signElements[s%4]
+}
+
+// MarshalText позволяет отдавать знак в JSON названием
+func (s Sign) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText разбирает название знака
+func (s *Sign) UnmarshalText(text []byte) error {
+	for i, name := range signNames {
+		if name == string(text) {
+			*s = Sign(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("неизвестный знак зодиака %q", text)
+}
+
+// Phase - фаза Луны
+type Phase struct {
+	Name string `json:"name"`
+	// Illumination - освещенная доля диска, 0..1
+	Illumination float64 `json:"illumination"`
+}
+
+var phaseNames = [8]string{
+	"новолуние", "растущий серп", "первая четверть", "растущая Луна",
+	"полнолуние", "убывающая Луна", "последняя четверть", "убывающий серп",
+}
+
+// phaseAt определяет фазу по элонгации Луны от Солнца
+func phaseAt(sun, moon float64) Phase {
+	elongation := norm(moon - sun)
+	return Phase{
+		Name:         phaseNames[int(norm(elongation+22.5)/45)%8],
+		Illumination: math.Round((1-cos(elongation))/2*100) / 100,
+	}
+}
+
+// Chinese - знак китайского календаря
+type Chinese struct {
+	Animal  string `json:"animal"`
+	Element string `json:"element"`
+	Year    int    `json:"year"`
+}
+
+var chineseAnimals = [12]string{
+	"Крыса", "Бык", "Тигр", "Кролик", "Дракон", "Змея",
+	"Лошадь", "Коза", "Обезьяна", "Петух", "Собака", "Свинья",
+}
+
+var chineseElements = [5]string{"Дерево", "Огонь", "Земля", "Металл", "Вода"}
+
+// chineseSign возвращает знак года. Год считается с Личунь - момента,
+// когда Солнце проходит 315° эклиптической долготы (около 4 февраля).
+func chineseSign(year int, sunLon float64, month int) Chinese {
+	if month <= 2 && sunLon >= 270 && sunLon < 315 {
+		year--
+	}
+	cycle := ((year-4)%60 + 60) % 60
+	return Chinese{
+		Animal:  chineseAnimals[cycle%12],
+		Element: chineseElements[cycle%10/2],
+		Year:    year,
+	}
+}
+
+// Aspect - угловое соотношение планет
+type Aspect struct {
+	Name  string
+	Angle float64
+}
+
+// aspects - мажорные аспекты; орбис у всех одинаковый
+var aspects = []Aspect{
+	{"соединение", 0},
+	{"секстиль", 60},
+	{"квадрат", 90},
+	{"трин", 120},
+	{"оппозиция", 180},
+}
+
+// aspectOrb - допустимое отклонение аспекта, градусы
+const aspectOrb = 6
+
+// aspectBetween возвращает аспект между долготами или пустую строку
+func aspectBetween(a, b float64) string {
+	diff := norm(a - b)
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	for _, asp := range aspects {
+		if math.Abs(diff-asp.Angle) <= aspectOrb {
+			return asp.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+// Package bot - Telegram-бот, открывающий мини-приложение
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/horoscope"
+	"github.com/PtsPuf/telegram-mini-app/pkg/narration"
+	"github.com/PtsPuf/telegram-mini-app/pkg/referral"
+	"github.com/PtsPuf/telegram-mini-app/pkg/share"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// captionLimit - максимальная длина подписи к фото в Telegram
+const captionLimit = 1024
+
+// Bot - обертка над telebot с управляемым жизненным циклом
+type Bot struct {
+	cfg         config.TelegramConfig
+	tb          *tele.Bot
+	subs        *horoscope.Service
+	shares      *share.Service
+	referrals   *referral.Service
+	transcriber speech.Transcriber
+	usage       *usage.Tracker
+	predictor   Predictor
+	stt         config.STTConfig
+	narrations  *narration.Service
+	photo       config.PhotoConfig
+
+	mu      sync.Mutex
+	started bool
+	done    chan struct{}
+}
+
+// Predictor получает предсказание с изображениями, как POST /prediction
+type Predictor interface {
+	Predict(ctx context.Context, state *common.UserState) (*common.PredictionResponse, error)
+}
+
+// Options - необязательные возможности бота; nil отключает соответствующие команды
+type Options struct {
+	// Subs - команды подписки на ежедневный гороскоп
+	Subs *horoscope.Service
+	// Shares - inline-режим со ссылками на публикации, если у них есть внешний адрес
+	Shares *share.Service
+	// Referrals - код приглашения в /start и команда /invite
+	Referrals *referral.Service
+	// Transcriber, Usage и Predictor - предсказания по голосовым вопросам.
+	// Usage учитывает расход на распознавание и проверяет бюджет до него.
+	Transcriber speech.Transcriber
+	Usage       *usage.Tracker
+	Predictor   Predictor
+	// STT - ограничения голосовых сообщений
+	STT config.STTConfig
+	// Narrations - озвучка ответов на голосовые вопросы
+	Narrations *narration.Service
+	// Photo - предсказания по фотографии ладони или чашки, вместе с Predictor
+	Photo config.PhotoConfig
+}
+
+// New создает бота и регистрирует обработчики команд для включенных возможностей
+func New(cfg config.TelegramConfig, opts Options) (*Bot, error) {
+	tb, err := tele.NewBot(tele.Settings{
+		Token:  string(cfg.BotToken),
+		Poller: &tele.LongPoller{Timeout: cfg.PollTimeout},
+		OnError: func(err error, c tele.Context) {
+			slog.Error("bot handler failed", slog.String("error", err.Error()))
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания бота: %v", err)
+	}
+
+	b := &Bot{
+		cfg:         cfg,
+		tb:          tb,
+		subs:        opts.Subs,
+		shares:      opts.Shares,
+		referrals:   opts.Referrals,
+		transcriber: opts.Transcriber,
+		usage:       opts.Usage,
+		predictor:   opts.Predictor,
+		stt:         opts.STT,
+		narrations:  opts.Narrations,
+		photo:       opts.Photo,
+	}
+	tb.Handle("/start", b.handleStart)
+	if b.subs != nil {
+		tb.Handle("/subscribe", b.handleSubscribe)
+		tb.Handle("/unsubscribe", b.handleUnsubscribe)
+		tb.Handle("/skip", b.handleSkip)
+	}
+	if b.shares != nil && b.shares.BaseURL() != "" {
+		tb.Handle(tele.OnQuery, b.handleQuery)
+	}
+	if b.referrals != nil {
+		tb.Handle("/invite", b.handleInvite)
+	}
+	if b.transcriber != nil && b.usage != nil && b.predictor != nil {
+		tb.Handle(tele.OnVoice, b.handleVoice)
+	}
+	if b.photo.Enabled && b.predictor != nil {
+		tb.Handle(tele.OnPhoto, b.handlePhoto)
+	}
+	return b, nil
+}
+
+// Start запускает long polling в фоне
+func (b *Bot) Start() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.started {
+		return
+	}
+	b.started = true
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+		slog.Info("bot started", slog.String("username", b.tb.Me.Username))
+		b.tb.Start()
+	}()
+}
+
+// Stop останавливает поллер и ждет завершения обработки текущих обновлений
+func (b *Bot) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		return
+	}
+	b.tb.Stop()
+	<-b.done
+	b.started = false
+	slog.Info("bot stopped")
+}
+
+// handleStart отвечает кнопкой открытия мини-приложения. Ссылка
+// t.me/<bot>?start=ref_<код> привязывает нового пользователя к пригласившему.
+func (b *Bot) handleStart(c tele.Context) error {
+	greeting := "Привет! Нажми кнопку, чтобы получить предсказание."
+	if bonus, ok := b.attribute(c); ok && bonus > 0 {
+		greeting = fmt.Sprintf("Привет! Тебя пригласил друг, и тебе начислено бонусных предсказаний: %d. Нажми кнопку, чтобы получить предсказание.", bonus)
+	}
+	if b.cfg.WebAppURL == "" {
+		return c.Send("Привет! Я гадалка. Мини-приложение пока не настроено.")
+	}
+	markup := &tele.ReplyMarkup{}
+	markup.Inline(markup.Row(markup.WebApp("🔮 Открыть гадалку", &tele.WebApp{URL: b.cfg.WebAppURL})))
+	return c.Send(greeting, markup)
+}
+
+// subscribeUsage - подсказка по команде /subscribe
+const subscribeUsage = `Чтобы получать гороскоп каждый день, отправьте:
+/subscribe <дата рождения> [время ЧЧ:ММ] [город или часовой пояс]
+
+Например: /subscribe 15.03.1990 08:30 Новосибирск`
+
+// handleSubscribe оформляет подписку: /subscribe <дата> [ЧЧ:ММ] [город|tz].
+// Без аргументов возобновляет прежнюю подписку.
+func (b *Bot) handleSubscribe(c tele.Context) error {
+	now := time.Now()
+	args := c.Args()
+	sub := horoscope.Subscription{UserID: c.Sender().ID, Name: c.Sender().FirstName}
+
+	if len(args) == 0 {
+		prev, err := b.subs.Get(sub.UserID)
+		if errors.Is(err, horoscope.ErrNotSubscribed) {
+			return c.Send(subscribeUsage)
+		}
+		if err != nil {
+			return err
+		}
+		sub = *prev
+	} else {
+		sub.BirthDate = args[0]
+		rest := args[1:]
+		if len(rest) > 0 && strings.Contains(rest[0], ":") {
+			sub.DeliveryTime = rest[0]
+			rest = rest[1:]
+		}
+		sub.TimeZone = strings.Join(rest, " ")
+	}
+
+	stored, err := b.subs.Subscribe(sub, now)
+	if errors.Is(err, horoscope.ErrInvalidSubscription) {
+		return c.Send(fmt.Sprintf("%v\n\n%s", err, subscribeUsage))
+	}
+	if err != nil {
+		return err
+	}
+	return c.Send(fmt.Sprintf("Готово! Гороскоп для знака %s будет приходить каждый день в %s (%s).\nОтписаться: /unsubscribe, пропустить день: /skip",
+		stored.Sign(), stored.DeliveryTime, stored.TimeZone))
+}
+
+// handleUnsubscribe отключает подписку
+func (b *Bot) handleUnsubscribe(c tele.Context) error {
+	err := b.subs.Unsubscribe(c.Sender().ID, time.Now())
+	if errors.Is(err, horoscope.ErrNotSubscribed) {
+		return c.Send("У вас нет подписки на гороскоп.")
+	}
+	if err != nil {
+		return err
+	}
+	return c.Send("Подписка отключена. Вернуться можно командой /subscribe.")
+}
+
+// handleSkip пропускает ближайший гороскоп
+func (b *Bot) handleSkip(c tele.Context) error {
+	date, err := b.subs.Skip(c.Sender().ID, time.Now())
+	if errors.Is(err, horoscope.ErrNotSubscribed) {
+		return c.Send("У вас нет подписки на гороскоп.")
+	}
+	if err != nil {
+		return err
+	}
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		date = t.Format("02.01.2006")
+	}
+	return c.Send(fmt.Sprintf("Гороскоп на %s не придет.", date))
+}
+
+// SendReading отправляет гороскоп в личный чат и реализует horoscope.Sender.
+// Длинный текст, не помещающийся в подпись, отправляется отдельным сообщением.
+func (b *Bot) SendReading(chatID int64, text string, image []byte) error {
+	chat := &tele.Chat{ID: chatID}
+	var err error
+	switch {
+	case len(image) == 0:
+		_, err = b.tb.Send(chat, text)
+	case len([]rune(text)) <= captionLimit:
+		_, err = b.tb.Send(chat, &tele.Photo{File: tele.FromReader(bytes.NewReader(image)), Caption: text})
+	default:
+		if _, err = b.tb.Send(chat, &tele.Photo{File: tele.FromReader(bytes.NewReader(image))}); err == nil {
+			_, err = b.tb.Send(chat, text)
+		}
+	}
+	switch {
+	case errors.Is(err, tele.ErrBlockedByUser), errors.Is(err, tele.ErrChatNotFound),
+		errors.Is(err, tele.ErrUserIsDeactivated), errors.Is(err, tele.ErrNotStartedByUser):
+		return fmt.Errorf("%w: %v", horoscope.ErrRecipientGone, err)
+	}
+	return err
+}
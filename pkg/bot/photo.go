@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/photo"
+)
+
+// photoHelp - подсказка, если по подписи нельзя понять, что на снимке
+const photoHelp = "Пришлите фотографию ладони или кофейной чашки с подписью-вопросом, например:\n" +
+	"«Ладонь: что ждет меня в любви?» или «Кофе: чего ждать от новой работы?»"
+
+// photoKeywords - начала слов подписи, по которым выбирается сфера
+var photoKeywords = []struct {
+	mode  string
+	words []string
+}{
+	{photo.ModePalm, []string{"ладон", "хиромант", "рука", "руки", "руке", "руку"}},
+	{photo.ModeCoffee, []string{"кофе", "чашк", "гущ"}},
+}
+
+// photoMode определяет сферу предсказания по подписи к фотографии
+func photoMode(caption string) (string, bool) {
+	for _, word := range strings.Fields(strings.ToLower(caption)) {
+		word = strings.Trim(word, ".,:;!?«»\"'()")
+		for _, k := range photoKeywords {
+			for _, prefix := range k.words {
+				if strings.HasPrefix(word, prefix) {
+					return k.mode, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// handlePhoto отвечает на фотографию ладони или чашки предсказанием с картами
+func (b *Bot) handlePhoto(c tele.Context) error {
+	msg := c.Message()
+	mode, ok := photoMode(msg.Caption)
+	if !ok {
+		return c.Send(photoHelp)
+	}
+	if msg.Photo.FileSize > int64(b.photo.MaxSize) {
+		return c.Send(fmt.Sprintf("Фотография должна быть не больше %d МБ.", b.photo.MaxSize>>20))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), predictTimeout)
+	defer cancel()
+
+	c.Notify(tele.Typing)
+	data, err := b.download(&msg.Photo.File, b.photo.MaxSize+1)
+	if err != nil {
+		return err
+	}
+	prepared, err := photo.Prepare(data, b.photo.MaxSize, b.photo.MaxSide)
+	switch {
+	case errors.Is(err, photo.ErrTooLarge):
+		return c.Send(fmt.Sprintf("Фотография должна быть не больше %d МБ.", b.photo.MaxSize>>20))
+	case err != nil:
+		slog.Error("bot photo rejected", slog.String("error", err.Error()))
+		return c.Send("Не удалось прочитать фотографию, попробуйте прислать другую.")
+	}
+	if err := c.Send("Рассматриваю фотографию, это займет около минуты…"); err != nil {
+		return err
+	}
+
+	state := b.userState(c.Sender(), strings.TrimSpace(msg.Caption), mode)
+	state.Photo = prepared
+	_, err = b.predict(ctx, c, state)
+	return err
+}
@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/horoscope"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/moderation"
+)
+
+const (
+	// predictTimeout - время на подготовку вопроса и предсказание
+	predictTimeout = 5 * time.Minute
+	// messageLimit - максимальная длина текстового сообщения Telegram
+	messageLimit = 4096
+)
+
+// predict получает предсказание и отправляет его в чат. Если предсказание
+// не получено, пользователю уже отправлено объяснение и resp равен nil.
+func (b *Bot) predict(ctx context.Context, c tele.Context, state *common.UserState) (*common.PredictionResponse, error) {
+	metrics.PredictionRequests.WithLabelValues(metrics.Mode(state.Mode)).Inc()
+	c.Notify(tele.UploadingPhoto)
+	resp, err := b.predictor.Predict(ctx, state)
+	var blocked *moderation.BlockedError
+	switch {
+	case errors.As(err, &blocked):
+		return nil, c.Send(blocked.Message)
+	case errors.Is(err, common.ErrBudgetExceeded):
+		return nil, c.Send("Лимит предсказаний исчерпан, попробуйте позже.")
+	case errors.Is(err, common.ErrBanned):
+		return nil, c.Send("Доступ ограничен.")
+	case err != nil:
+		slog.Error("bot prediction failed", slog.String("mode", state.Mode), slog.String("error", err.Error()))
+		return nil, c.Send("Не удалось получить предсказание, попробуйте позже.")
+	}
+	return resp, b.sendPrediction(c, resp)
+}
+
+// download скачивает файл сообщения, читая не больше limit байт
+func (b *Bot) download(file *tele.File, limit int) ([]byte, error) {
+	rc, err := b.tb.File(file)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка загрузки файла: %v", err)
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, int64(limit)))
+}
+
+// userState - состояние предсказания по вопросу из чата. Имя и дата
+// рождения берутся из подписки на гороскоп, если она есть.
+func (b *Bot) userState(sender *tele.User, question, mode string) *common.UserState {
+	state := &common.UserState{
+		Name:       sender.FirstName,
+		Question:   question,
+		Mode:       mode,
+		TelegramID: sender.ID,
+	}
+	if b.subs != nil {
+		sub, err := b.subs.Get(sender.ID)
+		if err == nil {
+			state.Name, state.BirthDate = sub.Name, sub.BirthDate
+		} else if !errors.Is(err, horoscope.ErrNotSubscribed) {
+			slog.Error("subscription load failed", slog.String("error", err.Error()))
+		}
+	}
+	return state
+}
+
+// sendPrediction отправляет карты альбомом и текст предсказания, разбитый
+// на сообщения; к последнему добавляется кнопка мини-приложения
+func (b *Bot) sendPrediction(c tele.Context, resp *common.PredictionResponse) error {
+	if len(resp.Images) > 0 {
+		album := make(tele.Album, len(resp.Images))
+		for i, img := range resp.Images {
+			album[i] = &tele.Photo{File: tele.FromReader(bytes.NewReader(img))}
+		}
+		if err := c.SendAlbum(album); err != nil {
+			return err
+		}
+	}
+	parts := splitMessage(resp.Text, messageLimit)
+	for i, part := range parts {
+		var opts []any
+		if i == len(parts)-1 && b.cfg.WebAppURL != "" {
+			markup := &tele.ReplyMarkup{}
+			markup.Inline(markup.Row(markup.WebApp("🔮 Уточнить в приложении", &tele.WebApp{URL: b.cfg.WebAppURL})))
+			opts = append(opts, markup)
+		}
+		if err := c.Send(part, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitMessage делит текст на части не длиннее limit символов, по
+// возможности по границам абзацев, строк или слов
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	for text = strings.TrimSpace(text); text != ""; {
+		runes := []rune(text)
+		if len(runes) <= limit {
+			parts = append(parts, text)
+			break
+		}
+		head := string(runes[:limit])
+		cut := len(head)
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(head, sep); i > 0 {
+				cut = i
+				break
+			}
+		}
+		parts = append(parts, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+	return parts
+}
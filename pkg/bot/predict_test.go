@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/photo"
+)
+
+func TestSplitMessage(t *testing.T) {
+	paragraph := strings.Repeat("звезды ", 30)
+	text := paragraph + "\n\n" + paragraph + "\n\n" + paragraph
+	parts := splitMessage(text, 250)
+	if len(parts) != 3 {
+		t.Fatalf("parts = %d, want 3: %q", len(parts), parts)
+	}
+	for _, p := range parts {
+		if p != strings.TrimSpace(paragraph) {
+			t.Errorf("part = %q, want a whole paragraph", p)
+		}
+	}
+
+	// Текст без пробелов режется по символам, а не по байтам
+	parts = splitMessage(strings.Repeat("я", 25), 10)
+	if len(parts) != 3 || utf8.RuneCountInString(parts[0]) != 10 || parts[2] != "яяяяя" {
+		t.Errorf("parts = %q", parts)
+	}
+	if parts := splitMessage("  ", 10); len(parts) != 0 {
+		t.Errorf("empty text parts = %q", parts)
+	}
+}
+
+func TestPhotoMode(t *testing.T) {
+	tests := []struct {
+		caption string
+		want    string
+	}{
+		{"Ладонь: что ждет меня в любви?", photo.ModePalm},
+		{"Погадай по руке", photo.ModePalm},
+		{"«Кофе», стоит ли переезжать?", photo.ModeCoffee},
+		{"моя чашка после эспрессо", photo.ModeCoffee},
+		{"Что меня ждет?", ""},
+		{"Что скажет руководитель?", ""},
+	}
+	for _, tt := range tests {
+		if got, _ := photoMode(tt.caption); got != tt.want {
+			t.Errorf("photoMode(%q) = %q, want %q", tt.caption, got, tt.want)
+		}
+	}
+}
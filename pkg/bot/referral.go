@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/referral"
+)
+
+// attribute привязывает пользователя, пришедшего по /start ref_<код>, к
+// пригласившему и возвращает начисленный бонус
+func (b *Bot) attribute(c tele.Context) (int, bool) {
+	if b.referrals == nil {
+		return 0, false
+	}
+	code, ok := referral.ParseStartParam(c.Message().Payload)
+	if !ok {
+		return 0, false
+	}
+	_, err := b.referrals.Attribute(c.Sender().ID, code, "bot", time.Now())
+	outcome := referral.Outcome(err)
+	metrics.Referrals.WithLabelValues(outcome).Inc()
+	if outcome == "failed" {
+		slog.Error("referral attribution failed", slog.String("error", err.Error()))
+	}
+	if err != nil {
+		return 0, false
+	}
+	return b.referrals.InviteeBonus(), true
+}
+
+// handleInvite отвечает ссылкой-приглашением и статистикой приглашений
+func (b *Bot) handleInvite(c tele.Context) error {
+	stats, err := b.referrals.Stats(c.Sender().ID, time.Now())
+	if err != nil {
+		return err
+	}
+	username := b.cfg.BotUsername
+	if username == "" {
+		username = b.tb.Me.Username
+	}
+	link := referral.Link(username, stats.Code)
+	if link == "" {
+		return c.Send("Приглашения пока не настроены.")
+	}
+	return c.Send(fmt.Sprintf(`Пригласите друзей по ссылке:
+%s
+
+Когда друг получит первое предсказание, вам начислятся бонусные предсказания.
+
+Приглашено: %d
+Получили предсказание: %d
+Заработано бонусов: %d`, link, stats.Invited, stats.Activated, stats.Earned), &tele.SendOptions{DisableWebPagePreview: true})
+}
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/share"
+)
+
+const (
+	// inlineResults - сколько последних публикаций показывать в inline-режиме
+	inlineResults = 10
+	// excerptLength - длина отрывка предсказания в сообщении со ссылкой
+	excerptLength = 300
+)
+
+// handleQuery отвечает на inline-запрос публикациями пользователя, чтобы
+// отправить предсказание в любой чат через @бота
+func (b *Bot) handleQuery(c tele.Context) error {
+	shares, err := b.shares.ForUser(c.Sender().ID, inlineResults)
+	if err != nil {
+		return err
+	}
+	results := make(tele.Results, 0, len(shares))
+	for _, sh := range shares {
+		results = append(results, ShareResult(sh, b.shares.URL("", sh.Slug)))
+	}
+	// Без публикаций клиент показывает кнопку перехода к боту
+	resp := &tele.QueryResponse{Results: results, IsPersonal: true, CacheTime: 10}
+	if len(results) == 0 {
+		resp.SwitchPMText = "Сначала поделитесь предсказанием в приложении"
+		resp.SwitchPMParameter = "share"
+	}
+	return c.Answer(resp)
+}
+
+// ShareResult - сообщение со ссылкой на публикацию для inline-режима и
+// подготовленных сообщений; превью строится по тегам Open Graph страницы
+func ShareResult(sh *share.Share, url string) *tele.ArticleResult {
+	markup := &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{{{Text: "🔮 Читать предсказание", URL: url}}}}
+	r := &tele.ArticleResult{
+		ResultBase: tele.ResultBase{
+			ID:   sh.Slug,
+			Type: "article",
+			Content: &tele.InputTextMessageContent{
+				Text: fmt.Sprintf("%s\n\n%s\n\n%s", sh.Title(), share.Excerpt(sh.Text, excerptLength), url),
+			},
+			ReplyMarkup: markup,
+		},
+		Title:       sh.Title(),
+		Description: share.Excerpt(sh.Text, 100),
+		URL:         url,
+		HideURL:     true,
+	}
+	if len(sh.Image) > 0 {
+		r.ThumbURL = url + "/image.jpg"
+	}
+	return r
+}
+
+// Preparer сохраняет подготовленные inline-сообщения, которые мини-приложение
+// отправляет в выбранный пользователем чат через Telegram.WebApp.shareMessage.
+// Работает без поллера и может использоваться в serverless.
+type Preparer struct {
+	tb *tele.Bot
+}
+
+// NewPreparer создает клиент Bot API без обращения к сети
+func NewPreparer(cfg config.TelegramConfig) (*Preparer, error) {
+	tb, err := tele.NewBot(tele.Settings{Token: string(cfg.BotToken), Offline: true})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания клиента бота: %v", err)
+	}
+	return &Preparer{tb: tb}, nil
+}
+
+// Prepare сохраняет сообщение со ссылкой на публикацию для пользователя
+// userID и возвращает его идентификатор
+func (p *Preparer) Prepare(userID int64, sh *share.Share, url string) (string, error) {
+	data, err := p.tb.Raw("savePreparedInlineMessage", map[string]any{
+		"user_id":             userID,
+		"result":              ShareResult(sh, url),
+		"allow_user_chats":    true,
+		"allow_bot_chats":     true,
+		"allow_group_chats":   true,
+		"allow_channel_chats": true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ошибка подготовки сообщения: %v", err)
+	}
+	var resp struct {
+		Result struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("ошибка разбора ответа Bot API: %v", err)
+	}
+	return resp.Result.ID, nil
+}
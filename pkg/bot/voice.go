@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// voiceMode - сфера предсказания по голосовому вопросу
+const voiceMode = "Другое"
+
+// handleVoice распознает голосовой вопрос и отвечает предсказанием с картами
+func (b *Bot) handleVoice(c tele.Context) error {
+	voice := c.Message().Voice
+	if time.Duration(voice.Duration)*time.Second > b.stt.MaxDuration || voice.FileSize > int64(b.stt.MaxSize) {
+		return c.Send(fmt.Sprintf("Голосовой вопрос должен быть не длиннее %s.", formatDuration(b.stt.MaxDuration)))
+	}
+	user := usage.User(c.Sender().ID)
+	// Распознавание платное, поэтому бюджет проверяется до него
+	if _, err := b.usage.Model(user); errors.Is(err, usage.ErrBudgetExceeded) {
+		metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+		return c.Send("Лимит предсказаний исчерпан, попробуйте позже.")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), predictTimeout)
+	defer cancel()
+
+	c.Notify(tele.Typing)
+	audio, err := b.download(&voice.File, b.stt.MaxSize)
+	if err != nil {
+		return err
+	}
+	question, err := b.transcriber.Transcribe(ctx, audio, "voice.ogg")
+	if err == nil || errors.Is(err, speech.ErrNoSpeech) {
+		b.usage.RecordSTT(ctx, user, speech.AudioDuration(audio))
+	}
+	if errors.Is(err, speech.ErrNoSpeech) {
+		return c.Send("Не удалось разобрать вопрос. Попробуйте записать его еще раз.")
+	}
+	if err != nil {
+		slog.Error("voice transcription failed", slog.String("error", err.Error()))
+		return c.Send("Не удалось распознать речь, попробуйте позже.")
+	}
+	if err := c.Send(fmt.Sprintf("Ваш вопрос: «%s»\nГотовлю предсказание, это займет около минуты…", question)); err != nil {
+		return err
+	}
+
+	resp, err := b.predict(ctx, c, b.userState(c.Sender(), question, voiceMode))
+	if err != nil || resp == nil {
+		return err
+	}
+	// На голосовой вопрос бот отвечает и голосом, если озвучивание настроено
+	if b.narrations != nil && resp.ConversationID != "" && resp.Moderation == "" {
+		b.sendNarration(ctx, c, resp)
+	}
+	return nil
+}
+
+// sendNarration озвучивает предсказание и отправляет голосовым сообщением.
+// Текст уже отправлен, поэтому ошибка озвучки только записывается в журнал.
+func (b *Bot) sendNarration(ctx context.Context, c tele.Context, resp *common.PredictionResponse) {
+	c.Notify(tele.RecordingAudio)
+	n, _, err := b.narrations.Narrate(ctx, resp.ConversationID, c.Sender().ID, resp.Text)
+	if err != nil {
+		metrics.Narrations.WithLabelValues("failed").Inc()
+		slog.Error("voice narration failed", slog.String("error", err.Error()))
+		return
+	}
+	metrics.Narrations.WithLabelValues("created").Inc()
+	voice := &tele.Voice{
+		File:     tele.FromReader(bytes.NewReader(n.Audio)),
+		Duration: int(n.Duration.Round(time.Second) / time.Second),
+		MIME:     "audio/ogg",
+	}
+	if err := c.Send(voice); err != nil {
+		slog.Error("voice narration send failed", slog.String("error", err.Error()))
+	}
+}
+
+// formatDuration - длительность в минутах или секундах для сообщений
+func formatDuration(d time.Duration) string {
+	if d >= time.Minute && d%time.Minute == 0 {
+		return fmt.Sprintf("%d мин", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%d с", int(d/time.Second))
+}
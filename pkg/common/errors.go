@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+)
+
+var (
+	// ErrGenerationFailed - Kandinsky вернул статус FAILED
+	ErrGenerationFailed = errors.New("генерация не удалась")
+	// ErrGenerationTimeout - изображение не готово за отведенное число опросов
+	ErrGenerationTimeout = errors.New("превышено время ожидания генерации")
+	// ErrNoImage - задача завершилась без изображения
+	ErrNoImage = errors.New("изображение не сгенерировано")
+	// ErrBudgetExceeded - лимит расходов пользователя исчерпан
+	ErrBudgetExceeded = errors.New("лимит расходов исчерпан")
+	// ErrContentBlocked - вопрос или ответ отклонен политикой модерации
+	ErrContentBlocked = errors.New("запрос отклонен модерацией")
+	// ErrBanned - пользователь заблокирован администратором
+	ErrBanned = errors.New("доступ ограничен")
+)
+
+// UpstreamError - ошибочный ответ внешнего API
+type UpstreamError struct {
+	Service    string
+	StatusCode int
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("%s API request failed with status code %d: %s", e.Service, e.StatusCode, e.Message)
+}
+
+// Transient сообщает, что запрос стоит повторить: API перегружен или недоступен
+func (e *UpstreamError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// ErrorCode возвращает короткий код ошибки для метрик и логов
+func ErrorCode(err error) string {
+	var upstream *UpstreamError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrBudgetExceeded):
+		return "budget_exceeded"
+	case errors.Is(err, ErrContentBlocked):
+		return "moderation_blocked"
+	case errors.Is(err, ErrBanned):
+		return "banned"
+	case errors.Is(err, resilience.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrGenerationTimeout):
+		return "image_timeout"
+	case errors.Is(err, ErrGenerationFailed), errors.Is(err, ErrNoImage):
+		return "image_failed"
+	case errors.As(err, &upstream):
+		switch {
+		case upstream.StatusCode == 429:
+			return upstream.Service + "_rate_limited"
+		case upstream.StatusCode >= 500:
+			return upstream.Service + "_5xx"
+		default:
+			return upstream.Service + "_4xx"
+		}
+	}
+	return "internal"
+}
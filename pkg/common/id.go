@@ -0,0 +1,15 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// NewID возвращает случайный непредсказуемый идентификатор
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,77 +2,155 @@ package common
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
-	"os"
+	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+)
+
+const (
+	// kandinskyStatusInitial - статус задачи, еще не взятой в обработку
+	kandinskyStatusInitial = "INITIAL"
+	// taskTimingTTL - сколько помнить время постановки незавершенной задачи
+	taskTimingTTL = time.Hour
 )
 
-// GenerateKandinskyImage генерирует изображение с помощью Kandinsky API
-func GenerateKandinskyImage(prompt string) ([]byte, error) {
-	log.Printf("Начало генерации изображения: %s", prompt)
+// KandinskyClient - клиент Kandinsky (Fusion Brain) API
+type KandinskyClient struct {
+	cfg        config.KandinskyConfig
+	baseURL    string
+	httpClient *http.Client
+	policy     resilience.Policy
+	breaker    *resilience.Breaker
 
-	uuid, err := createGenerationTask(prompt)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка создания задачи: %v", err)
+	// tasks хранит время постановки задач для метрик очереди и генерации
+	tasks sync.Map
+}
+
+// taskTiming - моменты жизни задачи Kandinsky, известные этому процессу
+type taskTiming struct {
+	created time.Time
+	started bool
+}
+
+// NewKandinskyClient создает клиент по настройкам Kandinsky. Постановка
+// задачи (пока UUID не получен) и опрос статуса повторяются при временных
+// ошибках, включая таймауты.
+func NewKandinskyClient(cfg config.KandinskyConfig, res config.ResilienceConfig) *KandinskyClient {
+	return &KandinskyClient{
+		cfg:     cfg,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(nil),
+		},
+		policy: resilience.Policy{
+			Attempts:      res.Attempts,
+			BaseDelay:     res.BaseDelay,
+			MaxDelay:      res.MaxDelay,
+			RetryTimeouts: true,
+		},
+		breaker: resilience.NewBreaker("kandinsky", res.BreakerThreshold, res.BreakerCooldown),
 	}
+}
 
-	log.Printf("Задача создана, UUID: %s", uuid)
+// GenerateImage генерирует изображение с помощью Kandinsky API и ждет результата
+func (c *KandinskyClient) GenerateImage(ctx context.Context, prompt string) (image []byte, err error) {
+	ctx, span := tracing.Start(ctx, "GenerateImage", tracing.ImageAttrs(ctx)...)
+	defer func() { tracing.End(span, err) }()
+
+	uuid, err := c.CreateTask(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания задачи: %w", err)
+	}
 
 	// Ждем завершения генерации
 	var imageData []byte
-	for i := 0; i < 30; i++ { // Максимум 30 попыток (5 минут)
-		status, err := checkGenerationStatus(uuid)
+	for i := 0; i < c.cfg.MaxPolls; i++ {
+		status, err := c.CheckStatus(ctx, uuid)
 		if err != nil {
-			return nil, fmt.Errorf("ошибка проверки статуса: %v", err)
+			return nil, fmt.Errorf("ошибка проверки статуса: %w", err)
 		}
+		slog.DebugContext(ctx, "kandinsky status",
+			slog.String("uuid", uuid),
+			slog.String("status", status.Status),
+			slog.Int("attempt", i+1),
+		)
 
-		if status.Status == "DONE" {
-			if len(status.Images) == 0 {
-				return nil, fmt.Errorf("изображение не сгенерировано")
-			}
-
-			// Декодируем base64 в байты
-			imageData, err = base64.StdEncoding.DecodeString(status.Images[0])
-			if err != nil {
-				return nil, fmt.Errorf("ошибка декодирования изображения: %v", err)
-			}
+		imageData, err = DecodeImage(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+		if imageData != nil {
 			break
-		} else if status.Status == "FAILED" {
-			return nil, fmt.Errorf("генерация не удалась: %s", status.Error)
 		}
 
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.cfg.PollInterval):
+		}
 	}
 
 	if imageData == nil {
-		return nil, fmt.Errorf("превышено время ожидания генерации")
+		c.tasks.Delete(uuid)
+		return nil, ErrGenerationTimeout
 	}
 
 	return imageData, nil
 }
 
-func createGenerationTask(prompt string) (string, error) {
-	apiKey := os.Getenv("KANDINSKY_API_KEY")
-	secret := os.Getenv("KANDINSKY_SECRET")
-	apiURL := os.Getenv("KANDINSKY_URL")
-
-	if apiKey == "" || secret == "" || apiURL == "" {
-		return "", fmt.Errorf("не установлены переменные окружения для Kandinsky API")
+// DecodeImage декодирует изображение готовой задачи в отдельном спане:
+// base64 для 1024x1024 занимает заметное время
+func DecodeImage(ctx context.Context, status *KandinskyStatusResponse) ([]byte, error) {
+	if status.Status != KandinskyStatusDone {
+		return status.Image()
 	}
+	_, span := tracing.Start(ctx, "decodeImage", tracing.ImageAttrs(ctx)...)
+	data, err := status.Image()
+	span.SetAttributes(attribute.Int("image.bytes", len(data)))
+	tracing.End(span, err)
+	return data, err
+}
+
+// PollInterval возвращает рекомендуемый интервал между проверками статуса
+func (c *KandinskyClient) PollInterval() time.Duration {
+	return c.cfg.PollInterval
+}
+
+// CreateTask ставит задачу генерации в очередь и возвращает ее UUID
+func (c *KandinskyClient) CreateTask(ctx context.Context, prompt string) (uuid string, err error) {
+	ctx, span := tracing.Start(ctx, "createGenerationTask", append(tracing.ImageAttrs(ctx),
+		attribute.Int("image.prompt_length", len(prompt)),
+		attribute.Int("image.width", c.cfg.Width),
+		attribute.Int("image.height", c.cfg.Height),
+	)...)
+	defer func() {
+		span.SetAttributes(attribute.String("kandinsky.uuid", uuid))
+		tracing.End(span, err)
+	}()
 
 	// Создаем запрос
 	reqBody := KandinskyGenerateRequest{
 		Type:      "GENERATE",
 		NumImages: 1,
-		Width:     1024,
-		Height:    1024,
+		Width:     c.cfg.Width,
+		Height:    c.cfg.Height,
 	}
 	reqBody.GenerateParams.Query = prompt
 
@@ -80,16 +158,8 @@ func createGenerationTask(prompt string) (string, error) {
 	var b bytes.Buffer
 	writer := multipart.NewWriter(&b)
 
-	// Добавляем API ключ
-	err := writer.WriteField("key", apiKey)
-	if err != nil {
-		return "", fmt.Errorf("ошибка записи API ключа: %v", err)
-	}
-
-	// Добавляем секрет
-	err = writer.WriteField("secret", secret)
-	if err != nil {
-		return "", fmt.Errorf("ошибка записи секрета: %v", err)
+	if err := c.writeCredentials(writer); err != nil {
+		return "", err
 	}
 
 	// Добавляем параметры запроса как JSON
@@ -111,52 +181,84 @@ func createGenerationTask(prompt string) (string, error) {
 		return "", fmt.Errorf("ошибка закрытия формы: %v", err)
 	}
 
-	// Отправляем запрос
-	req, err := http.NewRequest("POST", apiURL+"/key/api/v1/text2image/run", &b)
+	start := time.Now()
+	err = resilience.Do(ctx, c.policy, c.breaker, func(ctx context.Context) (err error) {
+		uuid, err = c.run(ctx, writer.FormDataContentType(), b.Bytes())
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("ошибка создания запроса: %v", err)
+		return "", err
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	c.forgetStale(start)
+	c.tasks.Store(uuid, &taskTiming{created: start})
+	slog.InfoContext(ctx, "kandinsky task created",
+		slog.String("uuid", uuid),
+		slog.String("prompt", prompt),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+	)
+	return uuid, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// run выполняет одну попытку постановки задачи и возвращает ее UUID
+func (c *KandinskyClient) run(ctx context.Context, contentType string, form []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/key/api/v1/text2image/run", bytes.NewReader(form))
 	if err != nil {
-		return "", fmt.Errorf("ошибка отправки запроса: %v", err)
+		return "", fmt.Errorf("ошибка создания запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	logging.Propagate(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка отправки запроса: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if err := upstreamError(resp); err != nil {
+		return "", err
+	}
+
 	var result KandinskyStatusResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", fmt.Errorf("ошибка декодирования ответа: %v", err)
 	}
-
 	if result.Error != "" {
 		return "", fmt.Errorf("ошибка API: %s", result.Error)
 	}
-
 	return result.UUID, nil
 }
 
-func checkGenerationStatus(uuid string) (*KandinskyStatusResponse, error) {
-	apiKey := os.Getenv("KANDINSKY_API_KEY")
-	secret := os.Getenv("KANDINSKY_SECRET")
-	apiURL := os.Getenv("KANDINSKY_URL")
+// upstreamError возвращает ошибку для ответов 429 и 5xx, которые стоит повторить
+func upstreamError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		body, _ := io.ReadAll(resp.Body)
+		return &UpstreamError{Service: "kandinsky", StatusCode: resp.StatusCode, Message: truncate(string(body), 500)}
+	}
+	return nil
+}
+
+// CheckStatus однократно запрашивает статус задачи генерации
+func (c *KandinskyClient) CheckStatus(ctx context.Context, uuid string) (status *KandinskyStatusResponse, err error) {
+	ctx, span := tracing.Start(ctx, "checkGenerationStatus", append(tracing.ImageAttrs(ctx),
+		attribute.String("kandinsky.uuid", uuid),
+	)...)
+	defer func() {
+		if status != nil {
+			span.SetAttributes(
+				attribute.String("kandinsky.status", status.Status),
+				attribute.Bool("kandinsky.censored", status.Censored),
+			)
+		}
+		tracing.End(span, err)
+	}()
 
 	// Создаем multipart форму
 	var b bytes.Buffer
 	writer := multipart.NewWriter(&b)
 
-	// Добавляем API ключ
-	err := writer.WriteField("key", apiKey)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка записи API ключа: %v", err)
-	}
-
-	// Добавляем секрет
-	err = writer.WriteField("secret", secret)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка записи секрета: %v", err)
+	if err := c.writeCredentials(writer); err != nil {
+		return nil, err
 	}
 
 	// Добавляем UUID
@@ -170,30 +272,145 @@ func checkGenerationStatus(uuid string) (*KandinskyStatusResponse, error) {
 		return nil, fmt.Errorf("ошибка закрытия формы: %v", err)
 	}
 
-	// Отправляем запрос
-	req, err := http.NewRequest("POST", apiURL+"/key/api/v1/text2image/status", &b)
+	err = resilience.Do(ctx, c.policy, c.breaker, func(ctx context.Context) (err error) {
+		status, err = c.status(ctx, writer.FormDataContentType(), b.Bytes())
+		if err != nil {
+			metrics.ImagePolls.WithLabelValues("error").Inc()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.ImagePolls.WithLabelValues("ok").Inc()
+	c.observe(uuid, status)
+	return status, nil
+}
+
+// status выполняет одну попытку запроса статуса задачи
+func (c *KandinskyClient) status(ctx context.Context, contentType string, form []byte) (*KandinskyStatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/key/api/v1/text2image/status", bytes.NewReader(form))
 	if err != nil {
 		return nil, fmt.Errorf("ошибка создания запроса: %v", err)
 	}
+	req.Header.Set("Content-Type", contentType)
+	logging.Propagate(req)
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка отправки запроса: %v", err)
+		return nil, fmt.Errorf("ошибка отправки запроса: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if err := upstreamError(resp); err != nil {
+		return nil, err
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения ответа: %v", err)
+		return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
 	}
 
 	var result KandinskyStatusResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("ошибка декодирования ответа: %v, тело: %s", err, string(body))
+		return nil, fmt.Errorf("ошибка декодирования ответа: %v, тело: %s", err, truncate(string(body), 500))
 	}
-
 	return &result, nil
 }
+
+// forgetStale удаляет задачи, которые так и не дошли до финального статуса
+// (например, брошенные по таймауту опроса)
+func (c *KandinskyClient) forgetStale(now time.Time) {
+	c.tasks.Range(func(key, v any) bool {
+		if now.Sub(v.(*taskTiming).created) > taskTimingTTL {
+			c.tasks.Delete(key)
+		}
+		return true
+	})
+}
+
+// observe записывает метрики по очередному статусу задачи.
+// Время известно только для задач, созданных этим процессом.
+func (c *KandinskyClient) observe(uuid string, status *KandinskyStatusResponse) {
+	if status.Censored {
+		metrics.ImagesCensored.Inc()
+	}
+	v, ok := c.tasks.Load(uuid)
+	if !ok {
+		return
+	}
+	timing := v.(*taskTiming)
+	elapsed := time.Since(timing.created).Seconds()
+
+	if status.Status != kandinskyStatusInitial && !timing.started {
+		timing.started = true
+		metrics.ImageQueueDuration.Observe(elapsed)
+	}
+	switch status.Status {
+	case KandinskyStatusDone, KandinskyStatusFailed:
+		metrics.ImageGenerationDuration.WithLabelValues(strings.ToLower(status.Status)).Observe(elapsed)
+		c.tasks.Delete(uuid)
+	}
+}
+
+// Availability проверяет, принимает ли сервис новые задачи.
+// Во время перегрузки или обслуживания API возвращает model_status
+// (например, DISABLED_BY_QUEUE).
+func (c *KandinskyClient) Availability(ctx context.Context) error {
+	var b bytes.Buffer
+	writer := multipart.NewWriter(&b)
+	if err := c.writeCredentials(writer); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("ошибка закрытия формы: %v", err)
+	}
+
+	// Проверка идет через автомат: пока он разомкнут, готовность сразу
+	// отрицательна, а успешный ответ замыкает его
+	return c.breaker.Call(ctx, func(ctx context.Context) error {
+		return c.availability(ctx, writer.FormDataContentType(), &b)
+	})
+}
+
+func (c *KandinskyClient) availability(ctx context.Context, contentType string, form io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/key/api/v1/text2image/availability", form)
+	if err != nil {
+		return fmt.Errorf("ошибка создания запроса: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	logging.Propagate(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка отправки запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := upstreamError(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("сервис вернул статус %d", resp.StatusCode)
+	}
+
+	var result KandinskyAvailabilityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+	if result.ModelStatus != "" {
+		return fmt.Errorf("генерация недоступна: %s", result.ModelStatus)
+	}
+	return nil
+}
+
+// writeCredentials добавляет API ключ и секрет в форму запроса
+func (c *KandinskyClient) writeCredentials(writer *multipart.Writer) error {
+	if err := writer.WriteField("key", string(c.cfg.APIKey)); err != nil {
+		return fmt.Errorf("ошибка записи API ключа: %v", err)
+	}
+	if err := writer.WriteField("secret", string(c.cfg.Secret)); err != nil {
+		return fmt.Errorf("ошибка записи секрета: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+)
+
+func newKandinsky(t *testing.T) (*testutil.Kandinsky, *common.KandinskyClient) {
+	fake := testutil.NewKandinsky(t)
+	cfg := testutil.Config(testutil.NewOpenRouter(t), fake)
+	cfg.Kandinsky.MaxPolls = 3
+	return fake, common.NewKandinskyClient(cfg.Kandinsky, cfg.Resilience)
+}
+
+func TestGenerateImage(t *testing.T) {
+	fake, client := newKandinsky(t)
+	image := []byte("image bytes")
+	fake.Push(testutil.Task{Pending: 2, Image: image})
+
+	got, err := client.GenerateImage(context.Background(), "a red circle")
+	if err != nil {
+		t.Fatalf("GenerateImage: %v", err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Errorf("image = %q, want %q", got, image)
+	}
+	if prompts := fake.Prompts(); len(prompts) != 1 || prompts[0] != "a red circle" {
+		t.Errorf("prompts = %q", prompts)
+	}
+}
+
+func TestGenerateImageErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		task testutil.Task
+		want error
+		code string
+	}{
+		{"failed", testutil.Task{Status: common.KandinskyStatusFailed, Error: "oops"}, common.ErrGenerationFailed, "image_failed"},
+		{"censored", testutil.Task{Censored: true}, common.ErrNoImage, "image_failed"},
+		{"timeout", testutil.Task{Pending: 10}, common.ErrGenerationTimeout, "image_timeout"},
+		{"rate limited", testutil.Task{RunStatus: http.StatusTooManyRequests}, nil, "kandinsky_rate_limited"},
+		{"server error", testutil.Task{RunStatus: http.StatusInternalServerError}, nil, "kandinsky_5xx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newKandinsky(t)
+			fake.Default = tt.task
+
+			_, err := client.GenerateImage(context.Background(), "prompt")
+			if err == nil {
+				t.Fatal("GenerateImage succeeded, want error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if code := common.ErrorCode(err); code != tt.code {
+				t.Errorf("ErrorCode = %s, want %s", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestGenerateImageRetry(t *testing.T) {
+	fake, client := newKandinsky(t)
+	image := []byte("image bytes")
+	fake.Push(testutil.Task{RunStatus: http.StatusServiceUnavailable}, testutil.Task{Image: image})
+
+	got, err := client.GenerateImage(context.Background(), "prompt")
+	if err != nil {
+		t.Fatalf("GenerateImage: %v", err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Errorf("image = %q, want %q", got, image)
+	}
+}
+
+func TestGenerateImageCanceled(t *testing.T) {
+	fake, client := newKandinsky(t)
+	fake.Push(testutil.Task{Delay: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := client.GenerateImage(ctx, "prompt")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	fake, client := newKandinsky(t)
+	if err := client.Availability(context.Background()); err != nil {
+		t.Fatalf("Availability: %v", err)
+	}
+	fake.ModelStatus = "DISABLED_BY_QUEUE"
+	if err := client.Availability(context.Background()); err == nil {
+		t.Error("Availability succeeded with DISABLED_BY_QUEUE")
+	}
+}
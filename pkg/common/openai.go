@@ -2,17 +2,31 @@ package common
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
 )
 
+// OpenAIClient - клиент OpenAI-совместимого API (OpenRouter)
 type OpenAIClient struct {
-	apiKey     string
+	cfg        config.OpenRouterConfig
 	httpClient *http.Client
+	policy     resilience.Policy
+	breaker    *resilience.Breaker
 }
 
 type OpenAIRequest struct {
@@ -22,11 +36,93 @@ type OpenAIRequest struct {
 	MaxTokens   int             `json:"max_tokens"`
 }
 
+// OpenAIMessage - сообщение диалога. Если есть Images, content отправляется
+// массивом частей: текст и изображения для моделей с поддержкой зрения.
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string
+	Content string
+	// Images - адреса изображений: https или data URL
+	Images []string
+}
+
+// ContentPart - часть составного content: текст или изображение
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL - изображение в части content
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+// Типы частей content
+const (
+	PartText     = "text"
+	PartImageURL = "image_url"
+)
+
+// wireMessage - сообщение в формате API, content - строка или массив частей
+type wireMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
 }
 
+// MarshalJSON записывает content строкой или, при наличии изображений, массивом частей
+func (m OpenAIMessage) MarshalJSON() ([]byte, error) {
+	var content any = m.Content
+	if len(m.Images) > 0 {
+		parts := []ContentPart{{Type: PartText, Text: m.Content}}
+		for _, url := range m.Images {
+			parts = append(parts, ContentPart{Type: PartImageURL, ImageURL: &ImageURL{URL: url}})
+		}
+		content = parts
+	}
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wireMessage{Role: m.Role, Content: raw})
+}
+
+// UnmarshalJSON читает content в обоих форматах; тексты частей склеиваются
+func (m *OpenAIMessage) UnmarshalJSON(data []byte) error {
+	var w wireMessage
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*m = OpenAIMessage{Role: w.Role}
+	if len(w.Content) == 0 || string(w.Content) == "null" {
+		return nil
+	}
+	if w.Content[0] == '"' {
+		return json.Unmarshal(w.Content, &m.Content)
+	}
+	var parts []ContentPart
+	if err := json.Unmarshal(w.Content, &parts); err != nil {
+		return err
+	}
+	var texts []string
+	for _, p := range parts {
+		switch {
+		case p.Type == PartText:
+			texts = append(texts, p.Text)
+		case p.Type == PartImageURL && p.ImageURL != nil:
+			m.Images = append(m.Images, p.ImageURL.URL)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// Роли сообщений диалога
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
 type OpenAIResponse struct {
 	ID      string `json:"id"`
 	Object  string `json:"object"`
@@ -40,81 +136,235 @@ type OpenAIResponse struct {
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// Usage - расход токенов по данным OpenRouter
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatCompletion - результат запроса к модели
+type ChatCompletion struct {
+	Content string
+	// Model - модель, фактически ответившая на запрос
+	Model string
+	Usage Usage
 }
 
-func NewOpenAIClient(apiKey string) *OpenAIClient {
+// NewOpenAIClient создает клиент по настройкам OpenRouter. Ответ модели
+// занимает до минуты, поэтому запросы, прерванные таймаутом, не повторяются.
+func NewOpenAIClient(cfg config.OpenRouterConfig, res config.ResilienceConfig) *OpenAIClient {
 	return &OpenAIClient{
-		apiKey: apiKey,
+		cfg: cfg,
 		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(nil),
 		},
+		policy:  resilience.Policy{Attempts: res.Attempts, BaseDelay: res.BaseDelay, MaxDelay: res.MaxDelay},
+		breaker: resilience.NewBreaker("openrouter", res.BreakerThreshold, res.BreakerCooldown),
 	}
 }
 
-func (c *OpenAIClient) CreateChatCompletion(prompt string) (string, error) {
-	log.Printf("Starting chat completion with prompt length: %d", len(prompt))
+// CreateChatCompletion отправляет промпт модели по умолчанию и возвращает ответ с расходом токенов
+func (c *OpenAIClient) CreateChatCompletion(ctx context.Context, prompt string) (*ChatCompletion, error) {
+	return c.CreateChatCompletionWithModel(ctx, c.cfg.Model, prompt)
+}
+
+// CreateChatCompletionWithModel отправляет промпт указанной модели,
+// например более дешевой после исчерпания бюджета
+func (c *OpenAIClient) CreateChatCompletionWithModel(ctx context.Context, model, prompt string) (*ChatCompletion, error) {
+	return c.CreateChatCompletionMessages(ctx, model, []OpenAIMessage{{Role: RoleUser, Content: prompt}})
+}
+
+// CreateChatCompletionMessages отправляет модели историю диалога
+func (c *OpenAIClient) CreateChatCompletionMessages(ctx context.Context, model string, messages []OpenAIMessage) (completion *ChatCompletion, err error) {
+	promptLen := 0
+	for _, m := range messages {
+		promptLen += len(m.Content)
+	}
+	ctx, span := tracing.Start(ctx, "CreateChatCompletion",
+		attribute.String("llm.model", model),
+		attribute.Int("llm.prompt_length", promptLen),
+		attribute.Int("llm.messages", len(messages)),
+	)
+	defer func() { tracing.End(span, err) }()
 
-	requestURL := "https://openrouter.ai/api/v1/chat/completions"
 	requestBody := OpenAIRequest{
-		Model: "anthropic/claude-3-haiku",
-		Messages: []OpenAIMessage{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		Temperature: 0.7,
-		MaxTokens:   4000,
+		Model:       model,
+		Messages:    messages,
+		Temperature: c.cfg.Temperature,
+		MaxTokens:   c.cfg.MaxTokens,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	log.Printf("Sending request to OpenRouter API, data size: %d bytes", len(jsonData))
-	req, err := http.NewRequest("POST", requestURL, bytes.NewBuffer(jsonData))
+	slog.DebugContext(ctx, "openrouter request",
+		slog.String("model", model),
+		slog.Int("messages", len(messages)),
+		slog.Int("prompt_len", promptLen),
+		slog.Int("request_bytes", len(jsonData)),
+	)
+	var body []byte
+	err = resilience.Do(ctx, c.policy, c.breaker, func(ctx context.Context) (err error) {
+		body, err = c.complete(ctx, model, jsonData)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, err
+	}
+
+	var openAIResponse OpenAIResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		slog.ErrorContext(ctx, "openrouter response decode failed",
+			slog.String("error", err.Error()),
+			slog.String("body", string(body)),
+		)
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+
+	if len(openAIResponse.Choices) == 0 {
+		slog.ErrorContext(ctx, "openrouter response has no choices", slog.String("body", string(body)))
+		return nil, fmt.Errorf("no choices in response")
+	}
+
+	completion = &ChatCompletion{
+		Content: openAIResponse.Choices[0].Message.Content,
+		Model:   openAIResponse.Model,
+		Usage:   openAIResponse.Usage,
+	}
+	if completion.Model == "" {
+		completion.Model = model
+	}
+	metrics.LLMTokens.WithLabelValues(completion.Model, "prompt").Add(float64(completion.Usage.PromptTokens))
+	metrics.LLMTokens.WithLabelValues(completion.Model, "completion").Add(float64(completion.Usage.CompletionTokens))
+	span.SetAttributes(
+		attribute.String("llm.response_model", completion.Model),
+		attribute.Int("llm.usage.prompt_tokens", completion.Usage.PromptTokens),
+		attribute.Int("llm.usage.completion_tokens", completion.Usage.CompletionTokens),
+		attribute.Int("llm.response_length", len(completion.Content)),
+	)
+
+	slog.DebugContext(ctx, "openrouter completion received",
+		slog.Int("content_len", len(completion.Content)),
+		slog.Int("prompt_tokens", completion.Usage.PromptTokens),
+		slog.Int("completion_tokens", completion.Usage.CompletionTokens),
+	)
+	return completion, nil
+}
+
+// complete выполняет одну попытку запроса /chat/completions и возвращает тело успешного ответа
+func (c *OpenAIClient) complete(ctx context.Context, model string, jsonData []byte) ([]byte, error) {
+	requestURL := strings.TrimSuffix(c.cfg.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("HTTP-Referer", "https://telegram-mini-app.onrender.com")
-	req.Header.Set("X-Title", "Telegram Mini App")
+	req.Header.Set("Authorization", "Bearer "+string(c.cfg.APIKey))
+	req.Header.Set("HTTP-Referer", c.cfg.Referer)
+	req.Header.Set("X-Title", c.cfg.Title)
+	logging.Propagate(req)
 
 	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %v", err)
+		metrics.LLMDuration.WithLabelValues(model, "error").Observe(time.Since(start).Seconds())
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	metrics.LLMDuration.WithLabelValues(model, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
 
-	log.Printf("Received response from OpenRouter API in %s, status code: %d", time.Since(start), resp.StatusCode)
+	slog.InfoContext(ctx, "openrouter response",
+		slog.Int("status", resp.StatusCode),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+	)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from OpenRouter API: %s", string(body))
-		return "", fmt.Errorf("API request failed with status code %d: %s", resp.StatusCode, string(body))
+		slog.ErrorContext(ctx, "openrouter error response",
+			slog.Int("status", resp.StatusCode),
+			slog.String("response", truncate(string(body), 500)),
+		)
+		return nil, &UpstreamError{Service: "openrouter", StatusCode: resp.StatusCode, Message: truncate(string(body), 500)}
 	}
+	return body, nil
+}
 
-	var openAIResponse OpenAIResponse
-	if err := json.Unmarshal(body, &openAIResponse); err != nil {
-		log.Printf("Failed to unmarshal response: %v, body: %s", err, string(body))
-		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+// ListModels возвращает идентификаторы моделей, доступных в API.
+// Запрос идет через автомат защиты, поэтому при разомкнутом автомате
+// проверка готовности сразу сообщает о недоступности.
+func (c *OpenAIClient) ListModels(ctx context.Context) (models []string, err error) {
+	err = c.breaker.Call(ctx, func(ctx context.Context) (err error) {
+		models, err = c.listModels(ctx)
+		return err
+	})
+	return models, err
+}
+
+func (c *OpenAIClient) listModels(ctx context.Context) ([]string, error) {
+	requestURL := strings.TrimSuffix(c.cfg.BaseURL, "/") + "/models"
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	req.Header.Set("Authorization", "Bearer "+string(c.cfg.APIKey))
+	logging.Propagate(req)
 
-	if len(openAIResponse.Choices) == 0 {
-		log.Printf("Empty choices in response: %s", string(body))
-		return "", fmt.Errorf("no choices in response")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &UpstreamError{Service: "openrouter", StatusCode: resp.StatusCode, Message: "list models"}
+	}
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
-	content := openAIResponse.Choices[0].Message.Content
-	log.Printf("Successfully received chat completion, content length: %d", len(content))
+	models := make([]string, len(result.Data))
+	for i, m := range result.Data {
+		models[i] = m.ID
+	}
+	return models, nil
+}
 
-	return content, nil
+// CheckModel проверяет, что API доступен и настроенная модель есть в списке
+func (c *OpenAIClient) CheckModel(ctx context.Context) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range models {
+		if id == c.cfg.Model {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s is not available", c.cfg.Model)
+}
+
+// truncate обрезает строку для логов
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
 }
@@ -0,0 +1,125 @@
+package common_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+)
+
+func newOpenAI(t *testing.T) (*testutil.OpenRouter, *common.OpenAIClient) {
+	fake := testutil.NewOpenRouter(t)
+	cfg := testutil.Config(fake, testutil.NewKandinsky(t))
+	return fake, common.NewOpenAIClient(cfg.OpenRouter, cfg.Resilience)
+}
+
+func TestCreateChatCompletion(t *testing.T) {
+	fake, client := newOpenAI(t)
+	fake.Push(testutil.Reply{
+		Content: "ответ",
+		Model:   "anthropic/claude-3-haiku-20240307",
+		Usage:   common.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	})
+
+	got, err := client.CreateChatCompletionWithModel(context.Background(), "cheap/model", "вопрос")
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	if got.Content != "ответ" || got.Model != "anthropic/claude-3-haiku-20240307" || got.Usage.TotalTokens != 15 {
+		t.Errorf("completion = %+v", got)
+	}
+
+	req := fake.Requests()[0]
+	if req.Model != "cheap/model" || len(req.Messages) != 1 || req.Messages[0].Content != "вопрос" {
+		t.Errorf("request = %+v", req)
+	}
+}
+
+func TestOpenAIMessageJSON(t *testing.T) {
+	text, err := json.Marshal(common.OpenAIMessage{Role: common.RoleUser, Content: "вопрос"})
+	if err != nil || string(text) != `{"role":"user","content":"вопрос"}` {
+		t.Errorf("text message = %s, %v", text, err)
+	}
+
+	msg := common.OpenAIMessage{Role: common.RoleUser, Content: "что на фото?", Images: []string{"data:image/jpeg;base64,AAAA"}}
+	data, err := json.Marshal(msg)
+	want := `{"role":"user","content":[{"type":"text","text":"что на фото?"},{"type":"image_url","image_url":{"url":"data:image/jpeg;base64,AAAA"}}]}`
+	if err != nil || string(data) != want {
+		t.Errorf("image message = %s, %v", data, err)
+	}
+	var back common.OpenAIMessage
+	if err := json.Unmarshal(data, &back); err != nil || back.Content != msg.Content || len(back.Images) != 1 || back.Images[0] != msg.Images[0] {
+		t.Errorf("round trip = %+v, %v", back, err)
+	}
+}
+
+func TestCreateChatCompletionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  testutil.Reply
+		status int
+	}{
+		{"rate limited", testutil.Reply{Status: http.StatusTooManyRequests}, http.StatusTooManyRequests},
+		{"server error", testutil.Reply{Status: http.StatusServiceUnavailable}, http.StatusServiceUnavailable},
+		{"malformed json", testutil.Reply{Body: "not json"}, 0},
+		{"no choices", testutil.Reply{Body: `{"choices": []}`}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newOpenAI(t)
+			fake.Default = tt.reply
+
+			_, err := client.CreateChatCompletion(context.Background(), "вопрос")
+			if err == nil {
+				t.Fatal("CreateChatCompletion succeeded, want error")
+			}
+			var upstream *common.UpstreamError
+			if got := errors.As(err, &upstream); got != (tt.status != 0) {
+				t.Fatalf("err = %v, upstream error: %v", err, got)
+			}
+			if upstream != nil && upstream.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", upstream.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestCreateChatCompletionRetry(t *testing.T) {
+	fake, client := newOpenAI(t)
+	fake.Push(testutil.Reply{Status: http.StatusBadGateway}, testutil.Reply{Status: http.StatusTooManyRequests})
+
+	got, err := client.CreateChatCompletion(context.Background(), "вопрос")
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	if got.Content != testutil.DefaultPrediction {
+		t.Errorf("content = %q", got.Content)
+	}
+	if n := len(fake.Requests()); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+
+	// Ответ 4xx не временный и не повторяется
+	fake.Push(testutil.Reply{Status: http.StatusBadRequest})
+	if _, err := client.CreateChatCompletion(context.Background(), "вопрос"); err == nil {
+		t.Fatal("CreateChatCompletion succeeded after 400")
+	}
+	if n := len(fake.Requests()); n != 4 {
+		t.Errorf("requests = %d, want 4", n)
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	fake, client := newOpenAI(t)
+	if err := client.CheckModel(context.Background()); err != nil {
+		t.Fatalf("CheckModel: %v", err)
+	}
+	fake.Models = []string{"other/model"}
+	if err := client.CheckModel(context.Background()); err == nil {
+		t.Error("CheckModel succeeded without the configured model")
+	}
+}
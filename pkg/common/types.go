@@ -1,5 +1,15 @@
 package common
 
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/numerology"
+)
+
 // UserState представляет состояние пользователя
 type UserState struct {
 	Name         string `json:"name"`
@@ -9,19 +19,62 @@ type UserState struct {
 	PartnerName  string `json:"partnerName"`
 	PartnerBirth string `json:"partnerBirth"`
 	Step         int    `json:"step"`
+
+	// BirthTime (ЧЧ:ММ) и BirthPlace (город) необязательны и уточняют расчет Луны и асцендента
+	BirthTime  string `json:"birthTime,omitempty"`
+	BirthPlace string `json:"birthPlace,omitempty"`
+
+	// Photo - снимок ладони или кофейной чашки для сфер по фотографии. Сервер
+	// проверяет загрузку и заменяет ее на JPEG без метаданных.
+	Photo []byte `json:"photo,omitempty"`
+
+	// Options - от двух до пяти вариантов для сферы «Принятие решений»
+	Options []string `json:"options,omitempty"`
+
+	// TelegramID и PredictionID выставляет сервер (из подписанных данных
+	// запуска и при создании предсказания); значения из тела запроса игнорируются
+	TelegramID   int64  `json:"telegramId,omitempty"`
+	PredictionID string `json:"predictionId,omitempty"`
+}
+
+// LogValue скрывает персональные данные при логировании состояния
+func (s UserState) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("user", logging.Hash(s.Name+"|"+s.BirthDate)),
+		slog.String("mode", s.Mode),
+		slog.Int("step", s.Step),
+		slog.Int("question_len", len([]rune(s.Question))),
+		slog.Bool("has_partner", s.PartnerName != ""),
+		slog.Bool("has_photo", len(s.Photo) > 0),
+		slog.Int("options", len(s.Options)),
+		slog.Bool("telegram_user", s.TelegramID != 0),
+	)
 }
 
 // Prediction представляет предсказание
 type Prediction struct {
-	Text         string   `json:"text"`
-	ImagePrompts []string `json:"imagePrompts"`
+	Text         string              `json:"text"`
+	ImagePrompts []string            `json:"imagePrompts"`
+	Numerology   *numerology.Profile `json:"numerology,omitempty"`
+	// ConversationID - диалог для уточняющих вопросов, пуст, если он не сохранен
+	ConversationID string `json:"conversationId,omitempty"`
+	// Moderation - категория модерации, если вместо предсказания показаны
+	// контакты помощи; изображений в таком ответе нет
+	Moderation string `json:"moderation,omitempty"`
+	// Decision - сравнение вариантов в сфере «Принятие решений»; промпты
+	// изображений идут в порядке мест вариантов
+	Decision *decision.Result `json:"decision,omitempty"`
 }
 
 // PredictionResponse представляет ответ с предсказанием
 type PredictionResponse struct {
-	Text    string   `json:"text"`
-	Images  [][]byte `json:"images"`
-	Prompts []string `json:"prompts"`
+	Text           string              `json:"text"`
+	Images         [][]byte            `json:"images"`
+	Prompts        []string            `json:"prompts"`
+	Numerology     *numerology.Profile `json:"numerology,omitempty"`
+	ConversationID string              `json:"conversationId,omitempty"`
+	Moderation     string              `json:"moderation,omitempty"`
+	Decision       *decision.Result    `json:"decision,omitempty"`
 }
 
 // KandinskyGenerateRequest представляет запрос к API Kandinsky
@@ -43,3 +96,35 @@ type KandinskyStatusResponse struct {
 	Error    string   `json:"errorDescription"`
 	Censored bool     `json:"censored"`
 }
+
+// KandinskyAvailabilityResponse представляет ответ о доступности Kandinsky
+type KandinskyAvailabilityResponse struct {
+	Status      string `json:"status"`
+	ModelStatus string `json:"model_status"`
+}
+
+// Статусы задачи генерации Kandinsky
+const (
+	KandinskyStatusDone   = "DONE"
+	KandinskyStatusFailed = "FAILED"
+)
+
+// Image возвращает изображение готовой задачи.
+// Для незавершенной задачи возвращает nil без ошибки.
+func (r *KandinskyStatusResponse) Image() ([]byte, error) {
+	switch r.Status {
+	case KandinskyStatusDone:
+		if len(r.Images) == 0 {
+			return nil, ErrNoImage
+		}
+		// Декодируем base64 в байты
+		data, err := base64.StdEncoding.DecodeString(r.Images[0])
+		if err != nil {
+			return nil, fmt.Errorf("ошибка декодирования изображения: %v", err)
+		}
+		return data, nil
+	case KandinskyStatusFailed:
+		return nil, fmt.Errorf("%w: %s", ErrGenerationFailed, r.Error)
+	}
+	return nil, nil
+}
@@ -0,0 +1,984 @@
+// Package config загружает и проверяет конфигурацию приложения
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/cors"
+)
+
+// Config - полная конфигурация приложения
+type Config struct {
+	// Provider - live (OpenRouter и Kandinsky) или mock (офлайн-заглушки без ключей)
+	Provider     string             `yaml:"provider" toml:"provider" json:"provider"`
+	Server       ServerConfig       `yaml:"server" toml:"server" json:"server"`
+	CORS         CORSConfig         `yaml:"cors" toml:"cors" json:"cors"`
+	OpenRouter   OpenRouterConfig   `yaml:"openrouter" toml:"openrouter" json:"openrouter"`
+	Kandinsky    KandinskyConfig    `yaml:"kandinsky" toml:"kandinsky" json:"kandinsky"`
+	STT          STTConfig          `yaml:"stt" toml:"stt" json:"stt"`
+	TTS          TTSConfig          `yaml:"tts" toml:"tts" json:"tts"`
+	Resilience   ResilienceConfig   `yaml:"resilience" toml:"resilience" json:"resilience"`
+	Telegram     TelegramConfig     `yaml:"telegram" toml:"telegram" json:"telegram"`
+	Store        StoreConfig        `yaml:"store" toml:"store" json:"store"`
+	Jobs         JobsConfig         `yaml:"jobs" toml:"jobs" json:"jobs"`
+	Health       HealthConfig       `yaml:"health" toml:"health" json:"health"`
+	Metrics      MetricsConfig      `yaml:"metrics" toml:"metrics" json:"metrics"`
+	Tracing      TracingConfig      `yaml:"tracing" toml:"tracing" json:"tracing"`
+	Usage        UsageConfig        `yaml:"usage" toml:"usage" json:"usage"`
+	Horoscope    HoroscopeConfig    `yaml:"horoscope" toml:"horoscope" json:"horoscope"`
+	Conversation ConversationConfig `yaml:"conversation" toml:"conversation" json:"conversation"`
+	Moderation   ModerationConfig   `yaml:"moderation" toml:"moderation" json:"moderation"`
+	Share        ShareConfig        `yaml:"share" toml:"share" json:"share"`
+	Photo        PhotoConfig        `yaml:"photo" toml:"photo" json:"photo"`
+	Referral     ReferralConfig     `yaml:"referral" toml:"referral" json:"referral"`
+	Admin        AdminConfig        `yaml:"admin" toml:"admin" json:"admin"`
+	Mock         MockConfig         `yaml:"mock" toml:"mock" json:"mock"`
+	Log          LogConfig          `yaml:"log" toml:"log" json:"log"`
+
+	// Serverless - запуск в serverless-функции (Vercel). Выставляет точка
+	// входа, а не файл конфигурации или окружение. Без store.kv_url данные
+	// живут в памяти экземпляра функции.
+	Serverless bool `yaml:"-" toml:"-" json:"-"`
+
+	// envProblems - ошибки разбора переменных окружения, отчет о них дает Validate
+	envProblems []string
+}
+
+// ServerConfig - настройки HTTP-сервера
+type ServerConfig struct {
+	Port         string        `yaml:"port" toml:"port" json:"port"`
+	StaticDir    string        `yaml:"static_dir" toml:"static_dir" json:"static_dir"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" json:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" json:"write_timeout"`
+	// ShutdownTimeout - время на завершение текущих запросов и задач при остановке
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout"`
+}
+
+// CORSConfig - CORS-политика API
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins" toml:"allowed_origins" json:"allowed_origins"`
+	MaxAge         time.Duration `yaml:"max_age" toml:"max_age" json:"max_age"`
+}
+
+// OpenRouterConfig - параметры LLM через OpenRouter
+type OpenRouterConfig struct {
+	APIKey      Secret        `yaml:"api_key" toml:"api_key" json:"api_key"`
+	BaseURL     string        `yaml:"base_url" toml:"base_url" json:"base_url"`
+	Model       string        `yaml:"model" toml:"model" json:"model"`
+	Referer     string        `yaml:"referer" toml:"referer" json:"referer"`
+	Title       string        `yaml:"title" toml:"title" json:"title"`
+	Timeout     time.Duration `yaml:"timeout" toml:"timeout" json:"timeout"`
+	Temperature float64       `yaml:"temperature" toml:"temperature" json:"temperature"`
+	MaxTokens   int           `yaml:"max_tokens" toml:"max_tokens" json:"max_tokens"`
+}
+
+// KandinskyConfig - параметры генерации изображений
+type KandinskyConfig struct {
+	APIKey       Secret        `yaml:"api_key" toml:"api_key" json:"api_key"`
+	Secret       Secret        `yaml:"secret" toml:"secret" json:"secret"`
+	BaseURL      string        `yaml:"base_url" toml:"base_url" json:"base_url"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" json:"timeout"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval" json:"poll_interval"`
+	MaxPolls     int           `yaml:"max_polls" toml:"max_polls" json:"max_polls"`
+	Width        int           `yaml:"width" toml:"width" json:"width"`
+	Height       int           `yaml:"height" toml:"height" json:"height"`
+}
+
+// STTConfig - распознавание голосовых вопросов. Без Backend распознавание
+// выключено: /transcribe не регистрируется, бот не принимает голосовые.
+type STTConfig struct {
+	// Backend - openai (OpenAI-совместимый /audio/transcriptions) или whispercpp (сервер whisper.cpp)
+	Backend string `yaml:"backend" toml:"backend" json:"backend"`
+	BaseURL string `yaml:"base_url" toml:"base_url" json:"base_url"`
+	APIKey  Secret `yaml:"api_key" toml:"api_key" json:"api_key"`
+	// Model - модель распознавания для backend openai
+	Model    string        `yaml:"model" toml:"model" json:"model"`
+	Language string        `yaml:"language" toml:"language" json:"language"`
+	Timeout  time.Duration `yaml:"timeout" toml:"timeout" json:"timeout"`
+	// MaxSize - максимальный размер аудио в байтах
+	MaxSize int `yaml:"max_size" toml:"max_size" json:"max_size"`
+	// MaxDuration - максимальная длительность голосового сообщения в боте
+	MaxDuration time.Duration `yaml:"max_duration" toml:"max_duration" json:"max_duration"`
+}
+
+// Бэкенды распознавания речи
+const (
+	STTOpenAI     = "openai"
+	STTWhisperCpp = "whispercpp"
+)
+
+// TTSConfig - озвучивание предсказаний. Без Backend озвучивание выключено.
+type TTSConfig struct {
+	// Backend - openai (OpenAI-совместимый /audio/speech) или piper (HTTP-сервер Piper)
+	Backend string `yaml:"backend" toml:"backend" json:"backend"`
+	BaseURL string `yaml:"base_url" toml:"base_url" json:"base_url"`
+	APIKey  Secret `yaml:"api_key" toml:"api_key" json:"api_key"`
+	// Model - модель синтеза для backend openai
+	Model string `yaml:"model" toml:"model" json:"model"`
+	// Voice - голос; для piper пусто - голос, с которым запущен сервер
+	Voice   string        `yaml:"voice" toml:"voice" json:"voice"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" json:"timeout"`
+	// MaxChunk - максимальная длина фрагмента текста в символах на один запрос
+	MaxChunk int `yaml:"max_chunk" toml:"max_chunk" json:"max_chunk"`
+	// FFmpeg - путь к ffmpeg, которым WAV от piper кодируется в OGG/Opus
+	FFmpeg string `yaml:"ffmpeg" toml:"ffmpeg" json:"ffmpeg"`
+}
+
+// Бэкенды синтеза речи
+const (
+	TTSOpenAI = "openai"
+	TTSPiper  = "piper"
+)
+
+// ResilienceConfig - повторы и автоматы защиты запросов к OpenRouter и Kandinsky
+type ResilienceConfig struct {
+	// Attempts - число попыток запроса при временной ошибке, включая первую
+	Attempts int `yaml:"attempts" toml:"attempts" json:"attempts"`
+	// BaseDelay - пауза перед первым повтором, дальше удваивается до MaxDelay
+	BaseDelay time.Duration `yaml:"base_delay" toml:"base_delay" json:"base_delay"`
+	MaxDelay  time.Duration `yaml:"max_delay" toml:"max_delay" json:"max_delay"`
+	// BreakerThreshold - число временных ошибок подряд, после которого
+	// запросы к API отклоняются сразу; 0 - автомат отключен
+	BreakerThreshold int `yaml:"breaker_threshold" toml:"breaker_threshold" json:"breaker_threshold"`
+	// BreakerCooldown - время до пробного запроса после размыкания
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown" toml:"breaker_cooldown" json:"breaker_cooldown"`
+}
+
+// TelegramConfig - параметры Telegram-бота. Без токена бот не запускается.
+type TelegramConfig struct {
+	BotToken  Secret `yaml:"bot_token" toml:"bot_token" json:"bot_token"`
+	WebAppURL string `yaml:"webapp_url" toml:"webapp_url" json:"webapp_url"`
+	// BotUsername - имя бота без @ для ссылок-приглашений t.me/<bot>?startapp=...
+	BotUsername string        `yaml:"bot_username" toml:"bot_username" json:"bot_username"`
+	PollTimeout time.Duration `yaml:"poll_timeout" toml:"poll_timeout" json:"poll_timeout"`
+	// InitDataMaxAge - срок действия подписанных данных запуска мини-приложения
+	InitDataMaxAge time.Duration `yaml:"init_data_max_age" toml:"init_data_max_age" json:"init_data_max_age"`
+}
+
+// StoreConfig - параметры хранилища
+type StoreConfig struct {
+	// Path - путь к JSON-файлу; пустое значение - хранение только в памяти
+	Path          string        `yaml:"path" toml:"path" json:"path"`
+	FlushInterval time.Duration `yaml:"flush_interval" toml:"flush_interval" json:"flush_interval"`
+	// KVURL и KVToken - REST API общего Redis (Upstash, Vercel KV). Если URL
+	// задан, Path не используется и данные разделяют все экземпляры сервера.
+	KVURL   string `yaml:"kv_url" toml:"kv_url" json:"kv_url"`
+	KVToken Secret `yaml:"kv_token" toml:"kv_token" json:"kv_token"`
+}
+
+// JobsConfig - параметры асинхронных задач
+type JobsConfig struct {
+	TTL time.Duration `yaml:"ttl" toml:"ttl" json:"ttl"`
+}
+
+// HealthConfig - параметры проверок готовности
+type HealthConfig struct {
+	// CacheTTL - время жизни результата проверки внешней зависимости
+	CacheTTL time.Duration `yaml:"cache_ttl" toml:"cache_ttl" json:"cache_ttl"`
+	// Timeout - ограничение времени одной проверки
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" json:"timeout"`
+}
+
+// MetricsConfig - параметры эндпоинта /metrics
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" json:"enabled"`
+	// Token - Prometheus должен передавать "Authorization: Bearer <token>";
+	// без него /metrics не регистрируется, если не разрешен Public
+	Token Secret `yaml:"token" toml:"token" json:"token"`
+	// Public - отдавать метрики без токена, например во внутренней сети
+	Public bool `yaml:"public" toml:"public" json:"public"`
+}
+
+// TracingConfig - параметры трассировки OpenTelemetry
+type TracingConfig struct {
+	// Exporter - none, stdout или otlp
+	Exporter string `yaml:"exporter" toml:"exporter" json:"exporter"`
+	// Endpoint - URL OTLP/HTTP коллектора. Если пуст, используются
+	// стандартные переменные OTEL_EXPORTER_OTLP_*.
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" json:"endpoint"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" json:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" json:"sample_ratio"`
+}
+
+// UsageConfig - учет расходов на LLM и генерацию изображений
+type UsageConfig struct {
+	// Prices - цены моделей в долларах за миллион токенов
+	Prices map[string]ModelPrice `yaml:"prices" toml:"prices" json:"prices"`
+	// ImagePrice - цена одной генерации Kandinsky в долларах
+	ImagePrice float64 `yaml:"image_price" toml:"image_price" json:"image_price"`
+	// STTPrice - цена распознавания речи в долларах за минуту записи
+	STTPrice float64 `yaml:"stt_price" toml:"stt_price" json:"stt_price"`
+	// TTSPrice - цена озвучки в долларах за миллион символов
+	TTSPrice float64      `yaml:"tts_price" toml:"tts_price" json:"tts_price"`
+	Budget   BudgetConfig `yaml:"budget" toml:"budget" json:"budget"`
+}
+
+// ModelPrice - цена модели в долларах за миллион токенов
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt" toml:"prompt" json:"prompt"`
+	Completion float64 `yaml:"completion" toml:"completion" json:"completion"`
+}
+
+// BudgetConfig - лимиты расходов одного пользователя в долларах (0 - без лимита).
+// После мягкого лимита (Daily, Monthly) используется FallbackModel, а если она
+// не задана, новые предсказания отклоняются. После жесткого лимита
+// (HardDaily, HardMonthly) предсказания отклоняются всегда.
+type BudgetConfig struct {
+	Daily         float64 `yaml:"daily" toml:"daily" json:"daily"`
+	Monthly       float64 `yaml:"monthly" toml:"monthly" json:"monthly"`
+	HardDaily     float64 `yaml:"hard_daily" toml:"hard_daily" json:"hard_daily"`
+	HardMonthly   float64 `yaml:"hard_monthly" toml:"hard_monthly" json:"hard_monthly"`
+	FallbackModel string  `yaml:"fallback_model" toml:"fallback_model" json:"fallback_model"`
+}
+
+// HoroscopeConfig - параметры рассылки ежедневного гороскопа. Рассылка
+// работает только вместе с ботом и только в долгоживущем сервере.
+type HoroscopeConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" json:"enabled"`
+	// DefaultTime - местное время доставки ЧЧ:ММ, если пользователь его не выбрал
+	DefaultTime     string `yaml:"default_time" toml:"default_time" json:"default_time"`
+	DefaultTimeZone string `yaml:"default_timezone" toml:"default_timezone" json:"default_timezone"`
+	// Interval - период проверки подписок, которым пора отправить гороскоп
+	Interval time.Duration `yaml:"interval" toml:"interval" json:"interval"`
+}
+
+// ConversationConfig - параметры диалога по предсказанию
+type ConversationConfig struct {
+	// MaxFollowUps - число уточняющих вопросов к одному предсказанию
+	MaxFollowUps int `yaml:"max_follow_ups" toml:"max_follow_ups" json:"max_follow_ups"`
+	// MaxEdits - число перегенераций изображений и переписываний текста одного предсказания
+	MaxEdits int `yaml:"max_edits" toml:"max_edits" json:"max_edits"`
+	// MaxContextTokens - примерный размер истории, отправляемой модели.
+	// Старые уточнения, не умещающиеся в него, не передаются.
+	MaxContextTokens int `yaml:"max_context_tokens" toml:"max_context_tokens" json:"max_context_tokens"`
+	// TTL - время хранения диалога после последнего вопроса
+	TTL time.Duration `yaml:"ttl" toml:"ttl" json:"ttl"`
+}
+
+// ModerationConfig - проверка вопросов и ответов модели по политике модерации
+type ModerationConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" json:"enabled"`
+	// Policy - YAML-файл с категориями и действиями; пустой - встроенная политика
+	Policy string `yaml:"policy" toml:"policy" json:"policy"`
+}
+
+// ShareConfig - публикация предсказаний по ссылке
+type ShareConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" json:"enabled"`
+	// BaseURL - внешний адрес сервера для ссылок и превью; пустой - адрес
+	// определяется по заголовкам запроса, а inline-режим бота отключен
+	BaseURL string `yaml:"base_url" toml:"base_url" json:"base_url"`
+}
+
+// PhotoConfig - предсказания по фотографии ладони или кофейной чашки
+type PhotoConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" json:"enabled"`
+	// Model - модель OpenRouter с поддержкой изображений; пустая - openrouter.model
+	Model string `yaml:"model" toml:"model" json:"model"`
+	// MaxSize - максимальный размер загружаемой фотографии в байтах
+	MaxSize int `yaml:"max_size" toml:"max_size" json:"max_size"`
+	// MaxSide - фотография уменьшается до этой длины большей стороны в пикселях
+	MaxSide int `yaml:"max_side" toml:"max_side" json:"max_side"`
+}
+
+// ReferralConfig - реферальная программа: приглашенный получает бонус сразу,
+// пригласивший - после первого предсказания приглашенного
+type ReferralConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" json:"enabled"`
+	// InviterBonus - бонусные предсказания пригласившему
+	InviterBonus int `yaml:"inviter_bonus" toml:"inviter_bonus" json:"inviter_bonus"`
+	// InviteeBonus - бонусные предсказания приглашенному
+	InviteeBonus int `yaml:"invitee_bonus" toml:"invitee_bonus" json:"invitee_bonus"`
+	// MaxPerMonth - сколько приглашений пользователя засчитывается за месяц
+	MaxPerMonth int `yaml:"max_per_month" toml:"max_per_month" json:"max_per_month"`
+}
+
+// AdminConfig - параметры административного API и панели /admin
+type AdminConfig struct {
+	// Token - пароль администратора; без него /admin не регистрируется
+	Token Secret `yaml:"token" toml:"token" json:"token"`
+	// RecentLimit - сколько последних предсказаний показывать
+	RecentLimit int `yaml:"recent_limit" toml:"recent_limit" json:"recent_limit"`
+}
+
+// Провайдеры LLM и изображений
+const (
+	ProviderLive = "live"
+	ProviderMock = "mock"
+)
+
+// MockConfig - параметры офлайн-заглушек для локальной разработки
+type MockConfig struct {
+	// LLMLatency и ImageLatency - искусственная задержка ответа и генерации
+	LLMLatency   time.Duration `yaml:"llm_latency" toml:"llm_latency" json:"llm_latency"`
+	ImageLatency time.Duration `yaml:"image_latency" toml:"image_latency" json:"image_latency"`
+	// FailureRate - доля запросов, завершающихся ошибкой, 0..1
+	FailureRate float64 `yaml:"failure_rate" toml:"failure_rate" json:"failure_rate"`
+}
+
+// LogConfig - параметры логирования
+type LogConfig struct {
+	// Level - debug, info, warn или error
+	Level string `yaml:"level" toml:"level" json:"level"`
+	// Format - json или text
+	Format string `yaml:"format" toml:"format" json:"format"`
+	// HashKey - ключ HMAC для отпечатков персональных данных в логах. Пустой -
+	// случайный ключ процесса: записи сопоставляются только до перезапуска.
+	HashKey Secret `yaml:"hash_key" toml:"hash_key" json:"hash_key"`
+}
+
+// Options задает источники конфигурации
+type Options struct {
+	// File - путь к YAML/TOML файлу. Если пуст, используется CONFIG_FILE.
+	File string
+	// DotEnv - путь к .env файлу. Отсутствующий файл не считается ошибкой.
+	DotEnv string
+}
+
+// Default возвращает конфигурацию со значениями по умолчанию
+func Default() *Config {
+	return &Config{
+		Provider: ProviderLive,
+		Server: ServerConfig{
+			Port:            "8080",
+			StaticDir:       "static",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    6 * time.Minute,
+			ShutdownTimeout: 25 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"https://ptspuf.github.io"},
+			MaxAge:         time.Hour,
+		},
+		OpenRouter: OpenRouterConfig{
+			BaseURL:     "https://openrouter.ai/api/v1",
+			Model:       "anthropic/claude-3-haiku",
+			Referer:     "https://telegram-mini-app.onrender.com",
+			Title:       "Telegram Mini App",
+			Timeout:     120 * time.Second,
+			Temperature: 0.7,
+			MaxTokens:   4000,
+		},
+		Kandinsky: KandinskyConfig{
+			BaseURL:      "https://api-key.fusionbrain.ai",
+			Timeout:      30 * time.Second,
+			PollInterval: 10 * time.Second,
+			MaxPolls:     30,
+			Width:        1024,
+			Height:       1024,
+		},
+		STT: STTConfig{
+			BaseURL:     "https://api.openai.com/v1",
+			Model:       "whisper-1",
+			Language:    "ru",
+			Timeout:     60 * time.Second,
+			MaxSize:     10 << 20,
+			MaxDuration: 2 * time.Minute,
+		},
+		TTS: TTSConfig{
+			BaseURL:  "https://api.openai.com/v1",
+			Model:    "tts-1",
+			Voice:    "nova",
+			Timeout:  60 * time.Second,
+			MaxChunk: 1000,
+			FFmpeg:   "ffmpeg",
+		},
+		Resilience: ResilienceConfig{
+			Attempts:         3,
+			BaseDelay:        500 * time.Millisecond,
+			MaxDelay:         5 * time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		Telegram: TelegramConfig{
+			PollTimeout:    10 * time.Second,
+			InitDataMaxAge: 24 * time.Hour,
+		},
+		Store: StoreConfig{
+			FlushInterval: 30 * time.Second,
+		},
+		Jobs: JobsConfig{
+			TTL: time.Hour,
+		},
+		Health: HealthConfig{
+			CacheTTL: 30 * time.Second,
+			Timeout:  5 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "telegram-mini-app",
+			SampleRatio: 1,
+		},
+		Usage: UsageConfig{
+			Prices: map[string]ModelPrice{
+				"anthropic/claude-3-haiku": {Prompt: 0.25, Completion: 1.25},
+			},
+			// Цены whisper-1 и tts-1 в OpenAI
+			STTPrice: 0.006,
+			TTSPrice: 15,
+		},
+		Horoscope: HoroscopeConfig{
+			Enabled:         true,
+			DefaultTime:     "09:00",
+			DefaultTimeZone: "Europe/Moscow",
+			Interval:        time.Minute,
+		},
+		Conversation: ConversationConfig{
+			MaxFollowUps:     5,
+			MaxEdits:         5,
+			MaxContextTokens: 6000,
+			TTL:              7 * 24 * time.Hour,
+		},
+		Moderation: ModerationConfig{
+			Enabled: true,
+		},
+		Share: ShareConfig{
+			Enabled: true,
+		},
+		Photo: PhotoConfig{
+			Enabled: true,
+			MaxSize: 10 << 20,
+			MaxSide: 1568,
+		},
+		Referral: ReferralConfig{
+			Enabled:      true,
+			InviterBonus: 3,
+			InviteeBonus: 1,
+			MaxPerMonth:  20,
+		},
+		Admin: AdminConfig{
+			RecentLimit: 50,
+		},
+		Mock: MockConfig{
+			LLMLatency:   time.Second,
+			ImageLatency: 3 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// Load собирает конфигурацию: значения по умолчанию, затем файл, затем
+// переменные окружения (включая загруженные из .env). Переменные окружения
+// имеют наивысший приоритет. Некорректные значения переменных не прерывают
+// загрузку: о них вместе с остальными проблемами сообщает Validate.
+func Load(opts Options) (*Config, error) {
+	if opts.DotEnv != "" {
+		// godotenv не перезаписывает уже установленные переменные
+		if err := godotenv.Load(opts.DotEnv); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("ошибка загрузки %s: %v", opts.DotEnv, err)
+		}
+	}
+
+	cfg := Default()
+
+	file := opts.File
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range cfg.envBindings() {
+		value, ok := os.LookupEnv(b.name)
+		if !ok {
+			continue
+		}
+		if err := b.set(value); err != nil {
+			cfg.envProblems = append(cfg.envProblems, fmt.Sprintf("%s: %v", b.name, err))
+		}
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла конфигурации: %v", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	case ".json":
+		err = json.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("неизвестный формат файла конфигурации: %s", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка разбора %s: %v", path, err)
+	}
+	return nil
+}
+
+// binding связывает переменную окружения с полем конфигурации
+type binding struct {
+	name   string
+	target any
+}
+
+func (c *Config) envBindings() []binding {
+	return []binding{
+		{"PROVIDER", &c.Provider},
+
+		{"PORT", &c.Server.Port},
+		{"STATIC_DIR", &c.Server.StaticDir},
+		{"SERVER_READ_TIMEOUT", &c.Server.ReadTimeout},
+		{"SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout},
+		{"SERVER_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout},
+
+		{"CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins},
+		{"CORS_MAX_AGE", &c.CORS.MaxAge},
+
+		{"OPENROUTER_API_KEY", &c.OpenRouter.APIKey},
+		{"OPENROUTER_BASE_URL", &c.OpenRouter.BaseURL},
+		{"OPENROUTER_MODEL", &c.OpenRouter.Model},
+		{"OPENROUTER_REFERER", &c.OpenRouter.Referer},
+		{"OPENROUTER_TITLE", &c.OpenRouter.Title},
+		{"OPENROUTER_TIMEOUT", &c.OpenRouter.Timeout},
+		{"OPENROUTER_TEMPERATURE", &c.OpenRouter.Temperature},
+		{"OPENROUTER_MAX_TOKENS", &c.OpenRouter.MaxTokens},
+
+		{"KANDINSKY_API_KEY", &c.Kandinsky.APIKey},
+		{"KANDINSKY_SECRET", &c.Kandinsky.Secret},
+		{"KANDINSKY_URL", &c.Kandinsky.BaseURL},
+		{"KANDINSKY_TIMEOUT", &c.Kandinsky.Timeout},
+		{"KANDINSKY_POLL_INTERVAL", &c.Kandinsky.PollInterval},
+		{"KANDINSKY_MAX_POLLS", &c.Kandinsky.MaxPolls},
+
+		{"STT_BACKEND", &c.STT.Backend},
+		{"STT_BASE_URL", &c.STT.BaseURL},
+		{"STT_API_KEY", &c.STT.APIKey},
+		{"STT_MODEL", &c.STT.Model},
+		{"STT_LANGUAGE", &c.STT.Language},
+		{"STT_TIMEOUT", &c.STT.Timeout},
+		{"STT_MAX_SIZE", &c.STT.MaxSize},
+		{"STT_MAX_DURATION", &c.STT.MaxDuration},
+		{"TTS_BACKEND", &c.TTS.Backend},
+		{"TTS_BASE_URL", &c.TTS.BaseURL},
+		{"TTS_API_KEY", &c.TTS.APIKey},
+		{"TTS_MODEL", &c.TTS.Model},
+		{"TTS_VOICE", &c.TTS.Voice},
+		{"TTS_TIMEOUT", &c.TTS.Timeout},
+		{"TTS_MAX_CHUNK", &c.TTS.MaxChunk},
+		{"TTS_FFMPEG", &c.TTS.FFmpeg},
+
+		{"RETRY_ATTEMPTS", &c.Resilience.Attempts},
+		{"RETRY_BASE_DELAY", &c.Resilience.BaseDelay},
+		{"RETRY_MAX_DELAY", &c.Resilience.MaxDelay},
+		{"BREAKER_THRESHOLD", &c.Resilience.BreakerThreshold},
+		{"BREAKER_COOLDOWN", &c.Resilience.BreakerCooldown},
+
+		{"TELEGRAM_BOT_TOKEN", &c.Telegram.BotToken},
+		{"TELEGRAM_WEBAPP_URL", &c.Telegram.WebAppURL},
+		{"TELEGRAM_BOT_USERNAME", &c.Telegram.BotUsername},
+		{"TELEGRAM_POLL_TIMEOUT", &c.Telegram.PollTimeout},
+		{"TELEGRAM_INIT_DATA_MAX_AGE", &c.Telegram.InitDataMaxAge},
+
+		{"STORE_PATH", &c.Store.Path},
+		{"STORE_FLUSH_INTERVAL", &c.Store.FlushInterval},
+		{"KV_REST_API_URL", &c.Store.KVURL},
+		{"KV_REST_API_TOKEN", &c.Store.KVToken},
+
+		{"JOBS_TTL", &c.Jobs.TTL},
+
+		{"HEALTH_CACHE_TTL", &c.Health.CacheTTL},
+		{"HEALTH_TIMEOUT", &c.Health.Timeout},
+
+		{"METRICS_ENABLED", &c.Metrics.Enabled},
+		{"METRICS_TOKEN", &c.Metrics.Token},
+		{"METRICS_PUBLIC", &c.Metrics.Public},
+
+		{"TRACING_EXPORTER", &c.Tracing.Exporter},
+		{"TRACING_ENDPOINT", &c.Tracing.Endpoint},
+		{"TRACING_SAMPLE_RATIO", &c.Tracing.SampleRatio},
+		{"OTEL_SERVICE_NAME", &c.Tracing.ServiceName},
+
+		{"USAGE_IMAGE_PRICE", &c.Usage.ImagePrice},
+		{"USAGE_STT_PRICE", &c.Usage.STTPrice},
+		{"USAGE_TTS_PRICE", &c.Usage.TTSPrice},
+		{"BUDGET_DAILY", &c.Usage.Budget.Daily},
+		{"BUDGET_MONTHLY", &c.Usage.Budget.Monthly},
+		{"BUDGET_HARD_DAILY", &c.Usage.Budget.HardDaily},
+		{"BUDGET_HARD_MONTHLY", &c.Usage.Budget.HardMonthly},
+		{"BUDGET_FALLBACK_MODEL", &c.Usage.Budget.FallbackModel},
+
+		{"HOROSCOPE_ENABLED", &c.Horoscope.Enabled},
+		{"HOROSCOPE_DEFAULT_TIME", &c.Horoscope.DefaultTime},
+		{"HOROSCOPE_DEFAULT_TIMEZONE", &c.Horoscope.DefaultTimeZone},
+		{"HOROSCOPE_INTERVAL", &c.Horoscope.Interval},
+
+		{"CONVERSATION_MAX_FOLLOW_UPS", &c.Conversation.MaxFollowUps},
+		{"CONVERSATION_MAX_EDITS", &c.Conversation.MaxEdits},
+		{"CONVERSATION_MAX_CONTEXT_TOKENS", &c.Conversation.MaxContextTokens},
+		{"CONVERSATION_TTL", &c.Conversation.TTL},
+
+		{"MODERATION_ENABLED", &c.Moderation.Enabled},
+		{"MODERATION_POLICY", &c.Moderation.Policy},
+
+		{"SHARE_ENABLED", &c.Share.Enabled},
+		{"SHARE_BASE_URL", &c.Share.BaseURL},
+		{"PHOTO_ENABLED", &c.Photo.Enabled},
+		{"PHOTO_MODEL", &c.Photo.Model},
+		{"PHOTO_MAX_SIZE", &c.Photo.MaxSize},
+		{"PHOTO_MAX_SIDE", &c.Photo.MaxSide},
+		{"REFERRAL_ENABLED", &c.Referral.Enabled},
+		{"REFERRAL_INVITER_BONUS", &c.Referral.InviterBonus},
+		{"REFERRAL_INVITEE_BONUS", &c.Referral.InviteeBonus},
+		{"REFERRAL_MAX_PER_MONTH", &c.Referral.MaxPerMonth},
+
+		{"ADMIN_TOKEN", &c.Admin.Token},
+		{"ADMIN_RECENT_LIMIT", &c.Admin.RecentLimit},
+
+		{"MOCK_LLM_LATENCY", &c.Mock.LLMLatency},
+		{"MOCK_IMAGE_LATENCY", &c.Mock.ImageLatency},
+		{"MOCK_FAILURE_RATE", &c.Mock.FailureRate},
+
+		{"LOG_LEVEL", &c.Log.Level},
+		{"LOG_FORMAT", &c.Log.Format},
+		{"LOG_HASH_KEY", &c.Log.HashKey},
+	}
+}
+
+func (b binding) set(value string) error {
+	value = strings.TrimSpace(value)
+	switch t := b.target.(type) {
+	case *string:
+		*t = value
+	case *Secret:
+		*t = Secret(value)
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("ожидается целое число, получено %q", value)
+		}
+		*t = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("ожидается число, получено %q", value)
+		}
+		*t = f
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ожидается true/false, получено %q", value)
+		}
+		*t = v
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("ожидается длительность (например 30s), получено %q", value)
+		}
+		*t = d
+	case *[]string:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*t = items
+	default:
+		return fmt.Errorf("неподдерживаемый тип поля %T", b.target)
+	}
+	return nil
+}
+
+// ValidationError содержит все найденные проблемы конфигурации
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "некорректная конфигурация:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate проверяет конфигурацию и возвращает все проблемы одной ошибкой
+func (c *Config) Validate() error {
+	problems := append([]string{}, c.envProblems...)
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		add("server.port (PORT): некорректный порт %q", c.Server.Port)
+	}
+	if c.Server.ReadTimeout <= 0 {
+		add("server.read_timeout: должен быть положительным")
+	}
+	if c.Server.WriteTimeout <= 0 {
+		add("server.write_timeout: должен быть положительным")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdown_timeout (SERVER_SHUTDOWN_TIMEOUT): должен быть положительным")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if !cors.ValidPattern(origin) {
+			add("cors.allowed_origins (CORS_ALLOWED_ORIGINS): некорректный источник %q", origin)
+		}
+	}
+
+	// Заглушкам ключи не нужны
+	live := true
+	switch c.Provider {
+	case ProviderLive:
+	case ProviderMock:
+		live = false
+		if c.Mock.LLMLatency < 0 || c.Mock.ImageLatency < 0 {
+			add("mock.llm_latency/image_latency: задержка не может быть отрицательной")
+		}
+		if c.Mock.FailureRate < 0 || c.Mock.FailureRate > 1 {
+			add("mock.failure_rate (MOCK_FAILURE_RATE): должна быть в диапазоне 0..1")
+		}
+	default:
+		add("provider (PROVIDER): ожидается live или mock, получено %q", c.Provider)
+	}
+
+	if live && c.OpenRouter.APIKey == "" {
+		add("openrouter.api_key (OPENROUTER_API_KEY): не задан")
+	}
+	if !validURL(c.OpenRouter.BaseURL) {
+		add("openrouter.base_url (OPENROUTER_BASE_URL): некорректный URL %q", c.OpenRouter.BaseURL)
+	}
+	if c.OpenRouter.Model == "" {
+		add("openrouter.model (OPENROUTER_MODEL): не задан")
+	}
+	if c.OpenRouter.Timeout <= 0 {
+		add("openrouter.timeout (OPENROUTER_TIMEOUT): должен быть положительным")
+	}
+	if c.OpenRouter.Temperature < 0 || c.OpenRouter.Temperature > 2 {
+		add("openrouter.temperature (OPENROUTER_TEMPERATURE): должна быть в диапазоне 0..2")
+	}
+	if c.OpenRouter.MaxTokens <= 0 {
+		add("openrouter.max_tokens (OPENROUTER_MAX_TOKENS): должен быть положительным")
+	}
+
+	if live && c.Kandinsky.APIKey == "" {
+		add("kandinsky.api_key (KANDINSKY_API_KEY): не задан")
+	}
+	if live && c.Kandinsky.Secret == "" {
+		add("kandinsky.secret (KANDINSKY_SECRET): не задан")
+	}
+	if !validURL(c.Kandinsky.BaseURL) {
+		add("kandinsky.base_url (KANDINSKY_URL): некорректный URL %q", c.Kandinsky.BaseURL)
+	}
+	if c.Kandinsky.Timeout <= 0 {
+		add("kandinsky.timeout (KANDINSKY_TIMEOUT): должен быть положительным")
+	}
+	if c.Kandinsky.PollInterval <= 0 {
+		add("kandinsky.poll_interval (KANDINSKY_POLL_INTERVAL): должен быть положительным")
+	}
+	if c.Kandinsky.MaxPolls <= 0 {
+		add("kandinsky.max_polls (KANDINSKY_MAX_POLLS): должен быть положительным")
+	}
+	if c.Kandinsky.Width <= 0 || c.Kandinsky.Height <= 0 {
+		add("kandinsky.width/height: размеры изображения должны быть положительными")
+	}
+
+	switch c.STT.Backend {
+	case "":
+	case STTOpenAI, STTWhisperCpp:
+		if !validURL(c.STT.BaseURL) {
+			add("stt.base_url (STT_BASE_URL): некорректный URL %q", c.STT.BaseURL)
+		}
+		if c.STT.Timeout <= 0 {
+			add("stt.timeout (STT_TIMEOUT): должен быть положительным")
+		}
+		if c.STT.MaxSize <= 0 || c.STT.MaxDuration <= 0 {
+			add("stt.max_size/max_duration (STT_MAX_SIZE, STT_MAX_DURATION): должны быть положительными")
+		}
+	default:
+		add("stt.backend (STT_BACKEND): ожидается openai, whispercpp или пусто, получено %q", c.STT.Backend)
+	}
+
+	switch c.TTS.Backend {
+	case "":
+	case TTSOpenAI, TTSPiper:
+		if !validURL(c.TTS.BaseURL) {
+			add("tts.base_url (TTS_BASE_URL): некорректный URL %q", c.TTS.BaseURL)
+		}
+		if c.TTS.Timeout <= 0 {
+			add("tts.timeout (TTS_TIMEOUT): должен быть положительным")
+		}
+		if c.TTS.MaxChunk < 100 {
+			add("tts.max_chunk (TTS_MAX_CHUNK): должно быть не меньше 100")
+		}
+		if c.TTS.Backend == TTSPiper && c.TTS.FFmpeg == "" {
+			add("tts.ffmpeg (TTS_FFMPEG): нужен для backend piper")
+		}
+	default:
+		add("tts.backend (TTS_BACKEND): ожидается openai, piper или пусто, получено %q", c.TTS.Backend)
+	}
+
+	if c.Resilience.Attempts < 1 {
+		add("resilience.attempts (RETRY_ATTEMPTS): должно быть не меньше 1")
+	}
+	if c.Resilience.BaseDelay < 0 || c.Resilience.MaxDelay < c.Resilience.BaseDelay {
+		add("resilience.base_delay/max_delay (RETRY_BASE_DELAY, RETRY_MAX_DELAY): некорректные паузы")
+	}
+	if c.Resilience.BreakerThreshold < 0 {
+		add("resilience.breaker_threshold (BREAKER_THRESHOLD): не может быть отрицательным")
+	}
+	if c.Resilience.BreakerThreshold > 0 && c.Resilience.BreakerCooldown <= 0 {
+		add("resilience.breaker_cooldown (BREAKER_COOLDOWN): должен быть положительным")
+	}
+
+	if c.Telegram.BotToken != "" {
+		if c.Telegram.WebAppURL != "" && !validURL(c.Telegram.WebAppURL) {
+			add("telegram.webapp_url (TELEGRAM_WEBAPP_URL): некорректный URL %q", c.Telegram.WebAppURL)
+		}
+		if c.Telegram.PollTimeout <= 0 {
+			add("telegram.poll_timeout (TELEGRAM_POLL_TIMEOUT): должен быть положительным")
+		}
+		if c.Telegram.InitDataMaxAge <= 0 {
+			add("telegram.init_data_max_age (TELEGRAM_INIT_DATA_MAX_AGE): должен быть положительным")
+		}
+	}
+
+	if c.Store.KVURL != "" {
+		if !validURL(c.Store.KVURL) {
+			add("store.kv_url (KV_REST_API_URL): некорректный URL %q", c.Store.KVURL)
+		}
+		if c.Store.KVToken == "" {
+			add("store.kv_token (KV_REST_API_TOKEN): обязателен вместе с store.kv_url")
+		}
+	}
+	if c.Store.KVURL == "" && c.Store.Path != "" && c.Store.FlushInterval <= 0 {
+		add("store.flush_interval (STORE_FLUSH_INTERVAL): должен быть положительным")
+	}
+	if c.Jobs.TTL <= 0 {
+		add("jobs.ttl (JOBS_TTL): должен быть положительным")
+	}
+
+	if c.Health.Timeout <= 0 {
+		add("health.timeout (HEALTH_TIMEOUT): должен быть положительным")
+	}
+
+	for model, price := range c.Usage.Prices {
+		if price.Prompt < 0 || price.Completion < 0 {
+			add("usage.prices[%s]: цена не может быть отрицательной", model)
+		}
+	}
+	b := c.Usage.Budget
+	if c.Usage.ImagePrice < 0 || c.Usage.STTPrice < 0 || c.Usage.TTSPrice < 0 || b.Daily < 0 || b.Monthly < 0 || b.HardDaily < 0 || b.HardMonthly < 0 {
+		add("usage: цены и лимиты не могут быть отрицательными")
+	}
+	if b.HardDaily > 0 && b.Daily > b.HardDaily || b.HardMonthly > 0 && b.Monthly > b.HardMonthly {
+		add("usage.budget: мягкий лимит больше жесткого")
+	}
+
+	if c.Horoscope.Enabled {
+		if _, err := time.Parse("15:04", c.Horoscope.DefaultTime); err != nil {
+			add("horoscope.default_time (HOROSCOPE_DEFAULT_TIME): ожидается ЧЧ:ММ, получено %q", c.Horoscope.DefaultTime)
+		}
+		if _, err := time.LoadLocation(c.Horoscope.DefaultTimeZone); err != nil {
+			add("horoscope.default_timezone (HOROSCOPE_DEFAULT_TIMEZONE): неизвестный часовой пояс %q", c.Horoscope.DefaultTimeZone)
+		}
+		if c.Horoscope.Interval <= 0 {
+			add("horoscope.interval (HOROSCOPE_INTERVAL): должен быть положительным")
+		}
+	}
+
+	if c.Conversation.MaxFollowUps < 0 {
+		add("conversation.max_follow_ups (CONVERSATION_MAX_FOLLOW_UPS): не может быть отрицательным")
+	}
+	if c.Conversation.MaxEdits < 0 {
+		add("conversation.max_edits (CONVERSATION_MAX_EDITS): не может быть отрицательным")
+	}
+	if c.Conversation.MaxContextTokens <= 0 {
+		add("conversation.max_context_tokens (CONVERSATION_MAX_CONTEXT_TOKENS): должен быть положительным")
+	}
+	if c.Conversation.TTL <= 0 {
+		add("conversation.ttl (CONVERSATION_TTL): должен быть положительным")
+	}
+
+	if c.Moderation.Enabled && c.Moderation.Policy != "" {
+		if _, err := os.Stat(c.Moderation.Policy); err != nil {
+			add("moderation.policy (MODERATION_POLICY): файл недоступен: %v", err)
+		}
+	}
+
+	if c.Share.Enabled && c.Share.BaseURL != "" && !validURL(c.Share.BaseURL) {
+		add("share.base_url (SHARE_BASE_URL): некорректный URL %q", c.Share.BaseURL)
+	}
+	if c.Photo.Enabled && (c.Photo.MaxSize <= 0 || c.Photo.MaxSide < 256) {
+		add("photo.max_size/max_side (PHOTO_MAX_SIZE, PHOTO_MAX_SIDE): размер должен быть положительным, сторона - не меньше 256")
+	}
+
+	if c.Referral.Enabled {
+		if c.Referral.InviterBonus < 0 || c.Referral.InviteeBonus < 0 {
+			add("referral.inviter_bonus/invitee_bonus (REFERRAL_INVITER_BONUS, REFERRAL_INVITEE_BONUS): не могут быть отрицательными")
+		}
+		if c.Referral.MaxPerMonth <= 0 {
+			add("referral.max_per_month (REFERRAL_MAX_PER_MONTH): должен быть положительным")
+		}
+	}
+	if strings.HasPrefix(c.Telegram.BotUsername, "@") {
+		add("telegram.bot_username (TELEGRAM_BOT_USERNAME): укажите имя без @")
+	}
+
+	if c.Admin.Token != "" && len(c.Admin.Token) < 16 {
+		add("admin.token (ADMIN_TOKEN): должен быть не короче 16 символов")
+	}
+	if c.Admin.RecentLimit <= 0 {
+		add("admin.recent_limit (ADMIN_RECENT_LIMIT): должен быть положительным")
+	}
+
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "", "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint != "" && !validURL(c.Tracing.Endpoint) {
+			add("tracing.endpoint (TRACING_ENDPOINT): некорректный URL %q", c.Tracing.Endpoint)
+		}
+	default:
+		add("tracing.exporter (TRACING_EXPORTER): ожидается none, stdout или otlp, получено %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio (TRACING_SAMPLE_RATIO): должна быть в диапазоне 0..1")
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level (LOG_LEVEL): ожидается debug, info, warn или error, получено %q", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		add("log.format (LOG_FORMAT): ожидается json или text, получено %q", c.Log.Format)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,152 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+)
+
+// writeFile создает файл во временном каталоге теста
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// unsetenv удаляет переменную на время теста: .env устанавливает
+// переменные процесса, и t.Setenv вернет прежнее значение
+func unsetenv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	unsetenv(t, "CONFIG_FILE", "PORT", "LOG_LEVEL", "LOG_FORMAT", "OPENROUTER_MODEL", "SERVER_READ_TIMEOUT", "CORS_ALLOWED_ORIGINS")
+	file := writeFile(t, "config.yaml", `
+server:
+  port: "9000"
+  read_timeout: 30s
+log:
+  level: debug
+  format: text
+openrouter:
+  model: file/model
+`)
+	dotenv := writeFile(t, ".env", "LOG_LEVEL=warn\nOPENROUTER_MODEL=dotenv/model\n")
+	t.Setenv("OPENROUTER_MODEL", "env/model")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example, https://*.vercel.app")
+
+	cfg, err := config.Load(config.Options{File: file, DotEnv: dotenv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		// Значение по умолчанию без переопределений
+		{"write timeout", cfg.Server.WriteTimeout, 6 * time.Minute},
+		// Файл переопределяет значения по умолчанию
+		{"port", cfg.Server.Port, "9000"},
+		{"read timeout", cfg.Server.ReadTimeout, 30 * time.Second},
+		{"log format", cfg.Log.Format, "text"},
+		// .env переопределяет файл
+		{"log level", cfg.Log.Level, "warn"},
+		// Окружение переопределяет .env
+		{"model", cfg.OpenRouter.Model, "env/model"},
+		{"origins", strings.Join(cfg.CORS.AllowedOrigins, " "), "https://a.example https://*.vercel.app"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	unsetenv(t, "CONFIG_FILE", "PORT")
+	files := map[string]string{
+		"config.yaml": "server:\n  port: \"9001\"\n",
+		"config.toml": "[server]\nport = \"9001\"\n",
+		"config.json": `{"server": {"port": "9001"}}`,
+	}
+	for name, content := range files {
+		cfg, err := config.Load(config.Options{File: writeFile(t, name, content)})
+		if err != nil || cfg.Server.Port != "9001" {
+			t.Errorf("%s: port %q, %v", name, cfg.Server.Port, err)
+		}
+	}
+
+	// Без Options.File используется CONFIG_FILE
+	t.Setenv("CONFIG_FILE", writeFile(t, "env.yaml", "server:\n  port: \"9002\"\n"))
+	if cfg, err := config.Load(config.Options{}); err != nil || cfg.Server.Port != "9002" {
+		t.Errorf("CONFIG_FILE: port %q, %v", cfg.Server.Port, err)
+	}
+	unsetenv(t, "CONFIG_FILE")
+
+	if _, err := config.Load(config.Options{File: writeFile(t, "config.ini", "port=1")}); err == nil {
+		t.Error("unknown file format accepted")
+	}
+	if _, err := config.Load(config.Options{File: writeFile(t, "broken.yaml", "server: [")}); err == nil {
+		t.Error("broken file accepted")
+	}
+	if _, err := config.Load(config.Options{DotEnv: filepath.Join(t.TempDir(), "missing.env")}); err != nil {
+		t.Errorf("missing .env: %v", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	unsetenv(t, "CONFIG_FILE", "PORT", "SERVER_READ_TIMEOUT", "MOCK_FAILURE_RATE")
+	t.Setenv("PROVIDER", "mock")
+	// Некорректная переменная не прерывает загрузку
+	t.Setenv("SERVER_READ_TIMEOUT", "soon")
+	t.Setenv("MOCK_FAILURE_RATE", "2")
+	t.Setenv("PORT", "70000")
+	cfg, err := config.Load(config.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.CORS.AllowedOrigins = []string{"https://*"}
+	cfg.Usage.STTPrice = -1
+	cfg.Log.Format = "xml"
+
+	err = cfg.Validate()
+	var invalid *config.ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Validate = %v, want ValidationError", err)
+	}
+	want := []string{"SERVER_READ_TIMEOUT", "server.port", "cors.allowed_origins", "mock.failure_rate", "usage:", "log.format"}
+	if len(invalid.Problems) != len(want) {
+		t.Errorf("problems = %q, want %d", invalid.Problems, len(want))
+	}
+	for _, prefix := range want {
+		found := false
+		for _, p := range invalid.Problems {
+			found = found || strings.HasPrefix(p, prefix)
+		}
+		if !found {
+			t.Errorf("no problem about %s in %q", prefix, invalid.Problems)
+		}
+	}
+	if !strings.Contains(err.Error(), "SERVER_READ_TIMEOUT") || !strings.Contains(err.Error(), "log.format") {
+		t.Errorf("error text does not list all problems: %v", err)
+	}
+
+	// Значения по умолчанию с заглушками корректны
+	cfg = config.Default()
+	cfg.Provider = config.ProviderMock
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("default mock config: %v", err)
+	}
+}
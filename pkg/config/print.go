@@ -0,0 +1,18 @@
+package config
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Print выводит конфигурацию в YAML с замаскированными секретами
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("ошибка кодирования конфигурации: %v", err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,38 @@
+package config
+
+import "encoding/json"
+
+// redacted - замена секретов в логах и выводе конфигурации
+const redacted = "***"
+
+// Secret - строка с секретом, которая не выводится в логи и дампы конфигурации.
+// Значение доступно только через явное приведение string(s).
+type Secret string
+
+// String возвращает замаскированное значение
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString маскирует значение для %#v
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+// MarshalJSON маскирует значение в JSON
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// MarshalYAML маскирует значение в YAML
+func (s Secret) MarshalYAML() (any, error) {
+	return s.String(), nil
+}
+
+// MarshalText маскирует значение в текстовых кодировщиках (TOML и др.)
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -0,0 +1,281 @@
+// Package conversation хранит диалог по предсказанию: после ответа пользователь
+// может задать уточняющие вопросы, и они отправляются LLM вместе с историей.
+// Идентификатор диалога совпадает с идентификатором предсказания.
+package conversation
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+// collection - коллекция диалогов в хранилище
+const collection = "conversations"
+
+// Persona - системное сообщение, задающее роль гадалки во всем диалоге
+const Persona = `Ты - мудрая и доброжелательная гадалка в мини-приложении Telegram.
+Отвечай на русском языке, образно и тепло, но по существу вопроса.
+Опирайся на данное ранее предсказание и на рассчитанные астрологические и нумерологические факты, не противоречь им и не придумывай новых положений планет.
+На уточняющие вопросы отвечай кратко, в 1-3 абзаца, и не добавляй строки IMAGE_PROMPT.
+Не давай медицинских, юридических и финансовых гарантий.`
+
+var (
+	// ErrNotFound - диалог не найден, устарел или принадлежит другому пользователю
+	ErrNotFound = errors.New("диалог не найден")
+	// ErrLimitReached - исчерпан лимит уточняющих вопросов
+	ErrLimitReached = errors.New("лимит уточняющих вопросов исчерпан")
+	// ErrEditLimitReached - исчерпан лимит перегенераций и переписываний
+	ErrEditLimitReached = errors.New("лимит изменений предсказания исчерпан")
+	// ErrNoImage - у предсказания нет изображения с таким номером
+	ErrNoImage = errors.New("изображение не найдено")
+)
+
+// Виды изменений предсказания
+const (
+	EditImage   = "image"
+	EditRewrite = "rewrite"
+)
+
+// Edit - запись об изменении предсказания
+type Edit struct {
+	Kind string `json:"kind"`
+	// Index и Prompt - номер и промпт перегенерированного изображения
+	Index  int    `json:"index,omitempty"`
+	Prompt string `json:"prompt,omitempty"`
+	// Style - стиль изображения или вид переписывания текста
+	Style     string    `json:"style,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Thread - диалог по одному предсказанию
+type Thread struct {
+	ID         string `json:"id"`
+	TelegramID int64  `json:"telegramId,omitempty"`
+	Mode       string `json:"mode"`
+	// Messages - полная история: персона, промпт предсказания, ответ и уточнения
+	Messages  []common.OpenAIMessage `json:"messages"`
+	FollowUps int                    `json:"followUps"`
+	// Prompts - текущие промпты изображений предсказания
+	Prompts []string `json:"prompts,omitempty"`
+	// Edits - история перегенераций изображений и переписываний текста
+	Edits []Edit `json:"edits,omitempty"`
+	// Personal - имена, даты и место рождения из запроса; убираются из
+	// текста при публикации предсказания
+	Personal  []string  `json:"personal,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Manager создает и хранит диалоги
+type Manager struct {
+	store *store.Store
+	cfg   config.ConversationConfig
+	now   func() time.Time
+}
+
+// NewManager создает менеджер диалогов
+func NewManager(st *store.Store, cfg config.ConversationConfig) *Manager {
+	return &Manager{store: st, cfg: cfg, now: time.Now}
+}
+
+// Start начинает диалог предсказания. Диалог сохраняется вызовом Save
+// после получения ответа модели.
+func (m *Manager) Start(state *common.UserState, prompt string) *Thread {
+	now := m.now()
+	return &Thread{
+		ID:         state.PredictionID,
+		TelegramID: state.TelegramID,
+		Mode:       state.Mode,
+		Messages: []common.OpenAIMessage{
+			{Role: common.RoleSystem, Content: Persona},
+			{Role: common.RoleUser, Content: prompt},
+		},
+		Personal:  personal(state),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// personal возвращает заполненные персональные поля запроса
+func personal(state *common.UserState) []string {
+	var fields []string
+	for _, f := range []string{state.Name, state.BirthDate, state.PartnerName, state.PartnerBirth, state.BirthPlace} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Save сохраняет диалог с ответом модели на первый промпт и промптами изображений
+func (m *Manager) Save(t *Thread, reply string, prompts []string) error {
+	t.Messages = append(t.Messages, common.OpenAIMessage{Role: common.RoleAssistant, Content: reply})
+	t.Prompts = prompts
+	m.prune()
+	return m.store.Put(collection, t.ID, t)
+}
+
+// Get возвращает диалог пользователя. Диалог, начатый в Telegram,
+// доступен только тому же пользователю.
+func (m *Manager) Get(id string, telegramID int64) (*Thread, error) {
+	var t Thread
+	ok, err := m.store.Get(collection, id, &t)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || t.TelegramID != 0 && t.TelegramID != telegramID || m.expired(&t) {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+// Left возвращает число оставшихся уточняющих вопросов
+func (m *Manager) Left(t *Thread) int {
+	return max(m.cfg.MaxFollowUps-t.FollowUps, 0)
+}
+
+// Context возвращает историю для запроса к модели с новым вопросом.
+// Персона, промпт предсказания и ответ на него передаются всегда, а из
+// уточнений - самые свежие, умещающиеся в conversation.max_context_tokens.
+func (m *Manager) Context(t *Thread, question string) ([]common.OpenAIMessage, error) {
+	if m.Left(t) == 0 {
+		return nil, ErrLimitReached
+	}
+
+	head := min(len(t.Messages), 3)
+	budget := m.cfg.MaxContextTokens - estimateTokens(question)
+	for _, msg := range t.Messages[:head] {
+		budget -= estimateTokens(msg.Content)
+	}
+
+	// Уточнения берутся парами "вопрос - ответ" с конца
+	start := len(t.Messages)
+	for start-2 >= head {
+		cost := estimateTokens(t.Messages[start-2].Content) + estimateTokens(t.Messages[start-1].Content)
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start -= 2
+	}
+
+	messages := make([]common.OpenAIMessage, 0, head+len(t.Messages)-start+1)
+	messages = append(messages, t.Messages[:head]...)
+	messages = append(messages, t.Messages[start:]...)
+	return append(messages, common.OpenAIMessage{Role: common.RoleUser, Content: question}), nil
+}
+
+// Append сохраняет уточняющий вопрос и ответ. Лимит проверяется повторно:
+// параллельный вопрос мог израсходовать последнюю попытку.
+func (m *Manager) Append(id, question, answer string) (*Thread, error) {
+	var t Thread
+	err := m.store.Update(collection, id, &t, func(exists bool) error {
+		if !exists {
+			return ErrNotFound
+		}
+		if t.FollowUps >= m.cfg.MaxFollowUps {
+			return ErrLimitReached
+		}
+		t.FollowUps++
+		t.UpdatedAt = m.now()
+		t.Messages = append(t.Messages,
+			common.OpenAIMessage{Role: common.RoleUser, Content: question},
+			common.OpenAIMessage{Role: common.RoleAssistant, Content: answer},
+		)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// EditsLeft возвращает число оставшихся изменений предсказания
+func (m *Manager) EditsLeft(t *Thread) int {
+	return max(m.cfg.MaxEdits-len(t.Edits), 0)
+}
+
+// Reading возвращает текущий текст предсказания
+func (t *Thread) Reading() string {
+	if len(t.Messages) < 3 {
+		return ""
+	}
+	return t.Messages[2].Content
+}
+
+// SetImage сохраняет новый промпт изображения index после его перегенерации
+func (m *Manager) SetImage(id string, index int, prompt, style string) (*Thread, error) {
+	return m.edit(id, Edit{Kind: EditImage, Index: index, Prompt: prompt, Style: style}, func(t *Thread) error {
+		if index < 0 || index >= len(t.Prompts) {
+			return ErrNoImage
+		}
+		t.Prompts[index] = prompt
+		return nil
+	})
+}
+
+// Rewrite заменяет текст предсказания переписанным: уточнения дальше
+// опираются на новую версию
+func (m *Manager) Rewrite(id, style, text string) (*Thread, error) {
+	return m.edit(id, Edit{Kind: EditRewrite, Style: style}, func(t *Thread) error {
+		if len(t.Messages) < 3 {
+			return ErrNotFound
+		}
+		t.Messages[2].Content = text
+		return nil
+	})
+}
+
+// edit применяет изменение и записывает его в историю. Лимит проверяется
+// повторно: параллельный запрос мог израсходовать последнюю попытку.
+func (m *Manager) edit(id string, e Edit, apply func(*Thread) error) (*Thread, error) {
+	var t Thread
+	err := m.store.Update(collection, id, &t, func(exists bool) error {
+		if !exists {
+			return ErrNotFound
+		}
+		if len(t.Edits) >= m.cfg.MaxEdits {
+			return ErrEditLimitReached
+		}
+		if err := apply(&t); err != nil {
+			return err
+		}
+		e.CreatedAt = m.now()
+		t.Edits = append(t.Edits, e)
+		t.UpdatedAt = e.CreatedAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// expired сообщает, истек ли срок хранения диалога
+func (m *Manager) expired(t *Thread) bool {
+	return m.cfg.TTL > 0 && m.now().Sub(t.UpdatedAt) > m.cfg.TTL
+}
+
+// prune удаляет диалоги, к которым не обращались дольше TTL
+func (m *Manager) prune() {
+	err := store.Each(m.store, collection, func(key string, t *Thread) error {
+		if m.expired(t) {
+			m.store.Delete(collection, key)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("conversations prune failed", slog.String("error", err.Error()))
+	}
+}
+
+// estimateTokens грубо оценивает число токенов: для русского текста
+// токенизаторы дают примерно 3 символа на токен
+func estimateTokens(s string) int {
+	return utf8.RuneCountInString(s)/3 + 4
+}
@@ -0,0 +1,202 @@
+package conversation_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/conversation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+// newThread создает менеджер в памяти и сохраненный диалог пользователя 42
+func newThread(t *testing.T, cfg config.ConversationConfig) (*conversation.Manager, *conversation.Thread) {
+	t.Helper()
+	m := conversation.NewManager(openStore(t), cfg)
+	state := &common.UserState{PredictionID: "p1", TelegramID: 42, Mode: "Карьера", Name: "Анна", BirthDate: "1990-03-15"}
+	thread := m.Start(state, "Промпт предсказания")
+	if err := m.Save(thread, "Предсказание", []string{"кот", "луна"}); err != nil {
+		t.Fatal(err)
+	}
+	return m, thread
+}
+
+func openStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// tokens - оценка пакета: около 3 символов русского текста на токен
+func tokens(s string) int {
+	return utf8.RuneCountInString(s)/3 + 4
+}
+
+func TestContextWindow(t *testing.T) {
+	cfg := config.Default().Conversation
+	cfg.MaxFollowUps = 10
+	m, thread := newThread(t, cfg)
+	for i := range 4 {
+		q := fmt.Sprintf("вопрос %d %s", i, strings.Repeat("в", 300))
+		a := fmt.Sprintf("ответ %d %s", i, strings.Repeat("о", 300))
+		var err error
+		if thread, err = m.Append(thread.ID, q, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const question = "Новый вопрос"
+	head := tokens(conversation.Persona) + tokens("Промпт предсказания") + tokens("Предсказание") + tokens(question)
+	pair := tokens(thread.Messages[3].Content) + tokens(thread.Messages[4].Content)
+
+	tests := []struct {
+		name   string
+		budget int
+		// pairs - сколько последних уточнений попадает в историю
+		pairs int
+	}{
+		{"everything fits", 100000, 4},
+		{"two latest pairs", head + 2*pair + pair/2, 2},
+		{"exactly one pair", head + pair, 1},
+		// Персона, промпт и предсказание передаются даже сверх лимита
+		{"no room", 1, 0},
+	}
+	for _, tt := range tests {
+		cfg.MaxContextTokens = tt.budget
+		m := conversation.NewManager(openStore(t), cfg)
+		messages, err := m.Context(thread, question)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(messages) != 3+2*tt.pairs+1 {
+			t.Errorf("%s: %d messages, want %d", tt.name, len(messages), 3+2*tt.pairs+1)
+			continue
+		}
+		if messages[0].Content != conversation.Persona || messages[2].Content != "Предсказание" {
+			t.Errorf("%s: head = %q, %q", tt.name, messages[0].Content[:20], messages[2].Content)
+		}
+		// Уточнения - самые свежие и в исходном порядке
+		for i, msg := range messages[3 : len(messages)-1] {
+			if want := thread.Messages[len(thread.Messages)-2*tt.pairs+i]; msg.Role != want.Role || msg.Content != want.Content {
+				t.Errorf("%s: message %d = %q", tt.name, 3+i, msg.Content[:20])
+			}
+		}
+		if last := messages[len(messages)-1]; last.Role != common.RoleUser || last.Content != question {
+			t.Errorf("%s: last message = %+v", tt.name, last)
+		}
+	}
+}
+
+func TestFollowUpLimit(t *testing.T) {
+	cfg := config.Default().Conversation
+	cfg.MaxFollowUps = 2
+	m, thread := newThread(t, cfg)
+	if m.Left(thread) != 2 {
+		t.Fatalf("Left = %d, want 2", m.Left(thread))
+	}
+	for i := range 2 {
+		if _, err := m.Context(thread, "вопрос"); err != nil {
+			t.Fatalf("Context %d: %v", i, err)
+		}
+		var err error
+		if thread, err = m.Append(thread.ID, "вопрос", "ответ"); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+	if m.Left(thread) != 0 || thread.FollowUps != 2 || len(thread.Messages) != 7 {
+		t.Errorf("thread after limit: left %d, %d follow-ups, %d messages", m.Left(thread), thread.FollowUps, len(thread.Messages))
+	}
+	if _, err := m.Context(thread, "еще"); !errors.Is(err, conversation.ErrLimitReached) {
+		t.Errorf("Context over limit = %v", err)
+	}
+	// Append проверяет лимит сам: вопрос мог уйти параллельно
+	if _, err := m.Append(thread.ID, "еще", "ответ"); !errors.Is(err, conversation.ErrLimitReached) {
+		t.Errorf("Append over limit = %v", err)
+	}
+	if _, err := m.Append("unknown", "вопрос", "ответ"); !errors.Is(err, conversation.ErrNotFound) {
+		t.Errorf("Append to unknown thread = %v", err)
+	}
+
+	// Лимит считается для каждого предсказания отдельно
+	other := m.Start(&common.UserState{PredictionID: "p2", TelegramID: 42}, "промпт")
+	m.Save(other, "ответ", nil)
+	if other, err := m.Get("p2", 42); err != nil || m.Left(other) != 2 {
+		t.Errorf("other reading: %v", err)
+	}
+}
+
+func TestEditLimit(t *testing.T) {
+	cfg := config.Default().Conversation
+	cfg.MaxEdits = 2
+	m, thread := newThread(t, cfg)
+
+	if _, err := m.SetImage(thread.ID, 2, "солнце", "акварель"); !errors.Is(err, conversation.ErrNoImage) {
+		t.Errorf("SetImage out of range = %v", err)
+	}
+	thread, err := m.SetImage(thread.ID, 1, "солнце", "акварель")
+	if err != nil || thread.Prompts[1] != "солнце" {
+		t.Fatalf("SetImage = %v, %v", thread.Prompts, err)
+	}
+	if thread, err = m.Rewrite(thread.ID, "короче", "Краткое предсказание"); err != nil {
+		t.Fatal(err)
+	}
+	// Неудачная попытка не расходует лимит
+	if len(thread.Edits) != 2 || thread.Edits[0].Kind != conversation.EditImage || thread.Edits[1].Kind != conversation.EditRewrite {
+		t.Errorf("edits = %+v", thread.Edits)
+	}
+	if thread.Reading() != "Краткое предсказание" || m.EditsLeft(thread) != 0 {
+		t.Errorf("reading %q, %d edits left", thread.Reading(), m.EditsLeft(thread))
+	}
+	if _, err := m.Rewrite(thread.ID, "короче", "еще"); !errors.Is(err, conversation.ErrEditLimitReached) {
+		t.Errorf("Rewrite over limit = %v", err)
+	}
+	if _, err := m.SetImage(thread.ID, 0, "звезда", ""); !errors.Is(err, conversation.ErrEditLimitReached) {
+		t.Errorf("SetImage over limit = %v", err)
+	}
+	if _, err := m.Rewrite("unknown", "короче", "текст"); !errors.Is(err, conversation.ErrNotFound) {
+		t.Errorf("Rewrite of unknown thread = %v", err)
+	}
+}
+
+func TestAccessAndExpiry(t *testing.T) {
+	cfg := config.Default().Conversation
+	cfg.TTL = 200 * time.Millisecond
+	m, thread := newThread(t, cfg)
+
+	if got, err := m.Get(thread.ID, 42); err != nil || got.Reading() != "Предсказание" || len(got.Personal) != 2 {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	if _, err := m.Get(thread.ID, 7); !errors.Is(err, conversation.ErrNotFound) {
+		t.Errorf("Get by other user = %v", err)
+	}
+	// Диалог из браузера доступен без данных Telegram
+	anon := m.Start(&common.UserState{PredictionID: "anon"}, "промпт")
+	m.Save(anon, "ответ", nil)
+	if _, err := m.Get("anon", 7); err != nil {
+		t.Errorf("anonymous thread: %v", err)
+	}
+
+	// Срок отсчитывается от последнего вопроса
+	time.Sleep(120 * time.Millisecond)
+	if _, err := m.Append(thread.ID, "вопрос", "ответ"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if _, err := m.Get(thread.ID, 42); err != nil {
+		t.Errorf("Get after follow-up: %v", err)
+	}
+	if _, err := m.Get("anon", 0); !errors.Is(err, conversation.ErrNotFound) {
+		t.Errorf("Get of expired thread = %v", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := m.Get(thread.ID, 42); !errors.Is(err, conversation.ErrNotFound) {
+		t.Errorf("Get after TTL = %v", err)
+	}
+}
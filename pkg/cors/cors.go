@@ -0,0 +1,261 @@
+// Package cors реализует настраиваемую CORS-политику для API мини-приложения
+package cors
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options описывает CORS-политику
+type Options struct {
+	// AllowedOrigins - список разрешенных источников. Поддерживаются точные значения
+	// ("https://ptspuf.github.io"), шаблоны поддоменов ("https://*.vercel.app"),
+	// "null" для sandbox-фреймов и "*" для любого источника.
+	AllowedOrigins []string
+	// AllowedMethods - методы, разрешенные в preflight-ответе
+	AllowedMethods []string
+	// AllowedHeaders - заголовки запроса, разрешенные в preflight-ответе
+	AllowedHeaders []string
+	// ExposedHeaders - заголовки ответа, доступные скрипту
+	ExposedHeaders []string
+	// AllowCredentials разрешает передачу cookies и Authorization
+	AllowCredentials bool
+	// MaxAge - время кэширования preflight-ответа браузером
+	MaxAge time.Duration
+}
+
+// Route переопределяет методы и заголовки политики для отдельного маршрута
+type Route struct {
+	Methods []string
+	Headers []string
+}
+
+// Policy - скомпилированная CORS-политика
+type Policy struct {
+	opts     Options
+	allowAll bool
+	exact    map[string]bool
+	patterns []originPattern
+	methods  map[string]bool
+	headers  map[string]bool
+}
+
+// originPattern описывает шаблон вида scheme://*.domain[:port]
+type originPattern struct {
+	scheme string
+	suffix string
+	port   string
+}
+
+// DefaultMethods - методы по умолчанию
+var DefaultMethods = []string{http.MethodGet, http.MethodPost, http.MethodHead, http.MethodOptions}
+
+// DefaultHeaders - заголовки запроса по умолчанию
+var DefaultHeaders = []string{"Accept", "Authorization", "Content-Type", "Origin"}
+
+// New создает политику из настроек
+func New(opts Options) *Policy {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = DefaultMethods
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = DefaultHeaders
+	}
+
+	p := &Policy{
+		opts:  opts,
+		exact: make(map[string]bool),
+	}
+	for _, origin := range opts.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "":
+		case origin == "*":
+			p.allowAll = true
+		case strings.Contains(origin, "*"):
+			if pattern, ok := parsePattern(origin); ok {
+				p.patterns = append(p.patterns, pattern)
+			}
+		default:
+			p.exact[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+	p.methods = toSet(opts.AllowedMethods, strings.ToUpper)
+	p.headers = toSet(opts.AllowedHeaders, http.CanonicalHeaderKey)
+	return p
+}
+
+// Route возвращает копию политики с методами и заголовками конкретного маршрута.
+// Пустые поля Route наследуются от исходной политики.
+func (p *Policy) Route(r Route) *Policy {
+	opts := p.opts
+	if len(r.Methods) > 0 {
+		opts.AllowedMethods = append(append([]string{}, r.Methods...), http.MethodOptions)
+	}
+	if len(r.Headers) > 0 {
+		opts.AllowedHeaders = r.Headers
+	}
+	return New(opts)
+}
+
+// ValidPattern сообщает, является ли строка допустимым значением AllowedOrigins
+func ValidPattern(origin string) bool {
+	switch {
+	case origin == "*", origin == "null":
+		return true
+	case strings.Contains(origin, "*"):
+		_, ok := parsePattern(origin)
+		return ok
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && (u.Path == "" || u.Path == "/")
+}
+
+// Allowed сообщает, разрешен ли источник
+func (p *Policy) Allowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	if len(p.patterns) == 0 {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, pattern := range p.patterns {
+		if pattern.match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler оборачивает обработчик CORS-проверкой.
+// Запросы без заголовка Origin (curl, вебхуки) и запросы с того же хоста
+// (например, статика и API одного деплоя Vercel) пропускаются без изменений.
+// Запросы от неразрешенных источников отклоняются с 403, чтобы не запускать
+// дорогую генерацию ради ответа, который браузер все равно не отдаст скрипту.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// Ответ зависит от Origin, поэтому кэши должны это учитывать
+		w.Header().Add("Vary", "Origin")
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin == "" || sameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !p.Allowed(origin) {
+			http.Error(w, "CORS origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		if preflight {
+			p.handlePreflight(w, r, origin)
+			return
+		}
+
+		p.setOrigin(w, origin)
+		if len(p.opts.ExposedHeaders) > 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.opts.ExposedHeaders, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Policy) handlePreflight(w http.ResponseWriter, r *http.Request, origin string) {
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !p.methods[method] {
+		http.Error(w, "CORS method not allowed", http.StatusForbidden)
+		return
+	}
+
+	requested := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+	for _, h := range requested {
+		if !p.headers[h] {
+			http.Error(w, "CORS header not allowed: "+h, http.StatusForbidden)
+			return
+		}
+	}
+
+	p.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.opts.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if p.opts.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.opts.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Policy) setOrigin(w http.ResponseWriter, origin string) {
+	// С credentials браузер не принимает "*", поэтому возвращаем конкретный источник
+	if p.allowAll && !p.opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// sameOrigin сообщает, совпадает ли Origin с хостом запроса
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func parsePattern(origin string) (originPattern, bool) {
+	scheme, rest, ok := strings.Cut(strings.ToLower(origin), "://")
+	if !ok || scheme == "" || !strings.HasPrefix(rest, "*.") {
+		return originPattern{}, false
+	}
+	host, port, _ := strings.Cut(strings.TrimPrefix(rest, "*"), ":")
+	if host == "." || strings.ContainsAny(host, "*/") {
+		return originPattern{}, false
+	}
+	return originPattern{scheme: scheme, suffix: host, port: port}, true
+}
+
+func (o originPattern) match(u *url.URL) bool {
+	if u.Scheme != o.scheme || u.Port() != o.port {
+		return false
+	}
+	host := u.Hostname()
+	// "*.example.com" совпадает с любым поддоменом, но не с самим example.com
+	return strings.HasSuffix(host, o.suffix) && len(host) > len(o.suffix)
+}
+
+func parseHeaderList(value string) []string {
+	var headers []string
+	for _, h := range strings.Split(value, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	return headers
+}
+
+func toSet(values []string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[normalize(strings.TrimSpace(v))] = true
+	}
+	return set
+}
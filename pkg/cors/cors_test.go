@@ -0,0 +1,158 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/cors"
+)
+
+func TestAllowed(t *testing.T) {
+	p := cors.New(cors.Options{AllowedOrigins: []string{
+		"https://ptspuf.github.io/",
+		"https://*.vercel.app",
+		"http://*.localhost:5173",
+		"null",
+	}})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://ptspuf.github.io", true},
+		{"HTTPS://PtsPuf.GitHub.io", true},
+		{"https://app.vercel.app", true},
+		{"https://a.b.vercel.app", true},
+		{"http://app.localhost:5173", true},
+		{"null", true},
+		// Шаблон поддоменов не совпадает с похожими доменами
+		{"https://vercel.app", false},
+		{"https://evil-vercel.app", false},
+		{"https://vercel.app.evil.com", false},
+		{"https://app.vercel.app.evil.com", false},
+		// Схема и порт шаблона обязательны
+		{"http://app.vercel.app", false},
+		{"https://app.vercel.app:8443", false},
+		{"http://app.localhost", false},
+		{"https://ptspuf.github.io.evil.com", false},
+		{"https://evil.example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !cors.New(cors.Options{AllowedOrigins: []string{"*"}}).Allowed("https://evil.example") {
+		t.Error("wildcard policy rejected an origin")
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	tests := map[string]bool{
+		"*":                            true,
+		"null":                         true,
+		"https://ptspuf.github.io":     true,
+		"https://*.vercel.app":         true,
+		"https://*.vercel.app:8443":    true,
+		"https://ptspuf.github.io/app": false,
+		"ptspuf.github.io":             false,
+		"https://*":                    false,
+		"https://*.":                   false,
+		"https://app.*.vercel.app":     false,
+		"*.vercel.app":                 false,
+	}
+	for origin, want := range tests {
+		if got := cors.ValidPattern(origin); got != want {
+			t.Errorf("ValidPattern(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p := cors.New(cors.Options{
+		AllowedOrigins:   []string{"https://*.vercel.app"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	handler := p.Route(cors.Route{Methods: []string{http.MethodPost}}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		host    string
+		headers map[string]string
+		want    int
+		// allow - ожидаемый Access-Control-Allow-Origin
+		allow string
+	}{
+		{"no origin", http.MethodPost, "api.example", nil, http.StatusTeapot, ""},
+		{"same origin", http.MethodPost, "api.example", map[string]string{"Origin": "https://api.example"}, http.StatusTeapot, ""},
+		{"allowed origin", http.MethodPost, "api.example", map[string]string{"Origin": "https://app.vercel.app"}, http.StatusTeapot, "https://app.vercel.app"},
+		{"rejected origin", http.MethodPost, "api.example", map[string]string{"Origin": "https://evil-vercel.app"}, http.StatusForbidden, ""},
+		{"rejected lookalike", http.MethodPost, "api.example", map[string]string{"Origin": "https://vercel.app.evil.com"}, http.StatusForbidden, ""},
+		{"preflight", http.MethodOptions, "api.example", map[string]string{
+			"Origin":                         "https://app.vercel.app",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type, authorization",
+		}, http.StatusNoContent, "https://app.vercel.app"},
+		{"preflight method of another route", http.MethodOptions, "api.example", map[string]string{
+			"Origin":                        "https://app.vercel.app",
+			"Access-Control-Request-Method": "DELETE",
+		}, http.StatusForbidden, ""},
+		{"preflight unknown header", http.MethodOptions, "api.example", map[string]string{
+			"Origin":                         "https://app.vercel.app",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "X-Secret",
+		}, http.StatusForbidden, ""},
+		{"preflight rejected origin", http.MethodOptions, "api.example", map[string]string{
+			"Origin":                        "https://evil.example",
+			"Access-Control-Request-Method": "POST",
+		}, http.StatusForbidden, ""},
+		// OPTIONS без Access-Control-Request-Method - обычный запрос
+		{"plain options", http.MethodOptions, "api.example", map[string]string{"Origin": "https://app.vercel.app"}, http.StatusTeapot, "https://app.vercel.app"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://"+tt.host+"/prediction", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("%s: Allow-Origin %q, want %q", tt.name, got, tt.allow)
+		}
+		if w.Header().Get("Vary") == "" {
+			t.Errorf("%s: no Vary header", tt.name)
+		}
+	}
+
+	// Заголовки ответа на разрешенные запросы
+	r := httptest.NewRequest(http.MethodOptions, "http://api.example/prediction", nil)
+	r.Header.Set("Origin", "https://app.vercel.app")
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	r.Header.Set("Access-Control-Request-Headers", "content-type")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Methods") != "POST, OPTIONS" || h.Get("Access-Control-Allow-Headers") != "Content-Type" ||
+		h.Get("Access-Control-Max-Age") != "600" || h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("preflight headers = %v", h)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "http://api.example/prediction", nil)
+	r.Header.Set("Origin", "https://app.vercel.app")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Errorf("exposed headers = %q", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
@@ -0,0 +1,81 @@
+package decision
+
+import "math/rand/v2"
+
+// Card - вытянутая карта Старших арканов
+type Card struct {
+	Name     string `json:"name"`
+	Reversed bool   `json:"reversed"`
+}
+
+// String - название карты для промпта и текста ответа
+func (c Card) String() string {
+	if c.Reversed {
+		return c.Name + " (перевернутая)"
+	}
+	return c.Name
+}
+
+// arcana - Старшие арканы: название, английское название для промпта
+// изображения и ключевые значения в прямом положении
+var arcana = []struct {
+	name, en, keywords string
+}{
+	{"Шут", "The Fool", "новое начало, спонтанность, риск"},
+	{"Маг", "The Magician", "воля, мастерство, ресурсы под рукой"},
+	{"Верховная Жрица", "The High Priestess", "интуиция, скрытое знание, ожидание"},
+	{"Императрица", "The Empress", "изобилие, забота, рост"},
+	{"Император", "The Emperor", "порядок, структура, ответственность"},
+	{"Иерофант", "The Hierophant", "традиции, наставник, общепринятый путь"},
+	{"Влюбленные", "The Lovers", "выбор сердцем, союз, ценности"},
+	{"Колесница", "The Chariot", "движение вперед, победа, контроль"},
+	{"Сила", "Strength", "мужество, терпение, внутренняя сила"},
+	{"Отшельник", "The Hermit", "уединение, поиск, мудрость"},
+	{"Колесо Фортуны", "Wheel of Fortune", "перемены, удача, поворот судьбы"},
+	{"Справедливость", "Justice", "равновесие, честность, последствия"},
+	{"Повешенный", "The Hanged Man", "пауза, жертва, новый взгляд"},
+	{"Смерть", "Death", "завершение, трансформация, освобождение"},
+	{"Умеренность", "Temperance", "гармония, мера, исцеление"},
+	{"Дьявол", "The Devil", "зависимость, соблазн, привязанность"},
+	{"Башня", "The Tower", "внезапный слом, потрясение, откровение"},
+	{"Звезда", "The Star", "надежда, вдохновение, обновление"},
+	{"Луна", "The Moon", "иллюзии, страхи, неясность"},
+	{"Солнце", "The Sun", "радость, успех, ясность"},
+	{"Суд", "Judgement", "пробуждение, призвание, итог"},
+	{"Мир", "The World", "завершенность, цельность, достижение"},
+}
+
+// keywords возвращает ключевые значения карты
+func keywords(name string) string {
+	for _, a := range arcana {
+		if a.name == name {
+			return a.keywords
+		}
+	}
+	return ""
+}
+
+// arcanaEnglish возвращает английское название карты
+func arcanaEnglish(name string) string {
+	for _, a := range arcana {
+		if a.name == name {
+			return a.en
+		}
+	}
+	return name
+}
+
+// Draw вытягивает n разных карт, каждая может выпасть перевернутой.
+// rng задает генератор в тестах; nil - общий генератор.
+func Draw(rng *rand.Rand, n int) []Card {
+	perm, reversed := rand.Perm, rand.IntN
+	if rng != nil {
+		perm, reversed = rng.Perm, rng.IntN
+	}
+	n = min(n, len(arcana))
+	cards := make([]Card, n)
+	for i, j := range perm(len(arcana))[:n] {
+		cards[i] = Card{Name: arcana[j].name, Reversed: reversed(2) == 1}
+	}
+	return cards
+}
@@ -0,0 +1,201 @@
+// Package decision - сфера «Принятие решений». Пользователь перечисляет от
+// двух до пяти вариантов, на каждый вытягивается карта Старших арканов, а
+// модель оценивает варианты по единой схеме: плюсы, минусы и энергия карты.
+// Вместо эссе ответ - сравнение вариантов, упорядоченное по энергии.
+package decision
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Mode - сфера предсказания с выбором между вариантами
+const Mode = "Принятие решений"
+
+const (
+	MinOptions = 2
+	MaxOptions = 5
+	// maxOptionLen - максимальная длина варианта в символах
+	maxOptionLen = 200
+	// Энергия варианта оценивается от minEnergy до maxEnergy
+	minEnergy = 1
+	maxEnergy = 10
+)
+
+var (
+	// ErrOptions - варианты не подходят для сравнения
+	ErrOptions = errors.New("некорректные варианты")
+	// ErrMalformed - ответ модели не соответствует схеме
+	ErrMalformed = errors.New("ответ модели не соответствует схеме сравнения")
+)
+
+// Option - оценка одного варианта
+type Option struct {
+	Option  string   `json:"option"`
+	Card    Card     `json:"card"`
+	Pros    []string `json:"pros"`
+	Cons    []string `json:"cons"`
+	Energy  int      `json:"energy"`
+	Summary string   `json:"summary"`
+	// Rank - место варианта, 1 - самый благоприятный
+	Rank int `json:"rank"`
+}
+
+// Result - сравнение вариантов. Options упорядочены по месту, и промпты
+// изображений (а значит, и изображения ответа) идут в том же порядке.
+type Result struct {
+	Options []Option `json:"options"`
+	Verdict string   `json:"verdict"`
+
+	prompts []string
+}
+
+// Normalize убирает пробелы и пустые строки и проверяет число, длину и
+// уникальность вариантов
+func Normalize(options []string) ([]string, error) {
+	var out []string
+	for _, o := range options {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		if utf8.RuneCountInString(o) > maxOptionLen {
+			return nil, fmt.Errorf("%w: вариант длиннее %d символов", ErrOptions, maxOptionLen)
+		}
+		if slices.ContainsFunc(out, func(s string) bool { return strings.EqualFold(s, o) }) {
+			return nil, fmt.Errorf("%w: вариант «%s» повторяется", ErrOptions, o)
+		}
+		out = append(out, o)
+	}
+	if len(out) < MinOptions || len(out) > MaxOptions {
+		return nil, fmt.Errorf("%w: нужно от %d до %d вариантов", ErrOptions, MinOptions, MaxOptions)
+	}
+	return out, nil
+}
+
+// Prompt собирает промпт сравнения: вариант i толкуется по карте cards[i].
+// facts - рассчитанные астрологические данные, может быть пустым.
+func Prompt(name, birthDate, question, facts string, options []string, cards []Card) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ты - опытный таролог. Человек по имени %s (родился(ась) %s) выбирает между вариантами. "+
+		"Вопрос: %s (сфера: %s). На каждый вариант вытянута карта Старших арканов:\n",
+		name, birthDate, question, Mode)
+	for i, o := range options {
+		fmt.Fprintf(&b, "Вариант %d: %s. Карта: %s — %s\n", i+1, o, cards[i], keywords(cards[i].Name))
+	}
+	if facts != "" {
+		b.WriteString("\nАстрологические данные рассчитаны по эфемеридам, учитывай их:\n")
+		b.WriteString(facts)
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\nИстолкуй карту каждого варианта применительно к вопросу. Ответь только JSON-объектом без пояснений:\n"+
+		`{"options": [{"index": 1, "pros": ["..."], "cons": ["..."], "energy": 7, "summary": "...", "image": "..."}], "verdict": "..."}`+"\n"+
+		"index - номер варианта, оцени все варианты. pros и cons - по 2-4 коротких пункта на русском. "+
+		"energy - целое число от %d до %d: насколько благоприятна энергия карты для этого варианта. "+
+		"summary - 2-3 предложения толкования. image - промпт на английском для изображения карты варианта в стиле Кандинского. "+
+		"verdict - итоговый совет на русском, 3-5 предложений; решение остается за человеком.",
+		minEnergy, maxEnergy)
+	return b.String()
+}
+
+// answer - ответ модели по схеме из Prompt
+type answer struct {
+	Options []struct {
+		Index   int      `json:"index"`
+		Pros    []string `json:"pros"`
+		Cons    []string `json:"cons"`
+		Energy  float64  `json:"energy"`
+		Summary string   `json:"summary"`
+		Image   string   `json:"image"`
+	} `json:"options"`
+	Verdict string `json:"verdict"`
+}
+
+// Parse разбирает ответ модели и ранжирует варианты по энергии; при равной
+// энергии сохраняется порядок пользователя
+func Parse(content string, options []string, cards []Card) (*Result, error) {
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("%w: нет JSON", ErrMalformed)
+	}
+	var a answer
+	if err := json.Unmarshal([]byte(content[start:end+1]), &a); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	r := &Result{Options: make([]Option, len(options)), Verdict: strings.TrimSpace(a.Verdict)}
+	images := make([]string, len(options))
+	seen := make([]bool, len(options))
+	for _, o := range a.Options {
+		i := o.Index - 1
+		if i < 0 || i >= len(options) || seen[i] {
+			continue
+		}
+		seen[i] = true
+		r.Options[i] = Option{
+			Option:  options[i],
+			Card:    cards[i],
+			Pros:    o.Pros,
+			Cons:    o.Cons,
+			Energy:  min(max(int(o.Energy+0.5), minEnergy), maxEnergy),
+			Summary: strings.TrimSpace(o.Summary),
+		}
+		images[i] = strings.TrimSpace(o.Image)
+	}
+	if i := slices.Index(seen, false); i >= 0 {
+		return nil, fmt.Errorf("%w: нет оценки варианта %d", ErrMalformed, i+1)
+	}
+	for i, img := range images {
+		if img == "" {
+			images[i] = fmt.Sprintf("Tarot card %s, abstract composition in Kandinsky style, vibrant colors", arcanaEnglish(cards[i].Name))
+		}
+	}
+
+	order := make([]int, len(options))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(x, y int) int {
+		return cmp.Compare(r.Options[y].Energy, r.Options[x].Energy)
+	})
+	ranked := make([]Option, len(order))
+	r.prompts = make([]string, len(order))
+	for rank, i := range order {
+		ranked[rank] = r.Options[i]
+		ranked[rank].Rank = rank + 1
+		r.prompts[rank] = images[i]
+	}
+	r.Options = ranked
+	return r, nil
+}
+
+// ImagePrompts - промпты изображений карт в порядке мест
+func (r *Result) ImagePrompts() []string {
+	return r.prompts
+}
+
+// Text - сравнение в виде текста для диалога, озвучки и публикации
+func (r *Result) Text() string {
+	var b strings.Builder
+	b.WriteString("Сравнение вариантов по картам:")
+	for _, o := range r.Options {
+		fmt.Fprintf(&b, "\n\n%d. %s — %s, энергия %d/%d", o.Rank, o.Option, o.Card, o.Energy, maxEnergy)
+		if len(o.Pros) > 0 {
+			b.WriteString("\nЗа: " + strings.Join(o.Pros, "; "))
+		}
+		if len(o.Cons) > 0 {
+			b.WriteString("\nПротив: " + strings.Join(o.Cons, "; "))
+		}
+		if o.Summary != "" {
+			b.WriteString("\n" + o.Summary)
+		}
+	}
+	if r.Verdict != "" {
+		b.WriteString("\n\nИтог: " + r.Verdict)
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package decision_test
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+)
+
+func TestNormalize(t *testing.T) {
+	got, err := decision.Normalize([]string{" Остаться ", "", "Переехать"})
+	if err != nil || len(got) != 2 || got[0] != "Остаться" {
+		t.Fatalf("Normalize = %q, %v", got, err)
+	}
+	for name, options := range map[string][]string{
+		"one":       {"Остаться", " "},
+		"six":       {"1", "2", "3", "4", "5", "6"},
+		"duplicate": {"Остаться", "остаться"},
+		"long":      {"Остаться", strings.Repeat("я", 201)},
+	} {
+		if _, err := decision.Normalize(options); !errors.Is(err, decision.ErrOptions) {
+			t.Errorf("%s: err = %v, want ErrOptions", name, err)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	cards := decision.Draw(rand.New(rand.NewPCG(1, 2)), 5)
+	if len(cards) != 5 {
+		t.Fatalf("drew %d cards, want 5", len(cards))
+	}
+	seen := map[string]bool{}
+	for _, c := range cards {
+		if c.Name == "" || seen[c.Name] {
+			t.Errorf("card %q is empty or repeated", c.Name)
+		}
+		seen[c.Name] = true
+	}
+}
+
+func TestParse(t *testing.T) {
+	options := []string{"Остаться", "Переехать", "Подождать"}
+	cards := []decision.Card{{Name: "Башня"}, {Name: "Звезда"}, {Name: "Повешенный", Reversed: true}}
+	prompt := decision.Prompt("Анна", "15.03.1990", "Куда двигаться?", "", options, cards)
+	if !strings.Contains(prompt, "Вариант 3: Подождать. Карта: Повешенный (перевернутая)") {
+		t.Errorf("prompt does not pair options with cards:\n%s", prompt)
+	}
+
+	// Модель обернула JSON в блок кода и вышла за шкалу энергии
+	content := "```json\n" + `{"options": [
+		{"index": 1, "pros": ["привычно"], "cons": ["застой"], "energy": 3, "summary": "Башня рушит опору.", "image": "falling tower"},
+		{"index": 3, "pros": ["время подумать"], "cons": ["упущенный шанс"], "energy": 3, "summary": "Пауза затянется."},
+		{"index": 2, "pros": ["надежда", "новые люди"], "cons": ["расходы"], "energy": 12, "summary": "Звезда ведет."}
+	], "verdict": "Карты склоняются к переезду."}` + "\n```"
+	r, err := decision.Parse(content, options, cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for i, o := range r.Options {
+		if o.Rank != i+1 {
+			t.Errorf("option %q rank = %d, want %d", o.Option, o.Rank, i+1)
+		}
+		order = append(order, o.Option)
+	}
+	// При равной энергии сохраняется порядок пользователя
+	if strings.Join(order, ",") != "Переехать,Остаться,Подождать" || r.Options[0].Energy != 10 || r.Options[0].Card.Name != "Звезда" {
+		t.Errorf("ranking = %v, top = %+v", order, r.Options[0])
+	}
+	prompts := r.ImagePrompts()
+	if len(prompts) != 3 || prompts[1] != "falling tower" || !strings.Contains(prompts[0], "The Star") {
+		t.Errorf("image prompts = %q", prompts)
+	}
+	text := r.Text()
+	if !strings.Contains(text, "1. Переехать — Звезда, энергия 10/10") || !strings.Contains(text, "Итог: Карты склоняются к переезду.") {
+		t.Errorf("text:\n%s", text)
+	}
+
+	for name, content := range map[string]string{
+		"not json": "Карты говорят: переезжайте.",
+		"missing":  `{"options": [{"index": 1, "energy": 5}, {"index": 2, "energy": 5}], "verdict": ""}`,
+	} {
+		if _, err := decision.Parse(content, options, cards); !errors.Is(err, decision.ErrMalformed) {
+			t.Errorf("%s: err = %v, want ErrMalformed", name, err)
+		}
+	}
+}
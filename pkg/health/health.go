@@ -0,0 +1,148 @@
+// Package health выполняет проверки готовности с кэшированием результатов
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Статусы отчета о готовности
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+// Check - одна проверка зависимости
+type Check struct {
+	Name string
+	// Critical - без этой зависимости сервис не может обработать запрос.
+	// Сбой некритичной проверки переводит отчет в состояние degraded.
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Result - результат проверки
+type Result struct {
+	OK        bool      `json:"ok"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Report - сводный отчет о готовности
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Summary - отчет для анонимных клиентов: только состояние проверок, без
+// текстов ошибок внешних API
+type Summary struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckStatus `json:"checks"`
+}
+
+// CheckStatus - состояние одной проверки в Summary
+type CheckStatus struct {
+	OK bool `json:"ok"`
+}
+
+// Summary убирает из отчета подробности проверок
+func (r Report) Summary() Summary {
+	s := Summary{Status: r.Status, Checks: make(map[string]CheckStatus, len(r.Checks))}
+	for name, result := range r.Checks {
+		s.Checks[name] = CheckStatus{OK: result.OK}
+	}
+	return s
+}
+
+// Checker выполняет проверки параллельно и кэширует результаты на ttl,
+// чтобы частые запросы /readyz не нагружали внешние API
+type Checker struct {
+	checks  []Check
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]Result
+}
+
+// NewChecker создает набор проверок. timeout ограничивает каждую проверку.
+func NewChecker(ttl, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:  checks,
+		ttl:     ttl,
+		timeout: timeout,
+		cache:   make(map[string]Result),
+	}
+}
+
+// Run выполняет устаревшие проверки и возвращает отчет
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := c.result(ctx, check)
+			mu.Lock()
+			report.Checks[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		switch {
+		case result.OK:
+		case result.Critical:
+			report.Status = StatusUnavailable
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// result возвращает кэшированный результат или выполняет проверку
+func (c *Checker) result(ctx context.Context, check Check) Result {
+	c.mu.Lock()
+	cached, ok := c.cache[check.Name]
+	c.mu.Unlock()
+	if ok && time.Since(cached.CheckedAt) < c.ttl {
+		return cached
+	}
+
+	// Отмена клиентом не должна попадать в кэш как сбой зависимости
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := Result{
+		OK:        err == nil,
+		Critical:  check.Critical,
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		slog.WarnContext(ctx, "readiness check failed",
+			slog.String("check", check.Name),
+			slog.Bool("critical", check.Critical),
+			slog.String("error", result.Error),
+		)
+	}
+
+	c.mu.Lock()
+	c.cache[check.Name] = result
+	c.mu.Unlock()
+	return result
+}
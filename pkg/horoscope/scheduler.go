@@ -0,0 +1,344 @@
+package horoscope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/astro"
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/numerology"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// ErrRecipientGone - пользователь заблокировал бота или удалил чат.
+// Такая подписка отключается.
+var ErrRecipientGone = errors.New("получатель недоступен")
+
+// readingsKeep - сколько дней хранятся сгенерированные чтения. Пользователи
+// в разных часовых поясах получают гороскоп на разные местные даты.
+const readingsKeep = 2
+
+// generateTimeout ограничивает генерацию чтения одного знака вместе с картинкой
+const generateTimeout = 5 * time.Minute
+
+const (
+	// generateAttempts - сколько раз генерация чтения знака на дату
+	// пробуется, прежде чем рассылка этого знака на дату отменяется
+	generateAttempts = 5
+	// retryDelay - пауза после первой неудачной генерации, дальше она удваивается
+	retryDelay = time.Minute
+)
+
+// LLM генерирует текст; ему удовлетворяет common.OpenAIClient
+type LLM interface {
+	CreateChatCompletion(ctx context.Context, prompt string) (*common.ChatCompletion, error)
+}
+
+// Images генерирует изображение; ему удовлетворяет common.KandinskyClient
+type Images interface {
+	GenerateImage(ctx context.Context, prompt string) ([]byte, error)
+}
+
+// Sender доставляет гороскоп пользователю; ему удовлетворяет бот
+type Sender interface {
+	SendReading(chatID int64, text string, image []byte) error
+}
+
+// Clock - источник времени планировщика, в тестах подменяется
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock - системные часы
+type RealClock struct{}
+
+// Now возвращает текущее время
+func (RealClock) Now() time.Time { return time.Now() }
+
+// After ждет d
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Reading - общий гороскоп знака на местную дату
+type Reading struct {
+	Sign      astro.Sign `json:"sign"`
+	Date      string     `json:"date"`
+	Text      string     `json:"text"`
+	Image     []byte     `json:"image,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Scheduler периодически рассылает гороскопы подписчикам, чье местное время
+// доставки наступило. Чтение генерируется один раз на знак и дату и
+// персонализируется для каждого подписчика без обращения к LLM.
+type Scheduler struct {
+	svc      *Service
+	llm      LLM
+	images   Images
+	sender   Sender
+	clock    Clock
+	usage    *usage.Tracker
+	interval time.Duration
+
+	// failures - неудачные генерации чтений по ключу знака и даты
+	failures map[string]*failure
+}
+
+// failure - неудачные попытки генерации одного чтения
+type failure struct {
+	attempts int
+	next     time.Time
+}
+
+// NewScheduler создает планировщик. images может быть nil - тогда гороскоп
+// отправляется без картинки; tracker - тогда расход на LLM не учитывается.
+func NewScheduler(svc *Service, llm LLM, images Images, sender Sender, tracker *usage.Tracker, clock Clock) *Scheduler {
+	if clock == nil {
+		clock = RealClock{}
+	}
+	return &Scheduler{
+		svc:      svc,
+		llm:      llm,
+		images:   images,
+		sender:   sender,
+		clock:    clock,
+		usage:    tracker,
+		interval: svc.cfg.Interval,
+		failures: make(map[string]*failure),
+	}
+}
+
+// Run проверяет подписки каждые horoscope.interval до отмены ctx
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		if n, err := s.Tick(ctx); err != nil {
+			slog.ErrorContext(ctx, "horoscope delivery failed", slog.String("error", err.Error()))
+		} else if n > 0 {
+			slog.InfoContext(ctx, "horoscopes delivered", slog.Int("count", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.interval):
+		}
+	}
+}
+
+// batch - подписчики одного знака с одной местной датой
+type batch struct {
+	sign astro.Sign
+	date string
+	subs []Subscription
+}
+
+// Tick отправляет гороскопы всем подписчикам, которым пора, и возвращает
+// число доставок. Ошибка одного знака или получателя не прерывает рассылку.
+func (s *Scheduler) Tick(ctx context.Context) (int, error) {
+	now := s.clock.Now()
+	subs, err := s.svc.active()
+	if err != nil {
+		return 0, err
+	}
+
+	var batches []*batch
+	index := make(map[string]*batch)
+	for _, sub := range subs {
+		date, ok := sub.due(now)
+		if !ok {
+			continue
+		}
+		k := readingKey(sub.Sign(), date)
+		b, ok := index[k]
+		if !ok {
+			b = &batch{sign: sub.Sign(), date: date}
+			index[k] = b
+			batches = append(batches, b)
+		}
+		b.subs = append(b.subs, sub)
+	}
+
+	sent := 0
+	for _, b := range batches {
+		if ctx.Err() != nil {
+			break
+		}
+		k := readingKey(b.sign, b.date)
+		if f := s.failures[k]; f != nil && (f.attempts >= generateAttempts || now.Before(f.next)) {
+			continue
+		}
+		reading, err := s.reading(ctx, b.sign, b.date, now)
+		if err != nil {
+			f := s.failures[k]
+			if f == nil {
+				f = &failure{}
+				s.failures[k] = f
+			}
+			f.attempts++
+			f.next = now.Add(retryDelay << (f.attempts - 1))
+			slog.ErrorContext(ctx, "horoscope generation failed",
+				slog.String("sign", b.sign.String()),
+				slog.String("date", b.date),
+				slog.Int("attempt", f.attempts),
+				slog.Bool("gave_up", f.attempts >= generateAttempts),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		delete(s.failures, k)
+		for _, sub := range b.subs {
+			if s.deliver(ctx, &sub, reading, now) {
+				sent++
+			}
+		}
+	}
+	s.prune(now)
+	return sent, ctx.Err()
+}
+
+// deliver отправляет чтение одному подписчику
+func (s *Scheduler) deliver(ctx context.Context, sub *Subscription, reading *Reading, now time.Time) bool {
+	err := s.sender.SendReading(sub.UserID, personalize(sub, reading, now), reading.Image)
+	if errors.Is(err, ErrRecipientGone) {
+		slog.InfoContext(ctx, "horoscope recipient gone, unsubscribing", slog.Int64("user_id", sub.UserID))
+		if err := s.svc.Unsubscribe(sub.UserID, now); err != nil {
+			slog.ErrorContext(ctx, "unsubscribe failed", slog.String("error", err.Error()))
+		}
+		return false
+	}
+	if err != nil {
+		// Повторим на следующем тике
+		slog.WarnContext(ctx, "horoscope send failed",
+			slog.Int64("user_id", sub.UserID),
+			slog.String("error", err.Error()),
+		)
+		return false
+	}
+	if err := s.svc.markSent(sub.UserID, reading.Date); err != nil {
+		slog.ErrorContext(ctx, "horoscope delivery not recorded", slog.String("error", err.Error()))
+	}
+	return true
+}
+
+// reading возвращает сохраненное чтение знака на дату или генерирует его
+func (s *Scheduler) reading(ctx context.Context, sign astro.Sign, date string, now time.Time) (*Reading, error) {
+	var r Reading
+	ok, err := s.svc.store.Get(readingsCollection, readingKey(sign, date), &r)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return &r, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, generateTimeout)
+	defer cancel()
+
+	completion, err := s.llm.CreateChatCompletion(ctx, readingPrompt(sign, date, now))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации гороскопа: %w", err)
+	}
+	if s.usage != nil {
+		s.usage.RecordShared(ctx, usage.Horoscope, completion)
+	}
+	r = Reading{Sign: sign, Date: date, CreatedAt: now}
+	var imagePrompt string
+	var lines []string
+	for _, line := range strings.Split(completion.Content, "\n") {
+		if p, ok := strings.CutPrefix(strings.TrimSpace(line), "IMAGE_PROMPT:"); ok {
+			imagePrompt = strings.TrimSpace(p)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	r.Text = strings.TrimSpace(strings.Join(lines, "\n"))
+
+	if s.images != nil {
+		if imagePrompt == "" {
+			imagePrompt = fmt.Sprintf("A mystical tarot card for the zodiac sign %s, abstract shapes in Kandinsky style, vibrant colors", sign)
+		}
+		// Без картинки гороскоп все равно отправляется
+		if r.Image, err = s.images.GenerateImage(ctx, imagePrompt); err != nil {
+			slog.WarnContext(ctx, "horoscope image failed",
+				slog.String("sign", sign.String()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	if err := s.svc.store.Put(readingsCollection, readingKey(sign, date), &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// prune удаляет устаревшие чтения и неудачи генерации
+func (s *Scheduler) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, -readingsKeep).Format(dateLayout)
+	for k := range s.failures {
+		if date, _, _ := strings.Cut(k, "/"); date < oldest {
+			delete(s.failures, k)
+		}
+	}
+	for _, k := range s.svc.store.Keys(readingsCollection) {
+		if date, _, _ := strings.Cut(k, "/"); date < oldest {
+			s.svc.store.Delete(readingsCollection, k)
+		}
+	}
+}
+
+// readingPrompt строит промпт общего гороскопа знака
+func readingPrompt(sign astro.Sign, date string, now time.Time) string {
+	transits := astro.SignTransits(sign, now)
+	return fmt.Sprintf(`Ты - опытный астролог. Напиши короткий гороскоп на %s для знака %s (стихия %s).
+Используй только эти рассчитанные положения планет, не придумывай другие:
+%s
+
+Требования:
+1. 3-4 предложения на русском языке, без заголовков и списков.
+2. Дай один практический совет на день.
+3. Тон доброжелательный, без пугающих прогнозов.
+4. Последней строкой добавь промпт для карты дня на английском, начиная с "IMAGE_PROMPT:".`,
+		date, sign, sign.Element(), astro.FormatTransits(transits, "знаку"))
+}
+
+// personalize дополняет общее чтение именем и числом личного дня подписчика
+func personalize(sub *Subscription, reading *Reading, now time.Time) string {
+	var b strings.Builder
+	if sub.Name != "" {
+		fmt.Fprintf(&b, "%s, ваш гороскоп на %s\n", sub.Name, displayDate(reading.Date))
+	} else {
+		fmt.Fprintf(&b, "Ваш гороскоп на %s\n", displayDate(reading.Date))
+	}
+	fmt.Fprintf(&b, "Знак: %s\n\n%s", reading.Sign, reading.Text)
+
+	if birth, err := astro.ParseDate(sub.BirthDate); err == nil {
+		local := now.In(sub.location())
+		day := numerology.Compute(sub.Name, birth, local).PersonalDay
+		fmt.Fprintf(&b, "\n\nЧисло личного дня: %d - %s", day.Value, day.Keywords)
+	}
+	b.WriteString("\n\nОтписаться: /unsubscribe, пропустить день: /skip")
+	return b.String()
+}
+
+// displayDate переводит ГГГГ-ММ-ДД в ДД.ММ.ГГГГ
+func displayDate(date string) string {
+	t, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return date
+	}
+	return t.Format("02.01.2006")
+}
+
+func readingKey(sign astro.Sign, date string) string {
+	return fmt.Sprintf("%s/%02d", date, int(sign))
+}
+
+// Проверка, что клиенты внешних сервисов подходят планировщику
+var (
+	_ LLM    = (*common.OpenAIClient)(nil)
+	_ Images = (*common.KandinskyClient)(nil)
+)
@@ -0,0 +1,241 @@
+package horoscope_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/horoscope"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// clock - часы, которые двигает тест
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time                       { return c.now }
+func (c *clock) After(time.Duration) <-chan time.Time { return make(chan time.Time) }
+
+// llm считает промпты чтений; пока err не nil, генерация не удается
+type llm struct {
+	mu      sync.Mutex
+	prompts []string
+	err     error
+}
+
+func (l *llm) CreateChatCompletion(_ context.Context, prompt string) (*common.ChatCompletion, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prompts = append(l.prompts, prompt)
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &common.ChatCompletion{
+		Content: "Звезды советуют не спешить.\nIMAGE_PROMPT: a calm sky",
+		Model:   "test/model",
+		Usage:   common.Usage{PromptTokens: 1000, CompletionTokens: 500},
+	}, nil
+}
+
+func (l *llm) calls() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.prompts)
+}
+
+// sender запоминает доставки; получатели из gone недоступны
+type sender struct {
+	sent map[int64]string
+	gone map[int64]bool
+}
+
+func (s *sender) SendReading(chatID int64, text string, _ []byte) error {
+	if s.gone[chatID] {
+		return horoscope.ErrRecipientGone
+	}
+	s.sent[chatID] = text
+	return nil
+}
+
+// newService - подписки в памяти с доставкой в 09:00 по Москве
+func newService() *horoscope.Service {
+	st, _ := store.Open("")
+	return horoscope.NewService(st, config.HoroscopeConfig{DefaultTime: "09:00", DefaultTimeZone: "Europe/Moscow", Interval: time.Minute})
+}
+
+func TestTick(t *testing.T) {
+	svc := newService()
+	// Подписки оформлены в 05:00 UTC: 08:00 в Москве, 01:00 в Нью-Йорке, 14:00 в Токио
+	clk := &clock{now: time.Date(2024, 6, 1, 5, 0, 0, 0, time.UTC)}
+	subs := []horoscope.Subscription{
+		{UserID: 1, Name: "Анна", BirthDate: "15.03.1990"},                               // Рыбы, Москва
+		{UserID: 2, Name: "Олег", BirthDate: "10.03.1985"},                               // Рыбы, Москва
+		{UserID: 3, Name: "Ира", BirthDate: "01.05.1992"},                                // Телец, Москва
+		{UserID: 4, Name: "Петр", BirthDate: "05.03.1980"},                               // Рыбы, Москва, пропуск
+		{UserID: 5, Name: "Джон", BirthDate: "12.03.1975", TimeZone: "America/New_York"}, // Рыбы
+		{UserID: 6, Name: "Юки", BirthDate: "01.08.1995", TimeZone: "Asia/Tokyo", DeliveryTime: "20:00"},
+	}
+	for _, sub := range subs {
+		if _, err := svc.Subscribe(sub, clk.now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := svc.Skip(4, clk.now); err != nil {
+		t.Fatal(err)
+	}
+
+	model := &llm{}
+	out := &sender{sent: map[int64]string{}, gone: map[int64]bool{6: true}}
+	sched := horoscope.NewScheduler(svc, model, nil, out, nil, clk)
+	// 06:30 UTC: 09:30 в Москве, 02:30 в Нью-Йорке, 15:30 в Токио
+	clk.now = clk.now.Add(90 * time.Minute)
+	tick := func(want int) {
+		t.Helper()
+		n, err := sched.Tick(context.Background())
+		if err != nil || n != want {
+			t.Fatalf("Tick at %s = %d, %v, want %d", clk.now.Format(time.RFC3339), n, err, want)
+		}
+	}
+
+	// Доставляются только московские подписчики без пропуска; два Рыб делят одно чтение
+	tick(3)
+	for _, id := range []int64{1, 2, 3} {
+		if out.sent[id] == "" {
+			t.Errorf("user %d got nothing", id)
+		}
+	}
+	if !strings.HasPrefix(out.sent[1], "Анна, ваш гороскоп на 01.06.2024") || !strings.Contains(out.sent[1], "Звезды советуют") {
+		t.Errorf("reading for user 1:\n%s", out.sent[1])
+	}
+	if _, ok := out.sent[4]; ok {
+		t.Error("skipped date delivered")
+	}
+	if len(model.prompts) != 2 {
+		t.Fatalf("LLM calls = %d, want one per sign", len(model.prompts))
+	}
+
+	// Повторный тик в тот же день ничего не отправляет
+	tick(0)
+
+	// 13:30 UTC: 09:30 в Нью-Йорке, 22:30 в Токио. Рыбы в Нью-Йорке получают
+	// уже сгенерированное чтение на ту же местную дату
+	clk.now = clk.now.Add(7 * time.Hour)
+	tick(1)
+	if out.sent[5] == "" {
+		t.Error("New York subscriber got nothing after local delivery time")
+	}
+	if len(model.prompts) != 3 {
+		t.Errorf("LLM calls = %d, want 3: Pisces reused, Leo generated", len(model.prompts))
+	}
+	// Заблокировавший бота пользователь отписывается
+	if sub, err := svc.Get(6); err != nil || sub.Active {
+		t.Errorf("gone recipient: %+v, %v", sub, err)
+	}
+	tick(0)
+
+	// На следующий день пропуск больше не действует. 09:30 UTC: в Москве
+	// 12:30, а в Нью-Йорке еще 05:30
+	clk.now = clk.now.Add(20 * time.Hour)
+	tick(4)
+	if !strings.Contains(out.sent[4], "02.06.2024") {
+		t.Errorf("user 4 after skipped day:\n%s", out.sent[4])
+	}
+}
+
+func TestLateSubscription(t *testing.T) {
+	svc := newService()
+	// 09:30 по Москве: время доставки сегодня уже прошло
+	clk := &clock{now: time.Date(2024, 6, 1, 6, 30, 0, 0, time.UTC)}
+	sub, err := svc.Subscribe(horoscope.Subscription{UserID: 1, Name: "Анна", BirthDate: "15.03.1990"}, clk.now)
+	if err != nil || sub.StartDate != "2024-06-02" {
+		t.Fatalf("Subscribe = %+v, %v", sub, err)
+	}
+	out := &sender{sent: map[int64]string{}}
+	sched := horoscope.NewScheduler(svc, &llm{}, nil, out, nil, clk)
+	if n, _ := sched.Tick(context.Background()); n != 0 {
+		t.Errorf("delivered %d on the subscription day", n)
+	}
+	// Ближайшая доставка, которую можно пропустить, - завтрашняя
+	if date, err := svc.Skip(1, clk.now); err != nil || date != "2024-06-02" {
+		t.Errorf("Skip = %q, %v", date, err)
+	}
+
+	// Повторная подписка с новым временем не откладывает начало
+	if sub, err = svc.Subscribe(horoscope.Subscription{UserID: 1, BirthDate: "15.03.1990", DeliveryTime: "08:00"}, clk.now); err != nil || sub.StartDate != "2024-06-02" {
+		t.Errorf("resubscribe = %+v, %v", sub, err)
+	}
+	clk.now = clk.now.AddDate(0, 0, 2)
+	if n, _ := sched.Tick(context.Background()); n != 1 || !strings.Contains(out.sent[1], "03.06.2024") {
+		t.Errorf("delivered %d after the start date: %q", n, out.sent[1])
+	}
+}
+
+func TestGenerationRetry(t *testing.T) {
+	svc := newService()
+	clk := &clock{now: time.Date(2024, 6, 1, 5, 0, 0, 0, time.UTC)}
+	svc.Subscribe(horoscope.Subscription{UserID: 1, BirthDate: "15.03.1990"}, clk.now)
+	model := &llm{err: errors.New("модель недоступна")}
+	out := &sender{sent: map[int64]string{}}
+	sched := horoscope.NewScheduler(svc, model, nil, out, nil, clk)
+
+	// Попытки через 1, 2, 4 и 8 минут после предыдущей, всего пять за день
+	start := time.Date(2024, 6, 1, 6, 30, 0, 0, time.UTC)
+	attempts := map[int]bool{0: true, 1: true, 3: true, 7: true, 15: true}
+	calls := 0
+	for minute := range 60 {
+		clk.now = start.Add(time.Duration(minute) * time.Minute)
+		sched.Tick(context.Background())
+		if attempts[minute] {
+			calls++
+		}
+		if model.calls() != calls {
+			t.Fatalf("minute %d: LLM calls = %d, want %d", minute, model.calls(), calls)
+		}
+	}
+	clk.now = start.Add(10 * time.Hour)
+	sched.Tick(context.Background())
+	if model.calls() != 5 || len(out.sent) != 0 {
+		t.Errorf("after giving up: %d calls, %d sent", model.calls(), len(out.sent))
+	}
+
+	// На следующий день генерация пробуется заново
+	model.mu.Lock()
+	model.err = nil
+	model.mu.Unlock()
+	clk.now = start.AddDate(0, 0, 1)
+	if n, err := sched.Tick(context.Background()); n != 1 || err != nil {
+		t.Errorf("next day: delivered %d, %v", n, err)
+	}
+}
+
+func TestGenerationCost(t *testing.T) {
+	svc := newService()
+	clk := &clock{now: time.Date(2024, 6, 1, 5, 0, 0, 0, time.UTC)}
+	for id := range int64(3) {
+		svc.Subscribe(horoscope.Subscription{UserID: id + 1, BirthDate: "15.03.1990"}, clk.now)
+	}
+	st, _ := store.Open("")
+	tracker := usage.NewTracker(st, config.UsageConfig{Prices: map[string]config.ModelPrice{"test/model": {Prompt: 1, Completion: 2}}}, "test/model")
+	sched := horoscope.NewScheduler(svc, &llm{}, nil, &sender{sent: map[int64]string{}}, tracker, clk)
+	clk.now = clk.now.Add(90 * time.Minute)
+	if n, _ := sched.Tick(context.Background()); n != 3 {
+		t.Fatalf("delivered %d, want 3", n)
+	}
+
+	// Одно чтение на всех подписчиков знака учитывается один раз и не в их бюджете
+	day, _ := tracker.Totals(usage.Horoscope)
+	if day.PromptTokens != 1000 || day.CompletionTokens != 500 || day.Cost != 0.002 || day.Predictions != 0 {
+		t.Errorf("horoscope totals = %+v", day)
+	}
+	if day, _ := tracker.Totals("1"); day.Cost != 0 {
+		t.Errorf("subscriber totals = %+v", day)
+	}
+}
@@ -0,0 +1,236 @@
+// Package horoscope реализует подписку на ежедневный гороскоп: хранение
+// подписок, генерацию чтений, общих для пользователей одного знака, и
+// планировщик, рассылающий их через бота в выбранное местное время
+package horoscope
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/astro"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+// Коллекции хранилища
+const (
+	subscriptionsCollection = "subscriptions"
+	readingsCollection      = "horoscopes"
+)
+
+// dateLayout - формат местной даты в подписке
+const dateLayout = "2006-01-02"
+
+var (
+	// ErrNotSubscribed - у пользователя нет активной подписки
+	ErrNotSubscribed = errors.New("подписка не найдена")
+	// ErrInvalidSubscription - некорректные параметры подписки
+	ErrInvalidSubscription = errors.New("некорректные параметры подписки")
+)
+
+// Subscription - подписка пользователя Telegram на ежедневный гороскоп
+type Subscription struct {
+	// UserID - идентификатор пользователя Telegram, он же личный чат с ботом
+	UserID    int64  `json:"userId"`
+	Name      string `json:"name"`
+	BirthDate string `json:"birthDate"`
+	// DeliveryTime - местное время доставки ЧЧ:ММ
+	DeliveryTime string `json:"deliveryTime"`
+	// TimeZone - часовой пояс IANA, например Europe/Moscow
+	TimeZone string `json:"timeZone"`
+	Active   bool   `json:"active"`
+	// SkipDate - местная дата, на которую рассылка пропускается
+	SkipDate string `json:"skipDate,omitempty"`
+	// LastSent - местная дата последней доставки
+	LastSent string `json:"lastSent,omitempty"`
+	// StartDate - местная дата первой доставки: подписка, оформленная после
+	// времени доставки, начинает работать со следующего дня
+	StartDate string    `json:"startDate,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Sign возвращает знак зодиака подписчика
+func (s *Subscription) Sign() astro.Sign {
+	date, _ := astro.ParseDate(s.BirthDate)
+	return astro.SunSign(date)
+}
+
+// location возвращает часовой пояс подписки
+func (s *Subscription) location() *time.Location {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// due сообщает, пора ли доставить гороскоп в момент now, и возвращает местную дату
+func (s *Subscription) due(now time.Time) (string, bool) {
+	local := now.In(s.location())
+	date := local.Format(dateLayout)
+	if !s.Active || date < s.StartDate || s.LastSent == date || s.SkipDate == date {
+		return date, false
+	}
+	return date, local.Format("15:04") >= s.DeliveryTime
+}
+
+// firstDate возвращает местную дату первой доставки подписки, оформленной в момент now
+func (s *Subscription) firstDate(now time.Time) string {
+	local := now.In(s.location())
+	if local.Format("15:04") >= s.DeliveryTime {
+		local = local.AddDate(0, 0, 1)
+	}
+	return local.Format(dateLayout)
+}
+
+// Service управляет подписками
+type Service struct {
+	cfg   config.HoroscopeConfig
+	store *store.Store
+}
+
+// NewService создает сервис подписок
+func NewService(st *store.Store, cfg config.HoroscopeConfig) *Service {
+	return &Service{cfg: cfg, store: st}
+}
+
+// Subscribe создает или обновляет подписку. Пустые время и часовой пояс
+// заменяются значениями по умолчанию из конфигурации. Если время доставки
+// сегодня уже прошло, новая подписка начинает работать с завтрашнего дня.
+func (s *Service) Subscribe(sub Subscription, now time.Time) (*Subscription, error) {
+	if sub.DeliveryTime == "" {
+		sub.DeliveryTime = s.cfg.DefaultTime
+	}
+	if sub.TimeZone == "" {
+		sub.TimeZone = s.cfg.DefaultTimeZone
+	}
+	if err := validate(&sub); err != nil {
+		return nil, err
+	}
+
+	var stored Subscription
+	err := s.store.Update(subscriptionsCollection, key(sub.UserID), &stored, func(exists bool) error {
+		if !exists {
+			stored.CreatedAt = now
+		}
+		stored.UserID = sub.UserID
+		stored.Name = sub.Name
+		stored.BirthDate = sub.BirthDate
+		stored.DeliveryTime = sub.DeliveryTime
+		stored.TimeZone = sub.TimeZone
+		if !exists || !stored.Active {
+			stored.StartDate = stored.firstDate(now)
+		}
+		stored.Active = true
+		stored.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Unsubscribe отключает подписку. История доставок сохраняется.
+func (s *Service) Unsubscribe(userID int64, now time.Time) error {
+	return s.modify(userID, func(sub *Subscription) error {
+		sub.Active = false
+		sub.UpdatedAt = now
+		return nil
+	})
+}
+
+// Skip пропускает ближайшую доставку: сегодняшнюю, если она еще не состоялась,
+// иначе завтрашнюю. Возвращает пропускаемую местную дату.
+func (s *Service) Skip(userID int64, now time.Time) (string, error) {
+	var date string
+	err := s.modify(userID, func(sub *Subscription) error {
+		local := now.In(sub.location())
+		date = local.Format(dateLayout)
+		if sub.LastSent == date {
+			date = local.AddDate(0, 0, 1).Format(dateLayout)
+		}
+		if date < sub.StartDate {
+			date = sub.StartDate
+		}
+		sub.SkipDate = date
+		sub.UpdatedAt = now
+		return nil
+	})
+	return date, err
+}
+
+// Get возвращает подписку пользователя
+func (s *Service) Get(userID int64) (*Subscription, error) {
+	var sub Subscription
+	ok, err := s.store.Get(subscriptionsCollection, key(userID), &sub)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotSubscribed
+	}
+	return &sub, nil
+}
+
+// markSent отмечает доставку за местную дату
+func (s *Service) markSent(userID int64, date string) error {
+	return s.modify(userID, func(sub *Subscription) error {
+		sub.LastSent = date
+		return nil
+	})
+}
+
+// modify изменяет существующую подписку
+func (s *Service) modify(userID int64, fn func(*Subscription) error) error {
+	var sub Subscription
+	return s.store.Update(subscriptionsCollection, key(userID), &sub, func(exists bool) error {
+		if !exists {
+			return ErrNotSubscribed
+		}
+		return fn(&sub)
+	})
+}
+
+// active возвращает все активные подписки
+func (s *Service) active() ([]Subscription, error) {
+	var subs []Subscription
+	err := store.Each(s.store, subscriptionsCollection, func(_ string, sub *Subscription) error {
+		if sub.Active {
+			subs = append(subs, *sub)
+		}
+		return nil
+	})
+	return subs, err
+}
+
+func validate(sub *Subscription) error {
+	if sub.UserID == 0 {
+		return fmt.Errorf("%w: не указан пользователь Telegram", ErrInvalidSubscription)
+	}
+	if _, err := astro.ParseDate(sub.BirthDate); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+	clock, err := time.Parse("15:04", strings.TrimSpace(sub.DeliveryTime))
+	if err != nil {
+		return fmt.Errorf("%w: время доставки должно быть в формате ЧЧ:ММ", ErrInvalidSubscription)
+	}
+	sub.DeliveryTime = clock.Format("15:04")
+	if _, err := time.LoadLocation(sub.TimeZone); err != nil {
+		// Город из справочника тоже подходит
+		place, ok := astro.LookupPlace(sub.TimeZone)
+		if !ok {
+			return fmt.Errorf("%w: неизвестный часовой пояс %q", ErrInvalidSubscription, sub.TimeZone)
+		}
+		sub.TimeZone = place.TimeZone
+	}
+	return nil
+}
+
+func key(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
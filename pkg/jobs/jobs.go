@@ -0,0 +1,539 @@
+// Package jobs реализует асинхронную генерацию предсказаний.
+//
+// Задача проходит два этапа: получение текста с промптами от LLM и генерацию
+// изображений в Kandinsky. В фоновом режиме задачу доводит до конца горутина.
+// В пошаговом режиме (serverless) каждый запрос статуса продвигает задачу на один
+// шаг, поэтому ни один вызов не упирается в лимит времени платформы.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/numerology"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+)
+
+// collection - коллекция задач в хранилище
+const collection = "jobs"
+
+// Status - состояние задачи или отдельного изображения
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+var (
+	// ErrNotFound возвращается для неизвестной задачи
+	ErrNotFound = errors.New("задача не найдена")
+	// ErrShuttingDown возвращается при попытке создать задачу во время остановки
+	ErrShuttingDown = errors.New("сервер останавливается")
+	// ErrNotFailed возвращается при попытке повторить незавершившуюся ошибкой задачу
+	ErrNotFailed = errors.New("задача не завершилась ошибкой")
+)
+
+// Image - состояние генерации одного изображения
+type Image struct {
+	Prompt string `json:"prompt"`
+	Status Status `json:"status"`
+	Data   []byte `json:"data,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Job - асинхронная задача генерации предсказания
+type Job struct {
+	ID     string           `json:"id"`
+	Status Status           `json:"status"`
+	State  common.UserState `json:"-"`
+	Text   string           `json:"text,omitempty"`
+	// Numerology - рассчитанные числа, готовы вместе с текстом
+	Numerology *numerology.Profile `json:"numerology,omitempty"`
+	// ConversationID - диалог для уточняющих вопросов
+	ConversationID string `json:"conversationId,omitempty"`
+	// Moderation - категория модерации, если вместо предсказания показаны контакты помощи
+	Moderation string `json:"moderation,omitempty"`
+	// Decision - сравнение вариантов, изображения идут в порядке мест
+	Decision  *decision.Result `json:"decision,omitempty"`
+	Images    []Image          `json:"images"`
+	Error     string           `json:"error,omitempty"`
+	Polls     int              `json:"-"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+// stored - представление задачи в хранилище, включая скрытые от клиента поля
+type stored struct {
+	Job
+	State   common.UserState `json:"state"`
+	TaskIDs []string         `json:"taskIds"`
+	Polls   int              `json:"polls"`
+	// Started - текст получен и изображения поставлены в очередь: дальше
+	// задача только опрашивает их. Пустой текст этого не означает.
+	Started bool `json:"started"`
+	// RequestID - идентификатор запроса, создавшего задачу, для сквозных логов
+	RequestID string `json:"requestId,omitempty"`
+	// Code - код ошибки неудачной задачи для метрик
+	Code string `json:"code,omitempty"`
+}
+
+// Done сообщает, завершена ли задача
+func (j *Job) Done() bool {
+	return j.Status != StatusPending
+}
+
+// PredictFunc получает текст предсказания и промпты изображений
+type PredictFunc func(ctx context.Context, state *common.UserState) (*common.Prediction, error)
+
+// ImageGenerator - источник изображений с очередью задач
+type ImageGenerator interface {
+	CreateTask(ctx context.Context, prompt string) (string, error)
+	CheckStatus(ctx context.Context, uuid string) (*common.KandinskyStatusResponse, error)
+	PollInterval() time.Duration
+}
+
+// Options - параметры менеджера задач
+type Options struct {
+	// Background включает доведение задач до конца в фоновой горутине
+	Background bool
+	// MaxPolls - максимальное число опросов статуса изображений
+	MaxPolls int
+	// TTL - время хранения задачи после создания
+	TTL time.Duration
+	// OnFinish вызывается один раз при завершении задачи (успешном или нет)
+	OnFinish func(ctx context.Context, job *Job)
+}
+
+// Manager управляет асинхронными задачами
+type Manager struct {
+	store   *store.Store
+	predict PredictFunc
+	images  ImageGenerator
+	opts    Options
+
+	// locks сериализует продвижение одной задачи
+	locks sync.Map
+
+	// ctx отменяется при остановке, прерывая фоновые задачи
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	stopping bool
+	running  sync.WaitGroup
+}
+
+// NewManager создает менеджер задач
+func NewManager(st *store.Store, predict PredictFunc, images ImageGenerator, opts Options) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		store:   st,
+		predict: predict,
+		images:  images,
+		opts:    opts,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Submit создает задачу. В фоновом режиме возвращает управление сразу;
+// в пошаговом - выполняет первый шаг (текст и постановку изображений в очередь).
+func (m *Manager) Submit(ctx context.Context, state common.UserState) (*Job, error) {
+	m.prune()
+
+	now := time.Now()
+	job := &stored{
+		Job: Job{
+			ID:        common.NewID(),
+			Status:    StatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		State:     state,
+		RequestID: logging.RequestID(ctx),
+	}
+	job.State.PredictionID = job.ID
+	if err := m.save(job); err != nil {
+		return nil, err
+	}
+
+	if m.opts.Background {
+		if !m.spawn(job.ID, job.RequestID) {
+			m.store.Delete(collection, job.ID)
+			return nil, ErrShuttingDown
+		}
+		return job.public(), nil
+	}
+	return m.Advance(ctx, job.ID)
+}
+
+// Resume перезапускает незавершенные задачи, например после перезапуска сервера.
+// Задачи Kandinsky живут на стороне API, поэтому опрос продолжается с того же места.
+func (m *Manager) Resume() int {
+	if !m.opts.Background {
+		return 0
+	}
+	resumed := 0
+	err := store.Each(m.store, collection, func(key string, job *stored) error {
+		if !job.Done() && m.spawn(key, job.RequestID) {
+			resumed++
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("jobs resume failed", slog.String("error", err.Error()))
+	}
+	return resumed
+}
+
+// Shutdown перестает принимать задачи и ждет завершения фоновых.
+// Если ctx истекает раньше, оставшиеся задачи отменяются; они остаются
+// в хранилище незавершенными и будут возобновлены при следующем запуске.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopping = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.cancel()
+		return nil
+	case <-ctx.Done():
+		m.cancel()
+		<-done
+		return fmt.Errorf("задачи прерваны по таймауту: %v", ctx.Err())
+	}
+}
+
+// spawn запускает фоновую обработку задачи, если менеджер не останавливается
+func (m *Manager) spawn(id, requestID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopping {
+		return false
+	}
+	m.running.Add(1)
+	go func() {
+		defer m.running.Done()
+		metrics.JobsInFlight.WithLabelValues("async").Inc()
+		defer metrics.JobsInFlight.WithLabelValues("async").Dec()
+		m.run(logging.WithRequestID(m.ctx, requestID), id)
+	}()
+	return true
+}
+
+// Get возвращает текущее состояние задачи без ее продвижения
+func (m *Manager) Get(id string) (*Job, error) {
+	job, err := m.load(id)
+	if err != nil {
+		return nil, err
+	}
+	return job.public(), nil
+}
+
+// List возвращает все задачи, новые первыми
+func (m *Manager) List() ([]*Job, error) {
+	var list []*Job
+	err := store.Each(m.store, collection, func(_ string, job *stored) error {
+		list = append(list, job.public())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+// Replay перезапускает неудавшуюся задачу с теми же данными пользователя.
+// Идентификатор сохраняется, поэтому клиент может продолжить опрос статуса.
+func (m *Manager) Replay(ctx context.Context, id string) (*Job, error) {
+	mu, _ := m.locks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	job, err := m.load(id)
+	if err == nil && job.Status != StatusFailed {
+		err = ErrNotFailed
+	}
+	if err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+
+	now := time.Now()
+	*job = stored{
+		Job: Job{
+			ID:        job.ID,
+			Status:    StatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		State:     job.State,
+		RequestID: logging.RequestID(ctx),
+	}
+	err = m.save(job)
+	mu.(*sync.Mutex).Unlock()
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "job replayed", slog.String("job_id", id))
+
+	if m.opts.Background {
+		if !m.spawn(job.ID, job.RequestID) {
+			return nil, ErrShuttingDown
+		}
+		return job.public(), nil
+	}
+	return m.Advance(ctx, job.ID)
+}
+
+// Poll возвращает состояние задачи; в пошаговом режиме сначала продвигает ее
+func (m *Manager) Poll(ctx context.Context, id string) (*Job, error) {
+	if m.opts.Background {
+		return m.Get(id)
+	}
+	return m.Advance(ctx, id)
+}
+
+// Advance выполняет один шаг задачи
+func (m *Manager) Advance(ctx context.Context, id string) (*Job, error) {
+	mu, _ := m.locks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	job, err := m.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Done() {
+		return job.public(), nil
+	}
+
+	ctx, span := tracing.Start(ctx, "jobs.Advance",
+		attribute.String("job.id", job.ID),
+		attribute.String("prediction.mode", job.State.Mode),
+		attribute.Int("job.polls", job.Polls),
+	)
+	defer func() {
+		span.SetAttributes(attribute.String("job.status", string(job.Status)))
+		span.End()
+	}()
+
+	if !job.Started {
+		m.start(ctx, job)
+	} else {
+		m.poll(ctx, job)
+	}
+	job.UpdatedAt = time.Now()
+	if err := m.save(job); err != nil {
+		return nil, err
+	}
+	if job.Done() {
+		job.observe()
+		if m.opts.OnFinish != nil {
+			m.opts.OnFinish(ctx, job.public())
+		}
+	}
+	return job.public(), nil
+}
+
+// start получает текст предсказания и ставит изображения в очередь
+func (m *Manager) start(ctx context.Context, job *stored) {
+	done := logging.Stage(ctx, "job_prediction", slog.String("job_id", job.ID))
+	prediction, err := m.predict(ctx, &job.State)
+	done(err)
+	if err != nil && ctx.Err() != nil {
+		// Прервано остановкой: задача останется незавершенной и будет возобновлена
+		return
+	}
+	if err != nil {
+		job.fail(common.ErrorCode(err), fmt.Sprintf("ошибка получения предсказания: %v", err))
+		return
+	}
+
+	job.Started = true
+	job.Text = prediction.Text
+	job.Numerology = prediction.Numerology
+	job.ConversationID = prediction.ConversationID
+	job.Moderation = prediction.Moderation
+	job.Decision = prediction.Decision
+	job.Images = make([]Image, len(prediction.ImagePrompts))
+	job.TaskIDs = make([]string, len(prediction.ImagePrompts))
+	for i, prompt := range prediction.ImagePrompts {
+		job.Images[i] = Image{Prompt: prompt, Status: StatusPending}
+		uuid, err := m.images.CreateTask(tracing.WithImageIndex(ctx, i), prompt)
+		if err != nil {
+			job.Images[i].Status = StatusFailed
+			job.Images[i].Error = fmt.Sprintf("ошибка создания задачи: %v", err)
+			continue
+		}
+		job.TaskIDs[i] = uuid
+	}
+	job.settle()
+}
+
+// poll однократно проверяет статус всех незавершенных изображений
+func (m *Manager) poll(ctx context.Context, job *stored) {
+	job.Polls++
+	for i := range job.Images {
+		img := &job.Images[i]
+		if img.Status != StatusPending {
+			continue
+		}
+		imgCtx := tracing.WithImageIndex(ctx, i)
+		status, err := m.images.CheckStatus(imgCtx, job.TaskIDs[i])
+		if err != nil {
+			// Сетевые ошибки не фатальны: повторим на следующем шаге
+			slog.WarnContext(ctx, "image status check failed",
+				slog.String("job_id", job.ID),
+				slog.Int("image", i+1),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		data, err := common.DecodeImage(imgCtx, status)
+		switch {
+		case err != nil:
+			img.Status = StatusFailed
+			img.Error = err.Error()
+		case data != nil:
+			img.Status = StatusDone
+			img.Data = data
+		}
+	}
+
+	if m.opts.MaxPolls > 0 && job.Polls >= m.opts.MaxPolls {
+		for i := range job.Images {
+			if job.Images[i].Status == StatusPending {
+				job.Images[i].Status = StatusFailed
+				job.Images[i].Error = common.ErrGenerationTimeout.Error()
+			}
+		}
+	}
+	job.settle()
+}
+
+// run доводит задачу до конца в фоне
+func (m *Manager) run(ctx context.Context, id string) {
+	for {
+		job, err := m.Advance(ctx, id)
+		if err != nil {
+			slog.ErrorContext(ctx, "job advance failed", slog.String("job_id", id), slog.String("error", err.Error()))
+			return
+		}
+		if job.Done() {
+			slog.InfoContext(ctx, "job finished",
+				slog.String("job_id", id),
+				slog.String("status", string(job.Status)),
+				slog.Int("polls", job.Polls),
+				slog.Int64("duration_ms", job.UpdatedAt.Sub(job.CreatedAt).Milliseconds()),
+			)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			slog.WarnContext(ctx, "job interrupted by shutdown", slog.String("job_id", id))
+			return
+		case <-time.After(m.images.PollInterval()):
+		}
+	}
+}
+
+// prune удаляет задачи старше TTL
+func (m *Manager) prune() {
+	if m.opts.TTL <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-m.opts.TTL)
+	err := store.Each(m.store, collection, func(key string, job *stored) error {
+		if job.CreatedAt.Before(deadline) {
+			m.store.Delete(collection, key)
+			m.locks.Delete(key)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("jobs prune failed", slog.String("error", err.Error()))
+	}
+}
+
+func (m *Manager) load(id string) (*stored, error) {
+	var job stored
+	ok, err := m.store.Get(collection, id, &job)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (m *Manager) save(job *stored) error {
+	return m.store.Put(collection, job.ID, job)
+}
+
+func (j *stored) fail(code, reason string) {
+	j.Status = StatusFailed
+	j.Code = code
+	j.Error = reason
+}
+
+// observe записывает метрики завершенной задачи
+func (j *stored) observe() {
+	outcome := "success"
+	if j.Status == StatusFailed {
+		outcome = "failure"
+		metrics.PredictionFailures.WithLabelValues(j.Code).Inc()
+	}
+	metrics.PredictionDuration.WithLabelValues(metrics.Mode(j.State.Mode), outcome).
+		Observe(j.UpdatedAt.Sub(j.CreatedAt).Seconds())
+}
+
+// settle выставляет итоговый статус задачи, когда все изображения завершены.
+// Задача считается успешной, если готово хотя бы одно изображение.
+func (j *stored) settle() {
+	done, failed := 0, 0
+	for _, img := range j.Images {
+		switch img.Status {
+		case StatusDone:
+			done++
+		case StatusFailed:
+			failed++
+		}
+	}
+	if done+failed < len(j.Images) {
+		return
+	}
+	if done == 0 && len(j.Images) > 0 {
+		j.fail("image_failed", "не удалось сгенерировать изображения")
+		return
+	}
+	j.Status = StatusDone
+}
+
+// public возвращает копию задачи для клиента
+func (j *stored) public() *Job {
+	job := j.Job
+	job.State = j.State
+	job.Polls = j.Polls
+	return &job
+}
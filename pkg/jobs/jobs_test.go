@@ -0,0 +1,318 @@
+package jobs_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/jobs"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+// generator - очередь изображений, где задача называется своим промптом.
+// Задача без статуса остается в очереди.
+type generator struct {
+	mu        sync.Mutex
+	statuses  map[string]string
+	createErr error
+	checks    int
+}
+
+func newGenerator() *generator {
+	return &generator{statuses: make(map[string]string)}
+}
+
+func (g *generator) set(prompt, status string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.statuses[prompt] = status
+}
+
+func (g *generator) CreateTask(_ context.Context, prompt string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return prompt, g.createErr
+}
+
+func (g *generator) CheckStatus(_ context.Context, uuid string) (*common.KandinskyStatusResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.checks++
+	status := &common.KandinskyStatusResponse{UUID: uuid, Status: g.statuses[uuid]}
+	if status.Status == common.KandinskyStatusDone {
+		status.Images = []string{base64.StdEncoding.EncodeToString([]byte("png " + uuid))}
+	}
+	return status, nil
+}
+
+func (g *generator) PollInterval() time.Duration {
+	return 5 * time.Millisecond
+}
+
+// predictor отвечает prediction или err и считает вызовы
+type predictor struct {
+	mu         sync.Mutex
+	prediction common.Prediction
+	err        error
+	calls      int
+}
+
+func (p *predictor) predict(context.Context, *common.UserState) (*common.Prediction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	prediction := p.prediction
+	return &prediction, nil
+}
+
+func (p *predictor) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func openStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+var state = common.UserState{Name: "Анна", Mode: "Карьера"}
+
+func TestAdvance(t *testing.T) {
+	images := newGenerator()
+	p := &predictor{prediction: common.Prediction{Text: "Все сложится.", ImagePrompts: []string{"кот", "луна", "солнце"}}}
+	var finished []*jobs.Job
+	m := jobs.NewManager(openStore(t), p.predict, images, jobs.Options{
+		OnFinish: func(_ context.Context, job *jobs.Job) { finished = append(finished, job) },
+	})
+	ctx := context.Background()
+
+	// Первый шаг получает текст и ставит изображения в очередь
+	job, err := m.Submit(ctx, state)
+	if err != nil || job.Status != jobs.StatusPending || job.Text != "Все сложится." || len(job.Images) != 3 || job.State.PredictionID != job.ID {
+		t.Fatalf("Submit = %+v, %v", job, err)
+	}
+
+	images.set("кот", common.KandinskyStatusDone)
+	images.set("луна", common.KandinskyStatusFailed)
+	if job, err = m.Poll(ctx, job.ID); err != nil || job.Status != jobs.StatusPending || job.Polls != 1 {
+		t.Fatalf("first poll = %+v, %v", job, err)
+	}
+	want := []jobs.Status{jobs.StatusDone, jobs.StatusFailed, jobs.StatusPending}
+	for i, img := range job.Images {
+		if img.Status != want[i] {
+			t.Errorf("image %d: %s, want %s", i, img.Status, want[i])
+		}
+	}
+	if string(job.Images[0].Data) != "png кот" || job.Images[1].Error == "" {
+		t.Errorf("images = %+v", job.Images)
+	}
+
+	// Задача успешна, если готово хоть одно изображение
+	images.set("солнце", common.KandinskyStatusDone)
+	if job, err = m.Advance(ctx, job.ID); err != nil || job.Status != jobs.StatusDone {
+		t.Fatalf("second poll = %+v, %v", job, err)
+	}
+	checks := images.checks
+	if job, err = m.Advance(ctx, job.ID); err != nil || job.Status != jobs.StatusDone || images.checks != checks {
+		t.Errorf("advance of a done job = %+v, %v, %d checks", job, err, images.checks-checks)
+	}
+	if len(finished) != 1 || finished[0].ID != job.ID || p.count() != 1 {
+		t.Errorf("OnFinish calls = %d, predict calls = %d", len(finished), p.count())
+	}
+	if _, err := m.Advance(ctx, "unknown"); !errors.Is(err, jobs.ErrNotFound) {
+		t.Errorf("unknown job = %v", err)
+	}
+}
+
+func TestSettleFailures(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		p      *predictor
+		setup  func(*generator)
+		status jobs.Status
+		error  string
+	}{
+		{"prediction failed", &predictor{err: errors.New("модель недоступна")}, nil, jobs.StatusFailed, "модель недоступна"},
+		{"all images failed", &predictor{prediction: common.Prediction{Text: "т", ImagePrompts: []string{"а", "б"}}}, func(g *generator) {
+			g.set("а", common.KandinskyStatusFailed)
+			g.set("б", common.KandinskyStatusFailed)
+		}, jobs.StatusFailed, "не удалось сгенерировать изображения"},
+		{"tasks not created", &predictor{prediction: common.Prediction{Text: "т", ImagePrompts: []string{"а"}}}, func(g *generator) {
+			g.createErr = errors.New("очередь переполнена")
+		}, jobs.StatusFailed, "не удалось сгенерировать изображения"},
+		// Предсказание без изображений (например, контакты помощи) готово сразу
+		{"no images", &predictor{prediction: common.Prediction{Text: "т"}}, nil, jobs.StatusDone, ""},
+	}
+	for _, tt := range tests {
+		images := newGenerator()
+		if tt.setup != nil {
+			tt.setup(images)
+		}
+		m := jobs.NewManager(openStore(t), tt.p.predict, images, jobs.Options{})
+		job, err := m.Submit(ctx, state)
+		if err == nil && !job.Done() {
+			job, err = m.Poll(ctx, job.ID)
+		}
+		if err != nil || job.Status != tt.status || !strings.Contains(job.Error, tt.error) {
+			t.Errorf("%s: job = %s %q, %v", tt.name, job.Status, job.Error, err)
+		}
+	}
+}
+
+func TestEmptyTextStarted(t *testing.T) {
+	images := newGenerator()
+	p := &predictor{prediction: common.Prediction{ImagePrompts: []string{"кот"}}}
+	m := jobs.NewManager(openStore(t), p.predict, images, jobs.Options{})
+	ctx := context.Background()
+
+	job, err := m.Submit(ctx, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Пустой текст не означает, что задача не начата: предсказание не повторяется
+	for range 3 {
+		if job, err = m.Poll(ctx, job.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.count() != 1 || job.Polls != 3 || images.checks != 3 {
+		t.Errorf("predict calls %d, polls %d, checks %d", p.count(), job.Polls, images.checks)
+	}
+}
+
+func TestMaxPolls(t *testing.T) {
+	images := newGenerator()
+	images.set("луна", common.KandinskyStatusDone)
+	p := &predictor{prediction: common.Prediction{Text: "т", ImagePrompts: []string{"кот", "луна"}}}
+	m := jobs.NewManager(openStore(t), p.predict, images, jobs.Options{MaxPolls: 2})
+	ctx := context.Background()
+
+	job, _ := m.Submit(ctx, state)
+	if job, _ = m.Poll(ctx, job.ID); job.Done() {
+		t.Fatalf("job finished after the first poll: %+v", job)
+	}
+	job, err := m.Poll(ctx, job.ID)
+	if err != nil || job.Status != jobs.StatusDone {
+		t.Fatalf("job after MaxPolls = %+v, %v", job, err)
+	}
+	if img := job.Images[0]; img.Status != jobs.StatusFailed || img.Error != common.ErrGenerationTimeout.Error() {
+		t.Errorf("expired image = %+v", img)
+	}
+}
+
+// wait опрашивает задачу, пока она не завершится
+func wait(t *testing.T, m *jobs.Manager, id string) *jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Done() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s not finished", id)
+	return nil
+}
+
+func TestResume(t *testing.T) {
+	st := openStore(t)
+	images := newGenerator()
+	p := &predictor{prediction: common.Prediction{Text: "т", ImagePrompts: []string{"кот"}}}
+	m := jobs.NewManager(st, p.predict, images, jobs.Options{Background: true})
+
+	job, err := m.Submit(context.Background(), state)
+	if err != nil || job.Status != jobs.StatusPending {
+		t.Fatalf("Submit = %+v, %v", job, err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if job, _ = m.Get(job.ID); job.Text != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job not started")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Изображение не готово: остановка по таймауту оставляет задачу незавершенной
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); err == nil {
+		t.Error("Shutdown did not report interrupted jobs")
+	}
+	if _, err := m.Submit(context.Background(), state); !errors.Is(err, jobs.ErrShuttingDown) {
+		t.Errorf("Submit after Shutdown = %v", err)
+	}
+	if job, _ = m.Get(job.ID); job.Done() {
+		t.Fatalf("interrupted job = %+v", job)
+	}
+
+	// После перезапуска опрос продолжается без нового предсказания
+	images.set("кот", common.KandinskyStatusDone)
+	restarted := jobs.NewManager(st, p.predict, images, jobs.Options{Background: true})
+	defer restarted.Shutdown(context.Background())
+	if n := restarted.Resume(); n != 1 {
+		t.Fatalf("Resume = %d, want 1", n)
+	}
+	if job = wait(t, restarted, job.ID); job.Status != jobs.StatusDone || p.count() != 1 {
+		t.Errorf("resumed job = %s, predict calls %d", job.Status, p.count())
+	}
+	if n := restarted.Resume(); n != 0 {
+		t.Errorf("second Resume = %d, want 0", n)
+	}
+	if n := jobs.NewManager(st, p.predict, images, jobs.Options{}).Resume(); n != 0 {
+		t.Errorf("Resume in step mode = %d", n)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	images := newGenerator()
+	images.set("кот", common.KandinskyStatusDone)
+	p := &predictor{err: errors.New("модель недоступна")}
+	m := jobs.NewManager(openStore(t), p.predict, images, jobs.Options{})
+	ctx := context.Background()
+
+	job, err := m.Submit(ctx, state)
+	if err != nil || job.Status != jobs.StatusFailed {
+		t.Fatalf("Submit = %+v, %v", job, err)
+	}
+
+	p.mu.Lock()
+	p.err = nil
+	p.prediction = common.Prediction{Text: "т", ImagePrompts: []string{"кот"}}
+	p.mu.Unlock()
+	replayed, err := m.Replay(ctx, job.ID)
+	if err != nil || replayed.ID != job.ID || replayed.Status != jobs.StatusPending || replayed.Error != "" || replayed.State.Name != "Анна" {
+		t.Fatalf("Replay = %+v, %v", replayed, err)
+	}
+	if replayed, err = m.Poll(ctx, job.ID); err != nil || replayed.Status != jobs.StatusDone {
+		t.Fatalf("replayed job = %+v, %v", replayed, err)
+	}
+
+	if _, err := m.Replay(ctx, job.ID); !errors.Is(err, jobs.ErrNotFailed) {
+		t.Errorf("Replay of a done job = %v", err)
+	}
+	if _, err := m.Replay(ctx, "unknown"); !errors.Is(err, jobs.ErrNotFound) {
+		t.Errorf("Replay of an unknown job = %v", err)
+	}
+	if list, err := m.List(); err != nil || len(list) != 1 {
+		t.Errorf("List = %d jobs, %v", len(list), err)
+	}
+}
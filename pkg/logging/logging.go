@@ -0,0 +1,171 @@
+// Package logging настраивает структурированные JSON-логи на базе log/slog.
+//
+// Идентификатор запроса хранится в контексте и автоматически добавляется
+// к каждой записи, сделанной через *Context-методы slog. Значения атрибутов
+// с персональными данными заменяются коротким HMAC-отпечатком, по которому
+// можно сопоставить записи одного пользователя, не раскрывая сами данные.
+// Без ключа отпечаток имени и даты рождения подбирался бы перебором.
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// PIIKeys - ключи атрибутов, значения которых всегда редактируются
+var PIIKeys = map[string]bool{
+	"name":          true,
+	"birth_date":    true,
+	"birth_time":    true,
+	"birth_place":   true,
+	"question":      true,
+	"partner_name":  true,
+	"partner_birth": true,
+	"prompt":        true,
+	"text":          true,
+	"body":          true,
+}
+
+// Options - параметры логирования
+type Options struct {
+	// Level - debug, info, warn или error
+	Level string
+	// Format - json или text
+	Format string
+	// HashKey - ключ отпечатков персональных данных; пустой - случайный ключ процесса
+	HashKey string
+}
+
+// hashKey - ключ HMAC для Hash
+var hashKey = randomKey()
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("ошибка генерации ключа логов: %v", err))
+	}
+	return key
+}
+
+// Setup создает логгер и делает его логгером по умолчанию, в том числе для
+// пакета log, чтобы сторонние библиотеки писали в тот же поток
+func Setup(opts Options, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, fmt.Errorf("некорректный уровень логирования %q", opts.Level)
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	case "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("некорректный формат логов %q", opts.Format)
+	}
+
+	if opts.HashKey != "" {
+		hashKey = []byte(opts.HashKey)
+	}
+	logger := slog.New(&contextHandler{Handler: handler})
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	return logger, nil
+}
+
+// MustSetup - Setup для точек входа: при ошибке пишет в stderr и завершает процесс
+func MustSetup(opts Options) *slog.Logger {
+	logger, err := Setup(opts, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return logger
+}
+
+// Hash возвращает короткий отпечаток значения - HMAC-SHA256 с ключом логов
+func Hash(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(value))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// redact заменяет значения PII-атрибутов отпечатком
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if !PIIKeys[a.Key] {
+		return a
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	return slog.String(a.Key, Hash(a.Value.String()))
+}
+
+type requestIDKey struct{}
+
+// WithRequestID сохраняет идентификатор запроса в контексте
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID возвращает идентификатор запроса из контекста
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler добавляет request_id из контекста к каждой записи
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Stage начинает замер этапа обработки. Возвращаемая функция пишет запись
+// с длительностью этапа и ошибкой, если она есть.
+//
+//	done := logging.Stage(ctx, "llm")
+//	text, err := llm.CreateChatCompletion(ctx, prompt)
+//	done(err)
+func Stage(ctx context.Context, stage string, attrs ...any) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		args := append([]any{
+			slog.String("stage", stage),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		}, attrs...)
+		if err != nil {
+			slog.ErrorContext(ctx, "stage failed", append(args, slog.String("error", err.Error()))...)
+			return
+		}
+		slog.InfoContext(ctx, "stage finished", args...)
+	}
+}
@@ -0,0 +1,38 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := Setup(Options{Level: "info", HashKey: "first"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := Hash("Анна|15.03.1990")
+	if first == "" || first != Hash("Анна|15.03.1990") {
+		t.Fatalf("hash is not stable: %q", first)
+	}
+	// Словарь имен и дат без ключа не восстанавливает значение
+	plain := sha256.Sum256([]byte("Анна|15.03.1990"))
+	if strings.Contains(first, hex.EncodeToString(plain[:6])) {
+		t.Error("hash is an unkeyed sha256")
+	}
+
+	logger.Info("prediction requested", "name", "Анна")
+	if strings.Contains(buf.String(), "Анна") || !strings.Contains(buf.String(), Hash("Анна")) {
+		t.Errorf("name not redacted: %s", buf.String())
+	}
+
+	if _, err := Setup(Options{Level: "info", HashKey: "second"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if Hash("Анна|15.03.1990") == first {
+		t.Error("hash does not depend on the key")
+	}
+}
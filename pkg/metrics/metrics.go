@@ -0,0 +1,275 @@
+// Package metrics - метрики Prometheus для конвейера предсказаний
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "miniapp"
+
+// Registry - реестр метрик приложения
+var Registry = prometheus.NewRegistry()
+
+// Бакеты рассчитаны на долгие операции: LLM отвечает за секунды,
+// генерация изображения в очереди Kandinsky занимает минуты
+var (
+	llmBuckets   = []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120}
+	imageBuckets = []float64{5, 10, 20, 30, 60, 90, 120, 180, 300}
+	totalBuckets = []float64{5, 10, 30, 60, 90, 120, 180, 240, 300, 420}
+)
+
+var (
+	// PredictionDuration - полное время предсказания (текст и изображения)
+	PredictionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "prediction_duration_seconds",
+		Help:      "Total time to produce a prediction including images.",
+		Buckets:   totalBuckets,
+	}, []string{"mode", "outcome"})
+
+	// PredictionRequests - запросы предсказаний по сферам
+	PredictionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "prediction_requests_total",
+		Help:      "Prediction requests by mode.",
+	}, []string{"mode"})
+
+	// PredictionFailures - неудачные предсказания по коду ошибки
+	PredictionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "prediction_failures_total",
+		Help:      "Failed predictions by error code.",
+	}, []string{"code"})
+
+	// FollowUps - уточняющие вопросы по исходу (answered, limit, failed)
+	FollowUps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "follow_ups_total",
+		Help:      "Follow-up questions in prediction conversations, by outcome.",
+	}, []string{"outcome"})
+
+	// Edits - перегенерации изображений и переписывания текста по виду
+	// (image, rewrite) и исходу (done, limit, failed)
+	Edits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "prediction_edits_total",
+		Help:      "Image regenerations and text rewrites of predictions, by kind and outcome.",
+	}, []string{"kind", "outcome"})
+
+	// ModerationVerdicts - сработавшие категории модерации по этапу (input, output)
+	// и действию; категории задает политика, поэтому их число ограничено
+	ModerationVerdicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moderation_verdicts_total",
+		Help:      "Moderation categories matched, by stage, category and action.",
+	}, []string{"stage", "category", "action"})
+
+	// ModerationErrors - ошибки классификатора модерации
+	ModerationErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moderation_classifier_errors_total",
+		Help:      "Moderation classifier failures; keyword checks still apply.",
+	})
+
+	// Shares - публичные ссылки на предсказания по действию (created, revoked, viewed)
+	Shares = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shares_total",
+		Help:      "Public prediction links created, revoked and viewed.",
+	}, []string{"action"})
+
+	// Referrals - приглашения по исходу (attributed, activated, self, known, limit, invalid, failed)
+	Referrals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "referrals_total",
+		Help:      "Referral attributions and activations, by outcome.",
+	}, []string{"outcome"})
+
+	// STTDuration - время распознавания речи по бэкенду и статусу ответа
+	STTDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stt_request_duration_seconds",
+		Help:      "Speech-to-text request latency.",
+		Buckets:   llmBuckets,
+	}, []string{"backend", "status"})
+
+	// TTSDuration - время синтеза одного фрагмента озвучки по бэкенду и статусу ответа
+	TTSDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tts_request_duration_seconds",
+		Help:      "Text-to-speech request latency per text chunk.",
+		Buckets:   llmBuckets,
+	}, []string{"backend", "status"})
+
+	// Narrations - запросы озвучки предсказаний по результату: created, cached, failed
+	Narrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "narrations_total",
+		Help:      "Prediction narration requests, by outcome.",
+	}, []string{"outcome"})
+
+	// LLMDuration - время ответа LLM
+	LLMDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "OpenRouter chat completion latency.",
+		Buckets:   llmBuckets,
+	}, []string{"model", "status"})
+
+	// LLMTokens - токены по данным поля usage ответа OpenRouter
+	LLMTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens reported by OpenRouter usage, by model and type (prompt, completion).",
+	}, []string{"model", "type"})
+
+	// LLMCost - стоимость запросов к LLM по таблице цен
+	LLMCost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_cost_usd_total",
+		Help:      "Estimated OpenRouter spend in USD, by model.",
+	}, []string{"model"})
+
+	// BudgetExceeded - срабатывания лимитов расходов по действию (fallback, refused)
+	BudgetExceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "budget_exceeded_total",
+		Help:      "Predictions affected by user budget caps, by action (fallback, refused).",
+	}, []string{"action"})
+
+	// ImageGenerationDuration - время от постановки задачи Kandinsky до результата
+	ImageGenerationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kandinsky_generation_duration_seconds",
+		Help:      "Time from Kandinsky task creation to a final status.",
+		Buckets:   imageBuckets,
+	}, []string{"status"})
+
+	// ImageQueueDuration - время ожидания задачи в очереди Kandinsky
+	ImageQueueDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kandinsky_queue_duration_seconds",
+		Help:      "Time a Kandinsky task spends queued before processing starts.",
+		Buckets:   imageBuckets,
+	})
+
+	// ImagePolls - запросы статуса задач Kandinsky
+	ImagePolls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kandinsky_poll_attempts_total",
+		Help:      "Kandinsky status polling attempts by result (ok, error).",
+	}, []string{"result"})
+
+	// ImagesCensored - изображения, отклоненные цензурой Kandinsky
+	ImagesCensored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kandinsky_censored_total",
+		Help:      "Images flagged as censored by Kandinsky.",
+	})
+
+	// UpstreamRetries - повторы запросов к внешним API после временных ошибок
+	UpstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Retried upstream calls after transient failures, by upstream.",
+	}, []string{"upstream"})
+
+	// CircuitState - состояние автомата защиты внешнего API: 0 - закрыт, 1 - пробный запрос, 2 - открыт
+	CircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_circuit_state",
+		Help:      "Upstream circuit breaker state (0 closed, 1 half-open, 2 open).",
+	}, []string{"upstream"})
+
+	// CircuitRejected - запросы, отклоненные открытым автоматом без обращения к API
+	CircuitRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_circuit_rejected_total",
+		Help:      "Upstream calls rejected by an open circuit breaker, by upstream.",
+	}, []string{"upstream"})
+
+	// JobsInFlight - предсказания в работе: синхронные запросы и фоновые задачи
+	JobsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jobs_in_flight",
+		Help:      "Predictions currently being processed, by kind (sync, async).",
+	}, []string{"kind"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		PredictionDuration,
+		PredictionRequests,
+		PredictionFailures,
+		FollowUps,
+		Edits,
+		ModerationVerdicts,
+		ModerationErrors,
+		Shares,
+		Referrals,
+		STTDuration,
+		TTSDuration,
+		Narrations,
+		LLMDuration,
+		LLMTokens,
+		LLMCost,
+		BudgetExceeded,
+		ImageGenerationDuration,
+		ImageQueueDuration,
+		ImagePolls,
+		ImagesCensored,
+		UpstreamRetries,
+		CircuitState,
+		CircuitRejected,
+		JobsInFlight,
+	)
+}
+
+// Handler отдает метрики в формате Prometheus. Если token не пуст,
+// требуется заголовок "Authorization: Bearer <token>".
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// modes - сферы вопроса из формы мини-приложения
+var modes = map[string]bool{
+	"Любовь":   true,
+	"Карьера":  true,
+	"Здоровье": true,
+	"Финансы":  true,
+	"Семья":    true,
+	"Другое":   true,
+	// Сферы по фотографии
+	"Хиромантия":    true,
+	"Кофейная гуща": true,
+	// Выбор между вариантами
+	"Принятие решений": true,
+}
+
+// Mode возвращает значение метки mode. Сфера приходит от клиента,
+// поэтому неизвестные значения сводятся к "unknown", чтобы не раздувать число серий.
+func Mode(mode string) string {
+	if modes[mode] {
+		return mode
+	}
+	return "unknown"
+}
@@ -0,0 +1,94 @@
+package mock
+
+// Шаблоны текстов заглушки. Приветствие получает имя пользователя.
+var greetings = []string{
+	"Дорогой(ая) %s, карты разложены, и свечи горят ровно.",
+	"%s, звезды сегодня говорят отчетливо, прислушайтесь к ним.",
+	"Приветствую, %s. Колода легла так, будто ждала именно вашего вопроса.",
+}
+
+// modeOpenings - вступления по сферам вопроса
+var modeOpenings = map[string][]string{
+	"Любовь": {
+		"В сердечных делах наступает время откровенности: чувство, которое вы берегли, просит быть высказанным.",
+		"Рядом с вами человек, который замечает больше, чем показывает. Тепло вернется к тому, кто сделает первый шаг.",
+	},
+	"Карьера": {
+		"В работе вы стоите на пороге перемен: задача, которая казалась рутинной, откроет новую дорогу.",
+		"Ваш труд давно заметили, но признание придет через неожиданного человека. Не отказывайтесь от сложного поручения.",
+	},
+	"Здоровье": {
+		"Телу нужен ритм: размеренный сон и прогулки вернут силы быстрее, чем вы ожидаете.",
+		"Энергия копится медленно, но верно. Берегите себя от спешки и лишних тревог.",
+	},
+	"Финансы": {
+		"Деньги любят порядок: пересмотрите мелкие траты, и найдется ресурс для давней цели.",
+		"Впереди период осторожного роста. Не торопитесь с крупными решениями до конца месяца.",
+	},
+	"Семья": {
+		"В доме назревает важный разговор, и он сблизит вас с близкими больше, чем праздники.",
+		"Старшие в семье хранят совет, который сейчас особенно нужен. Найдите время выслушать их.",
+	},
+	"Другое": {
+		"Ваш вопрос многогранен, и ответ на него складывается из нескольких знаков.",
+		"Путь, о котором вы спрашиваете, петляет, но ведет в верном направлении.",
+	},
+	"Хиромантия": {
+		"Линия жизни на вашей ладони длинная и ровная: запас сил больше, чем вам кажется.",
+		"Линия сердца поднимается к указательному пальцу — вы требовательны в чувствах, но верны им.",
+	},
+	"Кофейная гуща": {
+		"На стенке чашки проступает птица: скоро придет добрая весть.",
+		"Гуща на дне сложилась в дорогу — впереди поездка, которая многое прояснит.",
+	},
+}
+
+var cards = []string{
+	"Выпала карта Звезда: надежда не обманет, если вы будете последовательны.",
+	"Колесо Фортуны поворачивается в вашу сторону, но требует внимания к деталям.",
+	"Маг напоминает: все необходимое уже у вас в руках, осталось начать.",
+	"Императрица обещает плодотворный период и поддержку близких.",
+}
+
+// pros и cons - доводы за и против варианта в сравнении
+var pros = []string{
+	"совпадает с вашими ценностями",
+	"открывает новые знакомства",
+	"дает опору и стабильность",
+	"оставляет место для роста",
+}
+
+var cons = []string{
+	"потребует терпения",
+	"придется отказаться от привычного",
+	"результат придет не сразу",
+}
+
+var stars = []string{
+	"Луна в растущей фазе усиливает интуицию, а Венера смягчает острые углы в общении.",
+	"Меркурий благоприятствует переговорам и переписке, хороший день для важных писем.",
+	"Юпитер расширяет горизонты: планы, отложенные весной, снова становятся реальными.",
+	"Сатурн учит терпению, и маленькие шаги сейчас надежнее больших прыжков.",
+}
+
+var advice = []string{
+	"Совет: запишите три желания и перечитайте их через неделю.",
+	"Совет: сделайте сегодня то, что откладывали дольше всего.",
+	"Совет: проведите вечер без экранов и дайте мыслям улечься.",
+	"Совет: поблагодарите человека, который помог вам в этом месяце.",
+}
+
+var followUps = []string{
+	"Карты подтверждают сказанное: в ближайший месяц главное - не спешить с выводами.",
+	"Если посмотреть дальше, знаки становятся мягче: напряжение уйдет к концу сезона.",
+	"На ваш вопрос выпал Отшельник: ответ придет в тишине, а не в чужих советах.",
+}
+
+var imageSubjects = []string{
+	"a golden sun rising over a calm violet sea",
+	"a silver crescent moon above a dark forest",
+	"concentric circles of color floating in space",
+	"a tarot card with a burning star and blue rays",
+	"a crossroads of red and yellow lines under a night sky",
+	"a mystical eye surrounded by geometric shapes",
+}
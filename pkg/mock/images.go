@@ -0,0 +1,225 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+)
+
+// Images - заглушка Kandinsky, рисующая абстрактные композиции из кругов,
+// линий и фигур. Состояние задачи закодировано в ее UUID, поэтому опрос
+// работает и в serverless, где запросы попадают в разные экземпляры.
+type Images struct {
+	cfg           config.MockConfig
+	width, height int
+}
+
+// NewImages создает заглушку генерации изображений размера width x height
+func NewImages(cfg config.MockConfig, width, height int) *Images {
+	return &Images{cfg: cfg, width: width, height: height}
+}
+
+// GenerateImage рисует изображение после искусственной задержки
+func (m *Images) GenerateImage(ctx context.Context, prompt string) ([]byte, error) {
+	if err := sleep(ctx, m.cfg.ImageLatency); err != nil {
+		return nil, err
+	}
+	if fail(m.cfg.FailureRate) {
+		return nil, fmt.Errorf("%w: mock: injected failure", common.ErrGenerationFailed)
+	}
+	return m.render(hash(prompt))
+}
+
+// CreateTask "ставит" задачу: UUID содержит зерно рисунка, время готовности
+// и признак внесенной ошибки
+func (m *Images) CreateTask(_ context.Context, prompt string) (string, error) {
+	failed := 0
+	if fail(m.cfg.FailureRate) {
+		failed = 1
+	}
+	ready := time.Now().Add(m.cfg.ImageLatency).UnixMilli()
+	return fmt.Sprintf("mock-%x-%d-%d", hash(prompt), ready, failed), nil
+}
+
+// CheckStatus возвращает PROCESSING до времени готовности, затем результат
+func (m *Images) CheckStatus(_ context.Context, uuid string) (*common.KandinskyStatusResponse, error) {
+	seed, ready, failed, err := parseTask(uuid)
+	if err != nil {
+		return nil, err
+	}
+	status := &common.KandinskyStatusResponse{UUID: uuid, Status: "PROCESSING"}
+	switch {
+	case time.Now().Before(ready):
+	case failed:
+		status.Status = common.KandinskyStatusFailed
+		status.Error = "mock: injected failure"
+	default:
+		data, err := m.render(seed)
+		if err != nil {
+			return nil, err
+		}
+		status.Status = common.KandinskyStatusDone
+		status.Images = []string{base64.StdEncoding.EncodeToString(data)}
+	}
+	return status, nil
+}
+
+// PollInterval - интервал опроса, соразмерный искусственной задержке
+func (m *Images) PollInterval() time.Duration {
+	return max(m.cfg.ImageLatency/4, 100*time.Millisecond)
+}
+
+// Availability всегда успешна: заглушка доступна без сети
+func (m *Images) Availability(context.Context) error {
+	return nil
+}
+
+func parseTask(uuid string) (seed uint64, ready time.Time, failed bool, err error) {
+	parts := strings.Split(uuid, "-")
+	if len(parts) != 4 || parts[0] != "mock" {
+		return 0, time.Time{}, false, fmt.Errorf("неизвестная задача %q", uuid)
+	}
+	seed, err = strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return 0, time.Time{}, false, fmt.Errorf("неизвестная задача %q", uuid)
+	}
+	ms, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false, fmt.Errorf("неизвестная задача %q", uuid)
+	}
+	return seed, time.UnixMilli(ms), parts[3] == "1", nil
+}
+
+// render рисует композицию, детерминированную зерном
+func (m *Images) render(seed uint64) ([]byte, error) {
+	rng := rand.New(rand.NewPCG(seed, seed>>32))
+	c := &canvas{RGBA: image.NewRGBA(image.Rect(0, 0, m.width, m.height))}
+	size := float64(min(m.width, m.height))
+
+	c.fill(palette(rng, 40))
+	for range 3 + rng.IntN(3) {
+		c.rect(rng.Float64()*float64(m.width), rng.Float64()*float64(m.height),
+			size*(0.1+rng.Float64()*0.3), size*(0.05+rng.Float64()*0.2), palette(rng, 200))
+	}
+	for range 2 + rng.IntN(3) {
+		// Концентрические круги - узнаваемый мотив Кандинского
+		x, y := rng.Float64()*float64(m.width), rng.Float64()*float64(m.height)
+		r := size * (0.08 + rng.Float64()*0.15)
+		for ring := range 3 {
+			c.circle(x, y, r*float64(3-ring)/3, palette(rng, 230))
+		}
+	}
+	for range 4 + rng.IntN(4) {
+		c.line(rng.Float64()*float64(m.width), rng.Float64()*float64(m.height),
+			rng.Float64()*float64(m.width), rng.Float64()*float64(m.height),
+			size*(0.004+rng.Float64()*0.01), color.RGBA{20, 20, 30, 255})
+	}
+	for range 1 + rng.IntN(3) {
+		c.triangle(
+			rng.Float64()*float64(m.width), rng.Float64()*float64(m.height),
+			rng.Float64()*float64(m.width), rng.Float64()*float64(m.height),
+			rng.Float64()*float64(m.width), rng.Float64()*float64(m.height),
+			palette(rng, 180),
+		)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c); err != nil {
+		return nil, fmt.Errorf("ошибка кодирования изображения: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// palette выбирает цвет из палитры с прозрачностью alpha
+func palette(rng *rand.Rand, alpha uint8) color.RGBA {
+	colors := []color.RGBA{
+		{230, 57, 70, 0}, {241, 196, 15, 0}, {29, 53, 87, 0}, {69, 123, 157, 0},
+		{42, 157, 143, 0}, {244, 162, 97, 0}, {131, 56, 236, 0}, {250, 240, 220, 0},
+	}
+	c := colors[rng.IntN(len(colors))]
+	c.A = alpha
+	return c
+}
+
+// canvas - холст с простыми примитивами и наложением полупрозрачных цветов
+type canvas struct {
+	*image.RGBA
+}
+
+func (c *canvas) fill(col color.RGBA) {
+	col.A = 255
+	b := c.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c.SetRGBA(x, y, col)
+		}
+	}
+}
+
+func (c *canvas) blend(x, y int, col color.RGBA) {
+	if !(image.Point{x, y}.In(c.Bounds())) {
+		return
+	}
+	dst := c.RGBAAt(x, y)
+	a := uint32(col.A)
+	mix := func(s, d uint8) uint8 { return uint8((uint32(s)*a + uint32(d)*(255-a)) / 255) }
+	c.SetRGBA(x, y, color.RGBA{mix(col.R, dst.R), mix(col.G, dst.G), mix(col.B, dst.B), 255})
+}
+
+func (c *canvas) rect(cx, cy, w, h float64, col color.RGBA) {
+	for y := int(cy - h/2); y < int(cy+h/2); y++ {
+		for x := int(cx - w/2); x < int(cx+w/2); x++ {
+			c.blend(x, y, col)
+		}
+	}
+}
+
+func (c *canvas) circle(cx, cy, r float64, col color.RGBA) {
+	for y := int(cy - r); y <= int(cy+r); y++ {
+		for x := int(cx - r); x <= int(cx+r); x++ {
+			if dx, dy := float64(x)-cx, float64(y)-cy; dx*dx+dy*dy <= r*r {
+				c.blend(x, y, col)
+			}
+		}
+	}
+}
+
+func (c *canvas) line(x0, y0, x1, y1, width float64, col color.RGBA) {
+	steps := int(math.Hypot(x1-x0, y1-y0))
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(max(steps, 1))
+		x, y := x0+(x1-x0)*t, y0+(y1-y0)*t
+		for dy := -int(width); dy <= int(width); dy++ {
+			for dx := -int(width); dx <= int(width); dx++ {
+				c.SetRGBA(int(x)+dx, int(y)+dy, col)
+			}
+		}
+	}
+}
+
+func (c *canvas) triangle(x0, y0, x1, y1, x2, y2 float64, col color.RGBA) {
+	side := func(ax, ay, bx, by, px, py float64) float64 { return (bx-ax)*(py-ay) - (by-ay)*(px-ax) }
+	minX, maxX := int(min(x0, x1, x2)), int(max(x0, x1, x2))
+	minY, maxY := int(min(y0, y1, y2)), int(max(y0, y1, y2))
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			px, py := float64(x), float64(y)
+			a, b, d := side(x0, y0, x1, y1, px, py), side(x1, y1, x2, y2, px, py), side(x2, y2, x0, y0, px, py)
+			if (a >= 0 && b >= 0 && d >= 0) || (a <= 0 && b <= 0 && d <= 0) {
+				c.blend(x, y, col)
+			}
+		}
+	}
+}
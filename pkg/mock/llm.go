@@ -0,0 +1,216 @@
+// Package mock - офлайн-заглушки OpenRouter и Kandinsky для локальной
+// разработки (PROVIDER=mock или cmd/server --mock). Ответы детерминированы:
+// один и тот же промпт дает тот же текст и то же изображение.
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+)
+
+// Model - модель, от имени которой отвечает заглушка; цены для нее нет,
+// поэтому расходы не начисляются
+const Model = "mock"
+
+var (
+	nameRe = regexp.MustCompile(`по имени (.+?) \(`)
+	modeRe = regexp.MustCompile(`сфера: (.+?)\)`)
+	// optionRe - строки вариантов в промпте сферы «Принятие решений»
+	optionRe = regexp.MustCompile(`(?m)^Вариант \d+:`)
+)
+
+// LLM - заглушка языковой модели
+type LLM struct {
+	cfg config.MockConfig
+}
+
+// NewLLM создает заглушку языковой модели
+func NewLLM(cfg config.MockConfig) *LLM {
+	return &LLM{cfg: cfg}
+}
+
+// CreateChatCompletion отвечает на одиночный промпт
+func (m *LLM) CreateChatCompletion(ctx context.Context, prompt string) (*common.ChatCompletion, error) {
+	return m.CreateChatCompletionMessages(ctx, Model, []common.OpenAIMessage{{Role: common.RoleUser, Content: prompt}})
+}
+
+// CreateChatCompletionMessages отвечает на последнее сообщение диалога:
+// предсказанием с тремя IMAGE_PROMPT, сравнением вариантов в JSON, гороскопом
+// с одним IMAGE_PROMPT, переписанным предсказанием или коротким ответом на
+// уточняющий вопрос
+func (m *LLM) CreateChatCompletionMessages(ctx context.Context, _ string, messages []common.OpenAIMessage) (*common.ChatCompletion, error) {
+	if err := sleep(ctx, m.cfg.LLMLatency); err != nil {
+		return nil, err
+	}
+	if fail(m.cfg.FailureRate) {
+		return nil, &common.UpstreamError{Service: "openrouter", StatusCode: http.StatusServiceUnavailable, Message: "mock: injected failure"}
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages")
+	}
+
+	prompt := messages[len(messages)-1].Content
+	rng := random(prompt)
+	var content string
+	switch {
+	// Классификатор модерации: заглушка не находит нарушений
+	case strings.HasPrefix(messages[0].Content, "Классифицируй"):
+		content = "none"
+	case strings.HasPrefix(prompt, "Перепиши это предсказание") && len(messages) > 1:
+		content = rewrite(rng, messages[len(messages)-2].Content)
+	// После ответа модели идут только уточняющие вопросы
+	case hasAssistant(messages):
+		content = followUp(rng)
+	case strings.Contains(prompt, "короткий гороскоп"):
+		content = horoscope(rng)
+	case match(modeRe, prompt, "") == decision.Mode:
+		content = decide(rng, len(optionRe.FindAllString(prompt, -1)))
+	default:
+		content = reading(rng, match(nameRe, prompt, "путник"), match(modeRe, prompt, "Другое"))
+	}
+
+	promptTokens := 0
+	for _, msg := range messages {
+		promptTokens += tokens(msg.Content)
+	}
+	usage := common.Usage{PromptTokens: promptTokens, CompletionTokens: tokens(content)}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return &common.ChatCompletion{Content: content, Model: Model, Usage: usage}, nil
+}
+
+// CheckModel всегда успешна: заглушка доступна без сети
+func (m *LLM) CheckModel(context.Context) error {
+	return nil
+}
+
+func reading(rng *rand.Rand, name, mode string) string {
+	openings, ok := modeOpenings[mode]
+	if !ok {
+		openings = modeOpenings["Другое"]
+	}
+	paragraphs := []string{
+		fmt.Sprintf(pick(rng, greetings), name),
+		pick(rng, openings),
+		pick(rng, cards),
+		pick(rng, stars),
+		pick(rng, advice),
+		"(Это тестовое предсказание: сервер запущен в режиме заглушек.)",
+	}
+	lines := []string{strings.Join(paragraphs, "\n\n"), ""}
+	for _, i := range rng.Perm(len(imageSubjects))[:3] {
+		lines = append(lines, "IMAGE_PROMPT: "+imageSubjects[i]+", abstract composition in Kandinsky style, vibrant colors")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decide оценивает n вариантов по схеме decision.Prompt
+func decide(rng *rand.Rand, n int) string {
+	type option struct {
+		Index   int      `json:"index"`
+		Pros    []string `json:"pros"`
+		Cons    []string `json:"cons"`
+		Energy  int      `json:"energy"`
+		Summary string   `json:"summary"`
+		Image   string   `json:"image"`
+	}
+	answer := struct {
+		Options []option `json:"options"`
+		Verdict string   `json:"verdict"`
+	}{Verdict: pick(rng, advice) + " (Это тестовое сравнение: сервер запущен в режиме заглушек.)"}
+	for i := range n {
+		answer.Options = append(answer.Options, option{
+			Index:   i + 1,
+			Pros:    []string{pick(rng, pros), pick(rng, pros)},
+			Cons:    []string{pick(rng, cons)},
+			Energy:  1 + rng.IntN(10),
+			Summary: pick(rng, cards),
+			Image:   imageSubjects[rng.IntN(len(imageSubjects))] + ", tarot card, Kandinsky style",
+		})
+	}
+	data, _ := json.Marshal(answer)
+	return string(data)
+}
+
+func horoscope(rng *rand.Rand) string {
+	return pick(rng, stars) + " " + pick(rng, advice) +
+		"\nIMAGE_PROMPT: " + imageSubjects[rng.IntN(len(imageSubjects))] + ", tarot card of the day, Kandinsky style"
+}
+
+// rewrite сокращает предсказание до первых абзацев и добавляет совет
+func rewrite(rng *rand.Rand, reading string) string {
+	paragraphs := strings.Split(reading, "\n\n")
+	return strings.Join(paragraphs[:min(len(paragraphs), 2)], "\n\n") + "\n\n" + pick(rng, advice)
+}
+
+func followUp(rng *rand.Rand) string {
+	return pick(rng, followUps) + " " + pick(rng, advice)
+}
+
+func hasAssistant(messages []common.OpenAIMessage) bool {
+	for _, msg := range messages {
+		if msg.Role == common.RoleAssistant {
+			return true
+		}
+	}
+	return false
+}
+
+func match(re *regexp.Regexp, s, fallback string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return fallback
+}
+
+func pick(rng *rand.Rand, items []string) string {
+	return items[rng.IntN(len(items))]
+}
+
+// random возвращает генератор, детерминированный текстом
+func random(s string) *rand.Rand {
+	seed := hash(s)
+	return rand.New(rand.NewPCG(seed, seed>>32))
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// tokens оценивает число токенов так же грубо, как для истории диалога
+func tokens(s string) int {
+	return utf8.RuneCountInString(s)/3 + 4
+}
+
+// fail решает, внести ли ошибку в ответ
+func fail(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// sleep выдерживает искусственную задержку с учетом отмены ctx
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,171 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+)
+
+const prompt = "Ты - опытный таролог и экстрасенс. Тебе нужно дать предсказание для человека по имени Анна (родился(ась) 1990-03-15). " +
+	"Вопрос: Что ждет меня? (сфера: Карьера). В конце сгенерируй три промпта с префиксом 'IMAGE_PROMPT:'."
+
+func TestReading(t *testing.T) {
+	llm := NewLLM(config.MockConfig{})
+	ctx := context.Background()
+
+	first, err := llm.CreateChatCompletion(ctx, prompt)
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	second, _ := llm.CreateChatCompletion(ctx, prompt)
+	if first.Content != second.Content {
+		t.Error("reading is not deterministic")
+	}
+	if first.Model != Model || first.Usage.TotalTokens == 0 {
+		t.Errorf("model %s, usage %+v", first.Model, first.Usage)
+	}
+	if !strings.Contains(first.Content, "Анна") {
+		t.Error("reading does not mention the name")
+	}
+	if !containsAny(first.Content, modeOpenings["Карьера"]) {
+		t.Error("reading ignores the mode")
+	}
+	if n := strings.Count(first.Content, "\nIMAGE_PROMPT: "); n != 3 {
+		t.Errorf("image prompts = %d, want 3", n)
+	}
+}
+
+func TestDecision(t *testing.T) {
+	options := []string{"Остаться", "Переехать", "Подождать"}
+	cards := decision.Draw(nil, len(options))
+	reply, err := NewLLM(config.MockConfig{}).CreateChatCompletion(context.Background(),
+		decision.Prompt("Анна", "1990-03-15", "Куда двигаться?", "", options, cards))
+	if err != nil {
+		t.Fatalf("CreateChatCompletion: %v", err)
+	}
+	r, err := decision.Parse(reply.Content, options, cards)
+	if err != nil {
+		t.Fatalf("mock answer does not follow the schema: %v\n%s", err, reply.Content)
+	}
+	if len(r.Options) != 3 || len(r.ImagePrompts()) != 3 {
+		t.Errorf("%d options, %d image prompts", len(r.Options), len(r.ImagePrompts()))
+	}
+}
+
+func TestFollowUpAndHoroscope(t *testing.T) {
+	llm := NewLLM(config.MockConfig{})
+	ctx := context.Background()
+
+	reply, err := llm.CreateChatCompletionMessages(ctx, "any/model", []common.OpenAIMessage{
+		{Role: common.RoleSystem, Content: "persona"},
+		{Role: common.RoleUser, Content: prompt},
+		{Role: common.RoleAssistant, Content: "предсказание"},
+		{Role: common.RoleUser, Content: "А что дальше?"},
+	})
+	if err != nil {
+		t.Fatalf("follow-up: %v", err)
+	}
+	if strings.Contains(reply.Content, "IMAGE_PROMPT") {
+		t.Errorf("follow-up has image prompts: %q", reply.Content)
+	}
+
+	reply, err = llm.CreateChatCompletionMessages(ctx, "any/model", []common.OpenAIMessage{
+		{Role: common.RoleSystem, Content: "persona"},
+		{Role: common.RoleUser, Content: prompt},
+		{Role: common.RoleAssistant, Content: "первый абзац\n\nвторой абзац\n\nтретий абзац"},
+		{Role: common.RoleUser, Content: "Перепиши это предсказание короче"},
+	})
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if !strings.HasPrefix(reply.Content, "первый абзац\n\nвторой абзац\n\n") || strings.Contains(reply.Content, "третий") {
+		t.Errorf("rewrite = %q", reply.Content)
+	}
+
+	reply, err = llm.CreateChatCompletion(ctx, "Напиши короткий гороскоп на 2026-10-18 для знака Овен")
+	if err != nil {
+		t.Fatalf("horoscope: %v", err)
+	}
+	if n := strings.Count(reply.Content, "IMAGE_PROMPT:"); n != 1 {
+		t.Errorf("horoscope image prompts = %d, want 1", n)
+	}
+}
+
+func TestImageTask(t *testing.T) {
+	images := NewImages(config.MockConfig{ImageLatency: 30 * time.Millisecond}, 64, 48)
+	ctx := context.Background()
+
+	uuid, err := images.CreateTask(ctx, "a red circle")
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	status, err := images.CheckStatus(ctx, uuid)
+	if err != nil || status.Status != "PROCESSING" {
+		t.Fatalf("status before latency = %+v, %v", status, err)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	status, err = images.CheckStatus(ctx, uuid)
+	if err != nil || status.Status != common.KandinskyStatusDone {
+		t.Fatalf("status after latency = %+v, %v", status, err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(status.Images[0])
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+		t.Errorf("size = %v, want 64x48", b)
+	}
+
+	direct, err := images.GenerateImage(ctx, "a red circle")
+	if err != nil || !bytes.Equal(direct, data) {
+		t.Error("same prompt gives a different image")
+	}
+	other, _ := images.GenerateImage(ctx, "a blue square")
+	if bytes.Equal(other, data) {
+		t.Error("different prompts give the same image")
+	}
+
+	if _, err := images.CheckStatus(ctx, "unknown"); err == nil {
+		t.Error("CheckStatus accepted an unknown task")
+	}
+}
+
+func TestFailureInjection(t *testing.T) {
+	cfg := config.MockConfig{FailureRate: 1}
+	ctx := context.Background()
+
+	_, err := NewLLM(cfg).CreateChatCompletion(ctx, prompt)
+	var upstream *common.UpstreamError
+	if !errors.As(err, &upstream) {
+		t.Errorf("llm err = %v, want upstream error", err)
+	}
+
+	images := NewImages(cfg, 8, 8)
+	if _, err := images.GenerateImage(ctx, "x"); !errors.Is(err, common.ErrGenerationFailed) {
+		t.Errorf("image err = %v, want ErrGenerationFailed", err)
+	}
+	uuid, _ := images.CreateTask(ctx, "x")
+	if status, _ := images.CheckStatus(ctx, uuid); status.Status != common.KandinskyStatusFailed {
+		t.Errorf("task status = %s, want FAILED", status.Status)
+	}
+}
+
+func containsAny(s string, parts []string) bool {
+	for _, p := range parts {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
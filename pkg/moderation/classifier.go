@@ -0,0 +1,62 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+)
+
+// classifierPrompt - начало запроса к модели-классификатору
+const classifierPrompt = "Классифицируй текст пользователя мини-приложения с предсказаниями."
+
+// Completer - языковая модель для классификатора
+type Completer interface {
+	CreateChatCompletionMessages(ctx context.Context, model string, messages []common.OpenAIMessage) (*common.ChatCompletion, error)
+}
+
+// LLMClassifier определяет категории текста языковой моделью
+type LLMClassifier struct {
+	llm   Completer
+	model string
+}
+
+// NewLLMClassifier создает классификатор на модели model
+func NewLLMClassifier(llm Completer, model string) *LLMClassifier {
+	return &LLMClassifier{llm: llm, model: model}
+}
+
+// Classify возвращает имена подходящих категорий. Модель отвечает именами
+// через запятую или none; незнакомые имена отбрасываются.
+func (c *LLMClassifier) Classify(ctx context.Context, text string, categories []Category) ([]string, *common.ChatCompletion, error) {
+	var b strings.Builder
+	b.WriteString(classifierPrompt)
+	b.WriteString(" Категории:\n")
+	for _, cat := range categories {
+		fmt.Fprintf(&b, "- %s: %s\n", cat.Name, cat.Description)
+	}
+	b.WriteString("Ответь только именами подходящих категорий через запятую или словом none, если ни одна не подходит.")
+
+	completion, err := c.llm.CreateChatCompletionMessages(ctx, c.model, []common.OpenAIMessage{
+		{Role: common.RoleSystem, Content: b.String()},
+		{Role: common.RoleUser, Content: text},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка классификации: %w", err)
+	}
+
+	known := make(map[string]bool, len(categories))
+	for _, cat := range categories {
+		known[cat.Name] = true
+	}
+	var names []string
+	for _, name := range strings.FieldsFunc(strings.ToLower(completion.Content), func(r rune) bool {
+		return r == ',' || r == '\n' || r == ' ' || r == '.'
+	}) {
+		if known[name] {
+			names = append(names, name)
+		}
+	}
+	return names, completion, nil
+}
@@ -0,0 +1,178 @@
+// Package moderation проверяет вопросы пользователей и ответы модели по
+// политике: ключевыми словами и, если включено, классификатором на языковой
+// модели. В зависимости от категории вместо предсказания показываются
+// контакты помощи, запрос отклоняется или к ответу добавляется оговорка.
+package moderation
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+)
+
+// ErrBlocked - запрос или ответ отклонен политикой модерации
+var ErrBlocked = common.ErrContentBlocked
+
+// BlockedError - отказ по категории с действием block; Error возвращает
+// сообщение для пользователя из политики
+type BlockedError struct {
+	Stage    Stage
+	Category string
+	Message  string
+}
+
+func (e *BlockedError) Error() string { return e.Message }
+
+// Is позволяет проверять отказ через errors.Is(err, ErrBlocked)
+func (e *BlockedError) Is(target error) bool { return target == ErrBlocked }
+
+// Verdict - итог проверки текста
+type Verdict struct {
+	// Action - самое строгое действие среди сработавших категорий
+	Action Action
+	// Category - категория, определившая Action
+	Category string
+	// Message - сообщение для пользователя: контакты помощи или причина отказа
+	Message string
+	// Disclaimers - оговорки сработавших категорий с действием disclaimer
+	Disclaimers []string
+	// Usage - расход классификатора, nil без обращения к модели
+	Usage *common.ChatCompletion
+}
+
+// Err возвращает BlockedError для вердикта block
+func (v Verdict) Err(stage Stage) error {
+	if v.Action != Block {
+		return nil
+	}
+	return &BlockedError{Stage: stage, Category: v.Category, Message: v.Message}
+}
+
+// Classifier определяет категории текста
+type Classifier interface {
+	Classify(ctx context.Context, text string, categories []Category) ([]string, *common.ChatCompletion, error)
+}
+
+// Moderator проверяет тексты по политике
+type Moderator struct {
+	policy     *Policy
+	classifier Classifier
+}
+
+// New создает модератор; classifier используется, только если он включен в политике
+func New(policy *Policy, classifier Classifier) *Moderator {
+	if !policy.Classifier.Enabled {
+		classifier = nil
+	}
+	return &Moderator{policy: policy, classifier: classifier}
+}
+
+// Check проверяет текст на этапе stage. Ошибка классификатора не мешает
+// ответу: остается проверка ключевыми словами.
+func (m *Moderator) Check(ctx context.Context, stage Stage, text string) Verdict {
+	v := Verdict{Action: Allow}
+	if m == nil || strings.TrimSpace(text) == "" {
+		return v
+	}
+
+	matched := make(map[string]bool)
+	normalized := normalize(text) + " "
+	for _, c := range m.policy.Categories {
+		if !checks(c.Stages, stage) {
+			continue
+		}
+		for _, kw := range c.Keywords {
+			if strings.Contains(normalized, kw) {
+				matched[c.Name] = true
+				break
+			}
+		}
+	}
+
+	if m.classifier != nil && checks(m.policy.Classifier.Stages, stage) {
+		var candidates []Category
+		for _, c := range m.policy.Categories {
+			if checks(c.Stages, stage) && !matched[c.Name] {
+				candidates = append(candidates, c)
+			}
+		}
+		if len(candidates) > 0 {
+			names, usage, err := m.classifier.Classify(ctx, text, candidates)
+			v.Usage = usage
+			if err != nil {
+				metrics.ModerationErrors.Inc()
+				slog.WarnContext(ctx, "moderation classifier failed", slog.String("error", err.Error()))
+			}
+			for _, name := range names {
+				matched[name] = true
+			}
+		}
+	}
+
+	for _, c := range m.policy.Categories {
+		if !matched[c.Name] {
+			continue
+		}
+		metrics.ModerationVerdicts.WithLabelValues(string(stage), c.Name, string(c.Action)).Inc()
+		slog.InfoContext(ctx, "moderation category matched",
+			slog.String("stage", string(stage)),
+			slog.String("category", c.Name),
+			slog.String("action", string(c.Action)),
+		)
+		if c.Action == Disclaimer {
+			v.Disclaimers = append(v.Disclaimers, c.Message)
+		}
+		if severity[c.Action] > severity[v.Action] {
+			v.Action = c.Action
+			v.Category = c.Name
+			v.Message = c.Message
+			if c.Action == Crisis {
+				v.Message = m.policy.CrisisMessage
+			}
+		}
+	}
+	return v
+}
+
+// Disclaimer возвращает обязательную оговорку к предсказаниям сферы mode
+func (m *Moderator) Disclaimer(mode string) string {
+	if m == nil {
+		return ""
+	}
+	return m.policy.Disclaimers[mode]
+}
+
+// Present возвращает оговорки политики, уже добавленные к тексту, чтобы
+// сохранить их в новой версии текста
+func (m *Moderator) Present(text string) []string {
+	if m == nil {
+		return nil
+	}
+	var found []string
+	for _, c := range m.policy.Categories {
+		if c.Action == Disclaimer && strings.Contains(text, c.Message) {
+			found = append(found, c.Message)
+		}
+	}
+	for _, d := range m.policy.Disclaimers {
+		if strings.Contains(text, d) {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+// AppendDisclaimers добавляет оговорки в конец текста, пропуская уже
+// присутствующие: переписанный текст может сохранить их сам
+func AppendDisclaimers(text string, disclaimers ...string) string {
+	for _, d := range disclaimers {
+		d = strings.TrimSpace(d)
+		if d != "" && !strings.Contains(text, d) {
+			text = strings.TrimRight(text, "\n") + "\n\n" + d
+		}
+	}
+	return text
+}
@@ -0,0 +1,153 @@
+package moderation_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/moderation"
+)
+
+func defaultModerator(t *testing.T, classifier moderation.Classifier) *moderation.Moderator {
+	t.Helper()
+	policy, err := moderation.LoadPolicy("")
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	policy.Classifier.Enabled = classifier != nil
+	policy.Classifier.Stages = []moderation.Stage{moderation.Input}
+	return moderation.New(policy, classifier)
+}
+
+func TestCheckKeywords(t *testing.T) {
+	m := defaultModerator(t, nil)
+	tests := []struct {
+		stage    moderation.Stage
+		text     string
+		action   moderation.Action
+		category string
+	}{
+		{moderation.Input, "Что ждет меня в работе?", moderation.Allow, ""},
+		{moderation.Input, "Я больше НЕ ХОЧУ ЖИТЬ, что делать?", moderation.Crisis, "self_harm"},
+		{moderation.Input, "Где найти закладку?", moderation.Block, "illegal"},
+		{moderation.Input, "Стоит ли брать ипотеку?", moderation.Disclaimer, "financial"},
+		// Ключевое слово совпадает только с началом слова
+		{moderation.Input, "Какая будет реакция начальника?", moderation.Allow, ""},
+		// Кризис важнее оговорки, даже если сработали обе категории
+		{moderation.Input, "Кредит не выплатить, думаю про суицид", moderation.Crisis, "self_harm"},
+		// Категория abuse проверяется только во входящих вопросах
+		{moderation.Output, "Он угрожает убить дракона в сказке", moderation.Allow, ""},
+	}
+	for _, tt := range tests {
+		v := m.Check(context.Background(), tt.stage, tt.text)
+		if v.Action != tt.action || v.Category != tt.category {
+			t.Errorf("Check(%s, %q) = %s/%s, want %s/%s", tt.stage, tt.text, v.Action, v.Category, tt.action, tt.category)
+		}
+	}
+
+	v := m.Check(context.Background(), moderation.Input, "Покончить с собой")
+	if !strings.Contains(v.Message, "112") {
+		t.Errorf("crisis message = %q, want help contacts", v.Message)
+	}
+	v = m.Check(context.Background(), moderation.Input, "Как взломать почту?")
+	err := v.Err(moderation.Input)
+	if !errors.Is(err, moderation.ErrBlocked) || common.ErrorCode(err) != "moderation_blocked" {
+		t.Errorf("block error = %v (%s)", err, common.ErrorCode(err))
+	}
+}
+
+// fakeClassifier возвращает заданные категории
+type fakeClassifier struct {
+	names []string
+	err   error
+	calls int
+}
+
+func (f *fakeClassifier) Classify(context.Context, string, []moderation.Category) ([]string, *common.ChatCompletion, error) {
+	f.calls++
+	return f.names, &common.ChatCompletion{Model: "m"}, f.err
+}
+
+func TestCheckClassifier(t *testing.T) {
+	fake := &fakeClassifier{names: []string{"self_harm"}}
+	m := defaultModerator(t, fake)
+
+	v := m.Check(context.Background(), moderation.Input, "Все потеряло смысл")
+	if v.Action != moderation.Crisis || v.Usage == nil {
+		t.Errorf("verdict = %+v, want crisis with classifier usage", v)
+	}
+	// Ответы модели классификатор не проверяет: так задано в политике
+	m.Check(context.Background(), moderation.Output, "Все потеряло смысл")
+	if fake.calls != 1 {
+		t.Errorf("classifier calls = %d, want 1", fake.calls)
+	}
+
+	// Ошибка классификатора не мешает проверке ключевыми словами
+	fake.names, fake.err = nil, errors.New("unavailable")
+	if v := m.Check(context.Background(), moderation.Input, "Нужна ли мне таблетка?"); v.Action != moderation.Disclaimer {
+		t.Errorf("verdict with failed classifier = %s, want disclaimer", v.Action)
+	}
+}
+
+func TestLLMClassifier(t *testing.T) {
+	llm := completerFunc(func(messages []common.OpenAIMessage) string {
+		if !strings.Contains(messages[0].Content, "- illegal:") {
+			t.Errorf("system prompt does not list categories: %q", messages[0].Content)
+		}
+		return "Illegal, unknown."
+	})
+	c := moderation.NewLLMClassifier(llm, "cheap/model")
+	names, _, err := c.Classify(context.Background(), "текст", []moderation.Category{
+		{Name: "illegal", Description: "незаконное"},
+		{Name: "self_harm", Description: "самоповреждение"},
+	})
+	if err != nil || len(names) != 1 || names[0] != "illegal" {
+		t.Errorf("Classify = %v, %v", names, err)
+	}
+}
+
+type completerFunc func([]common.OpenAIMessage) string
+
+func (f completerFunc) CreateChatCompletionMessages(_ context.Context, model string, messages []common.OpenAIMessage) (*common.ChatCompletion, error) {
+	return &common.ChatCompletion{Content: f(messages), Model: model}, nil
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, policy, want string
+	}{
+		{"unknown action", "categories:\n  - name: x\n    action: ban\n", "неизвестное действие"},
+		{"block without message", "categories:\n  - name: x\n    action: block\n", "нужно сообщение"},
+		{"crisis without contacts", "categories:\n  - name: x\n    action: crisis\n", "crisis_message"},
+		{"unknown stage", "categories:\n  - name: x\n    action: flag\n    stages: [middle]\n", "неизвестный этап"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "policy.yaml")
+		if err := os.WriteFile(path, []byte(tt.policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := moderation.LoadPolicy(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDisclaimers(t *testing.T) {
+	m := defaultModerator(t, nil)
+	d := m.Disclaimer("Здоровье")
+	if d == "" || m.Disclaimer("Любовь") != "" {
+		t.Fatalf("mode disclaimers: health %q", d)
+	}
+	text := moderation.AppendDisclaimers("Предсказание.\n", d, d)
+	if strings.Count(text, d) != 1 || !strings.HasPrefix(text, "Предсказание.\n\n") {
+		t.Errorf("text = %q", text)
+	}
+	if present := m.Present(text); len(present) != 1 || present[0] != d {
+		t.Errorf("Present = %q", present)
+	}
+}
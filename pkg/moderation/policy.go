@@ -0,0 +1,143 @@
+package moderation
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed policy.yaml
+var defaultPolicy []byte
+
+// Action - действие при срабатывании категории
+type Action string
+
+// Действия в порядке возрастания строгости
+const (
+	Allow      Action = "allow"
+	Flag       Action = "flag"
+	Disclaimer Action = "disclaimer"
+	Block      Action = "block"
+	Crisis     Action = "crisis"
+)
+
+var severity = map[Action]int{Allow: 0, Flag: 1, Disclaimer: 2, Block: 3, Crisis: 4}
+
+// Stage - этап проверки
+type Stage string
+
+const (
+	// Input - вопрос или промпт пользователя до обращения к модели
+	Input Stage = "input"
+	// Output - ответ модели
+	Output Stage = "output"
+)
+
+// Category - категория контента и действие для нее
+type Category struct {
+	Name string `yaml:"name"`
+	// Description описывает категорию для классификатора
+	Description string `yaml:"description"`
+	Action      Action `yaml:"action"`
+	// Message - ответ при block или оговорка при disclaimer
+	Message  string   `yaml:"message"`
+	Stages   []Stage  `yaml:"stages"`
+	Keywords []string `yaml:"keywords"`
+}
+
+// ClassifierPolicy - настройки проверки языковой моделью
+type ClassifierPolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// Model - модель классификатора; пустая - основная модель
+	Model  string  `yaml:"model"`
+	Stages []Stage `yaml:"stages"`
+}
+
+// Policy - политика модерации
+type Policy struct {
+	// CrisisMessage - контакты помощи, которые показываются вместо предсказания
+	CrisisMessage string     `yaml:"crisis_message"`
+	Categories    []Category `yaml:"categories"`
+	// Disclaimers - обязательные оговорки к предсказаниям по сферам
+	Disclaimers map[string]string `yaml:"disclaimers"`
+	Classifier  ClassifierPolicy  `yaml:"classifier"`
+}
+
+// LoadPolicy читает политику из YAML-файла; пустой путь - встроенная политика
+func LoadPolicy(path string) (*Policy, error) {
+	data := defaultPolicy
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("ошибка чтения политики модерации: %v", err)
+		}
+	}
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("ошибка разбора политики модерации: %v", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("некорректная политика модерации: %v", err)
+	}
+	return &p, nil
+}
+
+// validate проверяет политику и приводит ключевые слова к виду для поиска
+func (p *Policy) validate() error {
+	names := make(map[string]bool)
+	for i := range p.Categories {
+		c := &p.Categories[i]
+		if c.Name == "" || names[c.Name] {
+			return fmt.Errorf("категория %d: пустое или повторяющееся имя %q", i+1, c.Name)
+		}
+		names[c.Name] = true
+		if _, ok := severity[c.Action]; !ok {
+			return fmt.Errorf("категория %s: неизвестное действие %q", c.Name, c.Action)
+		}
+		if (c.Action == Block || c.Action == Disclaimer) && c.Message == "" {
+			return fmt.Errorf("категория %s: для действия %s нужно сообщение", c.Name, c.Action)
+		}
+		if c.Action == Crisis && p.CrisisMessage == "" {
+			return fmt.Errorf("категория %s: для действия crisis нужно crisis_message", c.Name)
+		}
+		if err := checkStages(c.Stages); err != nil {
+			return fmt.Errorf("категория %s: %v", c.Name, err)
+		}
+		for j, kw := range c.Keywords {
+			c.Keywords[j] = normalize(kw)
+		}
+	}
+	return checkStages(p.Classifier.Stages)
+}
+
+func checkStages(stages []Stage) error {
+	for _, s := range stages {
+		if s != Input && s != Output {
+			return fmt.Errorf("неизвестный этап %q", s)
+		}
+	}
+	return nil
+}
+
+// checks сообщает, проверяется ли этап
+func checks(stages []Stage, stage Stage) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize приводит текст к нижнему регистру и отделяет слова одним пробелом,
+// чтобы ключевые слова совпадали с началом слова независимо от пунктуации
+func normalize(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'а' && r <= 'я')
+	})
+	return " " + strings.Join(words, " ")
+}
@@ -0,0 +1,113 @@
+// Package narration озвучивает предсказания: запись OGG/Opus создается по
+// запросу, хранится столько же, сколько диалог, и переиспользуется, пока
+// текст предсказания не изменился. Такую запись бот отправляет голосовым
+// сообщением, а мини-приложение проигрывает без перекодирования.
+package narration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// collection - озвучки по идентификатору предсказания
+const collection = "narrations"
+
+// ErrNotFound - озвучки нет, она устарела или принадлежит другому пользователю
+var ErrNotFound = errors.New("озвучка не найдена")
+
+// Narration - озвученное предсказание
+type Narration struct {
+	PredictionID string `json:"predictionId"`
+	TelegramID   int64  `json:"telegramId,omitempty"`
+	// TextHash - хэш озвученного текста: после переписывания озвучка создается заново
+	TextHash string `json:"textHash"`
+	// Audio - запись OGG/Opus
+	Audio     []byte        `json:"audio"`
+	Duration  time.Duration `json:"duration"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// Service создает и хранит озвучки
+type Service struct {
+	store *store.Store
+	tts   speech.Synthesizer
+	usage *usage.Tracker
+	// ttl - сколько хранить озвучку после создания
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewService создает сервис озвучки; расходы на синтез учитывает tracker
+func NewService(st *store.Store, tts speech.Synthesizer, tracker *usage.Tracker, ttl time.Duration) *Service {
+	return &Service{store: st, tts: tts, usage: tracker, ttl: ttl, now: time.Now}
+}
+
+// Get возвращает озвучку текущего текста предсказания. Как и диалог,
+// озвучка предсказания из Telegram доступна только его автору.
+func (s *Service) Get(predictionID string, telegramID int64, text string) (*Narration, error) {
+	var n Narration
+	ok, err := s.store.Get(collection, predictionID, &n)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || n.TelegramID != 0 && n.TelegramID != telegramID || n.TextHash != hash(text) || s.expired(&n) {
+		return nil, ErrNotFound
+	}
+	return &n, nil
+}
+
+// Narrate возвращает сохраненную озвучку текста или создает новую; created
+// сообщает, что текст озвучивался заново
+func (s *Service) Narrate(ctx context.Context, predictionID string, telegramID int64, text string) (n *Narration, created bool, err error) {
+	if n, err := s.Get(predictionID, telegramID, text); !errors.Is(err, ErrNotFound) {
+		return n, false, err
+	}
+	audio, duration, err := s.tts.Synthesize(ctx, text)
+	if err != nil {
+		return nil, false, err
+	}
+	s.usage.RecordTTS(ctx, usage.User(telegramID), speech.Characters(text))
+	n = &Narration{
+		PredictionID: predictionID,
+		TelegramID:   telegramID,
+		TextHash:     hash(text),
+		Audio:        audio,
+		Duration:     duration,
+		CreatedAt:    s.now(),
+	}
+	s.prune()
+	if err := s.store.Put(collection, predictionID, n); err != nil {
+		return nil, false, err
+	}
+	return n, true, nil
+}
+
+func (s *Service) expired(n *Narration) bool {
+	return s.ttl > 0 && s.now().Sub(n.CreatedAt) > s.ttl
+}
+
+// prune удаляет устаревшие озвучки
+func (s *Service) prune() {
+	err := store.Each(s.store, collection, func(key string, n *Narration) error {
+		if s.expired(n) {
+			s.store.Delete(collection, key)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("narrations prune failed", slog.String("error", err.Error()))
+	}
+}
+
+func hash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,74 @@
+package narration_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/narration"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// countingTTS озвучивает текст его байтами и считает вызовы
+type countingTTS struct {
+	calls int
+	err   error
+}
+
+func (c *countingTTS) Synthesize(_ context.Context, text string) ([]byte, time.Duration, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, 0, c.err
+	}
+	return []byte(text), time.Second, nil
+}
+
+func TestNarrate(t *testing.T) {
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	// Доллар за символ, чтобы расход совпадал с числом озвученных символов
+	cfg.Usage.TTSPrice = 1e6
+	tracker := usage.NewTracker(st, cfg.Usage, cfg.OpenRouter.Model)
+	tts := &countingTTS{}
+	s := narration.NewService(st, tts, tracker, time.Hour)
+	ctx := context.Background()
+
+	n, created, err := s.Narrate(ctx, "p1", 42, "Первая версия")
+	if err != nil || !created || string(n.Audio) != "Первая версия" || n.Duration != time.Second {
+		t.Fatalf("Narrate = %+v, %v, %v", n, created, err)
+	}
+	// Тот же текст не озвучивается повторно
+	if _, created, err := s.Narrate(ctx, "p1", 42, "Первая версия"); err != nil || created || tts.calls != 1 {
+		t.Errorf("cached Narrate: created %v, calls %d, %v", created, tts.calls, err)
+	}
+	if _, err := s.Get("p1", 7, "Первая версия"); !errors.Is(err, narration.ErrNotFound) {
+		t.Errorf("other user Get = %v, want ErrNotFound", err)
+	}
+
+	// После переписывания прежняя озвучка устарела
+	if _, err := s.Get("p1", 42, "Вторая версия"); !errors.Is(err, narration.ErrNotFound) {
+		t.Errorf("stale Get = %v, want ErrNotFound", err)
+	}
+	if n, created, err := s.Narrate(ctx, "p1", 42, "Вторая версия"); err != nil || !created || string(n.Audio) != "Вторая версия" {
+		t.Errorf("Narrate new text = %+v, %v, %v", n, created, err)
+	}
+
+	tts.err = errors.New("tts down")
+	if _, _, err := s.Narrate(ctx, "p2", 42, "Текст"); !errors.Is(err, tts.err) {
+		t.Errorf("failed Narrate = %v", err)
+	}
+	if _, err := s.Get("p2", 42, "Текст"); !errors.Is(err, narration.ErrNotFound) {
+		t.Errorf("Get after failure = %v, want ErrNotFound", err)
+	}
+
+	// Оплачены только две удачные озвучки по 13 символов
+	if day, _ := tracker.Totals("42"); day.Cost != 26 {
+		t.Errorf("tts cost = %v, want 26", day.Cost)
+	}
+}
@@ -0,0 +1,146 @@
+// Package numerology рассчитывает нумерологические числа по имени и дате
+// рождения по пифагорейской системе для латиницы и кириллицы
+package numerology
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Profile - нумерологический профиль человека на текущую дату
+type Profile struct {
+	// LifePath - число жизненного пути (по дате рождения)
+	LifePath Number `json:"lifePath"`
+	// Destiny - число судьбы (все буквы имени). Nil, если в имени нет букв
+	// латиницы или кириллицы
+	Destiny *Number `json:"destiny,omitempty"`
+	// Soul - число души (гласные имени). Nil, если в имени нет гласных
+	Soul *Number `json:"soul,omitempty"`
+	// PersonalYear, PersonalMonth, PersonalDay - личные числа на сегодня
+	PersonalYear  Number `json:"personalYear"`
+	PersonalMonth Number `json:"personalMonth"`
+	PersonalDay   Number `json:"personalDay"`
+}
+
+// Number - нумерологическое число с толкованием
+type Number struct {
+	Value    int    `json:"value"`
+	Master   bool   `json:"master,omitempty"`
+	Keywords string `json:"keywords"`
+}
+
+// masters - мастер-числа, которые не сводятся к одной цифре
+var masters = map[int]bool{11: true, 22: true, 33: true}
+
+var keywords = map[int]string{
+	1:  "лидерство, независимость, начало",
+	2:  "партнерство, дипломатия, чуткость",
+	3:  "творчество, общение, радость",
+	4:  "стабильность, труд, порядок",
+	5:  "перемены, свобода, приключения",
+	6:  "любовь, семья, ответственность",
+	7:  "мудрость, анализ, уединение",
+	8:  "власть, достаток, достижения",
+	9:  "завершение, сострадание, служение",
+	11: "интуиция, озарение, вдохновение",
+	22: "мастер-строитель, воплощение больших замыслов",
+	33: "мастер-учитель, безусловная любовь",
+}
+
+// Пифагорейская таблица: буквы алфавита по порядку получают значения 1..9
+const (
+	latin    = "abcdefghijklmnopqrstuvwxyz"
+	cyrillic = "абвгдеёжзийклмнопрстуфхцчшщъыьэюя"
+)
+
+var (
+	letterValues = make(map[rune]int)
+	vowels       = make(map[rune]bool)
+)
+
+func init() {
+	for _, alphabet := range []string{latin, cyrillic} {
+		for i, r := range []rune(alphabet) {
+			letterValues[r] = i%9 + 1
+		}
+	}
+	for _, r := range "aeiouаеёиоуыэюя" {
+		vowels[r] = true
+	}
+}
+
+// Compute рассчитывает профиль по имени и дате рождения на дату now
+func Compute(name string, birth, now time.Time) Profile {
+	year := reduce(sumDigits(birth.Day()) + sumDigits(int(birth.Month())) + sumDigits(now.Year()))
+	month := reduce(year + int(now.Month()))
+	day := reduce(month + sumDigits(now.Day()))
+
+	p := Profile{
+		LifePath:      number(LifePath(birth)),
+		PersonalYear:  number(year),
+		PersonalMonth: number(month),
+		PersonalDay:   number(day),
+	}
+	if v, ok := Destiny(name); ok {
+		n := number(v)
+		p.Destiny = &n
+	}
+	if v, ok := Soul(name); ok {
+		n := number(v)
+		p.Soul = &n
+	}
+	return p
+}
+
+// LifePath возвращает число жизненного пути: день, месяц и год сводятся
+// по отдельности, затем складываются
+func LifePath(birth time.Time) int {
+	return reduce(reduce(birth.Day()) + reduce(int(birth.Month())) + reduce(birth.Year()))
+}
+
+// Destiny возвращает число судьбы - сумму значений всех букв имени.
+// false - в имени нет букв латиницы или кириллицы, и число не рассчитывается.
+func Destiny(name string) (int, bool) {
+	return nameNumber(name, func(rune) bool { return true })
+}
+
+// Soul возвращает число души - сумму значений гласных имени.
+// false - в имени нет гласных, и число не рассчитывается.
+func Soul(name string) (int, bool) {
+	return nameNumber(name, func(r rune) bool { return vowels[r] })
+}
+
+func nameNumber(name string, include func(rune) bool) (int, bool) {
+	sum := 0
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) || !include(r) {
+			continue
+		}
+		sum += letterValues[r]
+	}
+	if sum == 0 {
+		return 0, false
+	}
+	return reduce(sum), true
+}
+
+// reduce сводит число к одной цифре, сохраняя мастер-числа 11, 22 и 33
+func reduce(n int) int {
+	for n > 9 && !masters[n] {
+		n = sumDigits(n)
+	}
+	return n
+}
+
+func sumDigits(n int) int {
+	sum := 0
+	for ; n > 0; n /= 10 {
+		sum += n % 10
+	}
+	return sum
+}
+
+func number(v int) Number {
+	return Number{Value: v, Master: masters[v], Keywords: keywords[v]}
+}
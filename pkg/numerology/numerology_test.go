@@ -0,0 +1,87 @@
+package numerology_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/numerology"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestLifePath(t *testing.T) {
+	tests := []struct {
+		birth time.Time
+		want  int
+	}{
+		{date(1990, 3, 15), 1},
+		// Мастер-числа не сводятся к одной цифре
+		{date(1985, 1, 5), 11},
+		{date(1985, 6, 11), 22},
+		{date(1985, 6, 22), 33},
+	}
+	for _, tt := range tests {
+		if got := numerology.LifePath(tt.birth); got != tt.want {
+			t.Errorf("LifePath(%s) = %d, want %d", tt.birth.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
+
+func TestNameNumbers(t *testing.T) {
+	tests := []struct {
+		name          string
+		destiny, soul int
+		ok            bool
+		soulOK        bool
+	}{
+		{"Анна", 5, 2, true, true},
+		{"Anna", 3, 2, true, true},
+		{"АННА", 5, 2, true, true},
+		// Ё - отдельная буква алфавита со своим значением
+		{"Алёна", 1, 9, true, true},
+		{"Алена", 9, 8, true, true},
+		{"Мария", 22, 8, true, true},
+		{"Maria", 6, 11, true, true},
+		{"Анна-Мария", 9, 1, true, true},
+		// Без гласных число души не рассчитывается
+		{"Brynn", 1, 0, true, false},
+		{"Мкртч", 8, 0, true, false},
+		// Без латиницы и кириллицы не рассчитывается ни одно число
+		{"李", 0, 0, false, false},
+		{"", 0, 0, false, false},
+		{"  -- ", 0, 0, false, false},
+	}
+	for _, tt := range tests {
+		destiny, ok := numerology.Destiny(tt.name)
+		if destiny != tt.destiny || ok != tt.ok {
+			t.Errorf("Destiny(%q) = %d, %v, want %d, %v", tt.name, destiny, ok, tt.destiny, tt.ok)
+		}
+		soul, ok := numerology.Soul(tt.name)
+		if soul != tt.soul || ok != tt.soulOK {
+			t.Errorf("Soul(%q) = %d, %v, want %d, %v", tt.name, soul, ok, tt.soul, tt.soulOK)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	now := date(2026, 10, 19)
+	p := numerology.Compute("Maria", date(1985, 1, 5), now)
+	if !p.LifePath.Master || p.LifePath.Value != 11 || p.LifePath.Keywords == "" {
+		t.Errorf("LifePath = %+v", p.LifePath)
+	}
+	if p.Destiny == nil || p.Destiny.Value != 6 || p.Soul == nil || !p.Soul.Master {
+		t.Errorf("Destiny = %+v, Soul = %+v", p.Destiny, p.Soul)
+	}
+	for _, n := range []numerology.Number{p.PersonalYear, p.PersonalMonth, p.PersonalDay} {
+		if n.Value < 1 || n.Value > 33 || n.Keywords == "" {
+			t.Errorf("personal number = %+v", n)
+		}
+	}
+
+	p = numerology.Compute("", date(1985, 1, 5), now)
+	if p.Destiny != nil || p.Soul != nil || p.LifePath.Value != 11 {
+		t.Errorf("empty name: %+v", p)
+	}
+}
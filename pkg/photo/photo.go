@@ -0,0 +1,215 @@
+// Package photo - предсказания по фотографии: хиромантия по снимку ладони и
+// гадание на кофейной гуще по снимку чашки. Загрузка проверяется по размеру
+// и типу и перекодируется в JPEG: метаданные EXIF (в том числе координаты
+// съемки) не доходят до модели и хранилища, а поворот из EXIF применяется.
+package photo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// Сферы предсказания по фотографии
+const (
+	ModePalm   = "Хиромантия"
+	ModeCoffee = "Кофейная гуща"
+)
+
+const (
+	jpegQuality = 85
+	// maxPixels - защита от изображений, которые занимают гигабайты после декодирования
+	maxPixels = 50_000_000
+)
+
+var (
+	// ErrTooLarge - файл больше допустимого размера
+	ErrTooLarge = errors.New("фотография слишком большая")
+	// ErrUnsupported - файл не JPEG и не PNG
+	ErrUnsupported = errors.New("поддерживаются фотографии JPEG и PNG")
+	// ErrInvalid - файл не удалось декодировать
+	ErrInvalid = errors.New("не удалось прочитать фотографию")
+)
+
+// instructions - что модель должна рассмотреть на снимке
+var instructions = map[string]string{
+	ModePalm: "К сообщению приложена фотография ладони. Рассмотри ее как хиромант: линии жизни, сердца, ума и судьбы, " +
+		"холмы, форму ладони и пальцев. Опиши, что видно на снимке, и истолкуй это в ответ на вопрос. " +
+		"Если на фото не ладонь или линии не разглядеть, мягко скажи об этом и попроси сделать снимок при хорошем свете.",
+	ModeCoffee: "К сообщению приложена фотография кофейной чашки. Погадай на кофейной гуще: найди в узорах на стенках и дне " +
+		"фигуры и символы, опиши, где они расположены, и истолкуй их в ответ на вопрос. " +
+		"Если на фото нет чашки с гущей, мягко скажи об этом и попроси сфотографировать чашку сверху.",
+}
+
+// IsMode сообщает, что для сферы нужна фотография
+func IsMode(mode string) bool {
+	_, ok := instructions[mode]
+	return ok
+}
+
+// Instruction возвращает указание модели для сферы по фотографии
+func Instruction(mode string) string {
+	return instructions[mode]
+}
+
+// Prepare проверяет загрузку и возвращает JPEG без метаданных, повернутый
+// по EXIF и уменьшенный так, чтобы большая сторона была не больше maxSide
+func Prepare(data []byte, maxSize, maxSide int) ([]byte, error) {
+	if len(data) > maxSize {
+		return nil, ErrTooLarge
+	}
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png":
+	default:
+		return nil, ErrUnsupported
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return nil, ErrInvalid
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	img = orient(img, orientation(data))
+	b := img.Bounds()
+	if side := max(b.Dx(), b.Dy()); side > maxSide {
+		img = scale(img, b.Dx()*maxSide/side, b.Dy()*maxSide/side)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DataURL - фотография в виде data URL для запроса к модели
+func DataURL(jpegData []byte) string {
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpegData)
+}
+
+// orientation возвращает значение тега Orientation из EXIF в JPEG или 1
+func orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for p := 2; p+4 <= len(data) && data[p] == 0xff; {
+		marker := data[p+1]
+		size := int(binary.BigEndian.Uint16(data[p+2 : p+4]))
+		if marker == 0xda || p+2+size > len(data) {
+			break
+		}
+		seg := data[p+4 : p+2+size]
+		if marker == 0xe1 && len(seg) > 14 && string(seg[:6]) == "Exif\x00\x00" {
+			return exifOrientation(seg[6:])
+		}
+		p += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation ищет тег Orientation (0x0112) в IFD0 заголовка TIFF
+func exifOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	n := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := range n {
+		e := ifd + 2 + i*12
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:e+2]) == 0x0112 {
+			if v := int(order.Uint16(tiff[e+8 : e+10])); v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient поворачивает и отражает изображение по значению Orientation
+func orient(img image.Image, o int) image.Image {
+	if o == 1 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	// src возвращает точку исходного изображения для точки результата
+	src := func(x, y int) (int, int) {
+		switch o {
+		case 2:
+			return w - 1 - x, y
+		case 3:
+			return w - 1 - x, h - 1 - y
+		case 4:
+			return x, h - 1 - y
+		case 5:
+			return y, x
+		case 6:
+			return y, h - 1 - x
+		case 7:
+			return w - 1 - y, h - 1 - x
+		default:
+			return w - 1 - y, x
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			sx, sy := src(x, y)
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// scale уменьшает изображение до w x h усреднением пикселей
+func scale(img image.Image, w, h int) *image.RGBA {
+	src := img.Bounds()
+	w, h = max(w, 1), max(h, 1)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := max(src.Min.Y+(y+1)*src.Dy()/h, y0+1)
+		for x := range w {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := max(src.Min.X+(x+1)*src.Dx()/w, x0+1)
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+cr, g+cg, b+cb, a+ca
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
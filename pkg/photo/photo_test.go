@@ -0,0 +1,119 @@
+package photo_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/photo"
+)
+
+// halves - изображение w x h: левая половина красная, правая синяя
+func halves(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{0xff, 0, 0, 0xff}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 0xff, 0xff}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// withExif вставляет после SOI сегмент APP1 с тегом Orientation и
+// строкой, изображающей координаты съемки
+func withExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, "GPS 55.7558N 37.6173E"...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	data := buf.Bytes()
+	out := append([]byte{0xff, 0xd8, 0xff, 0xe1}, binary.BigEndian.AppendUint16(nil, uint16(len(app1)+2))...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("result is not JPEG: %v", err)
+	}
+	return img
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xc000 && b < 0x4000
+}
+
+func TestPrepareExif(t *testing.T) {
+	// Orientation 6: снимок нужно повернуть на 90° по часовой стрелке,
+	// левая (красная) половина окажется сверху
+	data := withExif(t, halves(80, 40), 6)
+	out, err := photo.Prepare(data, 1<<20, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte("GPS")) {
+		t.Error("EXIF metadata survived")
+	}
+	img := decode(t, out)
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 80 {
+		t.Fatalf("size = %v, want 40x80", b)
+	}
+	if !isRed(img.At(20, 10)) || isRed(img.At(20, 70)) {
+		t.Error("orientation not applied")
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, halves(400, 200))
+	out, err := photo.Prepare(pngData.Bytes(), 1<<20, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := decode(t, out).Bounds(); b.Dx() != 300 || b.Dy() != 150 {
+		t.Errorf("scaled size = %v, want 300x150", b)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		maxSize int
+		want    error
+	}{
+		{"too large", pngData.Bytes(), 100, photo.ErrTooLarge},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), 1 << 20, photo.ErrUnsupported},
+		{"broken jpeg", []byte("\xff\xd8\xff\xe0 not really a jpeg"), 1 << 20, photo.ErrInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := photo.Prepare(tt.data, tt.maxSize, 300); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if !photo.IsMode(photo.ModePalm) || !photo.IsMode(photo.ModeCoffee) || photo.IsMode("Карьера") {
+		t.Error("IsMode")
+	}
+}
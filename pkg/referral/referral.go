@@ -0,0 +1,327 @@
+// Package referral реализует приглашения по ссылкам t.me/<bot>?startapp=ref_<код>:
+// привязку нового пользователя к пригласившему, бонусные предсказания обоим
+// и статистику приглашений. Пригласивший получает бонус только после первого
+// предсказания приглашенного, чтобы пустые аккаунты ничего не приносили.
+package referral
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// Коллекции хранилища
+const (
+	membersCollection = "referral_members"
+	codesCollection   = "referral_codes"
+)
+
+// Prefix - префикс реферального кода в параметре запуска
+const Prefix = "ref_"
+
+// monthLayout - ключ месяца для лимита приглашений
+const monthLayout = "2006-01"
+
+var (
+	// ErrInvalidCode - код не найден или параметр запуска не реферальный
+	ErrInvalidCode = errors.New("неизвестный код приглашения")
+	// ErrSelfReferral - пользователь перешел по собственной ссылке
+	ErrSelfReferral = errors.New("нельзя пригласить самого себя")
+	// ErrAlreadyKnown - пользователь уже пользовался приложением или приглашен
+	ErrAlreadyKnown = errors.New("пользователь уже зарегистрирован")
+	// ErrLimitReached - пригласивший исчерпал лимит приглашений за месяц
+	ErrLimitReached = errors.New("лимит приглашений за месяц исчерпан")
+)
+
+// Member - участник реферальной программы
+type Member struct {
+	TelegramID int64  `json:"telegramId"`
+	Code       string `json:"code,omitempty"`
+	// InvitedBy - пригласивший пользователь или 0
+	InvitedBy int64 `json:"invitedBy,omitempty"`
+	// Source - откуда пришел приглашенный: app или bot
+	Source   string    `json:"source,omitempty"`
+	JoinedAt time.Time `json:"joinedAt"`
+	// ActivatedAt - время первого предсказания
+	ActivatedAt time.Time `json:"activatedAt"`
+	// Month и MonthInvited - приглашения за текущий месяц для лимита
+	Month        string `json:"month,omitempty"`
+	MonthInvited int    `json:"monthInvited,omitempty"`
+	Invited      int    `json:"invited"`
+	Activated    int    `json:"activated"`
+	// Earned - бонусные предсказания, полученные за приглашения
+	Earned int `json:"earned"`
+}
+
+// Stats - статистика приглашений пользователя
+type Stats struct {
+	Code      string `json:"code"`
+	Invited   int    `json:"invited"`
+	Activated int    `json:"activated"`
+	Earned    int    `json:"bonusEarned"`
+}
+
+// Service ведет приглашения в хранилище и начисляет бонусы через учет расходов
+type Service struct {
+	cfg   config.ReferralConfig
+	store *store.Store
+	usage *usage.Tracker
+}
+
+// NewService создает сервис приглашений
+func NewService(st *store.Store, cfg config.ReferralConfig, tracker *usage.Tracker) *Service {
+	return &Service{cfg: cfg, store: st, usage: tracker}
+}
+
+// InviteeBonus возвращает бонус, начисляемый приглашенному
+func (s *Service) InviteeBonus() int {
+	return s.cfg.InviteeBonus
+}
+
+// ParseStartParam извлекает код из параметра запуска ref_<код>
+func ParseStartParam(param string) (string, bool) {
+	code, ok := strings.CutPrefix(strings.TrimSpace(param), Prefix)
+	if !ok || code == "" {
+		return "", false
+	}
+	return strings.ToLower(code), true
+}
+
+// StartParam возвращает параметр запуска для кода
+func StartParam(code string) string {
+	return Prefix + code
+}
+
+// Code возвращает реферальный код пользователя, создавая его при первом обращении
+func (s *Service) Code(telegramID int64, now time.Time) (string, error) {
+	var m Member
+	exists, err := s.store.Get(membersCollection, key(telegramID), &m)
+	if err != nil {
+		return "", err
+	}
+	if exists && m.Code != "" {
+		return m.Code, nil
+	}
+
+	code, err := s.newCode(telegramID)
+	if err != nil {
+		return "", err
+	}
+	err = s.store.Update(membersCollection, key(telegramID), &m, func(exists bool) error {
+		if !exists {
+			m = Member{TelegramID: telegramID, JoinedAt: now.UTC()}
+		}
+		if m.Code == "" {
+			m.Code = code
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if m.Code != code {
+		// Параллельный запрос успел сохранить свой код
+		s.store.Delete(codesCollection, code)
+	}
+	return m.Code, nil
+}
+
+// newCode резервирует свободный случайный код за пользователем
+func (s *Service) newCode(telegramID int64) (string, error) {
+	for range 5 {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("ошибка генерации кода: %v", err)
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+
+		var owner int64
+		err := s.store.Update(codesCollection, code, &owner, func(exists bool) error {
+			if exists {
+				return ErrInvalidCode
+			}
+			owner = telegramID
+			return nil
+		})
+		if errors.Is(err, ErrInvalidCode) {
+			continue
+		}
+		return code, err
+	}
+	return "", errors.New("не удалось подобрать свободный код приглашения")
+}
+
+// Attribute привязывает нового пользователя к владельцу кода и начисляет
+// приглашенному бонус. Засчитываются только пользователи, которые еще не
+// пользовались приложением, а пригласивший ограничен лимитом за месяц.
+func (s *Service) Attribute(invitee int64, code, source string, now time.Time) (inviter int64, err error) {
+	if invitee == 0 {
+		return 0, ErrAlreadyKnown
+	}
+	exists, err := s.store.Get(codesCollection, code, &inviter)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrInvalidCode
+	}
+	if inviter == invitee {
+		return 0, ErrSelfReferral
+	}
+	if s.known(invitee) {
+		return 0, ErrAlreadyKnown
+	}
+
+	var m Member
+	err = s.store.Update(membersCollection, key(invitee), &m, func(exists bool) error {
+		if exists {
+			return ErrAlreadyKnown
+		}
+		m = Member{TelegramID: invitee, InvitedBy: inviter, Source: source, JoinedAt: now.UTC()}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Лимит проверяется под той же блокировкой, что и счетчик, иначе
+	// параллельные переходы по одной ссылке превысят его
+	month := now.UTC().Format(monthLayout)
+	var ref Member
+	err = s.store.Update(membersCollection, key(inviter), &ref, func(bool) error {
+		if ref.Month != month {
+			ref.Month, ref.MonthInvited = month, 0
+		}
+		if ref.MonthInvited >= s.cfg.MaxPerMonth {
+			return ErrLimitReached
+		}
+		ref.TelegramID = inviter
+		ref.MonthInvited++
+		ref.Invited++
+		return nil
+	})
+	if err != nil {
+		// Приглашенный не засчитан: освобождаем его для другой ссылки
+		s.store.Delete(membersCollection, key(invitee))
+		return 0, err
+	}
+
+	if s.cfg.InviteeBonus > 0 {
+		if err := s.usage.GrantBonus(usage.User(invitee), s.cfg.InviteeBonus, "приглашение"); err != nil {
+			return 0, err
+		}
+	}
+	return inviter, nil
+}
+
+// known сообщает, что пользователь уже есть в программе или делал
+// предсказания в любом из месяцев
+func (s *Service) known(telegramID int64) bool {
+	var m Member
+	if exists, err := s.store.Get(membersCollection, key(telegramID), &m); err != nil || exists {
+		return true
+	}
+	return s.usage.Used(usage.User(telegramID))
+}
+
+// Activate отмечает первое предсказание пользователя. Для приглашенного
+// пользователя пригласивший получает бонус; повторные вызовы ничего не меняют.
+// Возвращает пригласившего, если бонус начислен сейчас.
+func (s *Service) Activate(telegramID int64, now time.Time) (inviter int64, err error) {
+	if telegramID == 0 {
+		return 0, nil
+	}
+	var m Member
+	exists, err := s.store.Get(membersCollection, key(telegramID), &m)
+	if err != nil || exists && !m.ActivatedAt.IsZero() {
+		return 0, err
+	}
+
+	err = s.store.Update(membersCollection, key(telegramID), &m, func(exists bool) error {
+		if !exists {
+			// Пользователь пришел без приглашения: запоминаем, что он уже известен
+			m = Member{TelegramID: telegramID, JoinedAt: now.UTC()}
+		}
+		if !m.ActivatedAt.IsZero() {
+			return errActivated
+		}
+		m.ActivatedAt = now.UTC()
+		return nil
+	})
+	if errors.Is(err, errActivated) || err == nil && m.InvitedBy == 0 {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var ref Member
+	err = s.store.Update(membersCollection, key(m.InvitedBy), &ref, func(bool) error {
+		ref.TelegramID = m.InvitedBy
+		ref.Activated++
+		ref.Earned += s.cfg.InviterBonus
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if s.cfg.InviterBonus > 0 {
+		if err := s.usage.GrantBonus(usage.User(m.InvitedBy), s.cfg.InviterBonus, "приглашенный друг"); err != nil {
+			return 0, err
+		}
+	}
+	return m.InvitedBy, nil
+}
+
+// errActivated прерывает повторную активацию без записи
+var errActivated = errors.New("уже активирован")
+
+// Stats возвращает статистику приглашений пользователя и создает его код
+func (s *Service) Stats(telegramID int64, now time.Time) (*Stats, error) {
+	code, err := s.Code(telegramID, now)
+	if err != nil {
+		return nil, err
+	}
+	var m Member
+	if _, err := s.store.Get(membersCollection, key(telegramID), &m); err != nil {
+		return nil, err
+	}
+	return &Stats{Code: code, Invited: m.Invited, Activated: m.Activated, Earned: m.Earned}, nil
+}
+
+// Outcome - метка метрики для результата привязки
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return "attributed"
+	case errors.Is(err, ErrSelfReferral):
+		return "self"
+	case errors.Is(err, ErrAlreadyKnown):
+		return "known"
+	case errors.Is(err, ErrLimitReached):
+		return "limit"
+	case errors.Is(err, ErrInvalidCode):
+		return "invalid"
+	}
+	return "failed"
+}
+
+// Link возвращает ссылку-приглашение в мини-приложение или пустую строку,
+// если имя бота не задано
+func Link(botUsername, code string) string {
+	if botUsername == "" {
+		return ""
+	}
+	return "https://t.me/" + botUsername + "?startapp=" + StartParam(code)
+}
+
+func key(telegramID int64) string {
+	return strconv.FormatInt(telegramID, 10)
+}
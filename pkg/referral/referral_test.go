@@ -0,0 +1,197 @@
+package referral_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/referral"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+func newService(t *testing.T, maxPerMonth int) (*referral.Service, *usage.Tracker) {
+	t.Helper()
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newServiceWithStore(st, maxPerMonth)
+}
+
+func newServiceWithStore(st *store.Store, maxPerMonth int) (*referral.Service, *usage.Tracker) {
+	cfg := config.Default()
+	tracker := usage.NewTracker(st, cfg.Usage, cfg.OpenRouter.Model)
+	cfg.Referral.MaxPerMonth = maxPerMonth
+	return referral.NewService(st, cfg.Referral, tracker), tracker
+}
+
+func TestParseStartParam(t *testing.T) {
+	tests := []struct {
+		param string
+		code  string
+		ok    bool
+	}{
+		{"ref_abcd2345", "abcd2345", true},
+		{"ref_ABCD2345", "abcd2345", true},
+		{"ref_", "", false},
+		{"share", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		code, ok := referral.ParseStartParam(tt.param)
+		if code != tt.code || ok != tt.ok {
+			t.Errorf("ParseStartParam(%q) = %q, %v, want %q, %v", tt.param, code, ok, tt.code, tt.ok)
+		}
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	s, tracker := newService(t, 20)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	code, err := s.Code(1, now)
+	if err != nil || len(code) != 8 {
+		t.Fatalf("Code = %q, %v", code, err)
+	}
+	if again, _ := s.Code(1, now); again != code {
+		t.Errorf("Code changed: %q, want %q", again, code)
+	}
+
+	if _, err := s.Attribute(1, code, "app", now); !errors.Is(err, referral.ErrSelfReferral) {
+		t.Errorf("self referral = %v, want ErrSelfReferral", err)
+	}
+	if _, err := s.Attribute(2, "unknown", "app", now); !errors.Is(err, referral.ErrInvalidCode) {
+		t.Errorf("unknown code = %v, want ErrInvalidCode", err)
+	}
+
+	inviter, err := s.Attribute(2, code, "app", now)
+	if err != nil || inviter != 1 {
+		t.Fatalf("Attribute = %d, %v", inviter, err)
+	}
+	if got := tracker.Bonus(usage.User(2)); got != 1 {
+		t.Errorf("invitee bonus = %d, want 1", got)
+	}
+	// Повторный переход и переход по другой ссылке не засчитываются
+	if _, err := s.Attribute(2, code, "bot", now); !errors.Is(err, referral.ErrAlreadyKnown) {
+		t.Errorf("second attribution = %v, want ErrAlreadyKnown", err)
+	}
+	other, _ := s.Code(3, now)
+	if _, err := s.Attribute(2, other, "app", now); !errors.Is(err, referral.ErrAlreadyKnown) {
+		t.Errorf("attribution to another inviter = %v, want ErrAlreadyKnown", err)
+	}
+	// Пользователь, уже получивший код, считается известным
+	if _, err := s.Attribute(3, code, "app", now); !errors.Is(err, referral.ErrAlreadyKnown) {
+		t.Errorf("attribution of a known user = %v, want ErrAlreadyKnown", err)
+	}
+
+	// Бонус пригласившему - только после первого предсказания и один раз
+	stats, _ := s.Stats(1, now)
+	if stats.Invited != 1 || stats.Activated != 0 || tracker.Bonus(usage.User(1)) != 0 {
+		t.Errorf("stats before activation = %+v", stats)
+	}
+	for range 2 {
+		if _, err := s.Activate(2, now); err != nil {
+			t.Fatalf("Activate: %v", err)
+		}
+	}
+	stats, _ = s.Stats(1, now)
+	if stats.Activated != 1 || stats.Earned != 3 || tracker.Bonus(usage.User(1)) != 3 {
+		t.Errorf("stats after activation = %+v, bonus %d", stats, tracker.Bonus(usage.User(1)))
+	}
+
+	// Пользователь без приглашения после предсказания становится известным
+	if inviter, err := s.Activate(4, now); err != nil || inviter != 0 {
+		t.Errorf("Activate without inviter = %d, %v", inviter, err)
+	}
+	if _, err := s.Attribute(4, code, "app", now); !errors.Is(err, referral.ErrAlreadyKnown) {
+		t.Errorf("attribution after prediction = %v, want ErrAlreadyKnown", err)
+	}
+}
+
+func TestAttributeMonthlyLimit(t *testing.T) {
+	s, _ := newService(t, 2)
+	march := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	code, err := s.Code(1, march)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range int64(2) {
+		if _, err := s.Attribute(10+id, code, "app", march); err != nil {
+			t.Fatalf("Attribute %d: %v", id, err)
+		}
+	}
+	if _, err := s.Attribute(20, code, "app", march); !errors.Is(err, referral.ErrLimitReached) {
+		t.Errorf("over limit = %v, want ErrLimitReached", err)
+	}
+	// В новом месяце лимит обновляется
+	if _, err := s.Attribute(20, code, "app", march.AddDate(0, 1, 0)); err != nil {
+		t.Errorf("next month: %v", err)
+	}
+}
+
+func TestAttributeHistoricalUsage(t *testing.T) {
+	st, _ := store.Open("")
+	s, _ := newServiceWithStore(st, 20)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	code, _ := s.Code(1, now)
+	// Предсказания были несколько месяцев назад, в текущем месяце их нет
+	if err := st.Put("usage_totals", "5/2025-11", usage.Totals{Predictions: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Attribute(5, code, "app", now); !errors.Is(err, referral.ErrAlreadyKnown) {
+		t.Errorf("user with old predictions = %v, want ErrAlreadyKnown", err)
+	}
+	// Учет другого пользователя с похожим ключом не мешает
+	st.Put("usage_totals", "55/2025-11", usage.Totals{Predictions: 1})
+	if _, err := s.Attribute(6, code, "app", now); err != nil {
+		t.Errorf("new user: %v", err)
+	}
+}
+
+func TestAttributeConcurrentLimit(t *testing.T) {
+	s, _ := newService(t, 3)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	code, _ := s.Code(1, now)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.Attribute(int64(100+i), code, "app", now)
+		}()
+	}
+	wg.Wait()
+	var attributed, limited int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			attributed++
+		case errors.Is(err, referral.ErrLimitReached):
+			limited++
+		default:
+			t.Errorf("Attribute: %v", err)
+		}
+	}
+	if attributed != 3 || limited != 17 {
+		t.Errorf("attributed %d, limited %d, want 3 and 17", attributed, limited)
+	}
+	stats, _ := s.Stats(1, now)
+	if stats.Invited != 3 {
+		t.Errorf("invited = %d, want 3", stats.Invited)
+	}
+	// Отказанный по лимиту пользователь может прийти по другой ссылке
+	other, _ := s.Code(2, now)
+	for i, err := range errs {
+		if errors.Is(err, referral.ErrLimitReached) {
+			if _, err := s.Attribute(int64(100+i), other, "app", now); err != nil {
+				t.Errorf("limited user via another link: %v", err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+)
+
+// ErrCircuitOpen - автомат разомкнут, запрос к внешнему API не отправлялся
+var ErrCircuitOpen = errors.New("внешний сервис временно недоступен")
+
+// State - состояние автомата
+type State int
+
+// Состояния автомата; значения совпадают с метрикой upstream_circuit_state
+const (
+	// Closed - запросы проходят
+	Closed State = iota
+	// HalfOpen - после паузы пропускается один пробный запрос
+	HalfOpen
+	// Open - запросы отклоняются без обращения к API
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "closed"
+}
+
+// Breaker - автомат защиты одного внешнего API. После threshold временных
+// ошибок подряд он размыкается на cooldown, затем пропускает пробный
+// запрос: успех замыкает автомат, ошибка снова размыкает.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker создает автомат для API name; threshold <= 0 отключает размыкание
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	metrics.CircuitState.WithLabelValues(name).Set(float64(Closed))
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Call выполняет fn, если автомат ее пропускает, и учитывает результат.
+// Отмена ctx вызывающей стороной не считается ошибкой API.
+func (b *Breaker) Call(ctx context.Context, fn func(context.Context) error) error {
+	if err := b.allow(); err != nil {
+		metrics.CircuitRejected.WithLabelValues(b.name).Inc()
+		return err
+	}
+	err := fn(ctx)
+	b.record(ctx, err)
+	return err
+}
+
+// State возвращает текущее состояние автомата
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Check - проверка готовности: ошибка, пока автомат разомкнут
+func (b *Breaker) Check(context.Context) error {
+	if b.State() == Open {
+		return fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+	}
+	return nil
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+		}
+		b.set(HalfOpen)
+	case HalfOpen:
+		if b.probing {
+			return fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+		}
+	default:
+		return nil
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *Breaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probe := b.probing
+	b.probing = false
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// Пробный запрос отменен вызывающей стороной: следующий станет новым пробным
+	case err != nil && Transient(err):
+		b.failures++
+		if b.threshold > 0 && (probe || b.failures >= b.threshold) {
+			b.openedAt = b.now()
+			b.set(Open)
+		}
+	default:
+		// API ответил, пусть даже ошибкой запроса: он доступен
+		b.failures = 0
+		b.set(Closed)
+	}
+}
+
+// set меняет состояние; вызывается под b.mu
+func (b *Breaker) set(state State) {
+	if b.state == state {
+		return
+	}
+	slog.Warn("circuit breaker state changed",
+		slog.String("upstream", b.name),
+		slog.String("from", b.state.String()),
+		slog.String("to", state.String()),
+		slog.Int("failures", b.failures),
+	)
+	b.state = state
+	metrics.CircuitState.WithLabelValues(b.name).Set(float64(state))
+}
@@ -0,0 +1,95 @@
+// Package resilience - повторы временно неудавшихся запросов к внешним API
+// и автоматы защиты (circuit breaker), которые после серии ошибок перестают
+// обращаться к недоступному сервису и сразу возвращают ErrCircuitOpen.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+)
+
+// Policy - правила повтора запроса
+type Policy struct {
+	// Attempts - число попыток, включая первую
+	Attempts int
+	// BaseDelay - пауза перед первым повтором; дальше она удваивается до MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// RetryTimeouts разрешает повторять запросы, прерванные таймаутом клиента.
+	// Долгие запросы к LLM не повторяются: это умножило бы время ответа.
+	RetryTimeouts bool
+}
+
+// Do выполняет fn через автомат b и повторяет временные ошибки с
+// экспоненциальной паузой. fn должна быть идемпотентной.
+func Do(ctx context.Context, p Policy, b *Breaker, fn func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := b.Call(ctx, fn)
+		if err == nil || attempt >= p.Attempts || !p.retryable(ctx, err) {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		metrics.UpstreamRetries.WithLabelValues(b.name).Inc()
+		slog.WarnContext(ctx, "upstream call failed, retrying",
+			slog.String("upstream", b.name),
+			slog.Int("attempt", attempt),
+			slog.Duration("delay", delay),
+			slog.String("error", err.Error()),
+		)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+func (p Policy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if !p.RetryTimeouts && timeout(err) {
+		return false
+	}
+	return Transient(err)
+}
+
+// backoff возвращает паузу перед повтором со случайным разбросом,
+// чтобы параллельные запросы не повторялись одновременно
+func (p Policy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Transient сообщает, что ошибка временная и запрос стоит повторить:
+// сетевая ошибка, обрыв ответа или ошибка, сообщающая об этом сама
+// (например, ответ API 429 или 5xx)
+func Transient(err error) bool {
+	var t interface{ Transient() bool }
+	if errors.As(err, &t) {
+		return t.Transient()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func timeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
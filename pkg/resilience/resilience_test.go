@@ -0,0 +1,113 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// transientErr - временная ошибка API, как ответ 503
+type transientErr struct{}
+
+func (transientErr) Error() string   { return "503" }
+func (transientErr) Transient() bool { return true }
+
+var errBadRequest = errors.New("400")
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker("test", 2, time.Minute)
+	b.now = func() time.Time { return now }
+	fail := func(context.Context) error { return transientErr{} }
+	ok := func(context.Context) error { return nil }
+	ctx := context.Background()
+
+	// Ошибки запроса не размыкают автомат: API доступен
+	for range 3 {
+		b.Call(ctx, func(context.Context) error { return errBadRequest })
+	}
+	if b.State() != Closed {
+		t.Fatalf("state after 4xx = %s, want closed", b.State())
+	}
+
+	b.Call(ctx, fail)
+	b.Call(ctx, fail)
+	if b.State() != Open {
+		t.Fatalf("state after %d failures = %s, want open", 2, b.State())
+	}
+	called := false
+	err := b.Call(ctx, func(context.Context) error { called = true; return nil })
+	if !errors.Is(err, ErrCircuitOpen) || called {
+		t.Fatalf("open breaker: err = %v, called = %v", err, called)
+	}
+	if b.Check(ctx) == nil {
+		t.Error("Check succeeded with open breaker")
+	}
+
+	// После паузы пробный запрос с ошибкой снова размыкает автомат
+	now = now.Add(time.Minute)
+	b.Call(ctx, fail)
+	if b.State() != Open {
+		t.Fatalf("state after failed probe = %s, want open", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.Call(ctx, ok); err != nil || b.State() != Closed {
+		t.Fatalf("probe: err = %v, state = %s, want closed", err, b.State())
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := NewBreaker("test", 0, time.Minute)
+	for range 10 {
+		b.Call(context.Background(), func(context.Context) error { return transientErr{} })
+	}
+	if b.State() != Closed {
+		t.Errorf("state = %s, want closed", b.State())
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		want  error
+	}{
+		{"success", nil, 1, nil},
+		{"recovered", []error{transientErr{}, transientErr{}}, 3, nil},
+		{"exhausted", []error{transientErr{}, transientErr{}, transientErr{}, nil}, 3, transientErr{}},
+		{"not transient", []error{errBadRequest, nil}, 1, errBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Do(context.Background(), p, NewBreaker("test", 0, 0), func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.want) || calls != tt.calls {
+				t.Errorf("err = %v, calls = %d; want %v, %d", err, calls, tt.want, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{Attempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	calls := 0
+	err := Do(ctx, p, NewBreaker("test", 0, 0), func(context.Context) error {
+		calls++
+		cancel()
+		return transientErr{}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("err = %v, calls = %d, want one failed call", err, calls)
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/conversation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/moderation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// maxQuestionLength - ограничение длины уточняющего вопроса в символах
+const maxQuestionLength = 1000
+
+// followUpRequest - уточняющий вопрос
+type followUpRequest struct {
+	Question string `json:"question"`
+}
+
+// followUpResponse - ответ на уточняющий вопрос
+type followUpResponse struct {
+	Answer        string `json:"answer"`
+	FollowUpsLeft int    `json:"followUpsLeft"`
+	// Moderation - категория модерации, если вместо ответа показаны контакты помощи
+	Moderation string `json:"moderation,omitempty"`
+}
+
+// conversationResponse - видимая пользователю часть диалога: предсказание
+// и уточнения, без системного сообщения и промпта
+type conversationResponse struct {
+	ID            string                 `json:"id"`
+	Messages      []common.OpenAIMessage `json:"messages"`
+	FollowUpsLeft int                    `json:"followUpsLeft"`
+}
+
+// HandleConversation возвращает историю диалога по предсказанию
+func (s *Server) HandleConversation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	thread, ok := s.conversation(w, r)
+	if !ok {
+		return
+	}
+
+	resp := conversationResponse{ID: thread.ID, FollowUpsLeft: s.conversations.Left(thread)}
+	if len(thread.Messages) > 2 {
+		resp.Messages = thread.Messages[2:]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleFollowUp отвечает на уточняющий вопрос с учетом истории диалога
+func (s *Server) HandleFollowUp(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req followUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+		return
+	}
+	question := strings.TrimSpace(req.Question)
+	if question == "" || utf8.RuneCountInString(question) > maxQuestionLength {
+		http.Error(w, "Вопрос должен содержать от 1 до 1000 символов", http.StatusBadRequest)
+		return
+	}
+
+	thread, ok := s.conversation(w, r)
+	if !ok {
+		return
+	}
+	state := threadState(thread)
+	input := s.moderate(ctx, state, moderation.Input, question)
+	if input.Action == moderation.Crisis {
+		// Контакты помощи не расходуют уточняющие вопросы
+		writeJSON(w, http.StatusOK, followUpResponse{Answer: input.Message, FollowUpsLeft: s.conversations.Left(thread), Moderation: input.Category})
+		return
+	}
+	if refused(w, input) {
+		return
+	}
+	messages, err := s.conversations.Context(thread, question)
+	if errors.Is(err, conversation.ErrLimitReached) {
+		metrics.FollowUps.WithLabelValues("limit").Inc()
+		http.Error(w, "Лимит уточняющих вопросов исчерпан", http.StatusTooManyRequests)
+		return
+	}
+
+	model, err := s.usage.Model(usage.User(thread.TelegramID))
+	if err != nil {
+		metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+		http.Error(w, "Лимит предсказаний исчерпан, попробуйте позже", http.StatusTooManyRequests)
+		return
+	}
+	if model != s.cfg.OpenRouter.Model {
+		metrics.BudgetExceeded.WithLabelValues("fallback").Inc()
+	}
+
+	done := logging.Stage(ctx, "follow_up", slog.Int("messages", len(messages)))
+	completion, err := s.llm.CreateChatCompletionMessages(ctx, model, messages)
+	done(err)
+	if err != nil {
+		metrics.FollowUps.WithLabelValues("failed").Inc()
+		http.Error(w, "Не удалось получить ответ, попробуйте позже", http.StatusInternalServerError)
+		return
+	}
+	// Уточнения учитываются в расходах исходного предсказания
+	s.usage.RecordLLM(ctx, state, completion)
+
+	answer := stripImagePrompts(completion.Content)
+	output := s.moderate(ctx, state, moderation.Output, answer)
+	if output.Action == moderation.Crisis {
+		writeJSON(w, http.StatusOK, followUpResponse{Answer: output.Message, FollowUpsLeft: s.conversations.Left(thread), Moderation: output.Category})
+		return
+	}
+	if refused(w, output) {
+		return
+	}
+	answer = moderation.AppendDisclaimers(answer, input.Disclaimers...)
+	answer = moderation.AppendDisclaimers(answer, output.Disclaimers...)
+	answer = moderation.AppendDisclaimers(answer, s.moderation.Disclaimer(thread.Mode))
+	thread, err = s.conversations.Append(thread.ID, question, answer)
+	if errors.Is(err, conversation.ErrLimitReached) {
+		metrics.FollowUps.WithLabelValues("limit").Inc()
+		http.Error(w, "Лимит уточняющих вопросов исчерпан", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "conversation append failed", slog.String("error", err.Error()))
+		http.Error(w, "Error saving conversation", http.StatusInternalServerError)
+		return
+	}
+
+	metrics.FollowUps.WithLabelValues("answered").Inc()
+	writeJSON(w, http.StatusOK, followUpResponse{Answer: answer, FollowUpsLeft: s.conversations.Left(thread)})
+}
+
+// conversation загружает диалог из пути запроса или отвечает ошибкой
+func (s *Server) conversation(w http.ResponseWriter, r *http.Request) (*conversation.Thread, bool) {
+	telegramID, err := s.telegramID(r)
+	if err != nil {
+		http.Error(w, "Invalid Telegram init data", http.StatusUnauthorized)
+		return nil, false
+	}
+	if s.banned(w, r, telegramID) {
+		return nil, false
+	}
+	thread, err := s.conversations.Get(r.PathValue("id"), telegramID)
+	if errors.Is(err, conversation.ErrNotFound) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "conversation load failed", slog.String("error", err.Error()))
+		http.Error(w, "Error loading conversation", http.StatusInternalServerError)
+		return nil, false
+	}
+	return thread, true
+}
+
+// stripImagePrompts убирает строки IMAGE_PROMPT, если модель все же их добавила
+func stripImagePrompts(content string) string {
+	lines := strings.Split(content, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "IMAGE_PROMPT:") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
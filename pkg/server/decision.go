@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+)
+
+// checkOptions проверяет варианты сферы «Принятие решений» и отвечает 400,
+// если их не хватает. В остальных сферах варианты не нужны и отбрасываются.
+func checkOptions(w http.ResponseWriter, state *common.UserState) bool {
+	if state.Mode != decision.Mode {
+		state.Options = nil
+		return true
+	}
+	options, err := decision.Normalize(state.Options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	state.Options = options
+	return true
+}
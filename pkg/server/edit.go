@@ -0,0 +1,267 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/conversation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/moderation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// maxImagePromptLength - ограничение Kandinsky на длину промпта в символах
+const maxImagePromptLength = 1000
+
+// imageStyles - стили перегенерации, добавляемые к промпту изображения
+var imageStyles = map[string]string{
+	"kandinsky":  "abstract composition in Kandinsky style, vibrant colors",
+	"watercolor": "soft watercolor painting, pastel colors",
+	"realistic":  "photorealistic, detailed, cinematic lighting",
+	"anime":      "anime illustration, bright colors",
+	"minimal":    "minimalist line art, muted colors",
+}
+
+// rewriteStyles - указания модели для переписывания предсказания
+var rewriteStyles = map[string]string{
+	"shorter":  "короче, в 2-3 абзаца, сохранив главное",
+	"gentler":  "мягче и бережнее, без тревожных формулировок",
+	"detailed": "подробнее, раскрыв каждую часть и добавив практические советы",
+}
+
+// regenerateRequest - параметры перегенерации изображения; пустые поля
+// означают прежний промпт без дополнительного стиля
+type regenerateRequest struct {
+	Prompt string `json:"prompt"`
+	Style  string `json:"style"`
+}
+
+// regenerateResponse - новое изображение предсказания
+type regenerateResponse struct {
+	Index     int    `json:"index"`
+	Prompt    string `json:"prompt"`
+	Image     []byte `json:"image"`
+	EditsLeft int    `json:"editsLeft"`
+}
+
+// rewriteRequest - вид переписывания: shorter, gentler или detailed
+type rewriteRequest struct {
+	Style string `json:"style"`
+}
+
+// rewriteResponse - переписанное предсказание
+type rewriteResponse struct {
+	Text      string `json:"text"`
+	EditsLeft int    `json:"editsLeft"`
+	// Moderation - категория модерации, если вместо текста показаны контакты помощи
+	Moderation string `json:"moderation,omitempty"`
+}
+
+// HandleRegenerateImage заново генерирует одно изображение предсказания,
+// при необходимости с измененным пользователем промптом или стилем
+func (s *Server) HandleRegenerateImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Тело необязательно: без него изображение генерируется по прежнему промпту
+	var req regenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+		return
+	}
+	style, ok := imageStyles[req.Style]
+	if req.Style != "" && !ok {
+		http.Error(w, "Неизвестный стиль изображения", http.StatusBadRequest)
+		return
+	}
+
+	thread, ok := s.conversation(w, r)
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(thread.Prompts) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	prompt := strings.TrimSpace(req.Prompt)
+	if prompt == "" {
+		prompt = thread.Prompts[index]
+	} else if v := s.moderate(ctx, threadState(thread), moderation.Input, prompt); v.Action == moderation.Crisis || v.Action == moderation.Block {
+		// Промпт пользователя проверяется так же, как вопрос
+		http.Error(w, v.Message, http.StatusUnprocessableEntity)
+		return
+	}
+	if style != "" {
+		prompt += ", " + style
+	}
+	if utf8.RuneCountInString(prompt) > maxImagePromptLength {
+		http.Error(w, "Промпт изображения должен быть не длиннее 1000 символов", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.canEdit(w, thread, conversation.EditImage); !ok {
+		return
+	}
+
+	done := logging.Stage(ctx, "regenerate_image", slog.Int("image", index+1))
+	image, err := s.images.GenerateImage(tracing.WithImageIndex(ctx, index), prompt)
+	done(err)
+	if err != nil {
+		metrics.Edits.WithLabelValues(conversation.EditImage, "failed").Inc()
+		editFailed(w, err, "Не удалось создать изображение, попробуйте позже")
+		return
+	}
+	s.usage.RecordImages(ctx, threadState(thread), 1)
+
+	thread, err = s.conversations.SetImage(thread.ID, index, prompt, req.Style)
+	if !s.edited(w, r, conversation.EditImage, err) {
+		return
+	}
+	if s.shares != nil {
+		if err := s.shares.SetCard(thread.ID, index, image); err != nil {
+			slog.ErrorContext(ctx, "share card save failed", slog.String("error", err.Error()))
+		}
+	}
+	writeJSON(w, http.StatusOK, regenerateResponse{
+		Index:     index,
+		Prompt:    prompt,
+		Image:     image,
+		EditsLeft: s.conversations.EditsLeft(thread),
+	})
+}
+
+// HandleRewrite просит модель переписать текст предсказания короче,
+// мягче или подробнее; новая версия заменяет прежнюю в диалоге
+func (s *Server) HandleRewrite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req rewriteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+		return
+	}
+	instruction, ok := rewriteStyles[req.Style]
+	if !ok {
+		http.Error(w, "Стиль должен быть shorter, gentler или detailed", http.StatusBadRequest)
+		return
+	}
+
+	thread, ok := s.conversation(w, r)
+	if !ok {
+		return
+	}
+	if thread.Reading() == "" {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	model, ok := s.canEdit(w, thread, conversation.EditRewrite)
+	if !ok {
+		return
+	}
+
+	// Модель видит персону, исходный промпт с фактами и текущую версию текста
+	messages := append(thread.Messages[:3:3], common.OpenAIMessage{
+		Role:    common.RoleUser,
+		Content: "Перепиши это предсказание " + instruction + ". Сохрани смысл и рассчитанные факты, ответь только новым текстом предсказания, без строк IMAGE_PROMPT.",
+	})
+	done := logging.Stage(ctx, "rewrite", slog.String("style", req.Style))
+	completion, err := s.llm.CreateChatCompletionMessages(ctx, model, messages)
+	done(err)
+	if err != nil {
+		metrics.Edits.WithLabelValues(conversation.EditRewrite, "failed").Inc()
+		editFailed(w, err, "Не удалось переписать предсказание, попробуйте позже")
+		return
+	}
+	state := threadState(thread)
+	s.usage.RecordLLM(ctx, state, completion)
+
+	text := stripImagePrompts(completion.Content)
+	output := s.moderate(ctx, state, moderation.Output, text)
+	if output.Action == moderation.Crisis {
+		// Прежний текст остается в диалоге, изменение не расходуется
+		writeJSON(w, http.StatusOK, rewriteResponse{Text: output.Message, EditsLeft: s.conversations.EditsLeft(thread), Moderation: output.Category})
+		return
+	}
+	if refused(w, output) {
+		return
+	}
+	text = moderation.AppendDisclaimers(text, s.moderation.Present(thread.Reading())...)
+	text = moderation.AppendDisclaimers(text, output.Disclaimers...)
+	text = moderation.AppendDisclaimers(text, s.moderation.Disclaimer(thread.Mode))
+	thread, err = s.conversations.Rewrite(thread.ID, req.Style, text)
+	if !s.edited(w, r, conversation.EditRewrite, err) {
+		return
+	}
+	writeJSON(w, http.StatusOK, rewriteResponse{Text: text, EditsLeft: s.conversations.EditsLeft(thread)})
+}
+
+// canEdit проверяет лимит изменений предсказания и бюджет пользователя до
+// обращения к внешним API и возвращает модель для запроса или отвечает 429
+func (s *Server) canEdit(w http.ResponseWriter, thread *conversation.Thread, kind string) (string, bool) {
+	if s.conversations.EditsLeft(thread) == 0 {
+		metrics.Edits.WithLabelValues(kind, "limit").Inc()
+		http.Error(w, "Лимит изменений предсказания исчерпан", http.StatusTooManyRequests)
+		return "", false
+	}
+	model, err := s.usage.Model(usage.User(thread.TelegramID))
+	if err != nil {
+		metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+		http.Error(w, "Лимит предсказаний исчерпан, попробуйте позже", http.StatusTooManyRequests)
+		return "", false
+	}
+	if model != s.cfg.OpenRouter.Model {
+		metrics.BudgetExceeded.WithLabelValues("fallback").Inc()
+	}
+	return model, true
+}
+
+// edited отвечает ошибкой сохранения изменения, если она есть
+func (s *Server) edited(w http.ResponseWriter, r *http.Request, kind string, err error) bool {
+	switch {
+	case err == nil:
+		metrics.Edits.WithLabelValues(kind, "done").Inc()
+		return true
+	case errors.Is(err, conversation.ErrEditLimitReached):
+		metrics.Edits.WithLabelValues(kind, "limit").Inc()
+		http.Error(w, "Лимит изменений предсказания исчерпан", http.StatusTooManyRequests)
+	default:
+		slog.ErrorContext(r.Context(), "prediction edit save failed", slog.String("error", err.Error()))
+		http.Error(w, "Error saving conversation", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// editFailed отвечает на ошибку внешнего API: 503, пока автомат разомкнут
+func editFailed(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, resilience.ErrCircuitOpen) {
+		http.Error(w, "Сервис временно недоступен, попробуйте позже", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// threadState - состояние для учета расходов изменения в исходном предсказании
+func threadState(thread *conversation.Thread) *common.UserState {
+	return &common.UserState{
+		TelegramID:   thread.TelegramID,
+		PredictionID: thread.ID,
+		Mode:         thread.Mode,
+	}
+}
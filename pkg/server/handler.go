@@ -1,300 +1,472 @@
-// Package server provides HTTP server functionality for the telegram mini app
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net/http"
-	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/PtsPuf/telegram-mini-app/pkg/common"
-)
-
-var (
-	// States maps user IDs to their state
-	States = make(map[string]*common.UserState)
-	// StateMutex protects the States map
-	StateMutex sync.RWMutex
+	"go.opentelemetry.io/otel/attribute"
 
-	// serverInstance используется только для локального запуска
-	serverInstance *http.Server
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/decision"
+	"github.com/PtsPuf/telegram-mini-app/pkg/jobs"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/moderation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/photo"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+	"github.com/PtsPuf/telegram-mini-app/pkg/webapp"
 )
 
-// NewMux создает и возвращает настроенный ServeMux
-func NewMux() *http.ServeMux {
-	mux := http.NewServeMux()
-
-	// Serve static files - БЕЗ AddHeaders
-	fs := http.FileServer(http.Dir("static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-	mux.Handle("/", fs) // Отдаем index.html и другие файлы из static
-
-	// Handle prediction endpoint - ПРИМЕНЯЕМ AddHeaders ТОЛЬКО ЗДЕСЬ
-	mux.Handle("/prediction", AddHeaders(http.HandlerFunc(HandlePrediction)))
-
-	return mux
-}
-
-// SetupAndRunServer initializes and starts the HTTP server *for local execution*
-func SetupAndRunServer() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	log.Printf("Setting up local server on port %s", port)
-
-	mux := NewMux()
+// HandlePrediction processes prediction requests
+func (s *Server) HandlePrediction(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
-	serverInstance = &http.Server{
-		Addr:         ":" + port,
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 20 * time.Second,
+	if r.Method != "POST" {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	log.Printf("Starting local server on port %s", port)
-	go func() {
-		if err := serverInstance.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start local server: %v", err)
-		}
-	}()
-}
-
-// Handler is the entry point for Vercel and other HTTP requests
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Создаем новый mux для каждого запроса в serverless среде
-	mux := NewMux()
-
-	// Применяем заголовки и обрабатываем запрос
-	mux.ServeHTTP(w, r)
-}
-
-// AddHeaders добавляет CORS и другие заголовки ТОЛЬКО для обработчиков API
-func AddHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		log.Printf("[API CORS Debug] Path: %s, Received Origin header: %s", r.URL.Path, origin)
-
-		// Проверяем Origin
-		allowedOrigin := "https://ptspuf.github.io"
-		isAllowed := false
-		// Разрешаем конкретный домен, 'null' или ПУСТОЙ origin (т.к. он приходит пустым)
-		if origin == allowedOrigin || origin == "null" || origin == "" {
-			isAllowed = true
-			// Устанавливаем заголовок, только если Origin НЕ пустой (иначе не требуется)
-			if origin != "" {
-				w.Header().Set("Access-Control-Allow-Origin", origin)
-				w.Header().Add("Vary", "Origin") // Vary нужен только если Allow-Origin не всегда *
-			}
-		} else {
-			log.Printf("[API CORS Warning] Request origin '%s' is not allowed for API.", origin)
-		}
-
-		// Устанавливаем остальные CORS заголовки, ТОЛЬКО если источник разрешен
-		if isAllowed {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, HEAD")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Origin")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Max-Age", "3600")
-		} else if r.Method == "OPTIONS" {
-			// Отвечаем на OPTIONS от неразрешенных источников
-			log.Printf("[API CORS Debug] Handling preflight OPTIONS from DISALLOWED origin: %s", origin)
-			w.WriteHeader(http.StatusForbidden)
-			return
-		}
-
-		// Handle preflight OPTIONS request (если источник был разрешен)
-		if r.Method == "OPTIONS" && isAllowed {
-			log.Printf("[API CORS Debug] Handling preflight OPTIONS from allowed origin: %s", origin)
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
 
-		// Если источник не разрешен и это не OPTIONS, прерываем обработку
-		if !isAllowed && r.Method != "OPTIONS" {
-			log.Printf("[API CORS Error] Blocking API request from disallowed origin: %s", origin)
-			http.Error(w, "CORS Origin Not Allowed", http.StatusForbidden)
-			return
+	// Предсказание по фотографии приходит multipart-формой с полями state и photo
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		state, ok := s.readPhotoForm(w, r)
+		if ok {
+			s.predict(w, r, state)
 		}
-
-		// Устанавливаем остальные заголовки (Security, Cache-Control)
-		// Эти заголовки можно применять и к API
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-		// CSP можно оставить или сделать менее строгим для API, если нужно
-		w.Header().Set("Content-Security-Policy", "default-src 'self' https://telegram.org; img-src 'self' data: https:; style-src 'self' 'unsafe-inline'; script-src 'self' 'unsafe-inline' https://telegram.org;")
-		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, proxy-revalidate")
-		w.Header().Set("Pragma", "no-cache")
-		w.Header().Set("Expires", "0")
-
-		log.Printf("[API AddHeaders Debug] Calling next.ServeHTTP for API request (Origin: %s, isAllowed: %t)", origin, isAllowed)
-		next.ServeHTTP(w, r)
-	})
-}
-
-// HandlePrediction processes prediction requests
-func HandlePrediction(w http.ResponseWriter, r *http.Request) {
-	log.Printf("HandlePrediction: Получен запрос на /prediction")
-	log.Printf("HandlePrediction: Метод запроса: %s", r.Method)
-	// --- УБИРАЕМ ДУБЛИРУЮЩИЕСЯ CORS ЗАГОЛОВКИ ---
-	/*
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, HEAD")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Origin")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-	*/
-
-	// Handle preflight OPTIONS request (ЭТО УЖЕ ДЕЛАЕТСЯ В AddHeaders, но оставим на всякий случай, хотя он не должен сюда дойти)
-	if r.Method == "OPTIONS" {
-		log.Printf("HandlePrediction: Обработка OPTIONS запроса (дублирующая проверка)")
-		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// Handle HEAD request
-	if r.Method == "HEAD" {
-		// ... (код обработки HEAD)
-		log.Printf("HandlePrediction: Обработка HEAD запроса")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.WarnContext(ctx, "request body read failed", slog.String("error", err.Error()))
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
 		return
 	}
 
-	if r.Method != "POST" {
-		// ... (код обработки не POST)
-		log.Printf("HandlePrediction: Неподдерживаемый метод: %s", r.Method)
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	var state common.UserState
+	if err := json.Unmarshal(body, &state); err != nil {
+		slog.WarnContext(ctx, "request body decode failed", slog.String("error", err.Error()))
+		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
 		return
 	}
+	s.predict(w, r, state)
+}
 
-	// Устанавливаем ЗАГОЛОВКИ ОТВЕТА (не CORS)
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, proxy-revalidate")
-	w.Header().Set("Pragma", "no-cache")
-	w.Header().Set("Expires", "0")
+// predict проверяет пользователя и бюджет и отвечает предсказанием или,
+// в асинхронном режиме, созданной задачей
+func (s *Server) predict(w http.ResponseWriter, r *http.Request, state common.UserState) {
+	ctx := r.Context()
 
-	// ... (остальная логика чтения тела, вызова GetPrediction, отправки ответа) ...
-	body, err := io.ReadAll(r.Body)
+	// Эти поля задает только сервер
+	data, err := s.initData(r)
 	if err != nil {
-		log.Printf("HandlePrediction: Ошибка чтения тела запроса: %v", err)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		slog.WarnContext(ctx, "telegram init data rejected", slog.String("error", err.Error()))
+		http.Error(w, "Invalid Telegram init data", http.StatusUnauthorized)
+		return
+	}
+	state.TelegramID = 0
+	if data != nil && data.User != nil {
+		state.TelegramID = data.User.ID
+	}
+	if s.banned(w, r, state.TelegramID) {
+		return
+	}
+	if !s.preparePhoto(w, &state) || !checkOptions(w, &state) {
+		return
+	}
+	// До проверки бюджета: бонус приглашенного может понадобиться уже сейчас
+	s.attribute(ctx, data)
+	state.PredictionID = common.NewID()
+
+	// UserState реализует slog.LogValuer и не раскрывает персональные данные
+	slog.InfoContext(ctx, "prediction requested", slog.Any("state", state))
+	metrics.PredictionRequests.WithLabelValues(metrics.Mode(state.Mode)).Inc()
+
+	// Отказываем сразу, а не после постановки задачи
+	if _, err := s.usage.Model(usage.User(state.TelegramID)); errors.Is(err, usage.ErrBudgetExceeded) {
+		metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+		slog.InfoContext(ctx, "prediction refused: budget exceeded")
+		http.Error(w, "Лимит предсказаний исчерпан, попробуйте позже", http.StatusTooManyRequests)
 		return
 	}
-	log.Printf("HandlePrediction: Тело запроса: %s", string(body))
 
-	var state common.UserState
-	if err := json.Unmarshal(body, &state); err != nil {
-		log.Printf("HandlePrediction: Ошибка декодирования JSON: %v", err)
-		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+	if s.serverless || wantsAsync(r) {
+		s.submitJob(w, r, state)
 		return
 	}
 
-	log.Printf("HandlePrediction: Получен запрос на предсказание для пользователя: %s", state.Name)
-	log.Printf("HandlePrediction: Данные запроса: %+v", state)
+	metrics.JobsInFlight.WithLabelValues("sync").Inc()
+	defer metrics.JobsInFlight.WithLabelValues("sync").Dec()
+	stageDone := logging.Stage(ctx, "prediction_total", slog.String("mode", state.Mode))
+	start := time.Now()
+	finish := func(err error) {
+		stageDone(err)
+		observePrediction(state.Mode, start, err)
+	}
 
-	prediction, err := GetPrediction(&state)
+	response, err := s.Predict(ctx, &state)
+	finish(err)
+	if errors.Is(err, usage.ErrBudgetExceeded) {
+		http.Error(w, "Лимит предсказаний исчерпан, попробуйте позже", http.StatusTooManyRequests)
+		return
+	}
+	var blocked *moderation.BlockedError
+	if errors.As(err, &blocked) {
+		http.Error(w, blocked.Message, http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, resilience.ErrCircuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.cfg.Resilience.BreakerCooldown.Seconds())))
+		http.Error(w, "Сервис предсказаний временно недоступен, попробуйте позже", http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
-		log.Printf("HandlePrediction: Ошибка получения предсказания: %v", err)
-		http.Error(w, fmt.Sprintf("Error getting prediction: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Predict синхронно получает предсказание с изображениями. Его же вызывает
+// бот для голосовых вопросов, поэтому блокировка проверяется и здесь.
+func (s *Server) Predict(ctx context.Context, state *common.UserState) (*common.PredictionResponse, error) {
+	if _, ok := s.bans.Get(state.TelegramID); ok {
+		return nil, common.ErrBanned
+	}
+	if state.PredictionID == "" {
+		state.PredictionID = common.NewID()
+	}
+	prediction, err := s.GetPrediction(ctx, state)
+	var blocked *moderation.BlockedError
+	switch {
+	case err == nil:
+	case errors.Is(err, usage.ErrBudgetExceeded), errors.Is(err, resilience.ErrCircuitOpen), errors.As(err, &blocked):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("Error getting prediction: %w", err)
+	}
 
-	log.Printf("HandlePrediction: Сгенерировано предсказание для пользователя: %s", state.Name)
+	imagesDone := logging.Stage(ctx, "images")
+	images, err := s.generateImages(ctx, state, prediction.ImagePrompts)
+	imagesDone(err)
+	if err != nil {
+		return nil, err
+	}
+	s.saveCards(ctx, prediction.ConversationID, images)
+
+	return &common.PredictionResponse{
+		Text:           prediction.Text,
+		Images:         images,
+		Prompts:        prediction.ImagePrompts,
+		Numerology:     prediction.Numerology,
+		ConversationID: prediction.ConversationID,
+		Moderation:     prediction.Moderation,
+		Decision:       prediction.Decision,
+	}, nil
+}
 
-	// ... (генерация изображений)
+// generateImages параллельно генерирует изображения по промптам и учитывает
+// их расход; ошибка содержит все неудавшиеся изображения
+func (s *Server) generateImages(ctx context.Context, state *common.UserState, prompts []string) ([][]byte, error) {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	var imageErrors []error
-	images := make([][]byte, 3)
+	images := make([][]byte, len(prompts))
 
-	for i := 0; i < 3; i++ {
+	for i := range prompts {
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
-			log.Printf("Начало генерации изображения %d для пользователя: %s", index+1, state.Name)
-			img, err := common.GenerateKandinskyImage(prediction.ImagePrompts[index])
+			done := logging.Stage(ctx, "image", slog.Int("image", index+1))
+			img, err := s.images.GenerateImage(tracing.WithImageIndex(ctx, index), prompts[index])
+			done(err)
 			if err != nil {
-				log.Printf("Ошибка генерации изображения %d: %v", index+1, err)
-				imageErrors = append(imageErrors, fmt.Errorf("error generating image %d: %v", index+1, err))
+				mu.Lock()
+				imageErrors = append(imageErrors, fmt.Errorf("error generating image %d: %w", index+1, err))
+				mu.Unlock()
 				return
 			}
 			images[index] = img
-			log.Printf("Успешно сгенерировано изображение %d для пользователя: %s", index+1, state.Name)
 		}(i)
 	}
 	wg.Wait()
 
+	s.usage.RecordImages(ctx, state, len(prompts)-len(imageErrors))
+
 	if len(imageErrors) > 0 {
-		errMsgs := make([]string, len(imageErrors))
-		for i, err := range imageErrors {
-			errMsgs[i] = err.Error()
+		return nil, &imagesError{errs: imageErrors}
+	}
+	return images, nil
+}
+
+// imagesError - ошибки изображений предсказания; коды метрик берутся из
+// вложенных ошибок
+type imagesError struct {
+	errs []error
+}
+
+func (e *imagesError) Error() string {
+	errMsgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		errMsgs[i] = err.Error()
+	}
+	return "Error generating images: " + strings.Join(errMsgs, "; ")
+}
+
+func (e *imagesError) Unwrap() []error {
+	return e.errs
+}
+
+// telegramID возвращает идентификатор пользователя Telegram из подписанных
+// данных запуска или 0, если запрос пришел не из Telegram или бот не настроен
+func (s *Server) telegramID(r *http.Request) (int64, error) {
+	data, err := s.initData(r)
+	if err != nil || data == nil || data.User == nil {
+		return 0, err
+	}
+	return data.User.ID, nil
+}
+
+// initData возвращает проверенные данные запуска или nil, если запрос
+// пришел не из Telegram или бот не настроен
+func (s *Server) initData(r *http.Request) (*webapp.InitData, error) {
+	token := string(s.cfg.Telegram.BotToken)
+	if token == "" {
+		return nil, nil
+	}
+	data, err := webapp.Parse(r.Header.Get(webapp.InitDataHeader), token, s.cfg.Telegram.InitDataMaxAge, time.Now())
+	if errors.Is(err, webapp.ErrNoInitData) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// banned отвечает 403, если пользователь заблокирован администратором
+func (s *Server) banned(w http.ResponseWriter, r *http.Request, telegramID int64) bool {
+	if _, ok := s.bans.Get(telegramID); !ok {
+		return false
+	}
+	slog.InfoContext(r.Context(), "request refused: user banned")
+	http.Error(w, "Доступ ограничен", http.StatusForbidden)
+	return true
+}
+
+// recordJobImages учитывает изображения завершенной асинхронной задачи и
+// сохраняет их для коллажа публикации
+func (s *Server) recordJobImages(ctx context.Context, job *jobs.Job) {
+	done := 0
+	images := make([][]byte, len(job.Images))
+	for i, img := range job.Images {
+		if img.Status == jobs.StatusDone {
+			done++
+			images[i] = img.Data
 		}
-		log.Printf("Ошибки при генерации изображений: %v", errMsgs)
-		http.Error(w, fmt.Sprintf("Error generating images: %s", strings.Join(errMsgs, "; ")), http.StatusInternalServerError)
+	}
+	s.usage.RecordImages(ctx, &job.State, done)
+	if done > 0 {
+		s.saveCards(ctx, job.ConversationID, images)
+	}
+}
+
+// observePrediction записывает длительность и исход синхронного предсказания
+func observePrediction(mode string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+		metrics.PredictionFailures.WithLabelValues(common.ErrorCode(err)).Inc()
+	}
+	metrics.PredictionDuration.WithLabelValues(metrics.Mode(mode), outcome).Observe(time.Since(start).Seconds())
+}
+
+// wantsAsync сообщает, просит ли клиент асинхронную обработку
+// (?async=1 или заголовок "Prefer: respond-async")
+func wantsAsync(r *http.Request) bool {
+	return r.URL.Query().Get("async") == "1" || strings.Contains(r.Header.Get("Prefer"), "respond-async")
+}
+
+// submitJob создает асинхронную задачу и отвечает 202 с ее состоянием
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request, state common.UserState) {
+	job, err := s.jobs.Submit(r.Context(), state)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "job submit failed", slog.String("error", err.Error()))
+		http.Error(w, fmt.Sprintf("Error creating job: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if job.Status == jobs.StatusFailed {
+		http.Error(w, job.Error, http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "job submitted", slog.String("job_id", job.ID))
+	writeJSON(w, http.StatusAccepted, job)
+}
 
-	response := common.PredictionResponse{
-		Text:    prediction.Text,
-		Images:  images,
-		Prompts: prediction.ImagePrompts,
+// HandleJob возвращает состояние асинхронной задачи
+func (s *Server) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	responseJSON, err := json.Marshal(response)
+	job, err := s.jobs.Poll(r.Context(), r.PathValue("id"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Printf("HandlePrediction: Ошибка кодирования ответа: %v", err)
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "job poll failed", slog.String("error", err.Error()))
+		http.Error(w, "Error getting job", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("HandlePrediction: Отправка ответа пользователю: %s", state.Name)
-	log.Printf("HandlePrediction: Размер ответа: %d байт", len(responseJSON))
+	status := http.StatusOK
+	if !job.Done() {
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, job)
+}
 
-	w.Write(responseJSON)
-	log.Printf("HandlePrediction: Успешно отправлен ответ пользователю: %s", state.Name)
+// writeJSON отправляет v в виде JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("response encode failed", slog.String("error", err.Error()))
+	}
 }
 
 // GetPrediction generates a prediction based on user state
-func GetPrediction(state *common.UserState) (*common.Prediction, error) {
-	log.Printf("Начинаем генерацию предсказания для пользователя %s", state.Name)
+func (s *Server) GetPrediction(ctx context.Context, state *common.UserState) (prediction *common.Prediction, err error) {
+	ctx, span := tracing.Start(ctx, "GetPrediction", attribute.String("prediction.mode", state.Mode))
+	defer func() { tracing.End(span, err) }()
+
+	// Вопрос проверяется до обращения к модели: в кризисной ситуации
+	// вместо предсказания показываются контакты помощи
+	// Варианты решения - тоже текст пользователя
+	question := strings.Join(append([]string{state.Question}, state.Options...), "\n")
+	input := s.moderate(ctx, state, moderation.Input, question)
+	if input.Action == moderation.Crisis {
+		return crisisPrediction(input), nil
+	}
+	if err := input.Err(moderation.Input); err != nil {
+		return nil, err
+	}
 
-	prompt := fmt.Sprintf("Ты - опытный таролог и экстрасенс. Тебе нужно дать предсказание для человека по имени %s (родился(ась) %s). "+
-		"Вопрос: %s (сфера: %s). "+
-		"Дай подробное предсказание (минимум 2000 символов). В конце предсказания сгенерируй три отдельных промпта для генерации изображений, "+
-		"каждый начни с новой строки и префиксом 'IMAGE_PROMPT:'. Каждый промпт должен быть на английском языке и содержать описание изображения в стиле Кандинского.",
-		state.Name, state.BirthDate, state.Question, state.Mode)
+	now := time.Now()
+	profile := numerologyProfile(state, now)
+	var prompt string
+	var cards []decision.Card
+	if state.Mode == decision.Mode {
+		if state.Options, err = decision.Normalize(state.Options); err != nil {
+			return nil, err
+		}
+		cards = decision.Draw(nil, len(state.Options))
+		prompt = decision.Prompt(state.Name, state.BirthDate, state.Question, astroFacts(state, now), state.Options, cards)
+	} else {
+		prompt = buildPrompt(state, now, profile)
+	}
 
-	apiKey := os.Getenv("OPENROUTER_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENROUTER_API_KEY не установлен")
+	span.SetAttributes(attribute.Int("llm.prompt_length", len(prompt)))
+	// Бюджет проверяется и здесь: асинхронная задача могла ждать, пока
+	// другие предсказания пользователя его израсходовали
+	model, err := s.usage.Model(usage.User(state.TelegramID))
+	if err != nil {
+		metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+		return nil, err
+	}
+	if model != s.cfg.OpenRouter.Model {
+		metrics.BudgetExceeded.WithLabelValues("fallback").Inc()
+		slog.InfoContext(ctx, "budget exceeded, using fallback model", slog.String("model", model))
 	}
 
-	log.Printf("API ключ OpenRouter доступен, длина: %d", len(apiKey))
-	client := common.NewOpenAIClient(apiKey)
+	thread := s.conversations.Start(state, prompt)
+	messages := thread.Messages
+	if len(state.Photo) > 0 {
+		// Фотография нужна только для первого ответа и в диалоге не хранится
+		messages = slices.Clone(messages)
+		messages[len(messages)-1].Images = []string{photo.DataURL(state.Photo)}
+		// Резервная модель бюджета может не понимать изображений
+		if s.cfg.Photo.Model != "" {
+			model = s.cfg.Photo.Model
+		}
+	}
 
-	response, err := client.CreateChatCompletion(prompt)
+	done := logging.Stage(ctx, "llm", slog.String("mode", state.Mode), slog.Int("prompt_len", len(prompt)))
+	response, err := s.llm.CreateChatCompletionMessages(ctx, model, messages)
+	done(err)
 	if err != nil {
-		log.Printf("Ошибка при вызове OpenRouter API: %v", err)
-		return nil, fmt.Errorf("error creating chat completion: %v", err)
+		return nil, fmt.Errorf("error creating chat completion: %w", err)
+	}
+	s.usage.RecordLLM(ctx, state, response)
+
+	var text string
+	var imagePrompts []string
+	var result *decision.Result
+	if cards != nil {
+		if result, err = decision.Parse(response.Content, state.Options, cards); err != nil {
+			return nil, fmt.Errorf("error parsing decision: %w", err)
+		}
+		text, imagePrompts = result.Text(), result.ImagePrompts()
+	} else {
+		text, imagePrompts = splitImagePrompts(response.Content, state.Name)
 	}
 
-	log.Printf("Получен ответ от OpenRouter API, длина: %d символов", len(response))
+	slog.DebugContext(ctx, "prediction parsed",
+		slog.Int("text_len", len(response.Content)),
+		slog.Int("image_prompts", len(imagePrompts)),
+	)
+	span.SetAttributes(attribute.Int("prediction.image_prompts", len(imagePrompts)))
+
+	output := s.moderate(ctx, state, moderation.Output, text)
+	if output.Action == moderation.Crisis {
+		return crisisPrediction(output), nil
+	}
+	if err := output.Err(moderation.Output); err != nil {
+		return nil, err
+	}
+	disclaimers := slices.Concat(input.Disclaimers, output.Disclaimers, []string{s.moderation.Disclaimer(state.Mode)})
+	text = moderation.AppendDisclaimers(text, disclaimers...)
+	if result != nil {
+		// Мини-приложение показывает сравнение по полям, без текста
+		result.Verdict = moderation.AppendDisclaimers(result.Verdict, disclaimers...)
+	}
+
+	prediction = &common.Prediction{
+		Text:         text,
+		ImagePrompts: imagePrompts,
+		Numerology:   profile,
+		Decision:     result,
+	}
+	// Без сохраненного диалога предсказание все равно отдается, только без уточнений
+	if err := s.conversations.Save(thread, prediction.Text, prediction.ImagePrompts); err != nil {
+		slog.ErrorContext(ctx, "conversation save failed", slog.String("error", err.Error()))
+	} else {
+		prediction.ConversationID = thread.ID
+	}
+	s.activate(ctx, state.TelegramID)
+	return prediction, nil
+}
 
-	// Ищем промпты для изображений по префиксу
+// splitImagePrompts отделяет от ответа модели строки IMAGE_PROMPT и
+// возвращает ровно три промпта, дополняя их промптом по умолчанию
+func splitImagePrompts(content, name string) (string, []string) {
 	var imagePrompts []string
 	var textParts []string
-	lines := strings.Split(response, "\n")
-
-	for _, line := range lines {
+	for _, line := range strings.Split(content, "\n") {
 		trimmedLine := strings.TrimSpace(line)
 		if strings.HasPrefix(trimmedLine, "IMAGE_PROMPT:") {
 			prompt := strings.TrimPrefix(trimmedLine, "IMAGE_PROMPT:")
@@ -306,22 +478,34 @@ func GetPrediction(state *common.UserState) (*common.Prediction, error) {
 
 	// Дополняем массив промптов, если их меньше трех
 	for len(imagePrompts) < 3 {
-		defaultPrompt := fmt.Sprintf("A mystical tarot card for %s, with abstract shapes in Kandinsky style, vibrant colors", state.Name)
+		defaultPrompt := fmt.Sprintf("A mystical tarot card for %s, with abstract shapes in Kandinsky style, vibrant colors", name)
 		imagePrompts = append(imagePrompts, defaultPrompt)
 	}
-
 	// Возвращаем только первые три промпта, если их больше
-	if len(imagePrompts) > 3 {
-		imagePrompts = imagePrompts[:3]
+	return strings.Join(textParts, "\n"), imagePrompts[:3]
+}
+
+// moderate проверяет текст по политике модерации и учитывает расход
+// классификатора в предсказании
+func (s *Server) moderate(ctx context.Context, state *common.UserState, stage moderation.Stage, text string) moderation.Verdict {
+	v := s.moderation.Check(ctx, stage, text)
+	if v.Usage != nil {
+		s.usage.RecordLLM(ctx, state, v.Usage)
 	}
+	return v
+}
 
-	log.Printf("Извлечено промптов для изображений: %d", len(imagePrompts))
-	for i, p := range imagePrompts {
-		log.Printf("Промпт %d: %s", i+1, p)
+// refused отвечает 422 с сообщением политики на вердикт block
+func refused(w http.ResponseWriter, v moderation.Verdict) bool {
+	if v.Action != moderation.Block {
+		return false
 	}
+	http.Error(w, v.Message, http.StatusUnprocessableEntity)
+	return true
+}
 
-	return &common.Prediction{
-		Text:         strings.Join(textParts, "\n"),
-		ImagePrompts: imagePrompts,
-	}, nil
+// crisisPrediction - ответ с контактами помощи вместо предсказания:
+// без изображений и без диалога для уточнений
+func crisisPrediction(v moderation.Verdict) *common.Prediction {
+	return &common.Prediction{Text: v.Message, Moderation: v.Category}
 }
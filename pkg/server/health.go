@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/health"
+)
+
+// HandleHealthz - проверка живости: процесс запущен и обслуживает запросы
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": health.StatusOK})
+}
+
+// HandleReadyz - проверка готовности: конфигурация, хранилище и внешние API.
+// При недоступности критичной зависимости отвечает 503. Недоступность
+// Kandinsky дает статус degraded: мини-приложение предупреждает, что
+// изображения временно не создаются. Подробности сбоев пишутся в лог и
+// доступны в панели администратора, анонимный клиент видит только статусы.
+func (s *Server) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.health.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status == health.StatusUnavailable {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report.Summary())
+}
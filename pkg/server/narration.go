@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/narration"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// NarrationDurationHeader - длительность озвучки в секундах
+const NarrationDurationHeader = "X-Narration-Duration"
+
+// HandleNarration отдает озвучку текущей версии предсказания в OGG/Opus:
+// GET - уже созданную, POST - создает ее, если текст еще не озвучивался
+func (s *Server) HandleNarration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	thread, ok := s.conversation(w, r)
+	if !ok {
+		return
+	}
+	text := thread.Reading()
+	if text == "" {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	n, err := s.narrations.Get(thread.ID, thread.TelegramID, text)
+	if errors.Is(err, narration.ErrNotFound) && r.Method == http.MethodPost {
+		// Озвучивание платное, поэтому бюджет проверяется до него
+		if _, err := s.usage.Model(usage.User(thread.TelegramID)); errors.Is(err, usage.ErrBudgetExceeded) {
+			metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+			http.Error(w, "Лимит предсказаний исчерпан, попробуйте позже", http.StatusTooManyRequests)
+			return
+		}
+		n, _, err = s.narrations.Narrate(ctx, thread.ID, thread.TelegramID, text)
+		if err != nil {
+			metrics.Narrations.WithLabelValues("failed").Inc()
+		} else {
+			metrics.Narrations.WithLabelValues("created").Inc()
+		}
+	} else if err == nil {
+		metrics.Narrations.WithLabelValues("cached").Inc()
+	}
+	switch {
+	case errors.Is(err, narration.ErrNotFound):
+		http.Error(w, "Narration not found", http.StatusNotFound)
+		return
+	case errors.Is(err, speech.ErrNothingToSay):
+		http.Error(w, "В предсказании нечего озвучивать", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, resilience.ErrCircuitOpen):
+		http.Error(w, "Озвучивание временно недоступно, попробуйте позже", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "narration failed", slog.String("error", err.Error()))
+		http.Error(w, "Не удалось озвучить предсказание, попробуйте позже", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/ogg; codecs=opus")
+	w.Header().Set("Content-Length", strconv.Itoa(len(n.Audio)))
+	w.Header().Set(NarrationDurationHeader, strconv.FormatFloat(n.Duration.Seconds(), 'f', 1, 64))
+	w.Write(n.Audio)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/photo"
+)
+
+// readPhotoForm читает multipart-запрос POST /prediction: поле state (JSON
+// как в теле обычного запроса) и файл photo
+func (s *Server) readPhotoForm(w http.ResponseWriter, r *http.Request) (common.UserState, bool) {
+	var state common.UserState
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.cfg.Photo.MaxSize)+formOverhead)
+	err := r.ParseMultipartForm(int64(s.cfg.Photo.MaxSize))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Фотография слишком большая", http.StatusRequestEntityTooLarge)
+		return state, false
+	case err != nil:
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return state, false
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("state")), &state); err != nil {
+		http.Error(w, "Invalid JSON in state field", http.StatusBadRequest)
+		return state, false
+	}
+	file, _, err := r.FormFile("photo")
+	if errors.Is(err, http.ErrMissingFile) {
+		return state, true
+	}
+	if err != nil {
+		http.Error(w, "Invalid photo field", http.StatusBadRequest)
+		return state, false
+	}
+	defer file.Close()
+	if state.Photo, err = io.ReadAll(file); err != nil {
+		http.Error(w, "Error reading photo", http.StatusBadRequest)
+		return state, false
+	}
+	return state, true
+}
+
+// preparePhoto проверяет фотографию для сфер по фотографии и заменяет ее
+// на JPEG без метаданных. В остальных сферах фотография не нужна и отбрасывается.
+func (s *Server) preparePhoto(w http.ResponseWriter, state *common.UserState) bool {
+	if !photo.IsMode(state.Mode) {
+		state.Photo = nil
+		return true
+	}
+	if !s.cfg.Photo.Enabled {
+		http.Error(w, "Предсказания по фотографии отключены", http.StatusBadRequest)
+		return false
+	}
+	if len(state.Photo) == 0 {
+		http.Error(w, "Для этой сферы нужна фотография", http.StatusBadRequest)
+		return false
+	}
+	prepared, err := photo.Prepare(state.Photo, s.cfg.Photo.MaxSize, s.cfg.Photo.MaxSide)
+	switch {
+	case errors.Is(err, photo.ErrTooLarge):
+		http.Error(w, "Фотография слишком большая", http.StatusRequestEntityTooLarge)
+		return false
+	case errors.Is(err, photo.ErrUnsupported):
+		http.Error(w, "Поддерживаются фотографии JPEG и PNG", http.StatusUnsupportedMediaType)
+		return false
+	case err != nil:
+		http.Error(w, "Не удалось прочитать фотографию", http.StatusBadRequest)
+		return false
+	}
+	state.Photo = prepared
+	return true
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/astro"
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/numerology"
+	"github.com/PtsPuf/telegram-mini-app/pkg/photo"
+)
+
+// buildPrompt собирает промпт предсказания. Рассчитанные факты (астрология,
+// нумерология) передаются модели явно, чтобы она не придумывала их сама.
+func buildPrompt(state *common.UserState, now time.Time, profile *numerology.Profile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ты - опытный таролог и экстрасенс. Тебе нужно дать предсказание для человека по имени %s (родился(ась) %s). "+
+		"Вопрос: %s (сфера: %s). ",
+		state.Name, state.BirthDate, state.Question, state.Mode)
+	if instruction := photo.Instruction(state.Mode); instruction != "" {
+		b.WriteString(instruction + " ")
+	}
+
+	if facts := astroFacts(state, now); facts != "" {
+		b.WriteString("\n\nАстрологические данные рассчитаны по эфемеридам. Опирайся на них и не противоречь им:\n")
+		b.WriteString(facts)
+		b.WriteString("\n\n")
+	}
+	if profile != nil {
+		b.WriteString("Нумерологические числа рассчитаны по имени и дате рождения, используй их в толковании:\n")
+		b.WriteString(numerologyFacts(profile))
+		b.WriteString("\n\n")
+	}
+
+	b.WriteString("Дай подробное предсказание (минимум 2000 символов). В конце предсказания сгенерируй три отдельных промпта для генерации изображений, " +
+		"каждый начни с новой строки и префиксом 'IMAGE_PROMPT:'. Каждый промпт должен быть на английском языке и содержать описание изображения в стиле Кандинского.")
+	return b.String()
+}
+
+// astroFacts описывает карту пользователя и, если указан, знаки партнера.
+// Нераспознанная дата не мешает предсказанию: модель получит только исходные данные.
+func astroFacts(state *common.UserState, now time.Time) string {
+	chart, err := astro.Compute(astro.Birth{Date: state.BirthDate, Time: state.BirthTime, Place: state.BirthPlace}, now)
+	if err != nil {
+		return ""
+	}
+	facts := chart.Summary()
+
+	if state.PartnerBirth != "" {
+		partner, err := astro.Compute(astro.Birth{Date: state.PartnerBirth}, now)
+		if err == nil {
+			name := state.PartnerName
+			if name == "" {
+				name = "Партнер"
+			}
+			facts += fmt.Sprintf("\n%s: Солнце в знаке %s, китайский гороскоп: %s, стихия %s.",
+				name, partner.Sun, partner.Chinese.Animal, partner.Chinese.Element)
+		}
+	}
+	return facts
+}
+
+// numerologyProfile рассчитывает числа пользователя или возвращает nil,
+// если дату рождения не удалось распознать
+func numerologyProfile(state *common.UserState, now time.Time) *numerology.Profile {
+	birth, err := astro.ParseDate(state.BirthDate)
+	if err != nil {
+		return nil
+	}
+	profile := numerology.Compute(state.Name, birth, now)
+	return &profile
+}
+
+func numerologyFacts(p *numerology.Profile) string {
+	var lines []string
+	line := func(title string, n *numerology.Number) {
+		if n == nil {
+			// Число не рассчитывается по этому имени
+			return
+		}
+		s := fmt.Sprintf("- %s: %d", title, n.Value)
+		if n.Master {
+			s += " (мастер-число)"
+		}
+		lines = append(lines, s+" — "+n.Keywords)
+	}
+	line("Число жизненного пути", &p.LifePath)
+	line("Число судьбы", p.Destiny)
+	line("Число души", p.Soul)
+	line("Личный год", &p.PersonalYear)
+	line("Личный месяц", &p.PersonalMonth)
+	line("Личный день", &p.PersonalDay)
+	return strings.Join(lines, "\n")
+}
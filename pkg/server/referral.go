@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/referral"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+	"github.com/PtsPuf/telegram-mini-app/pkg/webapp"
+)
+
+// referralResponse - ссылка-приглашение и статистика пользователя
+type referralResponse struct {
+	referral.Stats
+	// Link - пусто, если имя бота не настроено
+	Link      string `json:"link,omitempty"`
+	BonusLeft int    `json:"bonusLeft"`
+}
+
+// HandleReferrals возвращает реферальную ссылку пользователя и статистику приглашений
+func (s *Server) HandleReferrals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := s.subscriber(w, r)
+	if !ok {
+		return
+	}
+	stats, err := s.referrals.Stats(userID, time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "referral stats failed", slog.String("error", err.Error()))
+		http.Error(w, "Error loading referrals", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, referralResponse{
+		Stats:     *stats,
+		Link:      referral.Link(s.cfg.Telegram.BotUsername, stats.Code),
+		BonusLeft: s.usage.Bonus(usage.User(userID)),
+	})
+}
+
+// attribute привязывает пользователя, открывшего приложение по ссылке
+// ref_<код>, к пригласившему. Параметр запуска приходит со всеми запросами
+// сессии, поэтому повторные попытки уже известного пользователя не считаются.
+func (s *Server) attribute(ctx context.Context, data *webapp.InitData) {
+	if s.referrals == nil || data == nil || data.User == nil {
+		return
+	}
+	code, ok := referral.ParseStartParam(data.StartParam)
+	if !ok {
+		return
+	}
+	_, err := s.referrals.Attribute(data.User.ID, code, "app", time.Now())
+	if errors.Is(err, referral.ErrAlreadyKnown) {
+		return
+	}
+	outcome := referral.Outcome(err)
+	metrics.Referrals.WithLabelValues(outcome).Inc()
+	if outcome == "failed" {
+		slog.ErrorContext(ctx, "referral attribution failed", slog.String("error", err.Error()))
+		return
+	}
+	slog.InfoContext(ctx, "referral attribution", slog.String("outcome", outcome))
+}
+
+// activate начисляет бонус пригласившему после первого предсказания пользователя
+func (s *Server) activate(ctx context.Context, telegramID int64) {
+	if s.referrals == nil {
+		return
+	}
+	inviter, err := s.referrals.Activate(telegramID, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "referral activation failed", slog.String("error", err.Error()))
+		return
+	}
+	if inviter != 0 {
+		metrics.Referrals.WithLabelValues("activated").Inc()
+		slog.InfoContext(ctx, "referral activated")
+	}
+}
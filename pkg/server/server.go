@@ -0,0 +1,448 @@
+// Package server provides HTTP server functionality for the telegram mini app
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/admin"
+	"github.com/PtsPuf/telegram-mini-app/pkg/bot"
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/conversation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/cors"
+	"github.com/PtsPuf/telegram-mini-app/pkg/health"
+	"github.com/PtsPuf/telegram-mini-app/pkg/horoscope"
+	"github.com/PtsPuf/telegram-mini-app/pkg/jobs"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/mock"
+	"github.com/PtsPuf/telegram-mini-app/pkg/moderation"
+	"github.com/PtsPuf/telegram-mini-app/pkg/narration"
+	"github.com/PtsPuf/telegram-mini-app/pkg/referral"
+	"github.com/PtsPuf/telegram-mini-app/pkg/share"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+	"github.com/PtsPuf/telegram-mini-app/pkg/webapp"
+)
+
+var (
+	// States maps user IDs to their state
+	States = make(map[string]*common.UserState)
+	// StateMutex protects the States map
+	StateMutex sync.RWMutex
+)
+
+// LLM - языковая модель: OpenRouter или заглушка
+type LLM interface {
+	CreateChatCompletion(ctx context.Context, prompt string) (*common.ChatCompletion, error)
+	CreateChatCompletionMessages(ctx context.Context, model string, messages []common.OpenAIMessage) (*common.ChatCompletion, error)
+	CheckModel(ctx context.Context) error
+}
+
+// Images - генератор изображений: Kandinsky или заглушка
+type Images interface {
+	jobs.ImageGenerator
+	GenerateImage(ctx context.Context, prompt string) ([]byte, error)
+	Availability(ctx context.Context) error
+}
+
+// Server - HTTP API мини-приложения с клиентами внешних сервисов
+type Server struct {
+	cfg           *config.Config
+	llm           LLM
+	images        Images
+	store         *store.Store
+	jobs          *jobs.Manager
+	health        *health.Checker
+	usage         *usage.Tracker
+	conversations *conversation.Manager
+	subs          *horoscope.Service
+	bans          *admin.Bans
+	// moderation - nil, если модерация отключена
+	moderation *moderation.Moderator
+	// shares - nil, если публикация по ссылке отключена
+	shares *share.Service
+	// preparer - nil без токена бота
+	preparer *bot.Preparer
+	// referrals - nil, если реферальная программа отключена
+	referrals *referral.Service
+	// stt - nil, если распознавание речи не настроено
+	stt speech.Transcriber
+	// narrations - nil, если озвучивание не настроено
+	narrations *narration.Service
+	handler    http.Handler
+	// serverless - режим serverless-платформы: POST /prediction всегда создает
+	// асинхронную задачу, а изображения опрашиваются запросами статуса, без
+	// фоновых горутин, переживающих запрос
+	serverless bool
+}
+
+// New создает сервер по конфигурации
+func New(cfg *config.Config) (*Server, error) {
+	st, err := openStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:           cfg,
+		store:         st,
+		usage:         usage.NewTracker(st, cfg.Usage, cfg.OpenRouter.Model),
+		conversations: conversation.NewManager(st, cfg.Conversation),
+		bans:          admin.NewBans(st),
+		serverless:    cfg.Serverless,
+	}
+	if cfg.Provider == config.ProviderMock {
+		slog.Warn("mock provider enabled: predictions and images are fake")
+		s.llm = mock.NewLLM(cfg.Mock)
+		s.images = mock.NewImages(cfg.Mock, cfg.Kandinsky.Width, cfg.Kandinsky.Height)
+	} else {
+		s.llm = common.NewOpenAIClient(cfg.OpenRouter, cfg.Resilience)
+		s.images = common.NewKandinskyClient(cfg.Kandinsky, cfg.Resilience)
+	}
+	if cfg.Moderation.Enabled {
+		policy, err := moderation.LoadPolicy(cfg.Moderation.Policy)
+		if err != nil {
+			return nil, err
+		}
+		model := policy.Classifier.Model
+		if model == "" {
+			model = cfg.OpenRouter.Model
+		}
+		s.moderation = moderation.New(policy, moderation.NewLLMClassifier(s.llm, model))
+	}
+	if cfg.Share.Enabled {
+		s.shares = share.NewService(st, cfg.Share.BaseURL, cfg.Conversation.TTL)
+		if cfg.Telegram.BotToken != "" {
+			if s.preparer, err = bot.NewPreparer(cfg.Telegram); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.stt = speech.NewTranscriber(cfg.STT, cfg.Resilience)
+	if tts := speech.NewSynthesizer(cfg.TTS, cfg.Resilience); tts != nil {
+		s.narrations = narration.NewService(st, tts, s.usage, cfg.Conversation.TTL)
+	}
+	if cfg.Referral.Enabled {
+		s.referrals = referral.NewService(st, cfg.Referral, s.usage)
+	}
+	// Гороскоп доставляет бот, а рассылку ведет долгоживущий процесс
+	if cfg.Horoscope.Enabled && cfg.Telegram.BotToken != "" && !s.serverless {
+		s.subs = horoscope.NewService(st, cfg.Horoscope)
+	}
+	s.jobs = jobs.NewManager(st, s.GetPrediction, s.images, jobs.Options{
+		Background: !s.serverless,
+		MaxPolls:   cfg.Kandinsky.MaxPolls,
+		TTL:        cfg.Jobs.TTL,
+		OnFinish:   s.recordJobImages,
+	})
+	s.health = health.NewChecker(cfg.Health.CacheTTL, cfg.Health.Timeout,
+		health.Check{Name: "config", Critical: true, Run: func(context.Context) error { return cfg.Validate() }},
+		health.Check{Name: "store", Critical: true, Run: st.Ping},
+		health.Check{Name: "openrouter", Critical: true, Run: s.llm.CheckModel},
+		// Без изображений предсказание все равно можно получить
+		health.Check{Name: "kandinsky", Run: s.images.Availability},
+	)
+	s.handler = logging.Middleware(s.NewMux())
+	return s, nil
+}
+
+// ServeHTTP реализует http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// NewMux создает и возвращает настроенный ServeMux
+func (s *Server) NewMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Serve static files - без CORS
+	fs := http.FileServer(http.Dir(s.cfg.Server.StaticDir))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.Handle("/", fs) // Отдаем index.html и другие файлы из static
+
+	// CORS и служебные заголовки применяются только к API
+	policy := CORSPolicy(s.cfg.CORS)
+	mux.Handle("/prediction", tracing.Handler("/prediction", APIHandler(policy.Route(cors.Route{
+		Methods: []string{http.MethodPost},
+	}), http.HandlerFunc(s.HandlePrediction))))
+	mux.Handle("/jobs/{id}", tracing.Handler("/jobs/{id}", APIHandler(policy.Route(cors.Route{
+		Methods: []string{http.MethodGet},
+	}), http.HandlerFunc(s.HandleJob))))
+
+	mux.Handle("/conversations/{id}", tracing.Handler("/conversations/{id}", APIHandler(policy.Route(cors.Route{
+		Methods: []string{http.MethodGet},
+	}), http.HandlerFunc(s.HandleConversation))))
+	mux.Handle("/conversations/{id}/messages", tracing.Handler("/conversations/{id}/messages", APIHandler(policy.Route(cors.Route{
+		Methods: []string{http.MethodPost},
+	}), http.HandlerFunc(s.HandleFollowUp))))
+
+	mux.Handle("/predictions/{id}/images/{index}/regenerate", tracing.Handler("/predictions/{id}/images/{index}/regenerate", APIHandler(policy.Route(cors.Route{
+		Methods: []string{http.MethodPost},
+	}), http.HandlerFunc(s.HandleRegenerateImage))))
+	mux.Handle("/predictions/{id}/rewrite", tracing.Handler("/predictions/{id}/rewrite", APIHandler(policy.Route(cors.Route{
+		Methods: []string{http.MethodPost},
+	}), http.HandlerFunc(s.HandleRewrite))))
+
+	if s.shares != nil {
+		mux.Handle("/predictions/{id}/share", tracing.Handler("/predictions/{id}/share", APIHandler(policy.Route(cors.Route{
+			Methods: []string{http.MethodPost, http.MethodDelete},
+		}), http.HandlerFunc(s.HandleShare))))
+		// Публичные страницы открывают браузеры и сборщики превью, CORS не нужен
+		mux.Handle("GET /r/{slug}", tracing.Handler("/r/{slug}", securityHeaders(http.HandlerFunc(s.HandleSharePage))))
+		mux.Handle("GET /r/{slug}/image.jpg", tracing.Handler("/r/{slug}/image.jpg", securityHeaders(http.HandlerFunc(s.HandleShareImage))))
+	}
+
+	if s.stt != nil {
+		mux.Handle("/transcribe", tracing.Handler("/transcribe", APIHandler(policy.Route(cors.Route{
+			Methods: []string{http.MethodPost},
+		}), http.HandlerFunc(s.HandleTranscribe))))
+	}
+
+	if s.narrations != nil {
+		mux.Handle("/predictions/{id}/narration", tracing.Handler("/predictions/{id}/narration", APIHandler(policy.Route(cors.Route{
+			Methods: []string{http.MethodGet, http.MethodPost},
+		}), http.HandlerFunc(s.HandleNarration))))
+	}
+
+	if s.referrals != nil {
+		mux.Handle("/referrals", tracing.Handler("/referrals", APIHandler(policy.Route(cors.Route{
+			Methods: []string{http.MethodGet},
+		}), http.HandlerFunc(s.HandleReferrals))))
+	}
+
+	if s.subs != nil {
+		mux.Handle("/subscription", tracing.Handler("/subscription", APIHandler(policy.Route(cors.Route{
+			Methods: []string{http.MethodGet, http.MethodPut, http.MethodDelete},
+		}), http.HandlerFunc(s.HandleSubscription))))
+		mux.Handle("/subscription/skip", tracing.Handler("/subscription/skip", APIHandler(policy.Route(cors.Route{
+			Methods: []string{http.MethodPost},
+		}), http.HandlerFunc(s.HandleSubscriptionSkip))))
+	}
+
+	// Проверки живости и готовности доступны и мини-приложению
+	readOnly := policy.Route(cors.Route{Methods: []string{http.MethodGet, http.MethodHead}})
+	mux.Handle("/healthz", APIHandler(readOnly, http.HandlerFunc(s.HandleHealthz)))
+	mux.Handle("/readyz", APIHandler(readOnly, http.HandlerFunc(s.HandleReadyz)))
+
+	// Метрики собирает Prometheus, а не браузер, поэтому CORS не нужен.
+	// Без токена они отдаются, только если это разрешено явно.
+	switch {
+	case !s.cfg.Metrics.Enabled:
+	case s.cfg.Metrics.Token != "" || s.cfg.Metrics.Public:
+		mux.Handle("GET /metrics", metrics.Handler(string(s.cfg.Metrics.Token)))
+	default:
+		slog.Warn("metrics endpoint disabled: set metrics.token or metrics.public")
+	}
+
+	// Панель администратора открывается только браузеру администратора, CORS не нужен
+	if s.cfg.Admin.Token != "" {
+		mux.Handle("/admin/", securityHeaders(admin.Handler(admin.Options{
+			Token:       string(s.cfg.Admin.Token),
+			RecentLimit: s.cfg.Admin.RecentLimit,
+			Jobs:        s.jobs,
+			Usage:       s.usage,
+			Health:      s.health,
+			Bans:        s.bans,
+		})))
+	}
+
+	return mux
+}
+
+// openStore открывает общее хранилище, если оно задано, иначе файл или память
+func openStore(cfg *config.Config) (*store.Store, error) {
+	if cfg.Store.KVURL != "" {
+		return store.OpenKV(cfg.Store.KVURL, string(cfg.Store.KVToken)), nil
+	}
+	if cfg.Serverless {
+		// Экземпляры функции не видят данные друг друга: задачи, диалоги,
+		// лимиты и публикации доступны, пока запросы попадают в тот же экземпляр
+		slog.Warn("serverless without store.kv_url: data is local to each function instance")
+	}
+	return store.Open(cfg.Store.Path)
+}
+
+// Close сохраняет данные хранилища
+func (s *Server) Close() error {
+	return s.store.Flush()
+}
+
+// SetupAndRunServer запускает HTTP-сервер, бота и фоновые задачи для локального
+// запуска и блокируется до отмены ctx. После отмены сервер перестает принимать
+// соединения и ждет текущие запросы и задачи не дольше server.shutdown_timeout,
+// затем останавливает бота и сохраняет хранилище.
+func SetupAndRunServer(ctx context.Context, cfg *config.Config) error {
+	srv, err := New(cfg)
+	if err != nil {
+		return fmt.Errorf("ошибка инициализации сервера: %v", err)
+	}
+
+	// Бот создается до возобновления задач, чтобы при его ошибке еще
+	// не было запущенных задач и несохраненных данных
+	var tgBot *bot.Bot
+	if cfg.Telegram.BotToken != "" {
+		tgBot, err = bot.New(cfg.Telegram, bot.Options{
+			Subs:        srv.subs,
+			Shares:      srv.shares,
+			Referrals:   srv.referrals,
+			Transcriber: srv.stt,
+			Usage:       srv.usage,
+			Predictor:   srv,
+			Narrations:  srv.narrations,
+			STT:         cfg.STT,
+			Photo:       cfg.Photo,
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка инициализации бота: %v", err)
+		}
+	}
+
+	if n := srv.jobs.Resume(); n > 0 {
+		slog.Info("unfinished jobs resumed", slog.Int("count", n))
+	}
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go srv.store.Run(bgCtx, cfg.Store.FlushInterval)
+
+	schedulerDone := make(chan struct{})
+	if tgBot != nil {
+		tgBot.Start()
+	}
+	if tgBot != nil && srv.subs != nil {
+		scheduler := horoscope.NewScheduler(srv.subs, srv.llm, srv.images, tgBot, srv.usage, nil)
+		go func() {
+			defer close(schedulerDone)
+			scheduler.Run(bgCtx)
+		}()
+	} else {
+		close(schedulerDone)
+	}
+
+	httpServer := &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      srv,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", slog.String("port", cfg.Server.Port))
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			listenErr <- err
+		}
+		close(listenErr)
+	}()
+
+	select {
+	case err = <-listenErr:
+		if err != nil {
+			err = fmt.Errorf("ошибка запуска сервера: %v", err)
+		}
+	case <-ctx.Done():
+		slog.Info("shutdown signal received", slog.Duration("drain_timeout", cfg.Server.ShutdownTimeout))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := httpServer.Shutdown(shutdownCtx); shutdownErr != nil {
+		slog.Warn("requests did not finish in time", slog.String("error", shutdownErr.Error()))
+		httpServer.Close()
+	}
+	if jobsErr := srv.jobs.Shutdown(shutdownCtx); jobsErr != nil {
+		slog.Warn("jobs did not finish in time", slog.String("error", jobsErr.Error()))
+	}
+	// Рассылка останавливается до бота, а хранилище сохраняется после нее
+	stopBackground()
+	<-schedulerDone
+	if tgBot != nil {
+		tgBot.Stop()
+	}
+	if closeErr := srv.Close(); closeErr != nil {
+		slog.Error("store flush failed", slog.String("error", closeErr.Error()))
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	slog.Info("server stopped")
+	return err
+}
+
+var (
+	// serverlessOnce инициализирует сервер один раз на холодный старт
+	serverlessOnce     sync.Once
+	serverlessInstance *Server
+	serverlessErr      error
+)
+
+// Handler is the entry point for Vercel and other HTTP requests
+func Handler(w http.ResponseWriter, r *http.Request) {
+	serverlessOnce.Do(func() {
+		cfg, err := config.Load(config.Options{})
+		if err == nil {
+			cfg.Serverless = true
+			err = cfg.Validate()
+		}
+		if err != nil {
+			serverlessErr = fmt.Errorf("ошибка конфигурации: %v", err)
+			return
+		}
+		logging.MustSetup(logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format, HashKey: string(cfg.Log.HashKey)})
+		// Функция может быть заморожена сразу после ответа, поэтому спаны
+		// отправляются синхронно, а не пачками
+		if _, err := tracing.Setup(context.Background(), cfg.Tracing, true); err != nil {
+			slog.Warn("tracing disabled", slog.String("error", err.Error()))
+		}
+		serverlessInstance, serverlessErr = New(cfg)
+	})
+	if serverlessErr != nil {
+		slog.Error("server not initialized", slog.String("error", serverlessErr.Error()))
+		http.Error(w, "Server misconfigured", http.StatusInternalServerError)
+		return
+	}
+
+	serverlessInstance.ServeHTTP(w, r)
+}
+
+// CORSPolicy строит CORS-политику API из конфигурации
+func CORSPolicy(cfg config.CORSConfig) *cors.Policy {
+	return cors.New(cors.Options{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedHeaders:   append(append([]string{}, cors.DefaultHeaders...), webapp.InitDataHeader),
+		ExposedHeaders:   []string{logging.RequestIDHeader, NarrationDurationHeader},
+		AllowCredentials: true,
+		MaxAge:           cfg.MaxAge,
+	})
+}
+
+// APIHandler оборачивает обработчик API CORS-политикой и служебными заголовками
+func APIHandler(policy *cors.Policy, next http.Handler) http.Handler {
+	return policy.Handler(securityHeaders(next))
+}
+
+// securityHeaders добавляет заголовки безопасности и запрещает кэширование ответов API
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-XSS-Protection", "1; mode=block")
+		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		w.Header().Set("Content-Security-Policy", "default-src 'self' https://telegram.org; img-src 'self' data: https:; style-src 'self' 'unsafe-inline'; script-src 'self' 'unsafe-inline' https://telegram.org;")
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, proxy-revalidate")
+		w.Header().Set("Pragma", "no-cache")
+		w.Header().Set("Expires", "0")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,982 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/server"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+	"github.com/PtsPuf/telegram-mini-app/pkg/webapp"
+)
+
+const predictionBody = `{"name":"Анна","birthDate":"1990-03-15","question":"Что ждет меня в работе?","mode":"career"}`
+
+// env - сервер с фейковыми OpenRouter и Kandinsky
+type env struct {
+	llm    *testutil.OpenRouter
+	images *testutil.Kandinsky
+	srv    *server.Server
+}
+
+func newEnv(t *testing.T, configure ...func(*config.Config)) *env {
+	t.Helper()
+	e := &env{llm: testutil.NewOpenRouter(t), images: testutil.NewKandinsky(t)}
+	cfg := testutil.Config(e.llm, e.images)
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	e.srv = srv
+	return e
+}
+
+func (e *env) do(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	e.srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestPrediction(t *testing.T) {
+	e := newEnv(t)
+
+	w := e.do(t, http.MethodPost, "/prediction", predictionBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !strings.Contains(resp.Text, "Звезды благосклонны") || strings.Contains(resp.Text, "IMAGE_PROMPT") {
+		t.Errorf("text = %q, want prediction without image prompts", resp.Text)
+	}
+	if len(resp.Images) != 3 {
+		t.Fatalf("images = %d, want 3", len(resp.Images))
+	}
+	for i, img := range resp.Images {
+		if !bytes.Equal(img, testutil.PNG) {
+			t.Errorf("image %d = %q, want fake PNG", i, img)
+		}
+	}
+	if resp.ConversationID == "" {
+		t.Error("conversationId is empty")
+	}
+
+	requests := e.llm.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("llm requests = %d, want 1", len(requests))
+	}
+	last := requests[0].Messages[len(requests[0].Messages)-1]
+	if last.Role != common.RoleUser || !strings.Contains(last.Content, "Анна") {
+		t.Errorf("prompt does not mention the user: %+v", last)
+	}
+	if got := len(e.images.Prompts()); got != 3 {
+		t.Errorf("kandinsky tasks = %d, want 3", got)
+	}
+}
+
+func TestPredictionUpstreamFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		reply  testutil.Reply
+		task   testutil.Task
+		status int
+		want   string
+	}{
+		{
+			name:   "llm server error",
+			reply:  testutil.Reply{Status: http.StatusBadGateway},
+			status: http.StatusInternalServerError,
+			want:   "status code 502",
+		},
+		{
+			name:   "llm rate limited",
+			reply:  testutil.Reply{Status: http.StatusTooManyRequests},
+			status: http.StatusInternalServerError,
+			want:   "status code 429",
+		},
+		{
+			name:   "llm malformed json",
+			reply:  testutil.Reply{Body: `{"choices": [`},
+			status: http.StatusInternalServerError,
+			want:   "failed to unmarshal response",
+		},
+		{
+			name:   "llm timeout",
+			reply:  testutil.Reply{Delay: time.Second},
+			status: http.StatusInternalServerError,
+			want:   "request failed",
+		},
+		{
+			name:   "image failed",
+			reply:  testutil.Reply{Content: testutil.DefaultPrediction},
+			task:   testutil.Task{Pending: 2, Status: common.KandinskyStatusFailed, Error: "internal error"},
+			status: http.StatusInternalServerError,
+			want:   "генерация не удалась",
+		},
+		{
+			name:   "image censored",
+			reply:  testutil.Reply{Content: testutil.DefaultPrediction},
+			task:   testutil.Task{Censored: true},
+			status: http.StatusInternalServerError,
+			want:   "изображение не сгенерировано",
+		},
+		{
+			name:   "image queue overloaded",
+			reply:  testutil.Reply{Content: testutil.DefaultPrediction},
+			task:   testutil.Task{RunStatus: http.StatusServiceUnavailable},
+			status: http.StatusInternalServerError,
+			want:   "status code 503",
+		},
+		{
+			name:   "image status malformed json",
+			reply:  testutil.Reply{Content: testutil.DefaultPrediction},
+			task:   testutil.Task{StatusBody: "<html>"},
+			status: http.StatusInternalServerError,
+			want:   "ошибка декодирования ответа",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, func(cfg *config.Config) {
+				cfg.OpenRouter.Timeout = 100 * time.Millisecond
+				cfg.Resilience.BreakerThreshold = 0
+			})
+			e.llm.Default = tt.reply
+			e.images.Default = tt.task
+
+			w := e.do(t, http.MethodPost, "/prediction", predictionBody)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body: %s", w.Code, tt.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("body = %q, want it to contain %q", w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestPredictionRetry(t *testing.T) {
+	e := newEnv(t)
+	e.llm.Push(testutil.Reply{Status: http.StatusBadGateway})
+	e.images.Push(testutil.Task{RunStatus: http.StatusServiceUnavailable})
+
+	w := e.do(t, http.MethodPost, "/prediction", predictionBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body)
+	}
+	if n := len(e.llm.Requests()); n != 2 {
+		t.Errorf("llm requests = %d, want 2", n)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.Resilience.Attempts = 1
+		cfg.Resilience.BreakerThreshold = 2
+		cfg.Resilience.BreakerCooldown = time.Hour
+	})
+	e.llm.Default = testutil.Reply{Status: http.StatusServiceUnavailable}
+
+	for range 2 {
+		if w := e.do(t, http.MethodPost, "/prediction", predictionBody); w.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, body: %s", w.Code, w.Body)
+		}
+	}
+	w := e.do(t, http.MethodPost, "/prediction", predictionBody)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("open circuit: status %d, Retry-After %q, body: %s", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	if n := len(e.llm.Requests()); n != 2 {
+		t.Errorf("llm requests = %d, want 2: open circuit must not call the API", n)
+	}
+	if w := e.do(t, http.MethodGet, "/readyz", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz with open circuit: status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestPredictionAsync(t *testing.T) {
+	e := newEnv(t)
+	e.images.Default = testutil.Task{Pending: 3}
+
+	w := e.do(t, http.MethodPost, "/prediction?async=1", predictionBody)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body)
+	}
+	var job struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Text   string `json:"text"`
+		Images []struct {
+			Status string `json:"status"`
+			Data   []byte `json:"data"`
+		} `json:"images"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("decode job: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == "pending" {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is still pending", job.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+		w = e.do(t, http.MethodGet, "/jobs/"+job.ID, "")
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("decode job: %v", err)
+		}
+	}
+
+	if job.Status != "done" || w.Code != http.StatusOK {
+		t.Fatalf("job status = %s (%d), body: %s", job.Status, w.Code, w.Body)
+	}
+	if !strings.Contains(job.Text, "Звезды благосклонны") {
+		t.Errorf("text = %q", job.Text)
+	}
+	if len(job.Images) != 3 {
+		t.Fatalf("images = %d, want 3", len(job.Images))
+	}
+	for i, img := range job.Images {
+		if img.Status != "done" || !bytes.Equal(img.Data, testutil.PNG) {
+			t.Errorf("image %d: status %s, data %q", i, img.Status, img.Data)
+		}
+	}
+}
+
+func TestFollowUp(t *testing.T) {
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.Conversation.MaxFollowUps = 1
+	})
+
+	w := e.do(t, http.MethodPost, "/prediction", predictionBody)
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	e.llm.Push(testutil.Reply{Content: "В следующем месяце ждите повышения."})
+	path := "/conversations/" + resp.ConversationID + "/messages"
+	w = e.do(t, http.MethodPost, path, `{"question":"А что в следующем месяце?"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "повышения") {
+		t.Fatalf("follow-up: status %d, body: %s", w.Code, w.Body)
+	}
+	requests := e.llm.Requests()
+	if got := len(requests[1].Messages); got != 4 {
+		t.Errorf("follow-up context = %d messages, want persona, prompt, prediction and question", got)
+	}
+
+	w = e.do(t, http.MethodPost, path, `{"question":"А через год?"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("second follow-up: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestFollowUpDisclaimer(t *testing.T) {
+	e := newEnv(t)
+	health := `{"name":"Анна","birthDate":"1990-03-15","question":"Что с моим самочувствием?","mode":"Здоровье"}`
+	w := e.do(t, http.MethodPost, "/prediction", health)
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.ConversationID == "" {
+		t.Fatalf("prediction: status %d, body: %s", w.Code, w.Body)
+	}
+
+	// Обязательная оговорка сферы добавляется и к уточнению, один раз
+	e.llm.Push(testutil.Reply{Content: "Весной больше гуляйте на свежем воздухе."})
+	w = e.do(t, http.MethodPost, "/conversations/"+resp.ConversationID+"/messages", `{"question":"А весной?"}`)
+	var answer struct{ Answer string }
+	if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("follow-up: status %d, body: %s", w.Code, w.Body)
+	}
+	if strings.Count(answer.Answer, "не заменяет консультацию врача") != 1 {
+		t.Errorf("follow-up answer without a single health disclaimer: %q", answer.Answer)
+	}
+}
+
+// predict создает предсказание и возвращает ответ
+func (e *env) predict(t *testing.T) common.PredictionResponse {
+	t.Helper()
+	w := e.do(t, http.MethodPost, "/prediction", predictionBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("prediction: status %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp
+}
+
+func TestRegenerateImage(t *testing.T) {
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.Conversation.MaxEdits = 2
+	})
+	pred := e.predict(t)
+	image := []byte("new image")
+	e.images.Push(testutil.Task{Image: image})
+
+	path := "/predictions/" + pred.ConversationID + "/images/1/regenerate"
+	w := e.do(t, http.MethodPost, path, `{"prompt":"a white owl","style":"watercolor"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("regenerate: status %d, body: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Index     int    `json:"index"`
+		Prompt    string `json:"prompt"`
+		Image     []byte `json:"image"`
+		EditsLeft int    `json:"editsLeft"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Index != 1 || !strings.HasPrefix(resp.Prompt, "a white owl, soft watercolor") ||
+		!bytes.Equal(resp.Image, image) || resp.EditsLeft != 1 {
+		t.Errorf("response = %+v", resp)
+	}
+	prompts := e.images.Prompts()
+	if last := prompts[len(prompts)-1]; last != resp.Prompt {
+		t.Errorf("kandinsky prompt = %q, want %q", last, resp.Prompt)
+	}
+
+	// Без тела изображение перегенерируется по сохраненному промпту
+	if w := e.do(t, http.MethodPost, "/predictions/"+pred.ConversationID+"/images/0/regenerate", ""); w.Code != http.StatusOK {
+		t.Fatalf("regenerate without body: status %d, body: %s", w.Code, w.Body)
+	}
+	prompts = e.images.Prompts()
+	if last := prompts[len(prompts)-1]; last != pred.Prompts[0] {
+		t.Errorf("kandinsky prompt = %q, want saved %q", last, pred.Prompts[0])
+	}
+
+	tests := []struct {
+		path, body string
+		status     int
+	}{
+		{"/predictions/" + pred.ConversationID + "/images/0/regenerate", "", http.StatusTooManyRequests},
+		{"/predictions/" + pred.ConversationID + "/images/3/regenerate", "", http.StatusNotFound},
+		{"/predictions/" + pred.ConversationID + "/images/0/regenerate", `{"style":"cubism"}`, http.StatusBadRequest},
+		{"/predictions/unknown/images/0/regenerate", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := e.do(t, http.MethodPost, tt.path, tt.body); w.Code != tt.status {
+			t.Errorf("POST %s %s: status %d, want %d", tt.path, tt.body, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	e := newEnv(t)
+	pred := e.predict(t)
+	e.llm.Push(testutil.Reply{Content: "Коротко: звезды благосклонны."})
+
+	w := e.do(t, http.MethodPost, "/predictions/"+pred.ConversationID+"/rewrite", `{"style":"shorter"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Коротко") {
+		t.Fatalf("rewrite: status %d, body: %s", w.Code, w.Body)
+	}
+	messages := e.llm.Requests()[1].Messages
+	if len(messages) != 4 || messages[2].Content != pred.Text || !strings.Contains(messages[3].Content, "короче") {
+		t.Errorf("rewrite context = %+v", messages)
+	}
+
+	// Уточнения опираются на переписанный текст
+	w = e.do(t, http.MethodGet, "/conversations/"+pred.ConversationID, "")
+	if !strings.Contains(w.Body.String(), "Коротко: звезды благосклонны.") {
+		t.Errorf("conversation after rewrite: %s", w.Body)
+	}
+
+	if w := e.do(t, http.MethodPost, "/predictions/"+pred.ConversationID+"/rewrite", `{"style":"longer"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown style: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestShare(t *testing.T) {
+	e := newEnv(t)
+	e.llm.Push(testutil.Reply{Content: "Анна, родившейся 15.03.1990, звезды благосклонны.\n" + testutil.DefaultPrediction})
+	pred := e.predict(t)
+	path := "/predictions/" + pred.ConversationID + "/share"
+
+	w := e.do(t, http.MethodPost, path, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("share: status %d, body: %s", w.Code, w.Body)
+	}
+	var shared struct{ Slug, URL string }
+	if err := json.Unmarshal(w.Body.Bytes(), &shared); err != nil {
+		t.Fatalf("decode share: %v", err)
+	}
+	if shared.URL != "http://example.com/r/"+shared.Slug || len(shared.Slug) < 32 {
+		t.Errorf("share = %+v", shared)
+	}
+
+	w = e.do(t, http.MethodGet, "/r/"+shared.Slug, "")
+	page := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(page, `property="og:image" content="http://example.com/r/`+shared.Slug+`/image.jpg"`) {
+		t.Fatalf("page: status %d, body: %s", w.Code, page)
+	}
+	if strings.Contains(page, "Анна") || strings.Contains(page, "1990") || !strings.Contains(page, "звезды благосклонны") {
+		t.Errorf("page leaks personal data or misses the reading: %s", page)
+	}
+	w = e.do(t, http.MethodGet, "/r/"+shared.Slug+"/image.jpg", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("image: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// Повторная публикация возвращает ту же ссылку
+	w = e.do(t, http.MethodPost, path, "")
+	if !strings.Contains(w.Body.String(), shared.Slug) {
+		t.Errorf("republish: %s", w.Body)
+	}
+
+	if w := e.do(t, http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("revoke: status %d, body: %s", w.Code, w.Body)
+	}
+	if w := e.do(t, http.MethodGet, "/r/"+shared.Slug, ""); w.Code != http.StatusNotFound {
+		t.Errorf("revoked page: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := e.do(t, http.MethodDelete, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second revoke: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	e := newEnv(t)
+
+	crisis := `{"name":"Анна","birthDate":"1990-03-15","question":"Я не хочу жить","mode":"Любовь"}`
+	w := e.do(t, http.MethodPost, "/prediction", crisis)
+	if w.Code != http.StatusOK {
+		t.Fatalf("crisis: status %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Moderation != "self_harm" || !strings.Contains(resp.Text, "8-800-2000-122") || len(resp.Images) != 0 || resp.ConversationID != "" {
+		t.Errorf("crisis response = %+v", resp)
+	}
+	if n := len(e.llm.Requests()); n != 0 {
+		t.Errorf("llm requests = %d, want none for a crisis question", n)
+	}
+
+	illegal := `{"name":"Анна","birthDate":"1990-03-15","question":"Как взломать чужой телефон?","mode":"Другое"}`
+	if w := e.do(t, http.MethodPost, "/prediction", illegal); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "незаконной") {
+		t.Errorf("illegal: status %d, body: %s", w.Code, w.Body)
+	}
+
+	// Ответ модели проверяется так же, как вопрос
+	e.llm.Push(testutil.Reply{Content: "Советую купить оружие.\nIMAGE_PROMPT: a knife"})
+	if w := e.do(t, http.MethodPost, "/prediction", predictionBody); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("illegal output: status %d, body: %s", w.Code, w.Body)
+	}
+
+	health := `{"name":"Анна","birthDate":"1990-03-15","question":"Что с моим самочувствием?","mode":"Здоровье"}`
+	w = e.do(t, http.MethodPost, "/prediction", health)
+	if !strings.Contains(w.Body.String(), "не заменяет консультацию врача") {
+		t.Errorf("health prediction without disclaimer: status %d, body: %s", w.Code, w.Body)
+	}
+}
+
+func TestModerationDisabled(t *testing.T) {
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.Moderation.Enabled = false
+	})
+	illegal := `{"name":"Анна","birthDate":"1990-03-15","question":"Как взломать чужой телефон?","mode":"Другое"}`
+	if w := e.do(t, http.MethodPost, "/prediction", illegal); w.Code != http.StatusOK {
+		t.Errorf("status = %d, body: %s", w.Code, w.Body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	e := newEnv(t)
+	if w := e.do(t, http.MethodGet, "/readyz", ""); w.Code != http.StatusOK {
+		t.Fatalf("readyz: status %d, body: %s", w.Code, w.Body)
+	}
+
+	e = newEnv(t)
+	e.llm.Models = []string{"other/model"}
+	w := e.do(t, http.MethodGet, "/readyz", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz without model: status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	// Анонимный клиент видит только статусы проверок, без ошибок внешних API
+	var report struct {
+		Status string                    `json:"status"`
+		Checks map[string]map[string]any `json:"checks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "unavailable" || len(report.Checks) == 0 {
+		t.Errorf("readyz report = %s", w.Body)
+	}
+	for name, check := range report.Checks {
+		if len(check) != 1 || check["ok"] == nil {
+			t.Errorf("check %s exposes %v", name, check)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metricsConfig := func(token config.Secret, public bool) func(*config.Config) {
+		return func(cfg *config.Config) {
+			cfg.Metrics = config.MetricsConfig{Enabled: true, Token: token, Public: public}
+		}
+	}
+	get := func(e *env, token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		e.srv.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Без токена метрики не отдаются, пока это не разрешено явно
+	if code := get(newEnv(t, metricsConfig("", false)), ""); code != http.StatusNotFound {
+		t.Errorf("metrics without token: status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := get(newEnv(t, metricsConfig("", true)), ""); code != http.StatusOK {
+		t.Errorf("public metrics: status %d", code)
+	}
+
+	e := newEnv(t, metricsConfig("scrape-secret", false))
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "scrape-secret": http.StatusOK} {
+		if code := get(e, token); code != want {
+			t.Errorf("metrics with token %q: status %d, want %d", token, code, want)
+		}
+	}
+}
+
+// TestServerlessHandler проходит точку входа Vercel: конфигурация из
+// окружения, пошаговые задачи и общее хранилище, которое видит другой
+// экземпляр функции
+func TestServerlessHandler(t *testing.T) {
+	llm, images, kv := testutil.NewOpenRouter(t), testutil.NewKandinsky(t), testutil.NewKV(t)
+	for name, value := range map[string]string{
+		"CONFIG_FILE":             "",
+		"OPENROUTER_API_KEY":      "test-openrouter-key",
+		"OPENROUTER_BASE_URL":     llm.URL,
+		"KANDINSKY_API_KEY":       "test-kandinsky-key",
+		"KANDINSKY_SECRET":        "test-kandinsky-secret",
+		"KANDINSKY_URL":           images.URL,
+		"KANDINSKY_POLL_INTERVAL": "1ms",
+		"STATIC_DIR":              ".",
+		"METRICS_ENABLED":         "false",
+		"LOG_LEVEL":               "error",
+		"KV_REST_API_URL":         kv.URL,
+		"KV_REST_API_TOKEN":       testutil.KVToken,
+	} {
+		t.Setenv(name, value)
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.Handler(w, r)
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/readyz", ""); w.Code != http.StatusOK {
+		t.Fatalf("readyz: status %d, body: %s", w.Code, w.Body)
+	}
+	// В serverless предсказание всегда создает задачу
+	w := serve(http.MethodPost, "/prediction", predictionBody)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("prediction: status %d, body: %s", w.Code, w.Body)
+	}
+	var job struct {
+		ID             string `json:"id"`
+		Status         string `json:"status"`
+		ConversationID string `json:"conversationId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+
+	// Опрос попадает в другой экземпляр функции
+	cfg := testutil.Config(llm, images)
+	cfg.Serverless = true
+	cfg.Store.KVURL, cfg.Store.KVToken = kv.URL, testutil.KVToken
+	other, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		if job.Status != "pending" {
+			break
+		}
+		r := httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID, nil)
+		w = httptest.NewRecorder()
+		other.ServeHTTP(w, r)
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("poll: status %d, body: %s", w.Code, w.Body)
+		}
+	}
+	if job.Status != "done" || job.ConversationID == "" {
+		t.Fatalf("job = %+v, body: %s", job, w.Body)
+	}
+
+	// Публичная страница доступна из любого экземпляра
+	w = serve(http.MethodPost, "/predictions/"+job.ConversationID+"/share", "")
+	var shared struct{ Slug string }
+	if err := json.Unmarshal(w.Body.Bytes(), &shared); err != nil || shared.Slug == "" {
+		t.Fatalf("share: status %d, body: %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	other.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/r/"+shared.Slug, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("share page on another instance: status %d", w.Code)
+	}
+	if kv.Commands() == 0 {
+		t.Error("shared store not used")
+	}
+}
+
+func TestPredictionMockProvider(t *testing.T) {
+	cfg := config.Default()
+	cfg.Provider = config.ProviderMock
+	cfg.Mock = config.MockConfig{}
+	cfg.Kandinsky.Width, cfg.Kandinsky.Height = 32, 32
+	cfg.Metrics.Enabled = false
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("mock config without keys is invalid: %v", err)
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	r := httptest.NewRequest(http.MethodPost, "/prediction", strings.NewReader(predictionBody))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !strings.Contains(resp.Text, "Анна") || len(resp.Images) != 3 || len(resp.Prompts) != 3 {
+		t.Errorf("response: text %q, %d images, %d prompts", resp.Text, len(resp.Images), len(resp.Prompts))
+	}
+}
+
+// initData подписывает данные запуска мини-приложения токеном бота
+func initData(token string, userID int64, startParam string) string {
+	values := url.Values{}
+	values.Set("auth_date", strconv.FormatInt(time.Now().Unix(), 10))
+	values.Set("user", fmt.Sprintf(`{"id":%d,"first_name":"Test"}`, userID))
+	if startParam != "" {
+		values.Set("start_param", startParam)
+	}
+	values.Set("hash", webapp.Sign(values, token))
+	return values.Encode()
+}
+
+func TestReferral(t *testing.T) {
+	const token = "123:test-token"
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.Telegram.BotToken = token
+		cfg.Telegram.BotUsername = "oracle_bot"
+	})
+	doAs := func(userID int64, startParam, method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(webapp.InitDataHeader, initData(token, userID, startParam))
+		w := httptest.NewRecorder()
+		e.srv.ServeHTTP(w, r)
+		return w
+	}
+	type stats struct {
+		Code, Link                                 string
+		Invited, Activated, BonusEarned, BonusLeft int
+	}
+	referrals := func(userID int64) stats {
+		t.Helper()
+		w := doAs(userID, "", http.MethodGet, "/referrals", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("referrals: status %d, body: %s", w.Code, w.Body)
+		}
+		var s stats
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatalf("decode referrals: %v", err)
+		}
+		return s
+	}
+
+	if w := e.do(t, http.MethodGet, "/referrals", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("referrals without init data: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	inviter := referrals(1)
+	if inviter.Link != "https://t.me/oracle_bot?startapp=ref_"+inviter.Code {
+		t.Fatalf("link = %q", inviter.Link)
+	}
+
+	// Переход по своей ссылке не засчитывается
+	if w := doAs(1, "ref_"+inviter.Code, http.MethodPost, "/prediction", predictionBody); w.Code != http.StatusOK {
+		t.Fatalf("own prediction: status %d, body: %s", w.Code, w.Body)
+	}
+	if w := doAs(2, "ref_"+inviter.Code, http.MethodPost, "/prediction", predictionBody); w.Code != http.StatusOK {
+		t.Fatalf("invitee prediction: status %d, body: %s", w.Code, w.Body)
+	}
+	// Повторные запросы сессии с тем же параметром запуска ничего не меняют
+	if w := doAs(2, "ref_"+inviter.Code, http.MethodPost, "/prediction", predictionBody); w.Code != http.StatusOK {
+		t.Fatalf("second invitee prediction: status %d, body: %s", w.Code, w.Body)
+	}
+
+	got := referrals(1)
+	if got.Invited != 1 || got.Activated != 1 || got.BonusEarned != 3 || got.BonusLeft != 3 {
+		t.Errorf("inviter stats = %+v", got)
+	}
+	if got := referrals(2); got.BonusLeft != 1 || got.Invited != 0 {
+		t.Errorf("invitee stats = %+v", got)
+	}
+}
+
+func TestTranscribe(t *testing.T) {
+	stt := testutil.NewSTT(t)
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.STT.Backend = config.STTOpenAI
+		cfg.STT.BaseURL = stt.URL
+		cfg.STT.MaxSize = 1024
+	})
+	send := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/transcribe", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		e.srv.ServeHTTP(w, r)
+		return w
+	}
+
+	// Только распознавание: запись в теле запроса
+	w := send("audio/ogg", []byte("OggS voice"))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), testutil.DefaultTranscript) {
+		t.Fatalf("transcribe: status %d, body: %s", w.Code, w.Body)
+	}
+	if req := stt.Requests()[0]; req.Filename != "voice.ogg" || string(req.Audio) != "OggS voice" {
+		t.Errorf("stt request = %+v", req)
+	}
+
+	// Распознанный вопрос запускает обычное предсказание
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("audio", "question.webm")
+	fw.Write([]byte("webm voice"))
+	mw.WriteField("state", `{"name":"Анна","birthDate":"1990-03-15","mode":"Карьера"}`)
+	mw.Close()
+	w = send(mw.FormDataContentType(), form.Bytes())
+	if w.Code != http.StatusOK {
+		t.Fatalf("transcribe with state: status %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Images) != 3 {
+		t.Fatalf("prediction response: %v, %d images", err, len(resp.Images))
+	}
+	prompt := e.llm.Requests()[0].Messages
+	if !strings.Contains(prompt[len(prompt)-1].Content, "Вопрос: "+testutil.DefaultTranscript) {
+		t.Errorf("prompt does not contain transcribed question")
+	}
+
+	if w := send("audio/ogg", bytes.Repeat([]byte("x"), 128<<10)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large audio: status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if w := send("text/plain", []byte("hello")); w.Code != http.StatusBadRequest {
+		t.Errorf("text body: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	stt.Reply("", 0)
+	if w := send("audio/ogg", []byte("OggS silence")); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("silence: status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestTranscribeCost(t *testing.T) {
+	stt := testutil.NewSTT(t)
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.STT.Backend = config.STTOpenAI
+		cfg.STT.BaseURL = stt.URL
+		// Секунда записи стоит 1/60 доллара и исчерпывает дневной лимит
+		cfg.Usage.STTPrice = 1
+		cfg.Usage.Budget.Daily = 0.01
+	})
+	send := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/transcribe", bytes.NewReader(testutil.SilenceOpus))
+		r.Header.Set("Content-Type", "audio/ogg")
+		w := httptest.NewRecorder()
+		e.srv.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("first transcription: status %d", code)
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Errorf("transcription over budget: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if n := len(stt.Requests()); n != 1 {
+		t.Errorf("stt requests = %d, want 1", n)
+	}
+}
+
+func TestNarration(t *testing.T) {
+	tts := testutil.NewTTS(t)
+	e := newEnv(t, func(cfg *config.Config) {
+		cfg.TTS.Backend = config.TTSOpenAI
+		cfg.TTS.BaseURL = tts.URL
+	})
+	pred := e.predict(t)
+	path := "/predictions/" + pred.ConversationID + "/narration"
+
+	if w := e.do(t, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("narration before POST: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := e.do(t, http.MethodPost, path, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "audio/ogg; codecs=opus" || !bytes.HasPrefix(w.Body.Bytes(), []byte("OggS")) {
+		t.Fatalf("narration: status %d, type %q, %d bytes", w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+	}
+	calls := len(tts.Requests())
+	if calls == 0 || w.Header().Get(server.NarrationDurationHeader) != strconv.Itoa(calls)+".0" {
+		t.Errorf("%d tts requests, duration %q", calls, w.Header().Get(server.NarrationDurationHeader))
+	}
+
+	// Повторные запросы отдают сохраненную запись без синтеза
+	if w := e.do(t, http.MethodGet, path, ""); w.Code != http.StatusOK || !bytes.HasPrefix(w.Body.Bytes(), []byte("OggS")) {
+		t.Errorf("stored narration: status %d", w.Code)
+	}
+	if w := e.do(t, http.MethodPost, path, ""); w.Code != http.StatusOK || len(tts.Requests()) != calls {
+		t.Errorf("repeated POST: status %d, %d tts requests", w.Code, len(tts.Requests()))
+	}
+
+	// Переписанный текст озвучивается заново
+	e.llm.Push(testutil.Reply{Content: "Коротко: звезды благосклонны."})
+	if w := e.do(t, http.MethodPost, "/predictions/"+pred.ConversationID+"/rewrite", `{"style":"shorter"}`); w.Code != http.StatusOK {
+		t.Fatalf("rewrite: status %d", w.Code)
+	}
+	if w := e.do(t, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("stale narration: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	tts.Fail(http.StatusInternalServerError)
+	if w := e.do(t, http.MethodPost, path, ""); w.Code != http.StatusBadGateway {
+		t.Errorf("tts failure: status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if w := e.do(t, http.MethodPost, "/predictions/unknown/narration", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown prediction: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestPhotoPrediction(t *testing.T) {
+	e := newEnv(t)
+	var palm bytes.Buffer
+	jpeg.Encode(&palm, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil)
+	send := func(state string, photo []byte) *httptest.ResponseRecorder {
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		mw.WriteField("state", state)
+		if photo != nil {
+			fw, _ := mw.CreateFormFile("photo", "palm.jpg")
+			fw.Write(photo)
+		}
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/prediction", &form)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		e.srv.ServeHTTP(w, r)
+		return w
+	}
+	state := `{"name":"Анна","birthDate":"1990-03-15","question":"Что меня ждет?","mode":"Хиромантия"}`
+
+	w := send(state, palm.Bytes())
+	if w.Code != http.StatusOK {
+		t.Fatalf("photo prediction: status %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Images) != 3 {
+		t.Fatalf("prediction response: %v, %d images", err, len(resp.Images))
+	}
+	messages := e.llm.Requests()[0].Messages
+	last := messages[len(messages)-1]
+	if len(last.Images) != 1 || !strings.HasPrefix(last.Images[0], "data:image/jpeg;base64,") || !strings.Contains(last.Content, "ладони") {
+		t.Errorf("model request: %d images, prompt %q", len(last.Images), last.Content)
+	}
+
+	// Уточняющий вопрос отправляется без фотографии
+	e.llm.Push(testutil.Reply{Content: "Уточнение."})
+	if w := e.do(t, http.MethodPost, "/conversations/"+resp.ConversationID+"/messages", `{"question":"А линия сердца?"}`); w.Code != http.StatusOK {
+		t.Fatalf("follow-up: status %d, body: %s", w.Code, w.Body)
+	}
+	for _, m := range e.llm.Requests()[1].Messages {
+		if len(m.Images) > 0 {
+			t.Error("follow-up resent the photo")
+		}
+	}
+
+	if w := send(state, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("no photo: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := send(state, []byte("GIF89a\x01\x00\x01\x00")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("gif: status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestDecision(t *testing.T) {
+	e := newEnv(t)
+	e.llm.Push(testutil.Reply{Content: "```json\n" + `{"options": [
+		{"index": 1, "pros": ["стабильность"], "cons": ["застой"], "energy": 4, "summary": "Башня трясет опору.", "image": "tower"},
+		{"index": 2, "pros": ["рост"], "cons": ["риск"], "energy": 8, "summary": "Звезда ведет.", "image": "star"}
+	], "verdict": "Карты за переезд."}` + "\n```"})
+	w := e.do(t, http.MethodPost, "/prediction",
+		`{"name":"Анна","birthDate":"1990-03-15","question":"Куда двигаться?","mode":"Принятие решений","options":["Остаться"," Переехать ",""]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("decision: status %d, body: %s", w.Code, w.Body)
+	}
+	var resp common.PredictionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Decision == nil || len(resp.Decision.Options) != 2 || len(resp.Images) != 2 {
+		t.Fatalf("decision %+v, %d images", resp.Decision, len(resp.Images))
+	}
+	if top := resp.Decision.Options[0]; top.Option != "Переехать" || top.Rank != 1 || top.Card.Name == "" || resp.Prompts[0] != "star" {
+		t.Errorf("top option %+v, prompts %q", top, resp.Prompts)
+	}
+	if !strings.Contains(resp.Text, "1. Переехать") || !strings.Contains(resp.Text, "решение и ответственность") || !strings.Contains(resp.Decision.Verdict, "решение и ответственность") {
+		t.Errorf("text:\n%s", resp.Text)
+	}
+	messages := e.llm.Requests()[0].Messages
+	if prompt := messages[len(messages)-1].Content; !strings.Contains(prompt, "Вариант 2: Переехать. Карта:") {
+		t.Errorf("prompt does not list the options:\n%s", prompt)
+	}
+
+	// Одного варианта мало; в других сферах варианты не нужны
+	w = e.do(t, http.MethodPost, "/prediction",
+		`{"name":"Анна","birthDate":"1990-03-15","question":"Куда?","mode":"Принятие решений","options":["Остаться"]}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("one option: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w = e.do(t, http.MethodPost, "/prediction",
+		`{"name":"Анна","birthDate":"1990-03-15","question":"Что ждет?","mode":"Карьера","options":["Остаться"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("career: status %d, body: %s", w.Code, w.Body)
+	}
+	resp = common.PredictionResponse{}
+	if json.Unmarshal(w.Body.Bytes(), &resp); resp.Decision != nil || len(resp.Images) != 3 {
+		t.Errorf("career answered as a decision: %+v, %d images", resp.Decision, len(resp.Images))
+	}
+}
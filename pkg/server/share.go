@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/share"
+)
+
+// shareResponse - ссылка на опубликованное предсказание
+type shareResponse struct {
+	Slug string `json:"slug"`
+	URL  string `json:"url"`
+	// PreparedMessageID - подготовленное сообщение для Telegram.WebApp.shareMessage;
+	// пусто вне Telegram или если Bot API недоступен
+	PreparedMessageID string `json:"preparedMessageId,omitempty"`
+}
+
+// HandleShare публикует предсказание по ссылке (POST) или отзывает
+// ссылку (DELETE). Публикуется текущая версия текста без уточнений,
+// нумерологии и персональных данных.
+func (s *Server) HandleShare(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.publish(w, r)
+	case http.MethodDelete:
+		s.revoke(w, r)
+	default:
+		http.Error(w, "Only POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) publish(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	thread, ok := s.conversation(w, r)
+	if !ok {
+		return
+	}
+	if thread.Reading() == "" {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	sh, err := s.shares.Publish(share.Reading{
+		PredictionID: thread.ID,
+		TelegramID:   thread.TelegramID,
+		Mode:         thread.Mode,
+		Text:         thread.Reading(),
+		Personal:     thread.Personal,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "share publish failed", slog.String("error", err.Error()))
+		http.Error(w, "Error sharing prediction", http.StatusInternalServerError)
+		return
+	}
+	metrics.Shares.WithLabelValues("created").Inc()
+
+	resp := shareResponse{Slug: sh.Slug, URL: s.shares.URL(baseURL(r), sh.Slug)}
+	// Подготовленное сообщение без ссылки на внешний адрес не откроется в чате
+	if s.preparer != nil && thread.TelegramID != 0 && s.shares.BaseURL() != "" {
+		resp.PreparedMessageID, err = s.preparer.Prepare(thread.TelegramID, sh, resp.URL)
+		if err != nil {
+			slog.WarnContext(ctx, "prepared message failed", slog.String("error", err.Error()))
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	telegramID, err := s.telegramID(r)
+	if err != nil {
+		http.Error(w, "Invalid Telegram init data", http.StatusUnauthorized)
+		return
+	}
+	// Ссылку можно отозвать и после того, как истек срок хранения диалога
+	err = s.shares.Revoke(r.PathValue("id"), telegramID)
+	if errors.Is(err, share.ErrNotFound) {
+		http.Error(w, "Share not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "share revoke failed", slog.String("error", err.Error()))
+		http.Error(w, "Error revoking share", http.StatusInternalServerError)
+		return
+	}
+	metrics.Shares.WithLabelValues("revoked").Inc()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSharePage отдает публичную страницу предсказания
+func (s *Server) HandleSharePage(w http.ResponseWriter, r *http.Request) {
+	sh, ok := s.sharedPrediction(w, r)
+	if !ok {
+		return
+	}
+	metrics.Shares.WithLabelValues("viewed").Inc()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := sh.Render(w, s.shares.URL(baseURL(r), sh.Slug), s.cfg.Telegram.WebAppURL); err != nil {
+		slog.ErrorContext(r.Context(), "share page render failed", slog.String("error", err.Error()))
+	}
+}
+
+// HandleShareImage отдает коллаж карт опубликованного предсказания
+func (s *Server) HandleShareImage(w http.ResponseWriter, r *http.Request) {
+	sh, ok := s.sharedPrediction(w, r)
+	if !ok {
+		return
+	}
+	if len(sh.Image) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(sh.Image)
+}
+
+// sharedPrediction загружает публикацию из пути запроса или отвечает 404
+func (s *Server) sharedPrediction(w http.ResponseWriter, r *http.Request) (*share.Share, bool) {
+	sh, err := s.shares.Get(r.PathValue("slug"))
+	if errors.Is(err, share.ErrNotFound) {
+		http.Error(w, "Ссылка не найдена или отозвана", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "share load failed", slog.String("error", err.Error()))
+		http.Error(w, "Error loading share", http.StatusInternalServerError)
+		return nil, false
+	}
+	return sh, true
+}
+
+// saveCards сохраняет карты предсказания для коллажа публикации
+func (s *Server) saveCards(ctx context.Context, predictionID string, images [][]byte) {
+	if s.shares == nil || predictionID == "" {
+		return
+	}
+	if err := s.shares.SaveCards(predictionID, images); err != nil {
+		slog.ErrorContext(ctx, "share cards save failed", slog.String("error", err.Error()))
+	}
+}
+
+// baseURL возвращает адрес сервера, по которому пришел запрос
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/horoscope"
+)
+
+// subscriptionRequest - параметры подписки из мини-приложения
+type subscriptionRequest struct {
+	Name         string `json:"name"`
+	BirthDate    string `json:"birthDate"`
+	DeliveryTime string `json:"deliveryTime"`
+	TimeZone     string `json:"timeZone"`
+}
+
+// HandleSubscription управляет подпиской на ежедневный гороскоп:
+// GET возвращает подписку, PUT создает или обновляет ее, DELETE отключает.
+// Пользователь определяется только по подписанным данным запуска мини-приложения.
+func (s *Server) HandleSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := s.subscriber(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sub, err := s.subs.Get(userID)
+		if errors.Is(err, horoscope.ErrNotSubscribed) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "subscription load failed", slog.String("error", err.Error()))
+			http.Error(w, "Error loading subscription", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, sub)
+
+	case http.MethodPut:
+		var req subscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+			return
+		}
+		sub, err := s.subs.Subscribe(horoscope.Subscription{
+			UserID:       userID,
+			Name:         req.Name,
+			BirthDate:    req.BirthDate,
+			DeliveryTime: req.DeliveryTime,
+			TimeZone:     req.TimeZone,
+		}, time.Now())
+		if errors.Is(err, horoscope.ErrInvalidSubscription) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "subscribe failed", slog.String("error", err.Error()))
+			http.Error(w, "Error saving subscription", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(ctx, "horoscope subscribed", slog.String("time", sub.DeliveryTime), slog.String("tz", sub.TimeZone))
+		writeJSON(w, http.StatusOK, sub)
+
+	case http.MethodDelete:
+		err := s.subs.Unsubscribe(userID, time.Now())
+		if err != nil && !errors.Is(err, horoscope.ErrNotSubscribed) {
+			slog.ErrorContext(ctx, "unsubscribe failed", slog.String("error", err.Error()))
+			http.Error(w, "Error saving subscription", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSubscriptionSkip пропускает ближайший гороскоп
+func (s *Server) HandleSubscriptionSkip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := s.subscriber(w, r)
+	if !ok {
+		return
+	}
+
+	date, err := s.subs.Skip(userID, time.Now())
+	if errors.Is(err, horoscope.ErrNotSubscribed) {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "skip failed", slog.String("error", err.Error()))
+		http.Error(w, "Error saving subscription", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"skipDate": date})
+}
+
+// subscriber возвращает ID пользователя Telegram или отвечает 401
+func (s *Server) subscriber(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := s.telegramID(r)
+	if err != nil || userID == 0 {
+		http.Error(w, "Telegram init data required", http.StatusUnauthorized)
+		return 0, false
+	}
+	if s.banned(w, r, userID) {
+		return 0, false
+	}
+	return userID, true
+}
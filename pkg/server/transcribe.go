@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+// formOverhead - запас на поля multipart-формы сверх размера аудио
+const formOverhead = 64 << 10
+
+// audioExtensions - расширения файлов для бэкенда распознавания по типу тела запроса
+var audioExtensions = map[string]string{
+	"audio/ogg":   ".ogg",
+	"audio/opus":  ".ogg",
+	"audio/webm":  ".webm",
+	"audio/mpeg":  ".mp3",
+	"audio/mp4":   ".m4a",
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+}
+
+// transcribeResponse - распознанный вопрос
+type transcribeResponse struct {
+	Text string `json:"text"`
+}
+
+// HandleTranscribe распознает голосовой вопрос. Запись передается телом
+// запроса (audio/ogg и т.п.) или полем audio multipart-формы. Если в форме
+// есть поле state (JSON как в POST /prediction), распознанный текст
+// становится вопросом и ответ совпадает с ответом POST /prediction,
+// иначе возвращается только текст.
+func (s *Server) HandleTranscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	telegramID, err := s.telegramID(r)
+	if err != nil {
+		http.Error(w, "Invalid Telegram init data", http.StatusUnauthorized)
+		return
+	}
+	if s.banned(w, r, telegramID) {
+		return
+	}
+	// Распознавание платное, поэтому бюджет проверяется до него
+	if _, err := s.usage.Model(usage.User(telegramID)); errors.Is(err, usage.ErrBudgetExceeded) {
+		metrics.BudgetExceeded.WithLabelValues("refused").Inc()
+		http.Error(w, "Лимит предсказаний исчерпан, попробуйте позже", http.StatusTooManyRequests)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.cfg.STT.MaxSize)+formOverhead)
+	audio, filename, rawState, err := s.readAudio(r)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Запись слишком длинная", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var state common.UserState
+	if rawState != "" {
+		if err := json.Unmarshal([]byte(rawState), &state); err != nil {
+			http.Error(w, "Invalid JSON in state field", http.StatusBadRequest)
+			return
+		}
+	}
+
+	text, err := s.stt.Transcribe(ctx, audio, filename)
+	// Распознавание оплачено, даже если в записи нет речи
+	if err == nil || errors.Is(err, speech.ErrNoSpeech) {
+		s.usage.RecordSTT(ctx, usage.User(telegramID), speech.AudioDuration(audio))
+	}
+	switch {
+	case errors.Is(err, speech.ErrNoSpeech):
+		http.Error(w, "Не удалось разобрать вопрос, попробуйте еще раз", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, resilience.ErrCircuitOpen):
+		http.Error(w, "Распознавание речи временно недоступно, попробуйте позже", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "transcription failed", slog.String("error", err.Error()))
+		http.Error(w, "Не удалось распознать речь, попробуйте позже", http.StatusBadGateway)
+		return
+	}
+
+	if rawState == "" {
+		writeJSON(w, http.StatusOK, transcribeResponse{Text: text})
+		return
+	}
+	state.Question = text
+	s.predict(w, r, state)
+}
+
+// readAudio читает запись и необязательное поле state из тела запроса
+func (s *Server) readAudio(r *http.Request) (audio []byte, filename, state string, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		ext, ok := audioExtensions[mediaType]
+		if !ok {
+			return nil, "", "", errors.New("Ожидается аудио (audio/ogg) или multipart-форма с полем audio")
+		}
+		audio, err = io.ReadAll(r.Body)
+		if err == nil && len(audio) == 0 {
+			err = errors.New("Пустая запись")
+		}
+		return audio, "voice" + ext, "", err
+	}
+
+	if err := r.ParseMultipartForm(int64(s.cfg.STT.MaxSize)); err != nil {
+		return nil, "", "", err
+	}
+	file, header, err := r.FormFile("audio")
+	if err != nil {
+		return nil, "", "", errors.New("Нет поля audio с записью")
+	}
+	defer file.Close()
+	if audio, err = io.ReadAll(file); err != nil {
+		return nil, "", "", err
+	}
+	if len(audio) == 0 {
+		return nil, "", "", errors.New("Пустая запись")
+	}
+	filename = header.Filename
+	if !strings.Contains(filename, ".") {
+		filename = "voice.ogg"
+	}
+	return audio, filename, r.FormValue("state"), nil
+}
@@ -0,0 +1,120 @@
+package share
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// Размеры коллажа подобраны под превью Open Graph (1.91:1), карты - в
+// пропорции игральной карты 2:3
+const (
+	collageWidth  = 1200
+	collageHeight = 630
+	cardWidth     = 340
+	cardHeight    = 510
+	cardGap       = 40
+	frameWidth    = 4
+	jpegQuality   = 85
+)
+
+var (
+	background = color.RGBA{0x1a, 0x10, 0x33, 0xff}
+	emptyCard  = color.RGBA{0x2e, 0x22, 0x52, 0xff}
+	frame      = color.RGBA{0xd4, 0xaf, 0x37, 0xff}
+)
+
+// errNoCards - ни одна карта не декодировалась
+var errNoCards = errors.New("нет карт для коллажа")
+
+// Collage раскладывает до трех карт на холсте 1200x630 и возвращает JPEG.
+// Карта, которую не удалось декодировать, заменяется пустой рамкой.
+func Collage(cards [][]byte) ([]byte, error) {
+	canvas := image.NewRGBA(image.Rect(0, 0, collageWidth, collageHeight))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+
+	n := min(len(cards), 3)
+	left := (collageWidth - 3*cardWidth - 2*cardGap) / 2
+	top := (collageHeight - cardHeight) / 2
+	drawn := 0
+	for i := range 3 {
+		slot := image.Rect(0, 0, cardWidth, cardHeight).Add(image.Pt(left+i*(cardWidth+cardGap), top))
+		draw.Draw(canvas, slot.Inset(-frameWidth), &image.Uniform{frame}, image.Point{}, draw.Src)
+		draw.Draw(canvas, slot, &image.Uniform{emptyCard}, image.Point{}, draw.Src)
+		if i >= n || len(cards[i]) == 0 {
+			continue
+		}
+		img, _, err := image.Decode(bytes.NewReader(cards[i]))
+		if err != nil {
+			continue
+		}
+		draw.Draw(canvas, slot, scale(img, cover(img.Bounds(), cardWidth, cardHeight), cardWidth, cardHeight), image.Point{}, draw.Src)
+		drawn++
+	}
+	if drawn == 0 {
+		return nil, errNoCards
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, fmt.Errorf("ошибка кодирования коллажа: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// thumbnail уменьшает изображение до размера карты коллажа. Для
+// изображения, которое не удалось декодировать, возвращает nil.
+func thumbnail(data []byte) []byte {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scale(img, cover(img.Bounds(), cardWidth, cardHeight), cardWidth, cardHeight), &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// cover возвращает центральную часть b с пропорцией w:h, чтобы карта
+// заполнила ячейку без искажений
+func cover(b image.Rectangle, w, h int) image.Rectangle {
+	cw, ch := b.Dx(), b.Dy()
+	if cw*h > ch*w {
+		cw = ch * w / h
+	} else {
+		ch = cw * h / w
+	}
+	x := b.Min.X + (b.Dx()-cw)/2
+	y := b.Min.Y + (b.Dy()-ch)/2
+	return image.Rect(x, y, x+max(cw, 1), y+max(ch, 1))
+}
+
+// scale масштабирует область src изображения img до w x h усреднением
+// пикселей: при уменьшении в несколько раз это заметно чище ближайшего соседа
+func scale(img image.Image, src image.Rectangle, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := max(src.Min.Y+(y+1)*src.Dy()/h, y0+1)
+		for x := range w {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := max(src.Min.X+(x+1)*src.Dx()/w, x0+1)
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+cr, g+cg, b+cb, a+ca
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,69 @@
+package share
+
+import (
+	_ "embed"
+	"html/template"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed page.html
+var pageHTML string
+
+var page = template.Must(template.New("page").Parse(pageHTML))
+
+// descriptionLength - длина описания в превью ссылки, в символах
+const descriptionLength = 200
+
+// pageData - данные публичной страницы
+type pageData struct {
+	Title       string
+	Description string
+	URL         string
+	ImageURL    string
+	Paragraphs  []string
+	// AppURL - ссылка на мини-приложение для своего предсказания
+	AppURL string
+}
+
+// Title возвращает заголовок публикации
+func (sh *Share) Title() string {
+	if sh.Mode == "" {
+		return "Предсказание"
+	}
+	return "Предсказание: " + sh.Mode
+}
+
+// Render отрисовывает страницу публикации с тегами Open Graph; url - адрес
+// самой страницы, appURL - ссылка на мини-приложение, может быть пустой
+func (sh *Share) Render(w io.Writer, url, appURL string) error {
+	data := pageData{
+		Title:       sh.Title(),
+		Description: Excerpt(sh.Text, descriptionLength),
+		URL:         url,
+		AppURL:      appURL,
+	}
+	if len(sh.Image) > 0 {
+		data.ImageURL = url + "/image.jpg"
+	}
+	for _, p := range strings.Split(sh.Text, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			data.Paragraphs = append(data.Paragraphs, p)
+		}
+	}
+	return page.Execute(w, data)
+}
+
+// Excerpt возвращает начало текста не длиннее n символов, обрезанное по слову
+func Excerpt(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	cut := string([]rune(text)[:n])
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,97 @@
+package share
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Placeholder заменяет персональные данные в опубликованном тексте
+const Placeholder = "•••"
+
+var (
+	// words - слова текста; \b в regexp работает только с ASCII
+	words = regexp.MustCompile(`[\p{L}\p{N}]+`)
+	// dates - даты вида 15.03.1990, 15/03/90, 1990-03-15 и "15 марта 1990 года"
+	dates = regexp.MustCompile(`\b(\d{1,2}[./]\d{1,2}[./]\d{2,4}|\d{4}-\d{2}-\d{2})\b|\d{1,2}\s+(январ|феврал|март|апрел|ма|июн|июл|август|сентябр|октябр|ноябр|декабр)[а-я]*\s+\d{4}(\s+г(ода|\.)?)?`)
+	// mentions - имена пользователей Telegram
+	mentions = regexp.MustCompile(`@[A-Za-z0-9_]{5,}`)
+)
+
+// maxEnding - сколько букв окончания допускается после основы слова:
+// основа "анн" находит "Анне" и "Анной"
+const maxEnding = 3
+
+// Redact убирает из текста персональные данные: слова personal в любом
+// падеже, полные даты и упоминания @username. Числа из personal не ищутся:
+// дату рождения модель может записать по-своему, ее находит правило для дат.
+func Redact(text string, personal []string) string {
+	var stems []string
+	var short []shortWord
+	for _, p := range personal {
+		fieldWords := words.FindAllString(p, -1)
+		for _, w := range fieldWords {
+			switch {
+			case isDigits(w):
+			case utf8.RuneCountInString(w) >= 3:
+				stems = append(stems, stem(strings.ToLower(w)))
+			// Предлог в названии места ("Ростов-на-Дону") не имя, а короткое
+			// имя (Ян, Ия, Ли) ищется как целое слово
+			case len(fieldWords) == 1 || capitalized(w):
+				short = append(short, shortWord{strings.ToLower(w), capitalized(w)})
+			}
+		}
+	}
+
+	text = dates.ReplaceAllString(text, Placeholder)
+	text = mentions.ReplaceAllString(text, Placeholder)
+	if len(stems) == 0 && len(short) == 0 {
+		return text
+	}
+	return words.ReplaceAllStringFunc(text, func(w string) string {
+		lw := strings.ToLower(w)
+		for _, s := range stems {
+			if strings.HasPrefix(lw, s) && utf8.RuneCountInString(lw)-utf8.RuneCountInString(s) <= maxEnding {
+				return Placeholder
+			}
+		}
+		for _, s := range short {
+			// Имя с заглавной не совпадает с частицей: "Ли" и "стоит ли"
+			if lw == s.word && (!s.capitalized || capitalized(w)) {
+				return Placeholder
+			}
+		}
+		return w
+	})
+}
+
+// shortWord - короткое слово personal, которое ищется без склонения
+type shortWord struct {
+	word        string
+	capitalized bool
+}
+
+func capitalized(w string) bool {
+	r, _ := utf8.DecodeRuneInString(w)
+	return unicode.IsUpper(r)
+}
+
+// stem отбрасывает гласную, й или ь в конце слова: окончание меняется при
+// склонении. Короткие слова остаются как есть.
+func stem(w string) string {
+	r, size := utf8.DecodeLastRuneInString(w)
+	if utf8.RuneCountInString(w) > 3 && strings.ContainsRune("аеёиоуыэюяйь", r) {
+		return w[:len(w)-size]
+	}
+	return w
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+// Package share публикует предсказания по ссылке: пользователь сам решает
+// поделиться, и по неугадываемому адресу открывается страница с текстом без
+// персональных данных и коллажем карт. Ссылку можно отозвать.
+package share
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+const (
+	// collection - опубликованные предсказания по адресу
+	collection = "shares"
+	// indexCollection - адрес публикации по идентификатору предсказания
+	indexCollection = "shares_by_prediction"
+	// cardsCollection - уменьшенные карты предсказаний, из которых
+	// собирается коллаж при публикации
+	cardsCollection = "cards"
+)
+
+// ErrNotFound - ссылка не найдена, отозвана или принадлежит другому пользователю
+var ErrNotFound = errors.New("ссылка не найдена")
+
+// Share - опубликованное предсказание
+type Share struct {
+	Slug         string `json:"slug"`
+	PredictionID string `json:"predictionId"`
+	TelegramID   int64  `json:"telegramId,omitempty"`
+	Mode         string `json:"mode"`
+	// Text - текст предсказания без персональных данных
+	Text string `json:"text"`
+	// Image - коллаж карт в JPEG; пуст, если карт не сохранилось
+	Image     []byte    `json:"image,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Reading - предсказание, которое публикует пользователь
+type Reading struct {
+	PredictionID string
+	TelegramID   int64
+	Mode         string
+	Text         string
+	// Personal - имена, даты и место рождения, которые убираются из текста
+	Personal []string
+}
+
+// cards - карты предсказания, уменьшенные для коллажа
+type cards struct {
+	Images    [][]byte  `json:"images"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Service хранит опубликованные предсказания и карты для коллажей
+type Service struct {
+	store   *store.Store
+	baseURL string
+	// cardsTTL - сколько хранить карты неопубликованного предсказания
+	cardsTTL time.Duration
+	now      func() time.Time
+}
+
+// NewService создает сервис. baseURL - внешний адрес приложения для ссылок,
+// пустой - адрес определяется по запросу. Карты хранятся cardsTTL: столько
+// же, сколько диалог, в котором предсказание можно опубликовать.
+func NewService(st *store.Store, baseURL string, cardsTTL time.Duration) *Service {
+	return &Service{store: st, baseURL: strings.TrimRight(baseURL, "/"), cardsTTL: cardsTTL, now: time.Now}
+}
+
+// BaseURL возвращает настроенный внешний адрес приложения
+func (s *Service) BaseURL() string {
+	return s.baseURL
+}
+
+// URL возвращает адрес страницы публикации; base используется, если
+// внешний адрес не настроен
+func (s *Service) URL(base, slug string) string {
+	if s.baseURL != "" {
+		base = s.baseURL
+	}
+	return strings.TrimRight(base, "/") + "/r/" + slug
+}
+
+// SaveCards сохраняет уменьшенные карты предсказания. Неудачная карта
+// остается пустой и в коллаже заменяется фоном.
+func (s *Service) SaveCards(predictionID string, images [][]byte) error {
+	c := cards{Images: make([][]byte, len(images)), UpdatedAt: s.now()}
+	for i, img := range images {
+		c.Images[i] = thumbnail(img)
+	}
+	s.prune()
+	return s.store.Put(cardsCollection, predictionID, &c)
+}
+
+// SetCard заменяет карту index после перегенерации изображения
+func (s *Service) SetCard(predictionID string, index int, image []byte) error {
+	thumb := thumbnail(image)
+	var c cards
+	return s.store.Update(cardsCollection, predictionID, &c, func(bool) error {
+		for len(c.Images) <= index {
+			c.Images = append(c.Images, nil)
+		}
+		c.Images[index] = thumb
+		c.UpdatedAt = s.now()
+		return nil
+	})
+}
+
+// Publish публикует предсказание или обновляет уже опубликованное, сохраняя
+// адрес: после переписывания или новой карты по ссылке видна новая версия
+func (s *Service) Publish(r Reading) (*Share, error) {
+	sh, err := s.ByPrediction(r.PredictionID, r.TelegramID)
+	if errors.Is(err, ErrNotFound) {
+		sh = &Share{Slug: common.NewID(), PredictionID: r.PredictionID, TelegramID: r.TelegramID, CreatedAt: s.now()}
+	} else if err != nil {
+		return nil, err
+	}
+	sh.Mode = r.Mode
+	sh.Text = Redact(r.Text, r.Personal)
+	sh.UpdatedAt = s.now()
+
+	var c cards
+	ok, err := s.store.Get(cardsCollection, r.PredictionID, &c)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if sh.Image, err = Collage(c.Images); err != nil {
+			slog.Warn("share collage failed", slog.String("error", err.Error()))
+		}
+	}
+	if err := s.store.Put(collection, sh.Slug, sh); err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(indexCollection, sh.PredictionID, sh.Slug); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// Get возвращает публикацию по адресу
+func (s *Service) Get(slug string) (*Share, error) {
+	var sh Share
+	ok, err := s.store.Get(collection, slug, &sh)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &sh, nil
+}
+
+// ByPrediction возвращает публикацию предсказания. Как и диалог, публикация
+// предсказания из Telegram доступна только его автору.
+func (s *Service) ByPrediction(predictionID string, telegramID int64) (*Share, error) {
+	var slug string
+	ok, err := s.store.Get(indexCollection, predictionID, &slug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	sh, err := s.Get(slug)
+	if err != nil {
+		return nil, err
+	}
+	if sh.TelegramID != 0 && sh.TelegramID != telegramID {
+		return nil, ErrNotFound
+	}
+	return sh, nil
+}
+
+// ForUser возвращает публикации пользователя Telegram, новые первыми
+func (s *Service) ForUser(telegramID int64, limit int) ([]*Share, error) {
+	var shares []*Share
+	err := store.Each(s.store, collection, func(_ string, sh *Share) error {
+		if sh.TelegramID == telegramID {
+			shares = append(shares, sh)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].UpdatedAt.After(shares[j].UpdatedAt) })
+	if len(shares) > limit {
+		shares = shares[:limit]
+	}
+	return shares, nil
+}
+
+// Revoke отзывает публикацию предсказания: ссылка перестает открываться
+func (s *Service) Revoke(predictionID string, telegramID int64) error {
+	sh, err := s.ByPrediction(predictionID, telegramID)
+	if err != nil {
+		return err
+	}
+	s.store.Delete(collection, sh.Slug)
+	s.store.Delete(indexCollection, predictionID)
+	return nil
+}
+
+// prune удаляет карты предсказаний, которые уже нельзя опубликовать
+func (s *Service) prune() {
+	err := store.Each(s.store, cardsCollection, func(key string, c *cards) error {
+		if s.cardsTTL > 0 && s.now().Sub(c.UpdatedAt) > s.cardsTTL {
+			s.store.Delete(cardsCollection, key)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("share cards prune failed", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,156 @@
+package share_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/share"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+func TestRedact(t *testing.T) {
+	personal := []string{"Анна", "1990-03-15", "Сергей", "Нижний Новгород"}
+	tests := []struct {
+		text, want string
+	}{
+		{"Анна, звезды благосклонны.", "•••, звезды благосклонны."},
+		{"Анне и Сергею стоит поговорить", "••• и ••• стоит поговорить"},
+		{"С Анной все будет хорошо", "С ••• все будет хорошо"},
+		{"Вы родились 15.03.1990 в городе Нижний Новгород", "Вы родились ••• в городе ••• •••"},
+		{"Дата 15 марта 1990 года важна", "Дата ••• важна"},
+		{"Напишите @anna_stars", "Напишите •••"},
+		// Похожие слова с длинным окончанием и даты без года не трогаем
+		{"Аннотация к 15 марта", "Аннотация к 15 марта"},
+	}
+	for _, tt := range tests {
+		if got := share.Redact(tt.text, personal); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// Короткие имена ищутся как целые слова, а предлоги в названии места не трогаются
+	personal = []string{"Ян", "Ли", "Ростов-на-Дону"}
+	tests = []struct {
+		text, want string
+	}{
+		{"Ян, вас ждет встреча. Стоит ли ждать, Ли?", "•••, вас ждет встреча. Стоит ли ждать, •••?"},
+		{"Янтарь на столе, путь из Ростова-на-Дону", "Янтарь на столе, путь из •••-на-•••"},
+	}
+	for _, tt := range tests {
+		if got := share.Redact(tt.text, personal); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func pngImage(t *testing.T, w, h int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCollage(t *testing.T) {
+	red := pngImage(t, 64, 64, color.RGBA{0xff, 0, 0, 0xff})
+	data, err := share.Collage([][]byte{red, []byte("broken"), red})
+	if err != nil {
+		t.Fatalf("Collage: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode collage: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 1200 || b.Dy() != 630 {
+		t.Errorf("collage size = %v, want 1200x630", b)
+	}
+	// Центр первой карты - красный, второй - пустая карта
+	if r, g, _, _ := img.At(240, 315).RGBA(); r>>8 < 0xe0 || g>>8 > 0x20 {
+		t.Errorf("first card is not red: %v", img.At(240, 315))
+	}
+	if r, _, _, _ := img.At(600, 315).RGBA(); r>>8 > 0x60 {
+		t.Errorf("broken card is not empty: %v", img.At(600, 315))
+	}
+
+	if _, err := share.Collage([][]byte{nil, []byte("broken")}); err == nil {
+		t.Error("Collage without decodable cards: want error")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := share.NewService(st, "https://example.com/", time.Hour)
+	card := pngImage(t, 32, 48, color.White)
+	if err := s.SaveCards("p1", [][]byte{card, card, card}); err != nil {
+		t.Fatalf("SaveCards: %v", err)
+	}
+
+	reading := share.Reading{PredictionID: "p1", TelegramID: 42, Mode: "Любовь", Text: "Анна, вас ждет встреча.", Personal: []string{"Анна"}}
+	sh, err := s.Publish(reading)
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if sh.Text != "•••, вас ждет встреча." || len(sh.Image) == 0 {
+		t.Errorf("share = %q, image %d bytes", sh.Text, len(sh.Image))
+	}
+	if got := s.URL("http://ignored", sh.Slug); got != "https://example.com/r/"+sh.Slug {
+		t.Errorf("URL = %q", got)
+	}
+
+	// Повторная публикация обновляет текст и сохраняет адрес
+	reading.Text = "Новая версия"
+	again, err := s.Publish(reading)
+	if err != nil || again.Slug != sh.Slug || again.Text != "Новая версия" {
+		t.Errorf("republish = %+v, %v", again, err)
+	}
+	if shares, _ := s.ForUser(42, 10); len(shares) != 1 {
+		t.Errorf("ForUser = %d shares, want 1", len(shares))
+	}
+
+	if err := s.Revoke("p1", 7); !errors.Is(err, share.ErrNotFound) {
+		t.Errorf("Revoke by another user = %v, want ErrNotFound", err)
+	}
+	if err := s.Revoke("p1", 42); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := s.Get(sh.Slug); !errors.Is(err, share.ErrNotFound) {
+		t.Errorf("Get after revoke = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	sh := &share.Share{Slug: "abc", Mode: "Карьера", Text: "Первый абзац <b>.\n\nВторой абзац.", Image: []byte{1}}
+	var buf bytes.Buffer
+	if err := sh.Render(&buf, "https://example.com/r/abc", "https://t.me/app"); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="Предсказание: Карьера">`,
+		`<meta property="og:image" content="https://example.com/r/abc/image.jpg">`,
+		`<p>Первый абзац &lt;b&gt;.</p>`,
+		`<p>Второй абзац.</p>`,
+		`href="https://t.me/app"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+}
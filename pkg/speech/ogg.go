@@ -0,0 +1,247 @@
+package speech
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Ogg/Opus: страницы Ogg (RFC 3533) с пакетами Opus (RFC 7845). Сегменты
+// озвучки склеиваются в один логический поток: заголовки берутся из первого
+// сегмента, аудиопакеты всех сегментов идут подряд, а позиции (granule)
+// пересчитываются, чтобы Telegram и браузеры видели одну запись.
+
+const (
+	oggHeaderSize = 27
+	// oggMaxPage - ориентир размера страницы при записи
+	oggMaxPage = 4096
+	// opusRate - частота, в которой считаются позиции Opus
+	opusRate = 48000
+	// oggSerial - номер логического потока склеенной записи
+	oggSerial = 0x4f505553
+
+	oggBOS = 0x02
+	oggEOS = 0x04
+)
+
+var errNotOpus = errors.New("ожидается поток Ogg/Opus")
+
+// opusStream - пакеты одного потока Ogg/Opus
+type opusStream struct {
+	head    []byte
+	packets [][]byte
+}
+
+// JoinOpus склеивает записи Ogg/Opus в одну и возвращает ее длительность
+func JoinOpus(segments [][]byte) ([]byte, time.Duration, error) {
+	if len(segments) == 0 {
+		return nil, 0, errNotOpus
+	}
+	var head []byte
+	var packets [][]byte
+	for i, seg := range segments {
+		s, err := readOpus(seg)
+		if err != nil {
+			return nil, 0, fmt.Errorf("сегмент %d: %w", i+1, err)
+		}
+		if head == nil {
+			head = s.head
+		} else if s.head[9] != head[9] {
+			return nil, 0, fmt.Errorf("сегмент %d: другое число каналов", i+1)
+		}
+		packets = append(packets, s.packets...)
+	}
+	return writeOpus(head, packets)
+}
+
+// readOpus разбирает страницы Ogg первого логического потока на пакеты
+// и отбрасывает заголовки OpusHead и OpusTags
+func readOpus(data []byte) (*opusStream, error) {
+	var all [][]byte
+	var packet []byte
+	var serial uint32
+	for first := true; len(data) > 0; first = false {
+		if len(data) < oggHeaderSize || !bytes.Equal(data[:4], []byte("OggS")) {
+			return nil, errNotOpus
+		}
+		segments := int(data[26])
+		if len(data) < oggHeaderSize+segments {
+			return nil, errNotOpus
+		}
+		lacing := data[oggHeaderSize : oggHeaderSize+segments]
+		size := 0
+		for _, l := range lacing {
+			size += int(l)
+		}
+		body := data[oggHeaderSize+segments:]
+		if len(body) < size {
+			return nil, errNotOpus
+		}
+		pageSerial := binary.LittleEndian.Uint32(data[14:18])
+		if first {
+			serial = pageSerial
+		}
+		if pageSerial == serial {
+			for _, l := range lacing {
+				packet = append(packet, body[:l]...)
+				body = body[l:]
+				if l < 255 {
+					all = append(all, packet)
+					packet = nil
+				}
+			}
+		}
+		data = data[oggHeaderSize+segments+size:]
+	}
+	if len(all) < 2 || len(all[0]) < 19 || !bytes.HasPrefix(all[0], []byte("OpusHead")) || !bytes.HasPrefix(all[1], []byte("OpusTags")) {
+		return nil, errNotOpus
+	}
+	return &opusStream{head: all[0], packets: all[2:]}, nil
+}
+
+// opusDuration возвращает длительность потока Ogg/Opus так же, как JoinOpus
+func opusDuration(data []byte) (time.Duration, error) {
+	s, err := readOpus(data)
+	if err != nil {
+		return 0, err
+	}
+	var samples int64
+	for _, p := range s.packets {
+		n, err := opusSamples(p)
+		if err != nil {
+			return 0, err
+		}
+		samples += int64(n)
+	}
+	return time.Duration(samples) * time.Second / opusRate, nil
+}
+
+// writeOpus записывает поток Ogg/Opus из заголовка и аудиопакетов
+func writeOpus(head []byte, packets [][]byte) ([]byte, time.Duration, error) {
+	w := oggWriter{}
+	w.page([][]byte{head}, oggBOS, 0)
+	w.page([][]byte{opusTags()}, 0, 0)
+
+	preSkip := int64(binary.LittleEndian.Uint16(head[10:12]))
+	granule := preSkip
+	var page [][]byte
+	pageSize, pageSegments := 0, 0
+	for i, p := range packets {
+		samples, err := opusSamples(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		segs := len(p)/255 + 1
+		if len(page) > 0 && (pageSegments+segs > 255 || pageSize+len(p) > oggMaxPage) {
+			w.page(page, 0, granule)
+			page, pageSize, pageSegments = nil, 0, 0
+		}
+		page = append(page, p)
+		pageSize += len(p)
+		pageSegments += segs
+		granule += int64(samples)
+		if i == len(packets)-1 {
+			w.page(page, oggEOS, granule)
+		}
+	}
+	if len(packets) == 0 {
+		w.page(nil, oggEOS, granule)
+	}
+	duration := time.Duration(granule-preSkip) * time.Second / opusRate
+	return w.buf.Bytes(), duration, nil
+}
+
+// opusTags - заголовок с комментариями без тегов
+func opusTags() []byte {
+	vendor := "telegram-mini-app"
+	b := []byte("OpusTags")
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vendor)))
+	b = append(b, vendor...)
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+// opusSamples возвращает длительность пакета Opus в отсчетах 48 кГц по байту TOC
+func opusSamples(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, errNotOpus
+	}
+	config := int(p[0] >> 3)
+	var frame int
+	switch {
+	case config < 12:
+		frame = []int{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		frame = []int{480, 960}[config%2]
+	default:
+		frame = []int{120, 240, 480, 960}[config%4]
+	}
+	switch p[0] & 3 {
+	case 0:
+		return frame, nil
+	case 1, 2:
+		return 2 * frame, nil
+	}
+	if len(p) < 2 {
+		return 0, errNotOpus
+	}
+	return int(p[1]&0x3f) * frame, nil
+}
+
+// oggWriter записывает страницы одного логического потока
+type oggWriter struct {
+	buf bytes.Buffer
+	seq uint32
+}
+
+// page записывает страницу с целыми пакетами
+func (w *oggWriter) page(packets [][]byte, flags byte, granule int64) {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+
+	header := make([]byte, oggHeaderSize, oggHeaderSize+len(lacing))
+	copy(header, "OggS")
+	header[5] = flags
+	binary.LittleEndian.PutUint64(header[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(header[14:18], oggSerial)
+	binary.LittleEndian.PutUint32(header[18:22], w.seq)
+	header[26] = byte(len(lacing))
+	header = append(header, lacing...)
+	crc := oggCRC(oggCRC(0, header), body)
+	binary.LittleEndian.PutUint32(header[22:26], crc)
+
+	w.buf.Write(header)
+	w.buf.Write(body)
+	w.seq++
+}
+
+// oggTable - таблица CRC-32 Ogg (полином 0x04c11db7 без отражения)
+var oggTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func oggCRC(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc = crc<<8 ^ oggTable[byte(crc>>24)^b]
+	}
+	return crc
+}
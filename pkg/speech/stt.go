@@ -0,0 +1,186 @@
+// Package speech - распознавание голосовых вопросов и озвучивание
+// предсказаний через подключаемые бэкенды: OpenAI-совместимые
+// /audio/transcriptions и /audio/speech, серверы whisper.cpp и Piper
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+)
+
+// ErrNoSpeech - в записи не распознано ни слова
+var ErrNoSpeech = errors.New("речь не распознана")
+
+// voiceBytesPerSecond - оценка битрейта сжатой голосовой записи (около
+// 16 кбит/с) для форматов, длительность которых не читается из заголовков
+const voiceBytesPerSecond = 2000
+
+// Transcriber переводит запись голоса в текст
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, filename string) (string, error)
+}
+
+// AudioDuration возвращает длительность записи для учета расходов:
+// точную для Ogg/Opus и WAV, для остальных форматов - оценку по размеру
+func AudioDuration(audio []byte) time.Duration {
+	if d, err := opusDuration(audio); err == nil {
+		return d
+	}
+	if d, err := wavDuration(audio); err == nil {
+		return d
+	}
+	return time.Duration(len(audio)) * time.Second / voiceBytesPerSecond
+}
+
+// STTClient - клиент распознавания речи. Бэкенды различаются только
+// адресом и полями формы, ответ у обоих - JSON с полем text.
+type STTClient struct {
+	cfg        config.STTConfig
+	httpClient *http.Client
+	policy     resilience.Policy
+	breaker    *resilience.Breaker
+}
+
+// NewTranscriber создает клиент распознавания или возвращает nil, если бэкенд не задан
+func NewTranscriber(cfg config.STTConfig, res config.ResilienceConfig) Transcriber {
+	if cfg.Backend == "" {
+		return nil
+	}
+	return &STTClient{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(nil),
+		},
+		policy:  resilience.Policy{Attempts: res.Attempts, BaseDelay: res.BaseDelay, MaxDelay: res.MaxDelay},
+		breaker: resilience.NewBreaker("stt", res.BreakerThreshold, res.BreakerCooldown),
+	}
+}
+
+// Transcribe распознает запись (OGG/Opus голосовых сообщений Telegram или
+// другой формат, который понимает бэкенд) и возвращает текст
+func (c *STTClient) Transcribe(ctx context.Context, audio []byte, filename string) (string, error) {
+	body, contentType, err := c.form(audio, filename)
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	err = resilience.Do(ctx, c.policy, c.breaker, func(ctx context.Context) (err error) {
+		text, err = c.transcribe(ctx, body, contentType)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", ErrNoSpeech
+	}
+	return text, nil
+}
+
+// form собирает multipart-форму запроса для бэкенда
+func (c *STTClient) form(audio []byte, filename string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fields := map[string]string{"response_format": "json"}
+	if c.cfg.Language != "" {
+		fields["language"] = c.cfg.Language
+	}
+	switch c.cfg.Backend {
+	case config.STTOpenAI:
+		fields["model"] = c.cfg.Model
+	case config.STTWhisperCpp:
+		fields["temperature"] = "0"
+	}
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			return nil, "", fmt.Errorf("failed to build form: %v", err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build form: %v", err)
+	}
+	fw.Write(audio)
+	if err := mw.Close(); err != nil {
+		return nil, "", fmt.Errorf("failed to build form: %v", err)
+	}
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// transcribe выполняет одну попытку запроса и возвращает распознанный текст
+func (c *STTClient) transcribe(ctx context.Context, body []byte, contentType string) (string, error) {
+	path := "/audio/transcriptions"
+	if c.cfg.Backend == config.STTWhisperCpp {
+		path = "/inference"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.cfg.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+string(c.cfg.APIKey))
+	}
+	logging.Propagate(req)
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		metrics.STTDuration.WithLabelValues(c.cfg.Backend, "error").Observe(time.Since(start).Seconds())
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	metrics.STTDuration.WithLabelValues(c.cfg.Backend, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(ctx, "stt error response",
+			slog.Int("status", resp.StatusCode),
+			slog.String("response", truncate(string(data), 500)),
+		)
+		return "", &common.UpstreamError{Service: "stt", StatusCode: resp.StatusCode, Message: truncate(string(data), 500)}
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+	}
+	slog.InfoContext(ctx, "stt response",
+		slog.String("backend", c.cfg.Backend),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		slog.Int("text_len", len([]rune(result.Text))),
+	)
+	return result.Text, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
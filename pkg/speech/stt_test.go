@@ -0,0 +1,96 @@
+package speech_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+)
+
+func newTranscriber(t *testing.T, backend string) (*testutil.STT, speech.Transcriber) {
+	t.Helper()
+	fake := testutil.NewSTT(t)
+	cfg := config.Default()
+	cfg.STT.Backend = backend
+	cfg.STT.BaseURL = fake.URL
+	cfg.STT.APIKey = "test-stt-key"
+	cfg.Resilience.BaseDelay = time.Millisecond
+	cfg.Resilience.MaxDelay = time.Millisecond
+	return fake, speech.NewTranscriber(cfg.STT, cfg.Resilience)
+}
+
+func TestTranscribe(t *testing.T) {
+	tests := []struct {
+		backend, path, field string
+	}{
+		{config.STTOpenAI, "/audio/transcriptions", "model"},
+		{config.STTWhisperCpp, "/inference", "temperature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			fake, stt := newTranscriber(t, tt.backend)
+			audio := []byte("OggS fake opus")
+
+			text, err := stt.Transcribe(context.Background(), audio, "voice.ogg")
+			if err != nil || text != testutil.DefaultTranscript {
+				t.Fatalf("Transcribe = %q, %v", text, err)
+			}
+			req := fake.Requests()[0]
+			if req.Path != tt.path || req.Fields[tt.field] == "" || req.Fields["language"] != "ru" {
+				t.Errorf("request = %s %v", req.Path, req.Fields)
+			}
+			if req.Filename != "voice.ogg" || !bytes.Equal(req.Audio, audio) || req.Authorization != "Bearer test-stt-key" {
+				t.Errorf("file %q, %d bytes, auth %q", req.Filename, len(req.Audio), req.Authorization)
+			}
+		})
+	}
+}
+
+func TestTranscribeErrors(t *testing.T) {
+	fake, stt := newTranscriber(t, config.STTOpenAI)
+	fake.Reply("  ", 0)
+	if _, err := stt.Transcribe(context.Background(), []byte("x"), "voice.ogg"); !errors.Is(err, speech.ErrNoSpeech) {
+		t.Errorf("silence = %v, want ErrNoSpeech", err)
+	}
+
+	fake.Reply("", http.StatusServiceUnavailable)
+	_, err := stt.Transcribe(context.Background(), []byte("x"), "voice.ogg")
+	var upstream *common.UpstreamError
+	if !errors.As(err, &upstream) || upstream.Service != "stt" {
+		t.Errorf("server error = %v, want UpstreamError", err)
+	}
+	// Временная ошибка повторяется: 1 запрос выше и 3 попытки здесь
+	if n := len(fake.Requests()); n != 4 {
+		t.Errorf("requests = %d, want 4", n)
+	}
+
+	if speech.NewTranscriber(config.Default().STT, config.Default().Resilience) != nil {
+		t.Error("transcriber without backend is not nil")
+	}
+}
+
+func TestAudioDuration(t *testing.T) {
+	tests := []struct {
+		name  string
+		audio []byte
+		want  time.Duration
+	}{
+		{"opus", testutil.SilenceOpus, time.Second},
+		{"wav", testutil.SilenceWAV(16000), time.Second},
+		// Для прочих форматов - оценка по размеру при 16 кбит/с
+		{"mp3", append([]byte("ID3"), make([]byte, 3997)...), 2 * time.Second},
+		{"broken ogg", []byte("OggS"), 2 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := speech.AudioDuration(tt.audio); got != tt.want {
+			t.Errorf("%s: duration = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
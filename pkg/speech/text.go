@@ -0,0 +1,116 @@
+package speech
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// cleanText готовит текст к озвучке: убирает разметку Markdown и эмодзи,
+// которые синтезатор прочитал бы вслух, и лишние пробелы
+func cleanText(text string) string {
+	text = strings.NewReplacer("**", "", "__", "", "`", "", "#", "", "*", "").Replace(text)
+	text = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.So, r) || unicode.Is(unicode.Cs, r) || r == '\u200d' || r == '\ufe0f' {
+			return -1
+		}
+		return r
+	}, text)
+
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// Characters возвращает число символов текста, которые получит синтезатор:
+// по ним считается стоимость озвучки
+func Characters(text string) int {
+	return utf8.RuneCountInString(cleanText(text))
+}
+
+// SplitSentences делит текст на фрагменты не длиннее limit символов по
+// границам предложений. Предложение длиннее limit делится по словам.
+func SplitSentences(text string, limit int) []string {
+	var chunks []string
+	var cur strings.Builder
+	curLen := 0
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur.Reset()
+		curLen = 0
+	}
+	for _, sentence := range sentences(text) {
+		n := len([]rune(sentence))
+		if n > limit {
+			flush()
+			chunks = append(chunks, splitWords(sentence, limit)...)
+			continue
+		}
+		if curLen > 0 && curLen+1+n > limit {
+			flush()
+		}
+		if curLen > 0 {
+			cur.WriteByte(' ')
+			curLen++
+		}
+		cur.WriteString(sentence)
+		curLen += n
+	}
+	flush()
+	return chunks
+}
+
+// sentences делит текст на предложения: после . ! ? … и конца строки
+func sentences(text string) []string {
+	var out []string
+	runes := []rune(text)
+	start := 0
+	for i, r := range runes {
+		end := r == '\n'
+		if strings.ContainsRune(".!?…", r) && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])) {
+			end = true
+		}
+		if end {
+			if s := strings.TrimSpace(string(runes[start : i+1])); s != "" {
+				out = append(out, s)
+			}
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(string(runes[start:])); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// splitWords делит длинное предложение на части не длиннее limit символов по словам
+func splitWords(text string, limit int) []string {
+	var parts []string
+	var cur []rune
+	for _, word := range strings.Fields(text) {
+		w := []rune(word)
+		if len(cur) > 0 && len(cur)+1+len(w) > limit {
+			parts = append(parts, string(cur))
+			cur = nil
+		}
+		for len(w) > limit {
+			parts = append(parts, string(w[:limit]))
+			w = w[limit:]
+		}
+		if len(cur) > 0 {
+			cur = append(cur, ' ')
+		}
+		cur = append(cur, w...)
+	}
+	if len(cur) > 0 {
+		parts = append(parts, string(cur))
+	}
+	return parts
+}
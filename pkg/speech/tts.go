@@ -0,0 +1,167 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/resilience"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+)
+
+// ttsParallel - сколько фрагментов текста озвучивается одновременно
+const ttsParallel = 3
+
+// ErrNothingToSay - после очистки от разметки в тексте не осталось слов
+var ErrNothingToSay = errors.New("нечего озвучивать")
+
+// Synthesizer озвучивает текст и возвращает запись OGG/Opus и ее длительность
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, time.Duration, error)
+}
+
+// TTSClient - клиент синтеза речи. Длинный текст делится на фрагменты по
+// предложениям, фрагменты озвучиваются параллельно и склеиваются: OGG/Opus
+// от openai - без перекодирования, WAV от piper - с кодированием через ffmpeg.
+type TTSClient struct {
+	cfg        config.TTSConfig
+	httpClient *http.Client
+	policy     resilience.Policy
+	breaker    *resilience.Breaker
+}
+
+// NewSynthesizer создает клиент синтеза или возвращает nil, если бэкенд не задан
+func NewSynthesizer(cfg config.TTSConfig, res config.ResilienceConfig) Synthesizer {
+	if cfg.Backend == "" {
+		return nil
+	}
+	return &TTSClient{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: tracing.Transport(nil),
+		},
+		policy:  resilience.Policy{Attempts: res.Attempts, BaseDelay: res.BaseDelay, MaxDelay: res.MaxDelay},
+		breaker: resilience.NewBreaker("tts", res.BreakerThreshold, res.BreakerCooldown),
+	}
+}
+
+// Synthesize озвучивает текст целиком
+func (c *TTSClient) Synthesize(ctx context.Context, text string) ([]byte, time.Duration, error) {
+	chunks := SplitSentences(cleanText(text), c.cfg.MaxChunk)
+	if len(chunks) == 0 {
+		return nil, 0, ErrNothingToSay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	segments := make([][]byte, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, ttsParallel)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = resilience.Do(ctx, c.policy, c.breaker, func(ctx context.Context) (err error) {
+				segments[i], err = c.speak(ctx, chunk)
+				return err
+			})
+			if errs[i] != nil {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	// Первая по порядку ошибка, а не отмена, вызванная ею в других фрагментах
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, 0, err
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, 0, err
+	}
+
+	if c.cfg.Backend == config.TTSPiper {
+		wav, duration, err := joinWAV(segments)
+		if err != nil {
+			return nil, 0, err
+		}
+		audio, err := encodeOpus(ctx, c.cfg.FFmpeg, wav)
+		return audio, duration, err
+	}
+	return JoinOpus(segments)
+}
+
+// speak выполняет одну попытку озвучивания фрагмента
+func (c *TTSClient) speak(ctx context.Context, text string) ([]byte, error) {
+	path, payload := "/audio/speech", map[string]string{
+		"model":           c.cfg.Model,
+		"input":           text,
+		"voice":           c.cfg.Voice,
+		"response_format": "opus",
+	}
+	if c.cfg.Backend == config.TTSPiper {
+		path, payload = "/", map[string]string{"text": text}
+		if c.cfg.Voice != "" {
+			payload["voice"] = c.cfg.Voice
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.cfg.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+string(c.cfg.APIKey))
+	}
+	logging.Propagate(req)
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		metrics.TTSDuration.WithLabelValues(c.cfg.Backend, "error").Observe(time.Since(start).Seconds())
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	metrics.TTSDuration.WithLabelValues(c.cfg.Backend, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(ctx, "tts error response",
+			slog.Int("status", resp.StatusCode),
+			slog.String("response", truncate(string(data), 500)),
+		)
+		return nil, &common.UpstreamError{Service: "tts", StatusCode: resp.StatusCode, Message: truncate(string(data), 500)}
+	}
+	slog.InfoContext(ctx, "tts response",
+		slog.String("backend", c.cfg.Backend),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		slog.Int("text_len", len([]rune(text))),
+		slog.Int("audio_bytes", len(data)),
+	)
+	return data, nil
+}
@@ -0,0 +1,120 @@
+package speech_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/speech"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+)
+
+func newSynthesizer(t *testing.T, backend string) (*testutil.TTS, speech.Synthesizer) {
+	t.Helper()
+	fake := testutil.NewTTS(t)
+	cfg := config.Default()
+	cfg.TTS.Backend = backend
+	cfg.TTS.BaseURL = fake.URL
+	cfg.TTS.APIKey = "test-tts-key"
+	cfg.TTS.MaxChunk = 100
+	cfg.Resilience.BaseDelay = time.Millisecond
+	cfg.Resilience.MaxDelay = time.Millisecond
+	return fake, speech.NewSynthesizer(cfg.TTS, cfg.Resilience)
+}
+
+func TestSplitSentences(t *testing.T) {
+	text := "Первое предложение. Второе! Третье?\nЧетвертое без точки"
+	if got := speech.SplitSentences(text, 1000); len(got) != 1 || got[0] != "Первое предложение. Второе! Третье? Четвертое без точки" {
+		t.Errorf("short text = %q", got)
+	}
+	got := speech.SplitSentences(text, 25)
+	want := []string{"Первое предложение.", "Второе! Третье?", "Четвертое без точки"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("split = %q, want %q", got, want)
+	}
+	long := strings.Repeat("слово ", 30)
+	for _, part := range speech.SplitSentences(long, 40) {
+		if n := len([]rune(part)); n > 40 || strings.HasPrefix(part, " ") {
+			t.Errorf("part %q (%d runes) exceeds limit", part, n)
+		}
+	}
+}
+
+func TestSynthesize(t *testing.T) {
+	// Предложения по 61 символу при лимите 100: три фрагмента, заголовок
+	// присоединяется к первому
+	sentence := strings.Repeat("а", 60) + ". "
+	text := "## **Карта дня** 🔮\n" + strings.Repeat(sentence, 3)
+
+	t.Run(config.TTSOpenAI, func(t *testing.T) {
+		fake, tts := newSynthesizer(t, config.TTSOpenAI)
+		audio, duration, err := tts.Synthesize(context.Background(), text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs := fake.Requests()
+		if len(reqs) != 3 {
+			t.Fatalf("requests = %d, want 3", len(reqs))
+		}
+		if duration != 3*time.Second || !bytes.HasPrefix(audio, []byte("OggS")) {
+			t.Errorf("audio %d bytes, %s", len(audio), duration)
+		}
+		// Результат - корректный поток, который можно склеить еще раз
+		if _, d, err := speech.JoinOpus([][]byte{audio}); err != nil || d != duration {
+			t.Errorf("rejoin = %s, %v", d, err)
+		}
+		for _, req := range reqs {
+			if req.Path != "/audio/speech" || req.Fields["response_format"] != "opus" || req.Fields["voice"] != "nova" || req.Authorization != "Bearer test-tts-key" {
+				t.Errorf("request = %s %v", req.Path, req.Fields)
+			}
+			if strings.ContainsAny(req.Fields["input"], "#*🔮") {
+				t.Errorf("markup not removed: %q", req.Fields["input"])
+			}
+		}
+	})
+
+	t.Run(config.TTSPiper, func(t *testing.T) {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			t.Skip("ffmpeg not installed")
+		}
+		fake, tts := newSynthesizer(t, config.TTSPiper)
+		audio, duration, err := tts.Synthesize(context.Background(), text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if duration != 3*time.Second || !bytes.HasPrefix(audio, []byte("OggS")) {
+			t.Errorf("audio %d bytes, %s", len(audio), duration)
+		}
+		if req := fake.Requests()[0]; req.Path != "/" || req.Fields["text"] == "" {
+			t.Errorf("request = %s %v", req.Path, req.Fields)
+		}
+	})
+}
+
+func TestSynthesizeErrors(t *testing.T) {
+	fake, tts := newSynthesizer(t, config.TTSOpenAI)
+	if _, _, err := tts.Synthesize(context.Background(), "** 🔮 **"); !errors.Is(err, speech.ErrNothingToSay) {
+		t.Errorf("empty text = %v, want ErrNothingToSay", err)
+	}
+
+	fake.Fail(http.StatusServiceUnavailable)
+	_, _, err := tts.Synthesize(context.Background(), "Текст предсказания.")
+	var upstream *common.UpstreamError
+	if !errors.As(err, &upstream) || upstream.Service != "tts" {
+		t.Errorf("server error = %v, want UpstreamError", err)
+	}
+
+	if _, _, err := speech.JoinOpus([][]byte{[]byte("RIFF")}); err == nil {
+		t.Error("JoinOpus accepted non-Ogg data")
+	}
+	if speech.NewSynthesizer(config.Default().TTS, config.Default().Resilience) != nil {
+		t.Error("synthesizer without backend is not nil")
+	}
+}
@@ -0,0 +1,125 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var errNotWAV = errors.New("ожидается WAV с PCM")
+
+// wavFormat - параметры PCM из заголовка fmt
+type wavFormat struct {
+	channels   uint16
+	sampleRate uint32
+	bits       uint16
+}
+
+// readWAV возвращает формат и PCM-данные записи WAV
+func readWAV(data []byte) (wavFormat, []byte, error) {
+	var f wavFormat
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return f, nil, errNotWAV
+	}
+	data = data[12:]
+	for len(data) >= 8 {
+		id, size := string(data[:4]), int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		// Потоковые серверы не знают длину заранее и пишут в data максимум
+		if size > len(data) {
+			size = len(data)
+		}
+		switch id {
+		case "fmt ":
+			if size < 16 || binary.LittleEndian.Uint16(data[:2]) != 1 {
+				return f, nil, errNotWAV
+			}
+			f.channels = binary.LittleEndian.Uint16(data[2:4])
+			f.sampleRate = binary.LittleEndian.Uint32(data[4:8])
+			f.bits = binary.LittleEndian.Uint16(data[14:16])
+		case "data":
+			if f.sampleRate == 0 {
+				return f, nil, errNotWAV
+			}
+			return f, data[:size], nil
+		}
+		data = data[min(size+size%2, len(data)):]
+	}
+	return f, nil, errNotWAV
+}
+
+// wavDuration возвращает длительность записи WAV
+func wavDuration(data []byte) (time.Duration, error) {
+	f, pcm, err := readWAV(data)
+	if err != nil {
+		return 0, err
+	}
+	frame := int64(f.channels) * int64(f.bits/8)
+	if frame == 0 {
+		return 0, errNotWAV
+	}
+	return time.Duration(int64(len(pcm))/frame) * time.Second / time.Duration(f.sampleRate), nil
+}
+
+// joinWAV склеивает записи WAV одного формата в одну и возвращает ее длительность
+func joinWAV(segments [][]byte) ([]byte, time.Duration, error) {
+	var format wavFormat
+	var pcm []byte
+	for i, seg := range segments {
+		f, data, err := readWAV(seg)
+		if err != nil {
+			return nil, 0, fmt.Errorf("сегмент %d: %w", i+1, err)
+		}
+		if i == 0 {
+			format = f
+		} else if f != format {
+			return nil, 0, fmt.Errorf("сегмент %d: другой формат WAV", i+1)
+		}
+		pcm = append(pcm, data...)
+	}
+	if len(segments) == 0 {
+		return nil, 0, errNotWAV
+	}
+
+	blockAlign := uint32(format.channels) * uint32(format.bits) / 8
+	header := make([]byte, 44)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+len(pcm)))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], 1)
+	binary.LittleEndian.PutUint16(header[22:24], format.channels)
+	binary.LittleEndian.PutUint32(header[24:28], format.sampleRate)
+	binary.LittleEndian.PutUint32(header[28:32], format.sampleRate*blockAlign)
+	binary.LittleEndian.PutUint16(header[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:36], format.bits)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(len(pcm)))
+
+	var duration time.Duration
+	if blockAlign > 0 {
+		duration = time.Duration(len(pcm)) * time.Second / time.Duration(format.sampleRate*blockAlign)
+	}
+	return append(header, pcm...), duration, nil
+}
+
+// encodeOpus кодирует WAV в OGG/Opus с настройками для голоса
+func encodeOpus(ctx context.Context, ffmpeg string, wav []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error",
+		"-f", "wav", "-i", "pipe:0",
+		"-c:a", "libopus", "-b:a", "32k", "-application", "voip",
+		"-f", "ogg", "pipe:1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(wav)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ошибка кодирования в OGG/Opus: %v: %s", err, truncate(strings.TrimSpace(stderr.String()), 500))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// kvPrefix - префикс хешей коллекций в Redis
+const kvPrefix = "store:"
+
+// kvTimeout - ограничение времени одной команды
+const kvTimeout = 10 * time.Second
+
+// casScript заменяет поле хеша, только если оно не менялось с чтения.
+// Пустое ожидаемое значение означает отсутствие поля: документы - JSON и
+// пустыми не бывают.
+const casScript = `local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if (cur or '') ~= ARGV[2] then return 0 end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+return 1`
+
+// kv - клиент Redis с REST API (Upstash, Vercel KV). Коллекция хранится
+// хешем store:<коллекция>, документ - полем хеша.
+type kv struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// do выполняет команду Redis и возвращает поле result ответа
+func (k *kv) do(ctx context.Context, args ...string) (json.RawMessage, error) {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса к хранилищу: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("хранилище недоступно: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа хранилища: %v", err)
+	}
+	var out struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil || resp.StatusCode != http.StatusOK && out.Error == "" {
+		return nil, fmt.Errorf("хранилище ответило %d", resp.StatusCode)
+	}
+	if out.Error != "" {
+		return nil, fmt.Errorf("ошибка хранилища в %s: %s", args[0], out.Error)
+	}
+	return out.Result, nil
+}
+
+func (k *kv) get(collection, key string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	res, err := k.do(ctx, "HGET", kvPrefix+collection, key)
+	if err != nil {
+		return nil, false, err
+	}
+	var value *string
+	if err := json.Unmarshal(res, &value); err != nil {
+		return nil, false, fmt.Errorf("некорректный ответ хранилища: %v", err)
+	}
+	if value == nil {
+		return nil, false, nil
+	}
+	return []byte(*value), true, nil
+}
+
+func (k *kv) set(collection, key string, raw []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	_, err := k.do(ctx, "HSET", kvPrefix+collection, key, string(raw))
+	return err
+}
+
+// cas сохраняет next, если документ не менялся после чтения old
+// (nil - документа не было)
+func (k *kv) cas(collection, key string, old, next []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	res, err := k.do(ctx, "EVAL", casScript, "1", kvPrefix+collection, key, string(old), string(next))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(res)) == "1", nil
+}
+
+func (k *kv) del(collection, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	_, err := k.do(ctx, "HDEL", kvPrefix+collection, key)
+	return err
+}
+
+func (k *kv) keys(collection string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	res, err := k.do(ctx, "HKEYS", kvPrefix+collection)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(res, &keys); err != nil {
+		return nil, fmt.Errorf("некорректный ответ хранилища: %v", err)
+	}
+	return keys, nil
+}
+
+// all читает всю коллекцию одной командой
+func (k *kv) all(collection string) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kvTimeout)
+	defer cancel()
+	res, err := k.do(ctx, "HGETALL", kvPrefix+collection)
+	if err != nil {
+		return nil, err
+	}
+	var flat []string
+	if err := json.Unmarshal(res, &flat); err != nil || len(flat)%2 != 0 {
+		return nil, fmt.Errorf("некорректный ответ хранилища HGETALL")
+	}
+	docs := make(map[string][]byte, len(flat)/2)
+	for i := 0; i < len(flat); i += 2 {
+		docs[flat[i]] = []byte(flat[i+1])
+	}
+	return docs, nil
+}
+
+func (k *kv) ping(ctx context.Context) error {
+	_, err := k.do(ctx, "PING")
+	return err
+}
@@ -0,0 +1,349 @@
+// Package store - простое документное хранилище с сохранением в JSON-файл
+// или в общий Redis с REST API. Данные сгруппированы по коллекциям; значения
+// хранятся в виде JSON, поэтому каждый пакет сам определяет типы своих записей.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store - потокобезопасное хранилище документов
+type Store struct {
+	mu    sync.RWMutex
+	path  string
+	data  map[string]map[string]json.RawMessage
+	dirty bool
+	// kv - общий Redis; nil, если данные принадлежат процессу
+	kv *kv
+}
+
+// casAttempts - сколько раз Update повторяет чтение при конкурентной записи
+const casAttempts = 10
+
+// Open открывает хранилище. При пустом path данные хранятся только в памяти.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: make(map[string]map[string]json.RawMessage),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения хранилища: %v", err)
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("ошибка разбора хранилища %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// OpenKV открывает хранилище в Redis с REST API (Upstash, Vercel KV).
+// Данные разделяют все экземпляры сервера, поэтому оно подходит для
+// serverless-функций. Flush и Run для него ничего не делают.
+func OpenKV(url, token string) *Store {
+	return &Store{kv: &kv{url: strings.TrimRight(url, "/"), token: token, client: &http.Client{Timeout: kvTimeout}}}
+}
+
+// Put сохраняет документ
+func (s *Store) Put(collection, key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования %s/%s: %v", collection, key, err)
+	}
+
+	if s.kv != nil {
+		return s.kv.set(collection, key, raw)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(collection, key, raw)
+	return nil
+}
+
+// Get загружает документ в v. Возвращает false, если документа нет.
+func (s *Store) Get(collection, key string, v any) (bool, error) {
+	var raw []byte
+	var ok bool
+	if s.kv != nil {
+		var err error
+		if raw, ok, err = s.kv.get(collection, key); err != nil {
+			return false, err
+		}
+	} else {
+		s.mu.RLock()
+		raw, ok = s.data[collection][key]
+		s.mu.RUnlock()
+	}
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("ошибка декодирования %s/%s: %v", collection, key, err)
+	}
+	return true, nil
+}
+
+// Update атомарно читает документ в v, вызывает fn и сохраняет v.
+// exists сообщает, был ли документ до вызова. Если fn возвращает ошибку,
+// документ не меняется. В общем хранилище при конкурентной записи fn
+// вызывается повторно с перечитанным документом.
+func (s *Store) Update(collection, key string, v any, fn func(exists bool) error) error {
+	if s.kv != nil {
+		return s.updateKV(collection, key, v, fn)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, exists := s.data[collection][key]
+	if exists {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("ошибка декодирования %s/%s: %v", collection, key, err)
+		}
+	}
+	if err := fn(exists); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования %s/%s: %v", collection, key, err)
+	}
+	s.put(collection, key, raw)
+	return nil
+}
+
+// updateKV - Update с оптимистичной блокировкой: запись проходит, только
+// если документ не изменился с чтения, иначе попытка повторяется
+func (s *Store) updateKV(collection, key string, v any, fn func(exists bool) error) error {
+	// Каждая попытка начинается с исходного значения v, а не с результата
+	// предыдущего вызова fn
+	target := reflect.ValueOf(v).Elem()
+	initial := reflect.New(target.Type()).Elem()
+	initial.Set(target)
+
+	for attempt := range casAttempts {
+		if attempt > 0 {
+			// Случайная пауза разводит экземпляры, пишущие один документ
+			time.Sleep(time.Duration(rand.IntN(1<<attempt)) * time.Millisecond)
+		}
+		target.Set(initial)
+		raw, exists, err := s.kv.get(collection, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return fmt.Errorf("ошибка декодирования %s/%s: %v", collection, key, err)
+			}
+		}
+		if err := fn(exists); err != nil {
+			return err
+		}
+		next, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("ошибка кодирования %s/%s: %v", collection, key, err)
+		}
+		ok, err := s.kv.cas(collection, key, raw, next)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return fmt.Errorf("%s/%s: документ постоянно меняется конкурентными запросами", collection, key)
+}
+
+// Delete удаляет документ
+func (s *Store) Delete(collection, key string) {
+	if s.kv != nil {
+		if err := s.kv.del(collection, key); err != nil {
+			slog.Error("store delete failed", slog.String("collection", collection), slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[collection][key]; ok {
+		delete(s.data[collection], key)
+		s.dirty = true
+	}
+}
+
+// Keys возвращает отсортированные ключи коллекции
+func (s *Store) Keys(collection string) []string {
+	if s.kv != nil {
+		keys, err := s.kv.keys(collection)
+		if err != nil {
+			slog.Error("store keys failed", slog.String("collection", collection), slog.String("error", err.Error()))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data[collection]))
+	for k := range s.data[collection] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Each вызывает fn для каждого документа коллекции в порядке ключей
+func Each[T any](s *Store, collection string, fn func(key string, v *T) error) error {
+	if s.kv != nil {
+		return eachKV(s, collection, fn)
+	}
+	for _, key := range s.Keys(collection) {
+		v := new(T)
+		ok, err := s.Get(collection, key, v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := fn(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachKV читает коллекцию общего хранилища одним запросом
+func eachKV[T any](s *Store, collection string, fn func(key string, v *T) error) error {
+	docs, err := s.kv.all(collection)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := new(T)
+		if err := json.Unmarshal(docs[key], v); err != nil {
+			return fmt.Errorf("ошибка декодирования %s/%s: %v", collection, key, err)
+		}
+		if err := fn(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) put(collection, key string, raw json.RawMessage) {
+	if s.data[collection] == nil {
+		s.data[collection] = make(map[string]json.RawMessage)
+	}
+	s.data[collection][key] = raw
+	s.dirty = true
+}
+
+// Ping проверяет доступность хранилища
+func (s *Store) Ping(ctx context.Context) error {
+	if s.kv != nil {
+		return s.kv.ping(ctx)
+	}
+	if s.path == "" {
+		return nil
+	}
+	dir := filepath.Dir(s.path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("каталог хранилища недоступен: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s не является каталогом", dir)
+	}
+	return nil
+}
+
+// Flush сохраняет изменения на диск. Запись атомарна: сначала во временный
+// файл, затем переименование.
+func (s *Store) Flush() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	raw, err := json.Marshal(s.data)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("ошибка кодирования хранилища: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".store-*.json")
+	if err != nil {
+		s.markDirty()
+		return fmt.Errorf("ошибка создания временного файла: %v", err)
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		s.markDirty()
+		return fmt.Errorf("ошибка записи хранилища: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		s.markDirty()
+		return fmt.Errorf("ошибка записи хранилища: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		s.markDirty()
+		return fmt.Errorf("ошибка сохранения хранилища: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) markDirty() {
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+}
+
+// Run периодически сохраняет изменения до отмены ctx
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	if s.path == "" || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Error("store flush failed", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+)
+
+type doc struct {
+	Name  string `json:"name,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+// backends - хранилище в памяти и общее хранилище поверх одного фейка KV
+func backends(t *testing.T) map[string]*store.Store {
+	t.Helper()
+	mem, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := testutil.NewKV(t)
+	return map[string]*store.Store{
+		"memory": mem,
+		"kv":     store.OpenKV(kv.URL, testutil.KVToken),
+	}
+}
+
+func TestStore(t *testing.T) {
+	for name, st := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := st.Ping(context.Background()); err != nil {
+				t.Fatalf("Ping: %v", err)
+			}
+			var got doc
+			if ok, err := st.Get("docs", "a", &got); ok || err != nil {
+				t.Fatalf("Get missing = %v, %v", ok, err)
+			}
+			st.Put("docs", "b", doc{Name: "b"})
+			st.Put("docs", "a", doc{Name: "a", Count: 1})
+			if ok, err := st.Get("docs", "a", &got); !ok || err != nil || got != (doc{Name: "a", Count: 1}) {
+				t.Fatalf("Get = %+v, %v, %v", got, ok, err)
+			}
+
+			err := st.Update("docs", "a", &got, func(exists bool) error {
+				if !exists {
+					t.Error("existing document reported missing")
+				}
+				got.Count++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Ошибка fn не меняет документ
+			errStop := errors.New("stop")
+			if err := st.Update("docs", "a", &got, func(bool) error { got.Count = 100; return errStop }); !errors.Is(err, errStop) {
+				t.Fatalf("Update error = %v", err)
+			}
+			st.Get("docs", "a", &got)
+			if got.Count != 2 {
+				t.Errorf("count = %d, want 2", got.Count)
+			}
+
+			var keys []string
+			store.Each(st, "docs", func(key string, d *doc) error {
+				keys = append(keys, key+"="+d.Name)
+				return nil
+			})
+			if len(keys) != 2 || keys[0] != "a=a" || keys[1] != "b=b" {
+				t.Errorf("Each = %v", keys)
+			}
+			st.Delete("docs", "b")
+			if keys := st.Keys("docs"); len(keys) != 1 || keys[0] != "a" {
+				t.Errorf("Keys after delete = %v", keys)
+			}
+		})
+	}
+}
+
+func TestKVUpdateConflict(t *testing.T) {
+	kv := testutil.NewKV(t)
+	st := store.OpenKV(kv.URL, testutil.KVToken)
+
+	// Первая попытка проиграла чужой записи: fn вызывается снова с чистым v
+	kv.Conflict(1)
+	var d doc
+	calls := 0
+	err := st.Update("docs", "a", &d, func(exists bool) error {
+		calls++
+		if exists || d.Count != 0 {
+			t.Errorf("attempt %d: exists %v, %+v", calls, exists, d)
+		}
+		d.Count++
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Update = %v after %d calls", err, calls)
+	}
+
+	kv.Conflict(100)
+	if err := st.Update("docs", "a", &d, func(bool) error { return nil }); err == nil {
+		t.Error("endless conflicts not reported")
+	}
+	kv.Conflict(0)
+
+	// Экземпляры с общим хранилищем не теряют параллельные обновления
+	other := store.OpenKV(kv.URL, testutil.KVToken)
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := st
+			if i%2 == 1 {
+				s = other
+			}
+			var d doc
+			if err := s.Update("docs", "counter", &d, func(bool) error { d.Count++; return nil }); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	other.Get("docs", "counter", &d)
+	if d.Count != 20 {
+		t.Errorf("counter = %d, want 20", d.Count)
+	}
+}
+
+func TestKVErrors(t *testing.T) {
+	kv := testutil.NewKV(t)
+	st := store.OpenKV(kv.URL, "wrong-token")
+	if err := st.Ping(context.Background()); err == nil {
+		t.Error("Ping with a wrong token succeeded")
+	}
+	var d doc
+	if _, err := st.Get("docs", "a", &d); err == nil {
+		t.Error("Get with a wrong token succeeded")
+	}
+}
@@ -0,0 +1,29 @@
+package testutil
+
+import (
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+)
+
+// Config возвращает конфигурацию для работы с фейками: ключи заданы,
+// хранилище в памяти, изображения опрашиваются и запросы повторяются
+// без заметных пауз, бот отключен
+func Config(llm *OpenRouter, images *Kandinsky) *config.Config {
+	cfg := config.Default()
+	cfg.OpenRouter.APIKey = "test-openrouter-key"
+	cfg.OpenRouter.BaseURL = llm.URL
+	cfg.OpenRouter.Timeout = 5 * time.Second
+	cfg.Kandinsky.APIKey = "test-kandinsky-key"
+	cfg.Kandinsky.Secret = "test-kandinsky-secret"
+	cfg.Kandinsky.BaseURL = images.URL
+	cfg.Kandinsky.Timeout = 5 * time.Second
+	cfg.Kandinsky.PollInterval = time.Millisecond
+	cfg.Kandinsky.MaxPolls = 10
+	cfg.Resilience.BaseDelay = time.Millisecond
+	cfg.Resilience.MaxDelay = 5 * time.Millisecond
+	cfg.Store.Path = ""
+	cfg.Metrics.Enabled = false
+	cfg.Server.StaticDir = "."
+	return cfg
+}
@@ -0,0 +1,186 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+)
+
+// PNG - минимальное изображение 2x2, которое фейк возвращает по умолчанию
+var PNG = func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}()
+
+// Task - сценарий одной задачи Kandinsky
+type Task struct {
+	// RunStatus и RunBody - ответ на постановку задачи; 0 и "" - задача принята
+	RunStatus int
+	RunBody   string
+	// Pending - сколько опросов задача остается в статусе PROCESSING
+	Pending int
+	// Status - финальный статус: DONE (по умолчанию) или FAILED
+	Status string
+	// Error - описание ошибки для FAILED
+	Error string
+	// Censored - изображение отклонено фильтром: DONE без картинок
+	Censored bool
+	// Image - готовое изображение; nil - PNG
+	Image []byte
+	// StatusBody - тело ответа на опрос как есть, например некорректный JSON
+	StatusBody string
+	// Delay - задержка перед каждым ответом по задаче
+	Delay time.Duration
+}
+
+// Kandinsky - фейковый Fusion Brain API. Каждая новая задача берет
+// очередной сценарий из очереди, а когда она пуста - Default.
+type Kandinsky struct {
+	*httptest.Server
+	// Default - сценарий задачи, когда очередь пуста
+	Default Task
+	// ModelStatus - ответ availability; непустое значение означает недоступность
+	ModelStatus string
+
+	mu      sync.Mutex
+	scripts []Task
+	tasks   map[string]*kandinskyTask
+	prompts []string
+	seq     int
+}
+
+type kandinskyTask struct {
+	Task
+	polls int
+}
+
+// NewKandinsky запускает фейковый Kandinsky, который останавливается по завершении теста
+func NewKandinsky(t testing.TB) *Kandinsky {
+	f := &Kandinsky{tasks: make(map[string]*kandinskyTask)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /key/api/v1/text2image/run", f.handleRun)
+	mux.HandleFunc("POST /key/api/v1/text2image/status", f.handleStatus)
+	mux.HandleFunc("POST /key/api/v1/text2image/availability", f.handleAvailability)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Push добавляет сценарии задач в очередь
+func (f *Kandinsky) Push(tasks ...Task) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts = append(f.scripts, tasks...)
+}
+
+// Prompts возвращает промпты принятых и отклоненных задач в порядке поступления
+func (f *Kandinsky) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+func (f *Kandinsky) handleRun(w http.ResponseWriter, r *http.Request) {
+	var params common.KandinskyGenerateRequest
+	if err := json.Unmarshal([]byte(r.FormValue("params")), &params); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"errorDescription": "invalid params"})
+		return
+	}
+
+	f.mu.Lock()
+	task := f.Default
+	if len(f.scripts) > 0 {
+		task, f.scripts = f.scripts[0], f.scripts[1:]
+	}
+	f.prompts = append(f.prompts, params.GenerateParams.Query)
+	f.seq++
+	uuid := fmt.Sprintf("task-%d", f.seq)
+	if task.RunStatus == 0 && task.RunBody == "" {
+		f.tasks[uuid] = &kandinskyTask{Task: task}
+	}
+	f.mu.Unlock()
+
+	if !wait(r, task.Delay) {
+		return
+	}
+	if task.RunStatus != 0 || task.RunBody != "" {
+		writeRaw(w, task.RunStatus, task.RunBody)
+		return
+	}
+	writeJSON(w, http.StatusCreated, common.KandinskyStatusResponse{UUID: uuid, Status: "INITIAL"})
+}
+
+func (f *Kandinsky) handleStatus(w http.ResponseWriter, r *http.Request) {
+	uuid := r.FormValue("uuid")
+
+	f.mu.Lock()
+	task, ok := f.tasks[uuid]
+	var resp common.KandinskyStatusResponse
+	if ok {
+		task.polls++
+		resp = task.status(uuid)
+	}
+	f.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"errorDescription": "task not found"})
+		return
+	}
+	if !wait(r, task.Delay) {
+		return
+	}
+	if task.StatusBody != "" {
+		writeRaw(w, http.StatusOK, task.StatusBody)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// status возвращает ответ на очередной опрос задачи
+func (t *kandinskyTask) status(uuid string) common.KandinskyStatusResponse {
+	resp := common.KandinskyStatusResponse{UUID: uuid, Status: "PROCESSING"}
+	if t.polls <= t.Pending {
+		return resp
+	}
+	resp.Status = t.Status
+	if resp.Status == "" {
+		resp.Status = common.KandinskyStatusDone
+	}
+	switch {
+	case resp.Status == common.KandinskyStatusFailed:
+		resp.Error = t.Error
+	case t.Censored:
+		resp.Censored = true
+	default:
+		image := t.Image
+		if image == nil {
+			image = PNG
+		}
+		resp.Images = []string{base64.StdEncoding.EncodeToString(image)}
+	}
+	return resp
+}
+
+func (f *Kandinsky) handleAvailability(w http.ResponseWriter, _ *http.Request) {
+	f.mu.Lock()
+	resp := common.KandinskyAvailabilityResponse{ModelStatus: f.ModelStatus}
+	f.mu.Unlock()
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,123 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// KVToken - токен, который принимает фейк KV
+const KVToken = "test-kv-token"
+
+// KV - фейковый Redis с REST API в стиле Upstash. Поддерживает команды,
+// которыми пользуется store; EVAL выполняет только его сравнение с заменой.
+type KV struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	hashes   map[string]map[string]string
+	commands int
+	// conflicts - сколько ближайших EVAL завершить конфликтом
+	conflicts int
+}
+
+// NewKV запускает фейк, который останавливается по завершении теста
+func NewKV(t testing.TB) *KV {
+	f := &KV{hashes: make(map[string]map[string]string)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Conflict заставляет n ближайших сравнений с заменой вернуть конфликт,
+// как при записи другого экземпляра
+func (f *KV) Conflict(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conflicts = n
+}
+
+// Commands возвращает число выполненных команд
+func (f *KV) Commands() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.commands
+}
+
+func (f *KV) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+KVToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+	var args []string
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil || len(args) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "ERR bad request"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands++
+	result, errText := f.exec(args)
+	if errText != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": errText})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"result": result})
+}
+
+func (f *KV) exec(args []string) (any, string) {
+	hash := func() map[string]string {
+		h := f.hashes[args[1]]
+		if h == nil {
+			h = make(map[string]string)
+			f.hashes[args[1]] = h
+		}
+		return h
+	}
+	switch {
+	case args[0] == "PING":
+		return "PONG", ""
+	case args[0] == "HGET" && len(args) == 3:
+		if v, ok := f.hashes[args[1]][args[2]]; ok {
+			return v, ""
+		}
+		return nil, ""
+	case args[0] == "HSET" && len(args) == 4:
+		hash()[args[2]] = args[3]
+		return 1, ""
+	case args[0] == "HDEL" && len(args) == 3:
+		delete(hash(), args[2])
+		return 1, ""
+	case args[0] == "HKEYS" && len(args) == 2:
+		keys := []string{}
+		for k := range f.hashes[args[1]] {
+			keys = append(keys, k)
+		}
+		return keys, ""
+	case args[0] == "HGETALL" && len(args) == 2:
+		flat := []string{}
+		for k, v := range f.hashes[args[1]] {
+			flat = append(flat, k, v)
+		}
+		return flat, ""
+	case args[0] == "EVAL" && len(args) == 7 && args[2] == "1":
+		// KEYS[1] = args[3], ARGV = поле, ожидаемое и новое значения
+		args = args[2:]
+		if f.conflicts > 0 {
+			f.conflicts--
+			return 0, ""
+		}
+		if hash()[args[2]] != args[3] {
+			return 0, ""
+		}
+		hash()[args[2]] = args[4]
+		return 1, ""
+	}
+	return nil, "ERR unknown command " + args[0]
+}
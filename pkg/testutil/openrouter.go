@@ -0,0 +1,164 @@
+// Package testutil - тестовые двойники внешних API на httptest: OpenRouter,
+// Kandinsky, распознавание и синтез речи со сценариями ответов (задержки,
+// ошибки, некорректный JSON), чтобы тесты не требовали ключей и сети.
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+)
+
+// DefaultPrediction - ответ модели по умолчанию: текст и три промпта изображений
+const DefaultPrediction = `Звезды благосклонны к вам.
+IMAGE_PROMPT: a golden sun over calm sea
+IMAGE_PROMPT: a silver moon in the night forest
+IMAGE_PROMPT: a bright star above the mountains`
+
+// Reply - сценарий одного ответа OpenRouter на /chat/completions
+type Reply struct {
+	// Status - HTTP-статус; 0 - 200
+	Status int
+	// Content - текст ответа модели
+	Content string
+	// Body - тело ответа как есть, например некорректный JSON; заменяет Content
+	Body string
+	// Model - модель в ответе; пустое значение - модель из запроса
+	Model string
+	// Usage - расход токенов в ответе
+	Usage common.Usage
+	// Delay - задержка перед ответом
+	Delay time.Duration
+}
+
+// OpenRouter - фейковый OpenRouter. Ответы берутся из очереди сценариев,
+// а когда она пуста, используется Default.
+type OpenRouter struct {
+	*httptest.Server
+	// Default - ответ, когда очередь сценариев пуста
+	Default Reply
+	// Models - модели, которые возвращает /models
+	Models []string
+
+	mu       sync.Mutex
+	replies  []Reply
+	requests []common.OpenAIRequest
+}
+
+// NewOpenRouter запускает фейковый OpenRouter, который останавливается по завершении теста
+func NewOpenRouter(t testing.TB) *OpenRouter {
+	f := &OpenRouter{
+		Default: Reply{
+			Content: DefaultPrediction,
+			Usage:   common.Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150},
+		},
+		Models: []string{"anthropic/claude-3-haiku"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat/completions", f.handleCompletion)
+	mux.HandleFunc("GET /models", f.handleModels)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Push добавляет ответы в очередь сценариев
+func (f *OpenRouter) Push(replies ...Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, replies...)
+}
+
+// Requests возвращает полученные запросы /chat/completions
+func (f *OpenRouter) Requests() []common.OpenAIRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]common.OpenAIRequest(nil), f.requests...)
+}
+
+func (f *OpenRouter) handleCompletion(w http.ResponseWriter, r *http.Request) {
+	var req common.OpenAIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":{"message":"invalid request"}}`, http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	reply := f.Default
+	if len(f.replies) > 0 {
+		reply, f.replies = f.replies[0], f.replies[1:]
+	}
+	f.mu.Unlock()
+
+	if !wait(r, reply.Delay) {
+		return
+	}
+	if reply.Body != "" || reply.Status != 0 && reply.Status != http.StatusOK {
+		writeRaw(w, reply.Status, reply.Body)
+		return
+	}
+
+	model := reply.Model
+	if model == "" {
+		model = req.Model
+	}
+	resp := map[string]any{
+		"id":      "gen-test",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []map[string]any{{
+			"index":         0,
+			"message":       map[string]string{"role": common.RoleAssistant, "content": reply.Content},
+			"finish_reason": "stop",
+		}},
+		"usage": reply.Usage,
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (f *OpenRouter) handleModels(w http.ResponseWriter, _ *http.Request) {
+	data := make([]map[string]string, len(f.Models))
+	for i, id := range f.Models {
+		data[i] = map[string]string{"id": id}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": data})
+}
+
+// wait выдерживает задержку сценария; false - клиент отменил запрос раньше
+func wait(r *http.Request, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// writeRaw отвечает заданным статусом и телом; пустое тело заменяется описанием ошибки
+func writeRaw(w http.ResponseWriter, status int, body string) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if body == "" {
+		body = `{"error":{"message":"` + http.StatusText(status) + `"}}`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
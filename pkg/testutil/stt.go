@@ -0,0 +1,87 @@
+package testutil
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// DefaultTranscript - распознанный текст по умолчанию
+const DefaultTranscript = "Что ждет меня в работе?"
+
+// STTRequest - запрос распознавания, полученный фейком
+type STTRequest struct {
+	Path          string
+	Authorization string
+	Fields        map[string]string
+	Filename      string
+	Audio         []byte
+}
+
+// STT - фейковый сервис распознавания речи: OpenAI-совместимый
+// /audio/transcriptions и /inference сервера whisper.cpp
+type STT struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	text     string
+	status   int
+	requests []STTRequest
+}
+
+// NewSTT запускает фейк, который отвечает DefaultTranscript и
+// останавливается по завершении теста
+func NewSTT(t testing.TB) *STT {
+	f := &STT{text: DefaultTranscript}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /audio/transcriptions", f.handle)
+	mux.HandleFunc("POST /inference", f.handle)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Reply задает ответ: распознанный текст и HTTP-статус (0 - 200)
+func (f *STT) Reply(text string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.text, f.status = text, status
+}
+
+// Requests возвращает полученные запросы
+func (f *STT) Requests() []STTRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]STTRequest(nil), f.requests...)
+}
+
+func (f *STT) handle(w http.ResponseWriter, r *http.Request) {
+	req := STTRequest{Path: r.URL.Path, Authorization: r.Header.Get("Authorization"), Fields: map[string]string{}}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for name, values := range r.MultipartForm.Value {
+		req.Fields[name] = values[0]
+	}
+	if file, header, err := r.FormFile("file"); err == nil {
+		req.Filename = header.Filename
+		req.Audio, _ = io.ReadAll(file)
+		file.Close()
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	text, status := f.text, f.status
+	f.mu.Unlock()
+
+	if status != 0 && status != http.StatusOK {
+		http.Error(w, "stt failed", status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"text": text})
+}
@@ -0,0 +1,101 @@
+package testutil
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// SilenceOpus - секунда тишины в OGG/Opus: ответ фейка на каждый фрагмент текста
+//
+//go:embed silence.opus
+var SilenceOpus []byte
+
+// TTSRequest - запрос синтеза, полученный фейком
+type TTSRequest struct {
+	Path          string
+	Authorization string
+	Fields        map[string]string
+}
+
+// TTS - фейковый сервис синтеза речи: OpenAI-совместимый /audio/speech
+// отвечает SilenceOpus, сервер Piper (POST /) - секундой тишины в WAV
+type TTS struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []TTSRequest
+}
+
+// NewTTS запускает фейк, который останавливается по завершении теста
+func NewTTS(t testing.TB) *TTS {
+	f := &TTS{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /audio/speech", f.handle)
+	mux.HandleFunc("POST /{$}", f.handle)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Fail задает HTTP-статус ответа (0 - 200)
+func (f *TTS) Fail(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// Requests возвращает полученные запросы
+func (f *TTS) Requests() []TTSRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]TTSRequest(nil), f.requests...)
+}
+
+func (f *TTS) handle(w http.ResponseWriter, r *http.Request) {
+	req := TTSRequest{Path: r.URL.Path, Authorization: r.Header.Get("Authorization")}
+	if err := json.NewDecoder(r.Body).Decode(&req.Fields); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	status := f.status
+	f.mu.Unlock()
+
+	if status != 0 && status != http.StatusOK {
+		http.Error(w, "tts failed", status)
+		return
+	}
+	if r.URL.Path == "/" {
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Write(SilenceWAV(22050))
+		return
+	}
+	w.Header().Set("Content-Type", "audio/ogg")
+	w.Write(SilenceOpus)
+}
+
+// SilenceWAV - секунда тишины в WAV с частотой rate: моно, 16 бит
+func SilenceWAV(rate uint32) []byte {
+	size := rate * 2
+	b := []byte("RIFF")
+	b = binary.LittleEndian.AppendUint32(b, 36+size)
+	b = append(b, "WAVEfmt "...)
+	b = binary.LittleEndian.AppendUint32(b, 16)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint16(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, rate)
+	b = binary.LittleEndian.AppendUint32(b, rate*2)
+	b = binary.LittleEndian.AppendUint16(b, 2)
+	b = binary.LittleEndian.AppendUint16(b, 16)
+	b = append(b, "data"...)
+	b = binary.LittleEndian.AppendUint32(b, size)
+	return append(b, make([]byte, size)...)
+}
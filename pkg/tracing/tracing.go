@@ -0,0 +1,135 @@
+// Package tracing настраивает трассировку OpenTelemetry: провайдер, экспорт
+// спанов (OTLP или stdout) и инструментирование исходящих HTTP-запросов
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+)
+
+// instrumentation - имя библиотеки инструментирования в спанах
+const instrumentation = "github.com/PtsPuf/telegram-mini-app"
+
+// Экспортеры спанов
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Setup настраивает глобальный провайдер трассировки по конфигурации и
+// возвращает функцию, которая отправляет накопленные спаны и останавливает его.
+// С экспортером "none" трассировка отключена и спаны не записываются.
+// sync включает синхронную отправку каждого спана - для serverless, где
+// процесс может быть заморожен сразу после ответа.
+func Setup(ctx context.Context, cfg config.TracingConfig, sync bool) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(cfg.Exporter) {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("неизвестный экспортер трассировки: %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания экспортера трассировки: %v", err)
+	}
+
+	tp := NewProvider(exporter, cfg, sync)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// NewProvider создает провайдер с заданным экспортером и устанавливает
+// W3C-пропагацию контекста. Тесты передают сюда tracetest.InMemoryExporter.
+func NewProvider(exporter sdktrace.SpanExporter, cfg config.TracingConfig, sync bool) *sdktrace.TracerProvider {
+	processor := sdktrace.WithBatcher(exporter)
+	if sync {
+		processor = sdktrace.WithSyncer(exporter)
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "telegram-mini-app"
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+}
+
+// Start начинает спан. Провайдер берется глобальный, поэтому без Setup
+// спаны ничего не стоят.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End завершает спан, отмечая ошибку, если она есть
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Transport оборачивает транспорт HTTP-клиента: каждый исходящий запрос
+// получает клиентский спан, а заголовок traceparent передается сервису
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// Handler оборачивает входящие запросы маршрута серверным спаном.
+// Имя спана - шаблон маршрута, а не путь, чтобы идентификаторы задач
+// не размножали имена операций.
+func Handler(route string, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, route)
+}
+
+type imageIndexKey struct{}
+
+// WithImageIndex сохраняет в контексте номер изображения предсказания (с нуля),
+// чтобы спаны клиента Kandinsky были подписаны им
+func WithImageIndex(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, imageIndexKey{}, index)
+}
+
+// ImageAttrs возвращает атрибут с номером изображения, если он есть в контексте
+func ImageAttrs(ctx context.Context) []attribute.KeyValue {
+	if index, ok := ctx.Value(imageIndexKey{}).(int); ok {
+		return []attribute.KeyValue{attribute.Int("image.index", index)}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/server"
+	"github.com/PtsPuf/telegram-mini-app/pkg/testutil"
+	"github.com/PtsPuf/telegram-mini-app/pkg/tracing"
+)
+
+// attrs собирает атрибуты спана в карту
+func attrs(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestPredictionSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider(exporter, config.TracingConfig{SampleRatio: 1}, true)
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		tp.Shutdown(context.Background())
+	})
+
+	llm, images := testutil.NewOpenRouter(t), testutil.NewKandinsky(t)
+	cfg := testutil.Config(llm, images)
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	r := httptest.NewRequest(http.MethodPost, "/prediction",
+		strings.NewReader(`{"name":"Анна","birthDate":"1990-03-15","question":"Что ждет меня в работе?","mode":"Карьера"}`))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body: %s", w.Code, w.Body)
+	}
+
+	spans := map[string][]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = append(spans[s.Name], s)
+	}
+	for _, name := range []string{"GetPrediction", "CreateChatCompletion", "createGenerationTask", "checkGenerationStatus"} {
+		if len(spans[name]) == 0 {
+			t.Fatalf("no %s span, got %v", name, exporter.GetSpans().Snapshots())
+		}
+	}
+
+	prediction := attrs(spans["GetPrediction"][0].Attributes)
+	if prediction["prediction.mode"].AsString() != "Карьера" || prediction["llm.prompt_length"].AsInt64() == 0 {
+		t.Errorf("GetPrediction attributes: %v", spans["GetPrediction"][0].Attributes)
+	}
+	completion := attrs(spans["CreateChatCompletion"][0].Attributes)
+	if completion["llm.model"].AsString() != cfg.OpenRouter.Model || completion["llm.prompt_length"].AsInt64() == 0 {
+		t.Errorf("CreateChatCompletion attributes: %v", spans["CreateChatCompletion"][0].Attributes)
+	}
+	// Каждое из трех изображений подписано своим номером
+	for _, name := range []string{"createGenerationTask", "checkGenerationStatus"} {
+		seen := map[int64]bool{}
+		for _, s := range spans[name] {
+			if v, ok := attrs(s.Attributes)["image.index"]; ok {
+				seen[v.AsInt64()] = true
+			}
+		}
+		if len(seen) != 3 || !seen[0] || !seen[1] || !seen[2] {
+			t.Errorf("%s image indexes: %v", name, seen)
+		}
+	}
+	// Спан модели вложен в спан предсказания
+	if spans["CreateChatCompletion"][0].Parent.SpanID() != spans["GetPrediction"][0].SpanContext.SpanID() {
+		t.Error("CreateChatCompletion is not a child of GetPrediction")
+	}
+}
@@ -0,0 +1,457 @@
+// Package usage ведет учет расхода токенов LLM, генераций изображений и речи,
+// переводит его в стоимость и проверяет бюджеты пользователей
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/logging"
+	"github.com/PtsPuf/telegram-mini-app/pkg/metrics"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+)
+
+// Коллекции хранилища
+const (
+	recordsCollection     = "usage"
+	totalsCollection      = "usage_totals"
+	quotasCollection      = "usage_quotas"
+	adjustmentsCollection = "usage_adjustments"
+	bonusCollection       = "usage_bonus"
+)
+
+// Anonymous - учетная запись запросов без подписанных данных Telegram
+// (например, из браузера). Все такие запросы делят один бюджет.
+const Anonymous = "anonymous"
+
+// Horoscope - учетная запись рассылки гороскопов: чтение знака общее для
+// подписчиков, поэтому его расход не относится ни к одному пользователю
+const Horoscope = "horoscope"
+
+// ErrBudgetExceeded возвращается, когда лимит расходов пользователя исчерпан
+var ErrBudgetExceeded = common.ErrBudgetExceeded
+
+// ErrInvalidAdjustment - некорректная сумма или лимиты
+var ErrInvalidAdjustment = errors.New("некорректная корректировка расходов")
+
+// Record - расходы одного предсказания
+type Record struct {
+	PredictionID     string    `json:"predictionId"`
+	User             string    `json:"user"`
+	Mode             string    `json:"mode"`
+	Model            string    `json:"model,omitempty"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	TotalTokens      int       `json:"totalTokens"`
+	Images           int       `json:"images"`
+	Cost             float64   `json:"cost"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Totals - суммарные расходы пользователя за период
+type Totals struct {
+	Predictions      int     `json:"predictions"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Images           int     `json:"images"`
+	Cost             float64 `json:"cost"`
+	// Credit - начисленный администратором кредит, уменьшающий расход для лимитов
+	Credit float64 `json:"credit,omitempty"`
+}
+
+// Spent возвращает расход с учетом кредита
+func (t Totals) Spent() float64 {
+	return max(t.Cost-t.Credit, 0)
+}
+
+// Adjustment - начисление кредита или бонусных предсказаний пользователю
+type Adjustment struct {
+	Amount float64 `json:"amount"`
+	// Predictions - число начисленных бонусных предсказаний
+	Predictions int       `json:"predictions,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Tracker учитывает расходы в хранилище
+type Tracker struct {
+	cfg   config.UsageConfig
+	model string
+	store *store.Store
+	now   func() time.Time
+}
+
+// NewTracker создает учет расходов; model - модель по умолчанию
+func NewTracker(st *store.Store, cfg config.UsageConfig, model string) *Tracker {
+	return &Tracker{
+		cfg:   cfg,
+		model: model,
+		store: st,
+		now:   time.Now,
+	}
+}
+
+// User возвращает учетную запись по идентификатору пользователя Telegram
+func User(telegramID int64) string {
+	if telegramID == 0 {
+		return Anonymous
+	}
+	return strconv.FormatInt(telegramID, 10)
+}
+
+// LLMCost переводит токены в доллары по таблице цен.
+// Для модели без цены стоимость считается нулевой.
+func (t *Tracker) LLMCost(model string, u common.Usage) float64 {
+	price, ok := t.cfg.Prices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1e6
+}
+
+// Model выбирает модель для нового предсказания пользователя с учетом бюджета.
+// После мягкого лимита возвращает резервную модель, а если ее нет или
+// исчерпан жесткий лимит - основную модель при наличии бонусных предсказаний,
+// иначе ErrBudgetExceeded.
+func (t *Tracker) Model(user string) (string, error) {
+	fallback, refused := t.limited(user)
+	switch {
+	case refused && t.Bonus(user) > 0:
+		return t.model, nil
+	case refused:
+		return "", ErrBudgetExceeded
+	case fallback != "":
+		return fallback, nil
+	}
+	return t.model, nil
+}
+
+// limited проверяет лимиты пользователя: возвращает резервную модель после
+// мягкого лимита или refused, если без бонуса предсказание будет отклонено
+func (t *Tracker) limited(user string) (fallback string, refused bool) {
+	day, month := t.Totals(user)
+	b := t.Budget(user)
+
+	if over(day.Spent(), b.HardDaily) || over(month.Spent(), b.HardMonthly) {
+		return "", true
+	}
+	if over(day.Spent(), b.Daily) || over(month.Spent(), b.Monthly) {
+		return b.FallbackModel, b.FallbackModel == ""
+	}
+	return "", false
+}
+
+// Budget возвращает лимиты пользователя: индивидуальные, если они заданы, иначе общие
+func (t *Tracker) Budget(user string) config.BudgetConfig {
+	if quota, ok := t.Quota(user); ok {
+		return quota
+	}
+	return t.cfg.Budget
+}
+
+// Quota возвращает индивидуальные лимиты пользователя
+func (t *Tracker) Quota(user string) (config.BudgetConfig, bool) {
+	var quota config.BudgetConfig
+	ok, err := t.store.Get(quotasCollection, user, &quota)
+	if err != nil {
+		slog.Error("usage quota read failed", slog.String("error", err.Error()))
+	}
+	return quota, ok && err == nil
+}
+
+// SetQuota задает индивидуальные лимиты пользователя вместо usage.budget
+func (t *Tracker) SetQuota(user string, quota config.BudgetConfig) error {
+	if quota.Daily < 0 || quota.Monthly < 0 || quota.HardDaily < 0 || quota.HardMonthly < 0 {
+		return fmt.Errorf("%w: лимиты не могут быть отрицательными", ErrInvalidAdjustment)
+	}
+	return t.store.Put(quotasCollection, user, quota)
+}
+
+// ClearQuota возвращает пользователю общие лимиты
+func (t *Tracker) ClearQuota(user string) {
+	t.store.Delete(quotasCollection, user)
+}
+
+// Credit начисляет пользователю кредит в долларах: он вычитается из расходов
+// текущих суток и месяца при проверке лимитов
+func (t *Tracker) Credit(user string, amount float64, note string) error {
+	if amount <= 0 {
+		return fmt.Errorf("%w: сумма должна быть положительной", ErrInvalidAdjustment)
+	}
+	now := t.now().UTC()
+	for _, key := range []string{dayKey(user, now), monthKey(user, now)} {
+		var tot Totals
+		err := t.store.Update(totalsCollection, key, &tot, func(bool) error {
+			tot.Credit += amount
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var history []Adjustment
+	return t.store.Update(adjustmentsCollection, user, &history, func(bool) error {
+		history = append(history, Adjustment{Amount: amount, Note: note, CreatedAt: now})
+		return nil
+	})
+}
+
+// GrantBonus начисляет пользователю n бонусных предсказаний: они позволяют
+// получить предсказание основной моделью, когда бюджет исчерпан
+func (t *Tracker) GrantBonus(user string, n int, note string) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: число предсказаний должно быть положительным", ErrInvalidAdjustment)
+	}
+	var bonus int
+	err := t.store.Update(bonusCollection, user, &bonus, func(bool) error {
+		bonus += n
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var history []Adjustment
+	return t.store.Update(adjustmentsCollection, user, &history, func(bool) error {
+		history = append(history, Adjustment{Predictions: n, Note: note, CreatedAt: t.now().UTC()})
+		return nil
+	})
+}
+
+// Bonus возвращает число оставшихся бонусных предсказаний пользователя
+func (t *Tracker) Bonus(user string) int {
+	var bonus int
+	if _, err := t.store.Get(bonusCollection, user, &bonus); err != nil {
+		slog.Error("usage bonus read failed", slog.String("error", err.Error()))
+	}
+	return bonus
+}
+
+// useBonus списывает бонусное предсказание, если без него новое
+// предсказание пользователя было бы отклонено
+func (t *Tracker) useBonus(ctx context.Context, user string) {
+	if _, refused := t.limited(user); !refused {
+		return
+	}
+	var bonus int
+	err := t.store.Update(bonusCollection, user, &bonus, func(bool) error {
+		if bonus <= 0 {
+			return ErrBudgetExceeded
+		}
+		bonus--
+		return nil
+	})
+	if err == nil {
+		slog.InfoContext(ctx, "bonus prediction used", slog.Int("bonus_left", bonus))
+	}
+}
+
+// Adjustments возвращает историю начислений пользователя
+func (t *Tracker) Adjustments(user string) ([]Adjustment, error) {
+	var history []Adjustment
+	_, err := t.store.Get(adjustmentsCollection, user, &history)
+	return history, err
+}
+
+// Recent возвращает последние limit записей о расходах, новые первыми
+func (t *Tracker) Recent(limit int) ([]Record, error) {
+	var records []Record
+	err := store.Each(t.store, recordsCollection, func(_ string, r *Record) error {
+		records = append(records, *r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// over сообщает, достигнут ли лимит (0 - без лимита)
+func over(spent, limit float64) bool {
+	return limit > 0 && spent >= limit
+}
+
+// RecordLLM учитывает ответ модели для предсказания
+func (t *Tracker) RecordLLM(ctx context.Context, state *common.UserState, completion *common.ChatCompletion) {
+	cost := t.LLMCost(completion.Model, completion.Usage)
+	metrics.LLMCost.WithLabelValues(completion.Model).Add(cost)
+
+	t.record(ctx, state, completion.Model, Totals{
+		PromptTokens:     completion.Usage.PromptTokens,
+		CompletionTokens: completion.Usage.CompletionTokens,
+		Cost:             cost,
+	})
+}
+
+// RecordShared учитывает ответ модели, не относящийся к предсказанию
+// пользователя, в суммах служебной учетной записи account
+func (t *Tracker) RecordShared(ctx context.Context, account string, completion *common.ChatCompletion) {
+	cost := t.LLMCost(completion.Model, completion.Usage)
+	metrics.LLMCost.WithLabelValues(completion.Model).Add(cost)
+	t.addTotals(ctx, account, t.now().UTC(), Totals{
+		PromptTokens:     completion.Usage.PromptTokens,
+		CompletionTokens: completion.Usage.CompletionTokens,
+		Cost:             cost,
+	})
+}
+
+// RecordImages учитывает сгенерированные для предсказания изображения
+func (t *Tracker) RecordImages(ctx context.Context, state *common.UserState, n int) {
+	if n == 0 {
+		return
+	}
+	t.record(ctx, state, "", Totals{Images: n, Cost: float64(n) * t.cfg.ImagePrice})
+}
+
+// RecordSTT учитывает распознавание записи длительностью d. Вопрос еще не
+// стал предсказанием, поэтому расход добавляется только к суммам пользователя.
+func (t *Tracker) RecordSTT(ctx context.Context, user string, d time.Duration) {
+	cost := d.Minutes() * t.cfg.STTPrice
+	if cost == 0 {
+		return
+	}
+	t.addTotals(ctx, user, t.now().UTC(), Totals{Cost: cost})
+	slog.InfoContext(ctx, "usage recorded",
+		slog.String("user", logging.Hash(user)),
+		slog.Float64("cost", cost),
+		slog.Float64("stt_seconds", d.Seconds()),
+	)
+}
+
+// RecordTTS учитывает озвучку chars символов. Как и распознавание, расход
+// добавляется только к суммам пользователя.
+func (t *Tracker) RecordTTS(ctx context.Context, user string, chars int) {
+	cost := float64(chars) * t.cfg.TTSPrice / 1e6
+	if cost == 0 {
+		return
+	}
+	t.addTotals(ctx, user, t.now().UTC(), Totals{Cost: cost})
+	slog.InfoContext(ctx, "usage recorded",
+		slog.String("user", logging.Hash(user)),
+		slog.Float64("cost", cost),
+		slog.Int("tts_characters", chars),
+	)
+}
+
+// Get возвращает расходы предсказания
+func (t *Tracker) Get(predictionID string) (*Record, bool, error) {
+	var r Record
+	ok, err := t.store.Get(recordsCollection, predictionID, &r)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return &r, true, nil
+}
+
+// Totals возвращает расходы пользователя за текущие сутки и месяц (UTC)
+func (t *Tracker) Totals(user string) (day, month Totals) {
+	now := t.now().UTC()
+	if _, err := t.store.Get(totalsCollection, dayKey(user, now), &day); err != nil {
+		slog.Error("usage totals read failed", slog.String("error", err.Error()))
+	}
+	if _, err := t.store.Get(totalsCollection, monthKey(user, now), &month); err != nil {
+		slog.Error("usage totals read failed", slog.String("error", err.Error()))
+	}
+	return day, month
+}
+
+// Used сообщает, делал ли пользователь предсказания за любой месяц
+func (t *Tracker) Used(user string) bool {
+	prefix := user + "/"
+	for _, key := range t.store.Keys(totalsCollection) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var tot Totals
+		if ok, err := t.store.Get(totalsCollection, key, &tot); err != nil || ok && tot.Predictions > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// record добавляет расходы d к записи предсказания и суммам пользователя.
+// Ошибки учета не должны ломать выдачу предсказания, поэтому они только логируются.
+func (t *Tracker) record(ctx context.Context, state *common.UserState, model string, d Totals) {
+	user := User(state.TelegramID)
+	now := t.now().UTC()
+
+	var r Record
+	var newPrediction bool
+	err := t.store.Update(recordsCollection, state.PredictionID, &r, func(exists bool) error {
+		if !exists {
+			newPrediction = true
+			r = Record{PredictionID: state.PredictionID, User: user, Mode: state.Mode, CreatedAt: now}
+		}
+		if model != "" {
+			r.Model = model
+		}
+		r.PromptTokens += d.PromptTokens
+		r.CompletionTokens += d.CompletionTokens
+		r.TotalTokens += d.PromptTokens + d.CompletionTokens
+		r.Images += d.Images
+		r.Cost += d.Cost
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "usage record failed", slog.String("error", err.Error()))
+		return
+	}
+
+	if newPrediction {
+		d.Predictions = 1
+		// Проверка до учета расходов: бонус нужен, если лимит уже был исчерпан
+		t.useBonus(ctx, user)
+	}
+	t.addTotals(ctx, user, now, d)
+
+	slog.InfoContext(ctx, "usage recorded",
+		slog.String("prediction_id", state.PredictionID),
+		slog.String("user", logging.Hash(user)),
+		slog.Float64("cost", d.Cost),
+		slog.Float64("prediction_cost", r.Cost),
+	)
+}
+
+// addTotals добавляет расходы d к суммам пользователя за сутки и месяц
+func (t *Tracker) addTotals(ctx context.Context, user string, now time.Time, d Totals) {
+	for _, key := range []string{dayKey(user, now), monthKey(user, now)} {
+		var tot Totals
+		err := t.store.Update(totalsCollection, key, &tot, func(bool) error {
+			tot.add(d)
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "usage totals update failed", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (t *Totals) add(d Totals) {
+	t.Predictions += d.Predictions
+	t.PromptTokens += d.PromptTokens
+	t.CompletionTokens += d.CompletionTokens
+	t.Images += d.Images
+	t.Cost += d.Cost
+}
+
+func dayKey(user string, t time.Time) string {
+	return fmt.Sprintf("%s/%s", user, t.Format("2006-01-02"))
+}
+
+func monthKey(user string, t time.Time) string {
+	return fmt.Sprintf("%s/%s", user, t.Format("2006-01"))
+}
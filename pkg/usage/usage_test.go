@@ -0,0 +1,233 @@
+package usage_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PtsPuf/telegram-mini-app/pkg/common"
+	"github.com/PtsPuf/telegram-mini-app/pkg/config"
+	"github.com/PtsPuf/telegram-mini-app/pkg/store"
+	"github.com/PtsPuf/telegram-mini-app/pkg/usage"
+)
+
+const (
+	user      = "42"
+	userID    = 42
+	mainModel = "main/model"
+	cheap     = "cheap/model"
+)
+
+// newTracker - учет в памяти, где миллион токенов запроса стоит доллар,
+// то есть токен - микродоллар
+func newTracker(t *testing.T, budget config.BudgetConfig) *usage.Tracker {
+	t.Helper()
+	st, err := store.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return usage.NewTracker(st, config.UsageConfig{
+		Prices: map[string]config.ModelPrice{
+			mainModel: {Prompt: 1, Completion: 2},
+			cheap:     {Prompt: 0.5, Completion: 0.5},
+		},
+		ImagePrice: 0.25,
+		STTPrice:   0.6,
+		TTSPrice:   10,
+		Budget:     budget,
+	}, mainModel)
+}
+
+// spend записывает новое предсказание стоимостью dollars
+func spend(t *testing.T, tr *usage.Tracker, predictionID string, dollars float64) {
+	t.Helper()
+	state := &common.UserState{TelegramID: userID, PredictionID: predictionID, Mode: "Карьера"}
+	tr.RecordLLM(context.Background(), state, &common.ChatCompletion{
+		Model: mainModel,
+		Usage: common.Usage{PromptTokens: int(dollars * 1e6)},
+	})
+}
+
+func TestModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget config.BudgetConfig
+		spent  float64
+		credit float64
+		bonus  int
+		want   string
+	}{
+		{"no limits", config.BudgetConfig{}, 100, 0, 0, mainModel},
+		{"below soft daily", config.BudgetConfig{Daily: 2, FallbackModel: cheap}, 1, 0, 0, mainModel},
+		{"at soft daily", config.BudgetConfig{Daily: 2, FallbackModel: cheap}, 2, 0, 0, cheap},
+		{"at soft monthly", config.BudgetConfig{Monthly: 2, FallbackModel: cheap}, 2, 0, 0, cheap},
+		{"soft daily without fallback", config.BudgetConfig{Daily: 2}, 2, 0, 0, ""},
+		{"soft monthly without fallback", config.BudgetConfig{Monthly: 2}, 3, 0, 0, ""},
+		{"below hard daily", config.BudgetConfig{Daily: 1, HardDaily: 3, FallbackModel: cheap}, 2, 0, 0, cheap},
+		{"at hard daily", config.BudgetConfig{Daily: 1, HardDaily: 3, FallbackModel: cheap}, 3, 0, 0, ""},
+		{"at hard monthly", config.BudgetConfig{Monthly: 1, HardMonthly: 3, FallbackModel: cheap}, 3, 0, 0, ""},
+		{"hard limit with bonus", config.BudgetConfig{HardDaily: 3}, 3, 0, 1, mainModel},
+		{"soft limit with bonus", config.BudgetConfig{Daily: 2}, 2, 0, 1, mainModel},
+		// Бонус не нужен, пока есть резервная модель
+		{"fallback with bonus", config.BudgetConfig{Daily: 2, FallbackModel: cheap}, 2, 0, 1, cheap},
+		{"credit below soft limit", config.BudgetConfig{Daily: 2, FallbackModel: cheap}, 2.5, 1, 0, mainModel},
+		{"credit below hard limit", config.BudgetConfig{Daily: 1, HardDaily: 3, FallbackModel: cheap}, 3, 0.5, 0, cheap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTracker(t, tt.budget)
+			spend(t, tr, "p1", tt.spent)
+			if tt.credit > 0 {
+				if err := tr.Credit(user, tt.credit, "тест"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.bonus > 0 {
+				if err := tr.GrantBonus(user, tt.bonus, "тест"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			model, err := tr.Model(user)
+			if tt.want == "" {
+				if !errors.Is(err, usage.ErrBudgetExceeded) {
+					t.Errorf("Model = %q, %v, want ErrBudgetExceeded", model, err)
+				}
+				return
+			}
+			if err != nil || model != tt.want {
+				t.Errorf("Model = %q, %v, want %q", model, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	tr := newTracker(t, config.BudgetConfig{Daily: 10})
+	spend(t, tr, "p1", 2)
+	if model, err := tr.Model(user); err != nil || model != mainModel {
+		t.Fatalf("Model = %q, %v", model, err)
+	}
+
+	// Индивидуальные лимиты заменяют общие целиком
+	if err := tr.SetQuota(user, config.BudgetConfig{Daily: 1, FallbackModel: cheap}); err != nil {
+		t.Fatal(err)
+	}
+	if model, err := tr.Model(user); err != nil || model != cheap {
+		t.Errorf("Model with quota = %q, %v", model, err)
+	}
+	if model, _ := tr.Model("7"); model != mainModel {
+		t.Errorf("other user model = %q", model)
+	}
+	if err := tr.SetQuota(user, config.BudgetConfig{Daily: -1}); !errors.Is(err, usage.ErrInvalidAdjustment) {
+		t.Errorf("negative quota = %v", err)
+	}
+
+	tr.ClearQuota(user)
+	if _, ok := tr.Quota(user); ok {
+		t.Error("quota not cleared")
+	}
+	if model, _ := tr.Model(user); model != mainModel {
+		t.Errorf("Model after clear = %q", model)
+	}
+}
+
+func TestUseBonus(t *testing.T) {
+	tr := newTracker(t, config.BudgetConfig{HardDaily: 1})
+	// Бонус не списывается, пока лимит не исчерпан
+	tr.GrantBonus(user, 2, "тест")
+	spend(t, tr, "p1", 1)
+	if n := tr.Bonus(user); n != 2 {
+		t.Fatalf("bonus after spending within budget = %d, want 2", n)
+	}
+
+	// Новое предсказание сверх лимита списывает один бонус
+	spend(t, tr, "p2", 0.5)
+	if n := tr.Bonus(user); n != 1 {
+		t.Errorf("bonus after prediction over budget = %d, want 1", n)
+	}
+	// Изображения того же предсказания бонус не тратят
+	tr.RecordImages(context.Background(), &common.UserState{TelegramID: userID, PredictionID: "p2"}, 2)
+	if n := tr.Bonus(user); n != 1 {
+		t.Errorf("bonus after images = %d, want 1", n)
+	}
+
+	spend(t, tr, "p3", 0.5)
+	spend(t, tr, "p4", 0.5)
+	if n := tr.Bonus(user); n != 0 {
+		t.Errorf("bonus = %d, want 0", n)
+	}
+	if _, err := tr.Model(user); !errors.Is(err, usage.ErrBudgetExceeded) {
+		t.Errorf("Model without bonus = %v", err)
+	}
+	if err := tr.GrantBonus(user, 0, ""); !errors.Is(err, usage.ErrInvalidAdjustment) {
+		t.Errorf("zero bonus = %v", err)
+	}
+}
+
+func TestCredit(t *testing.T) {
+	tr := newTracker(t, config.BudgetConfig{})
+	spend(t, tr, "p1", 1)
+	for _, amount := range []float64{0, -1} {
+		if err := tr.Credit(user, amount, ""); !errors.Is(err, usage.ErrInvalidAdjustment) {
+			t.Errorf("Credit(%v) = %v", amount, err)
+		}
+	}
+	tr.Credit(user, 0.25, "компенсация")
+	tr.Credit(user, 2, "подарок")
+
+	day, month := tr.Totals(user)
+	for name, tot := range map[string]usage.Totals{"day": day, "month": month} {
+		if tot.Cost != 1 || tot.Credit != 2.25 || tot.Spent() != 0 {
+			t.Errorf("%s totals = %+v, spent %v", name, tot, tot.Spent())
+		}
+	}
+	history, err := tr.Adjustments(user)
+	if err != nil || len(history) != 2 || history[0].Amount != 0.25 || history[1].Note != "подарок" {
+		t.Errorf("adjustments = %+v, %v", history, err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tr := newTracker(t, config.BudgetConfig{})
+	ctx := context.Background()
+	state := &common.UserState{TelegramID: userID, PredictionID: "p1", Mode: "Любовь"}
+	tr.RecordLLM(ctx, state, &common.ChatCompletion{Model: mainModel, Usage: common.Usage{PromptTokens: 1000, CompletionTokens: 500}})
+	tr.RecordImages(ctx, state, 3)
+	// Уточняющий вопрос к тому же предсказанию и модель без цены
+	tr.RecordLLM(ctx, state, &common.ChatCompletion{Model: "free/model", Usage: common.Usage{PromptTokens: 100}})
+
+	r, ok, err := tr.Get("p1")
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	wantCost := (1000*1+500*2)/1e6 + 3*0.25
+	if r.User != user || r.Mode != "Любовь" || r.Model != "free/model" || r.PromptTokens != 1100 ||
+		r.CompletionTokens != 500 || r.TotalTokens != 1600 || r.Images != 3 || r.Cost != wantCost {
+		t.Errorf("record = %+v", r)
+	}
+
+	day, month := tr.Totals(user)
+	if day != month || day.Predictions != 1 || day.Images != 3 || day.Cost != wantCost {
+		t.Errorf("totals = %+v, %+v", day, month)
+	}
+
+	// Речь учитывается в суммах пользователя, но не создает предсказаний
+	other := usage.User(7)
+	tr.RecordSTT(ctx, other, 30*time.Second)
+	tr.RecordTTS(ctx, other, 1000)
+	if day, _ := tr.Totals(other); day.Predictions != 0 || day.Cost != 0.3+0.01 {
+		t.Errorf("speech totals = %+v", day)
+	}
+	if tr.Used(other) || !tr.Used(user) {
+		t.Errorf("Used = %v for speech only, %v with predictions", tr.Used(other), tr.Used(user))
+	}
+
+	if recent, err := tr.Recent(10); err != nil || len(recent) != 1 {
+		t.Errorf("Recent = %d, %v", len(recent), err)
+	}
+	if usage.User(0) != usage.Anonymous || usage.User(42) != strconv.Itoa(userID) {
+		t.Error("User does not map Telegram IDs")
+	}
+}
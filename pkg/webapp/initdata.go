@@ -0,0 +1,113 @@
+// Package webapp проверяет данные запуска Telegram Mini App (initData)
+package webapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InitDataHeader - заголовок, в котором мини-приложение передает
+// Telegram.WebApp.initData
+const InitDataHeader = "X-Telegram-Init-Data"
+
+var (
+	// ErrNoInitData - запрос пришел не из Telegram (например, из браузера)
+	ErrNoInitData = errors.New("нет данных запуска Telegram")
+	// ErrInvalidSignature - подпись не совпадает с токеном бота
+	ErrInvalidSignature = errors.New("неверная подпись данных запуска")
+	// ErrExpired - данные запуска устарели
+	ErrExpired = errors.New("данные запуска устарели")
+)
+
+// User - пользователь Telegram из данных запуска
+type User struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+}
+
+// InitData - проверенные данные запуска
+type InitData struct {
+	User     *User
+	AuthDate time.Time
+	QueryID  string
+	// StartParam - параметр ссылки t.me/<bot>?startapp=<param>
+	StartParam string
+}
+
+// Parse проверяет подпись initData токеном бота и возвращает данные запуска.
+// Данные старше maxAge отклоняются, чтобы перехваченную строку нельзя было
+// использовать бесконечно.
+func Parse(initData, botToken string, maxAge time.Duration, now time.Time) (*InitData, error) {
+	if initData == "" {
+		return nil, ErrNoInitData
+	}
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора данных запуска: %v", err)
+	}
+
+	hash := values.Get("hash")
+	if hash == "" {
+		return nil, ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(Sign(values, botToken)), []byte(hash)) {
+		return nil, ErrInvalidSignature
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("некорректное поле auth_date: %v", err)
+	}
+	data := &InitData{
+		AuthDate:   time.Unix(authDate, 0),
+		QueryID:    values.Get("query_id"),
+		StartParam: values.Get("start_param"),
+	}
+	if maxAge > 0 && now.Sub(data.AuthDate) > maxAge {
+		return nil, ErrExpired
+	}
+
+	if raw := values.Get("user"); raw != "" {
+		data.User = &User{}
+		if err := json.Unmarshal([]byte(raw), data.User); err != nil {
+			return nil, fmt.Errorf("некорректное поле user: %v", err)
+		}
+	}
+	return data, nil
+}
+
+// Sign вычисляет подпись данных запуска по алгоритму Telegram: HMAC-SHA256
+// от отсортированных пар key=value (кроме hash), где ключ - HMAC-SHA256
+// токена бота с ключом "WebAppData"
+func Sign(values url.Values, botToken string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if k != "hash" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = k + "=" + values.Get(k)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}